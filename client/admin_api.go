@@ -18,7 +18,6 @@ import (
 	"cmp"
 	"encoding/json"
 	"fmt"
-	"gitee.com/menciis/logx"
 	"io"
 	"net"
 	"net/http"
@@ -62,6 +61,14 @@ func (svr *Service) registerRouteHandlers(helper *httppkg.RouterRegisterHelper)
 	})
 }
 
+// registerStatusRouteHandlers registers the read-only apis of the status server.
+func (svr *Service) registerStatusRouteHandlers(helper *httppkg.RouterRegisterHelper) {
+	helper.Router.HandleFunc("/healthz", svr.healthz)
+	subRouter := helper.Router.NewRoute().Subrouter()
+	subRouter.Use(helper.AuthMiddleware.Middleware)
+	subRouter.HandleFunc("/api/status", svr.apiStatus).Methods("GET")
+}
+
 // /healthz
 func (svr *Service) healthz(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(200)
@@ -76,9 +83,9 @@ func (svr *Service) apiReload(w http.ResponseWriter, r *http.Request) {
 		strictConfigMode, _ = strconv.ParseBool(strictStr)
 	}
 
-	logx.Verbosef("api request [/api/reload]")
+	svr.xl.Debugf("api request [/api/reload]")
 	defer func() {
-		logx.Verbosef("api response [/api/reload], code [%d]", res.Code)
+		svr.xl.Debugf("api response [/api/reload], code [%d]", res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
@@ -89,32 +96,32 @@ func (svr *Service) apiReload(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		res.Code = 400
 		res.Msg = err.Error()
-		logx.Warnf("reload frpc proxy config error: %s", res.Msg)
+		svr.xl.Warnf("reload frpc proxy config error: %s", res.Msg)
 		return
 	}
 	if _, err := validation.ValidateAllClientConfig(cliCfg, proxyCfgs, visitorCfgs); err != nil {
 		res.Code = 400
 		res.Msg = err.Error()
-		logx.Warnf("reload frpc proxy config error: %s", res.Msg)
+		svr.xl.Warnf("reload frpc proxy config error: %s", res.Msg)
 		return
 	}
 
 	if err := svr.UpdateAllConfigurer(proxyCfgs, visitorCfgs); err != nil {
 		res.Code = 500
 		res.Msg = err.Error()
-		logx.Warnf("reload frpc proxy config error: %s", res.Msg)
+		svr.xl.Warnf("reload frpc proxy config error: %s", res.Msg)
 		return
 	}
-	logx.Verbosef("success reload conf")
+	svr.xl.Debugf("success reload conf")
 }
 
 // POST /api/stop
 func (svr *Service) apiStop(w http.ResponseWriter, _ *http.Request) {
 	res := GeneralResponse{Code: 200}
 
-	logx.Verbosef("api request [/api/stop]")
+	svr.xl.Debugf("api request [/api/stop]")
 	defer func() {
-		logx.Verbosef("api response [/api/stop], code [%d]", res.Code)
+		svr.xl.Debugf("api response [/api/stop], code [%d]", res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
@@ -134,14 +141,22 @@ type ProxyStatusResp struct {
 	LocalAddr  string `json:"local_addr"`
 	Plugin     string `json:"plugin"`
 	RemoteAddr string `json:"remote_addr"`
+	CurConns   int64  `json:"cur_conns"`
+	TotalConns int64  `json:"total_conns"`
+	TrafficIn  int64  `json:"traffic_in"`
+	TrafficOut int64  `json:"traffic_out"`
 }
 
 func NewProxyStatusResp(status *proxy.WorkingStatus, serverAddr string) ProxyStatusResp {
 	psr := ProxyStatusResp{
-		Name:   status.Name,
-		Type:   status.Type,
-		Status: status.Phase,
-		Err:    status.Err,
+		Name:       status.Name,
+		Type:       status.Type,
+		Status:     status.Phase,
+		Err:        status.Err,
+		CurConns:   status.CurConns,
+		TotalConns: status.TotalConns,
+		TrafficIn:  status.TrafficIn,
+		TrafficOut: status.TrafficOut,
 	}
 	baseCfg := status.Cfg.GetBaseConfig()
 	if baseCfg.LocalPort != 0 {
@@ -165,9 +180,9 @@ func (svr *Service) apiStatus(w http.ResponseWriter, _ *http.Request) {
 		res StatusResp = make(map[string][]ProxyStatusResp)
 	)
 
-	logx.Verbosef("Http request [/api/status]")
+	svr.xl.Debugf("Http request [/api/status]")
 	defer func() {
-		logx.Verbosef("Http response [/api/status]")
+		svr.xl.Debugf("Http response [/api/status]")
 		buf, _ = json.Marshal(&res)
 		_, _ = w.Write(buf)
 	}()
@@ -198,9 +213,9 @@ func (svr *Service) apiStatus(w http.ResponseWriter, _ *http.Request) {
 func (svr *Service) apiGetConfig(w http.ResponseWriter, _ *http.Request) {
 	res := GeneralResponse{Code: 200}
 
-	logx.Verbosef("Http get request [/api/config]")
+	svr.xl.Debugf("Http get request [/api/config]")
 	defer func() {
-		logx.Verbosef("Http get response [/api/config], code [%d]", res.Code)
+		svr.xl.Debugf("Http get response [/api/config], code [%d]", res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
@@ -210,7 +225,7 @@ func (svr *Service) apiGetConfig(w http.ResponseWriter, _ *http.Request) {
 	if svr.configFilePath == "" {
 		res.Code = 400
 		res.Msg = "frpc has no config file path"
-		logx.Warnf("%s", res.Msg)
+		svr.xl.Warnf("%s", res.Msg)
 		return
 	}
 
@@ -218,7 +233,7 @@ func (svr *Service) apiGetConfig(w http.ResponseWriter, _ *http.Request) {
 	if err != nil {
 		res.Code = 400
 		res.Msg = err.Error()
-		logx.Warnf("load frpc config file error: %s", res.Msg)
+		svr.xl.Warnf("load frpc config file error: %s", res.Msg)
 		return
 	}
 	res.Msg = string(content)
@@ -228,9 +243,9 @@ func (svr *Service) apiGetConfig(w http.ResponseWriter, _ *http.Request) {
 func (svr *Service) apiPutConfig(w http.ResponseWriter, r *http.Request) {
 	res := GeneralResponse{Code: 200}
 
-	logx.Verbosef("Http put request [/api/config]")
+	svr.xl.Debugf("Http put request [/api/config]")
 	defer func() {
-		logx.Verbosef("Http put response [/api/config], code [%d]", res.Code)
+		svr.xl.Debugf("Http put response [/api/config], code [%d]", res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
@@ -242,21 +257,21 @@ func (svr *Service) apiPutConfig(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		res.Code = 400
 		res.Msg = fmt.Sprintf("read request body error: %v", err)
-		logx.Warnf("%s", res.Msg)
+		svr.xl.Warnf("%s", res.Msg)
 		return
 	}
 
 	if len(body) == 0 {
 		res.Code = 400
 		res.Msg = "body can't be empty"
-		logx.Warnf("%s", res.Msg)
+		svr.xl.Warnf("%s", res.Msg)
 		return
 	}
 
 	if err := os.WriteFile(svr.configFilePath, body, 0o600); err != nil {
 		res.Code = 500
 		res.Msg = fmt.Sprintf("write content to frpc config file error: %v", err)
-		logx.Warnf("%s", res.Msg)
+		svr.xl.Warnf("%s", res.Msg)
 		return
 	}
 }
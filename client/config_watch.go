@@ -0,0 +1,118 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/iami317/hepx/pkg/config"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/config/v1/validation"
+	"github.com/iami317/hepx/pkg/util/xlog"
+)
+
+// configWatcher polls the config file and the files matched by its includes. Proxies and visitors are
+// reloaded when the files stay unchanged for the debounce duration after a change.
+type configWatcher struct {
+	svr      *Service
+	path     string
+	includes []string
+}
+
+func (svr *Service) watchConfig() {
+	xl := xlog.FromContextSafe(svr.ctx)
+	w := &configWatcher{
+		svr:      svr,
+		path:     svr.configFilePath,
+		includes: svr.common.IncludeConfigFiles,
+	}
+	interval := time.Duration(svr.common.ConfigWatch.IntervalMs) * time.Millisecond
+	debounce := time.Duration(svr.common.ConfigWatch.DebounceMs) * time.Millisecond
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	xl.Infof("watching config file [%s] and its includes for changes", w.path)
+	applied := w.snapshot()
+	seen := applied
+	var changedAt time.Time
+	for {
+		var now time.Time
+		select {
+		case <-svr.ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+
+		if s := w.snapshot(); s != seen {
+			seen = s
+			changedAt = now
+			continue
+		}
+		if seen == applied || now.Sub(changedAt) < debounce {
+			continue
+		}
+
+		if err := w.reload(); err != nil {
+			xl.Warnf("reload config file [%s] error, keep the previous proxies and visitors: %v", w.path, err)
+		} else {
+			xl.Infof("config file [%s] changed, proxies and visitors reloaded", w.path)
+		}
+		// includes may be changed by the reload
+		applied = w.snapshot()
+		seen = applied
+	}
+}
+
+// reload loads the config in strict mode and applies the proxies and visitors if they are valid.
+func (w *configWatcher) reload() error {
+	// watch the files of the new includes even if they are invalid, so fixing them triggers another reload
+	allCfg := v1.ClientConfig{}
+	if err := config.LoadConfigureFromFile(w.path, &allCfg, true); err != nil {
+		return err
+	}
+	w.includes = allCfg.IncludeConfigFiles
+
+	cliCfg, proxyCfgs, visitorCfgs, err := config.LoadClientConfig(w.path, true)
+	if err != nil {
+		return err
+	}
+	warning, err := validation.ValidateAllClientConfig(cliCfg, proxyCfgs, visitorCfgs)
+	if warning != nil {
+		w.svr.xl.Warnf("reload config file [%s] warning: %v", w.path, warning)
+	}
+	if err != nil {
+		return err
+	}
+	return w.svr.UpdateAllConfigurer(proxyCfgs, visitorCfgs)
+}
+
+// snapshot returns the size and modification time of all watched files.
+func (w *configWatcher) snapshot() string {
+	files := []string{w.path}
+	for _, include := range w.includes {
+		absDir, err := filepath.Abs(filepath.Dir(include))
+		if err != nil {
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(absDir, filepath.Base(include)))
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+
+	var b strings.Builder
+	for _, f := range slices.Compact(files) {
+		fi, err := os.Stat(f)
+		switch {
+		case err != nil:
+			fmt.Fprintf(&b, "%s:-\n", f)
+		case fi.IsDir():
+		default:
+			fmt.Fprintf(&b, "%s:%d:%d\n", f, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
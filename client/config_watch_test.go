@@ -0,0 +1,37 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigWatcherSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frpc.toml")
+	require.NoError(os.WriteFile(path, []byte(`serverPort = 7000`), 0o600))
+	w := &configWatcher{path: path, includes: []string{filepath.Join(dir, "confd", "*.toml")}}
+
+	s := w.snapshot()
+	require.Equal(s, w.snapshot())
+
+	// new files of includes are watched
+	require.NoError(os.Mkdir(filepath.Join(dir, "confd"), 0o755))
+	require.NoError(os.WriteFile(filepath.Join(dir, "confd", "web.toml"), []byte(`[[proxies]]`), 0o600))
+	s2 := w.snapshot()
+	require.NotEqual(s, s2)
+
+	require.NoError(os.WriteFile(filepath.Join(dir, "confd", "web.txt"), []byte(`ignored`), 0o600))
+	require.Equal(s2, w.snapshot())
+
+	require.NoError(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.NotEqual(s2, w.snapshot())
+
+	require.NoError(os.Remove(path))
+	require.Contains(w.snapshot(), path+":-")
+}
@@ -17,6 +17,8 @@ package client
 import (
 	"context"
 	"net"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 	"github.com/iami317/hepx/pkg/auth"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/msg"
+	"github.com/iami317/hepx/pkg/proto/udp"
 	"github.com/iami317/hepx/pkg/transport"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
 	"github.com/iami317/hepx/pkg/util/wait"
@@ -62,6 +65,9 @@ type Control struct {
 	// manage all visitors
 	vm *visitor.Manager
 
+	// carries packets of udp proxies in QUIC datagrams, nil if the connection doesn't support it
+	datagramMux *udp.DatagramMux
+
 	doneCh chan struct{}
 
 	// of time.Time, last time got the Pong message
@@ -75,15 +81,24 @@ type Control struct {
 	// msgDispatcher is a wrapper for control connection.
 	// It provides a channel for sending messages, and you can register handlers to process messages based on their respective types.
 	msgDispatcher *msg.Dispatcher
+
+	// proxies and visitors from the configuration, and the ones pushed by the server
+	cfgMu             sync.Mutex
+	proxyCfgs         []v1.ProxyConfigurer
+	visitorCfgs       []v1.VisitorConfigurer
+	pushedProxyCfgs   map[string]v1.ProxyConfigurer
+	pushedVisitorCfgs map[string]v1.VisitorConfigurer
 }
 
 func NewControl(ctx context.Context, sessionCtx *SessionContext) (*Control, error) {
 	// new xlog instance
 	ctl := &Control{
-		ctx:        ctx,
-		xl:         xlog.FromContextSafe(ctx),
-		sessionCtx: sessionCtx,
-		doneCh:     make(chan struct{}),
+		ctx:               ctx,
+		xl:                xlog.FromContextSafe(ctx),
+		sessionCtx:        sessionCtx,
+		doneCh:            make(chan struct{}),
+		pushedProxyCfgs:   make(map[string]v1.ProxyConfigurer),
+		pushedVisitorCfgs: make(map[string]v1.VisitorConfigurer),
 	}
 	ctl.lastPong.Store(time.Now())
 
@@ -99,7 +114,13 @@ func NewControl(ctx context.Context, sessionCtx *SessionContext) (*Control, erro
 	ctl.registerMsgHandlers()
 	ctl.msgTransporter = transport.NewMessageTransporter(ctl.msgDispatcher.SendChannel())
 
-	ctl.pm = proxy.NewManager(ctl.ctx, sessionCtx.Common, ctl.msgTransporter)
+	// packets of udp proxies are carried in QUIC datagrams if frps allocates flows for them
+	pxyCtx := ctl.ctx
+	if quicConn, ok := netpkg.QuicConnFromConn(sessionCtx.Conn); ok && quicConn.ConnectionState().SupportsDatagrams {
+		ctl.datagramMux = udp.NewDatagramMux(quicConn)
+		pxyCtx = udp.NewDatagramMuxContext(pxyCtx, ctl.datagramMux)
+	}
+	ctl.pm = proxy.NewManager(pxyCtx, sessionCtx.Common, ctl.msgTransporter)
 	ctl.vm = visitor.NewManager(ctl.ctx, sessionCtx.RunID, sessionCtx.Common, ctl.connectServer, ctl.msgTransporter)
 	return ctl, nil
 }
@@ -107,6 +128,11 @@ func NewControl(ctx context.Context, sessionCtx *SessionContext) (*Control, erro
 func (ctl *Control) Run(proxyCfgs []v1.ProxyConfigurer, visitorCfgs []v1.VisitorConfigurer) {
 	go ctl.worker()
 
+	ctl.cfgMu.Lock()
+	defer ctl.cfgMu.Unlock()
+	ctl.proxyCfgs = proxyCfgs
+	ctl.visitorCfgs = visitorCfgs
+
 	// start all proxies
 	ctl.pm.UpdateAll(proxyCfgs)
 
@@ -169,6 +195,32 @@ func (ctl *Control) handleNewProxyResp(m msg.Message) {
 	}
 }
 
+func (ctl *Control) handleCloseProxy(m msg.Message) {
+	xl := ctl.xl
+	inMsg := m.(*msg.CloseProxy)
+	xl.Warnf("[%s] proxy closed by server: %s", inMsg.ProxyName, inMsg.Reason)
+	if err := ctl.pm.CloseProxyByServer(inMsg.ProxyName, inMsg.Reason); err != nil {
+		xl.Tracef("close proxy by server error: %v", err)
+	}
+}
+
+func (ctl *Control) handleCloseControl(m msg.Message) {
+	xl := ctl.xl
+	inMsg := m.(*msg.CloseControl)
+	xl.Warnf("connection closed by server: %s", inMsg.Reason)
+}
+
+func (ctl *Control) handleQuotaExceeded(m msg.Message) {
+	xl := ctl.xl
+	inMsg := m.(*msg.QuotaExceeded)
+	action := "new connections are rejected"
+	if inMsg.Action == v1.TrafficQuotaActionThrottle {
+		action = "new connections are throttled"
+	}
+	xl.Warnf("traffic quota [%s] exceeded, used %d bytes of %d bytes in %s period, %s",
+		inMsg.Rule, inMsg.Used, inMsg.Limit, inMsg.Period, action)
+}
+
 func (ctl *Control) handleNatHoleResp(m msg.Message) {
 	xl := ctl.xl
 	inMsg := m.(*msg.NatHoleResp)
@@ -228,6 +280,10 @@ func (ctl *Control) registerMsgHandlers() {
 	ctl.msgDispatcher.RegisterHandler(&msg.NewProxyResp{}, ctl.handleNewProxyResp)
 	ctl.msgDispatcher.RegisterHandler(&msg.NatHoleResp{}, ctl.handleNatHoleResp)
 	ctl.msgDispatcher.RegisterHandler(&msg.Pong{}, ctl.handlePong)
+	ctl.msgDispatcher.RegisterHandler(&msg.CloseProxy{}, ctl.handleCloseProxy)
+	ctl.msgDispatcher.RegisterHandler(&msg.CloseControl{}, ctl.handleCloseControl)
+	ctl.msgDispatcher.RegisterHandler(&msg.PushProxies{}, msg.AsyncHandler(ctl.handlePushProxies))
+	ctl.msgDispatcher.RegisterHandler(&msg.QuotaExceeded{}, ctl.handleQuotaExceeded)
 }
 
 // headerWorker sends heartbeat to server and check heartbeat timeout.
@@ -274,6 +330,11 @@ func (ctl *Control) heartbeatWorker() {
 func (ctl *Control) worker() {
 	go ctl.heartbeatWorker()
 	go ctl.msgDispatcher.Run()
+	if ctl.datagramMux != nil {
+		ctx, cancel := context.WithCancel(ctl.ctx)
+		defer cancel()
+		go ctl.datagramMux.Run(ctx)
+	}
 
 	<-ctl.msgDispatcher.Done()
 	ctl.closeSession()
@@ -284,7 +345,34 @@ func (ctl *Control) worker() {
 }
 
 func (ctl *Control) UpdateAllConfigurer(proxyCfgs []v1.ProxyConfigurer, visitorCfgs []v1.VisitorConfigurer) error {
+	ctl.cfgMu.Lock()
+	defer ctl.cfgMu.Unlock()
+	ctl.proxyCfgs = proxyCfgs
+	ctl.visitorCfgs = visitorCfgs
+	ctl.updateAllLocked()
+	return nil
+}
+
+// updateAllLocked applies the configured proxies and visitors together with the pushed ones.
+// Pushed ones are dropped if the configuration now contains the same name. cfgMu must be held.
+func (ctl *Control) updateAllLocked() {
+	proxyCfgs := slices.Clone(ctl.proxyCfgs)
+	for name, cfg := range ctl.pushedProxyCfgs {
+		if slices.ContainsFunc(ctl.proxyCfgs, func(c v1.ProxyConfigurer) bool { return c.GetBaseConfig().Name == name }) {
+			delete(ctl.pushedProxyCfgs, name)
+			continue
+		}
+		proxyCfgs = append(proxyCfgs, cfg)
+	}
+	visitorCfgs := slices.Clone(ctl.visitorCfgs)
+	for name, cfg := range ctl.pushedVisitorCfgs {
+		if slices.ContainsFunc(ctl.visitorCfgs, func(c v1.VisitorConfigurer) bool { return c.GetBaseConfig().Name == name }) {
+			delete(ctl.pushedVisitorCfgs, name)
+			continue
+		}
+		visitorCfgs = append(visitorCfgs, cfg)
+	}
+
 	ctl.vm.UpdateAll(visitorCfgs)
 	ctl.pm.UpdateAll(proxyCfgs)
-	return nil
 }
@@ -26,6 +26,8 @@ const (
 	ProxyPhaseRunning     = "running"
 	ProxyPhaseCheckFailed = "check failed"
 	ProxyPhaseClosed      = "closed"
+	// ProxyPhaseClosedByServer means frps closed the proxy, it will not be started again in this session.
+	ProxyPhaseClosedByServer = "closed by server"
 )
 
 var (
@@ -43,13 +45,20 @@ type WorkingStatus struct {
 
 	// Got from server.
 	RemoteAddr string `json:"remote_addr"`
+
+	// Counted by TrafficStats.
+	CurConns   int64 `json:"cur_conns"`
+	TotalConns int64 `json:"total_conns"`
+	TrafficIn  int64 `json:"traffic_in"`
+	TrafficOut int64 `json:"traffic_out"`
 }
 
 type Wrapper struct {
 	WorkingStatus
 
 	// underlying proxy
-	pxy Proxy
+	pxy   Proxy
+	stats TrafficStats
 
 	// if ProxyConf has healcheck config
 	// monitor will watch if it is alive
@@ -79,7 +88,9 @@ func NewWrapper(
 	msgTransporter transport.MessageTransporter,
 ) *Wrapper {
 	baseInfo := cfg.GetBaseConfig()
-	xl := xlog.FromContextSafe(ctx).Spawn().AppendPrefix(baseInfo.Name)
+	xl := xlog.FromContextSafe(ctx).Spawn().
+		AddPrefix(xlog.LogPrefix{Name: "proxyName", Value: baseInfo.Name}).
+		AddPrefix(xlog.LogPrefix{Name: "proxyType", Value: baseInfo.Type, Hidden: true})
 	pw := &Wrapper{
 		WorkingStatus: WorkingStatus{
 			Name:  baseInfo.Name,
@@ -103,7 +114,7 @@ func NewWrapper(
 		xl.Tracef("enable health check monitor")
 	}
 
-	pw.pxy = NewProxy(pw.ctx, pw.Cfg, clientCfg, pw.msgTransporter)
+	pw.pxy = NewProxy(pw.ctx, pw.Cfg, clientCfg, pw.msgTransporter, &pw.stats)
 	return pw
 }
 
@@ -139,6 +150,18 @@ func (pw *Wrapper) SetRunningStatus(remoteAddr string, respErr string) error {
 	return nil
 }
 
+// SetClosedByServer marks the proxy as closed by frps. There is no need to notify the server again.
+func (pw *Wrapper) SetClosedByServer(reason string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.Phase == ProxyPhaseClosed {
+		return
+	}
+	pw.xl.Tracef("change status from [%s] to [%s]", pw.Phase, ProxyPhaseClosedByServer)
+	pw.Phase = ProxyPhaseClosedByServer
+	pw.Err = reason
+}
+
 func (pw *Wrapper) Start() {
 	go pw.checkWorker()
 	if pw.monitor != nil {
@@ -244,7 +267,7 @@ func (pw *Wrapper) InWorkConn(workConn net.Conn, m *msg.StartWorkConn) {
 	pw.mu.RUnlock()
 	if pxy != nil && pw.Phase == ProxyPhaseRunning {
 		xl.Tracef("start a new work connection, localAddr: %s remoteAddr: %s", workConn.LocalAddr().String(), workConn.RemoteAddr().String())
-		go pxy.InWorkConn(workConn, m)
+		go pxy.InWorkConn(newStatsConn(workConn, &pw.stats), m)
 	} else {
 		workConn.Close()
 	}
@@ -260,6 +283,10 @@ func (pw *Wrapper) GetStatus() *WorkingStatus {
 		Err:        pw.Err,
 		Cfg:        pw.Cfg,
 		RemoteAddr: pw.RemoteAddr,
+		CurConns:   pw.stats.curConns.Load(),
+		TotalConns: pw.stats.totalConns.Load(),
+		TrafficIn:  pw.stats.trafficIn.Load(),
+		TrafficOut: pw.stats.trafficOut.Load(),
 	}
 	return ps
 }
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// TrafficStats counts the work connections and the traffic of a proxy since it's added. TrafficIn is the
+// bytes received from frps and TrafficOut is the bytes sent to frps, udp packets carried in QUIC datagrams
+// are counted too.
+type TrafficStats struct {
+	curConns   atomic.Int64
+	totalConns atomic.Int64
+	trafficIn  atomic.Int64
+	trafficOut atomic.Int64
+}
+
+func (s *TrafficStats) AddTrafficIn(n int64) {
+	s.trafficIn.Add(n)
+}
+
+func (s *TrafficStats) AddTrafficOut(n int64) {
+	s.trafficOut.Add(n)
+}
+
+// statsConn counts the traffic of a work connection as it happens, so the status shows the throughput of
+// long connections.
+type statsConn struct {
+	net.Conn
+	stats     *TrafficStats
+	closeOnce sync.Once
+}
+
+func newStatsConn(conn net.Conn, stats *TrafficStats) *statsConn {
+	stats.curConns.Add(1)
+	stats.totalConns.Add(1)
+	return &statsConn{Conn: conn, stats: stats}
+}
+
+func (c *statsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.trafficIn.Add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.trafficOut.Add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stats.curConns.Add(-1)
+	})
+	return c.Conn.Close()
+}
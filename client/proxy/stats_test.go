@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsConn(t *testing.T) {
+	require := require.New(t)
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	stats := &TrafficStats{}
+	conn := newStatsConn(c1, stats)
+	require.EqualValues(1, stats.curConns.Load())
+
+	go func() {
+		_, _ = c2.Write([]byte("hello"))
+		buf := make([]byte, 3)
+		_, _ = c2.Read(buf)
+	}()
+	buf := make([]byte, 5)
+	_, err := conn.Read(buf)
+	require.NoError(err)
+	_, err = conn.Write([]byte("abc"))
+	require.NoError(err)
+	stats.AddTrafficIn(100)
+
+	require.NoError(conn.Close())
+	_ = conn.Close()
+	require.EqualValues(0, stats.curConns.Load())
+	require.EqualValues(1, stats.totalConns.Load())
+	require.EqualValues(105, stats.trafficIn.Load())
+	require.EqualValues(3, stats.trafficOut.Load())
+}
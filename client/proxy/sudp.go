@@ -90,7 +90,7 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 	conn = netpkg.WrapReadWriteCloserToConn(rwc, conn)
 
 	workConn := conn
-	readCh := make(chan *msg.UDPPacket, 1024)
+	readCh := make(chan *udp.Packet, 1024)
 	sendCh := make(chan msg.Message, 1024)
 	isClose := false
 
@@ -112,7 +112,7 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 	}
 
 	// udp service <- frpc <- frps <- frpc visitor <- user
-	workConnReaderFn := func(conn net.Conn, readCh chan *msg.UDPPacket) {
+	workConnReaderFn := func(conn *udp.FramedConn, readCh chan *udp.Packet) {
 		defer closeFn()
 
 		for {
@@ -124,14 +124,18 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 			default:
 			}
 
-			var udpMsg msg.UDPPacket
-			if errRet := msg.ReadMsgInto(conn, &udpMsg); errRet != nil {
+			rawMsg, errRet := conn.ReadMsg()
+			if errRet != nil {
 				xl.Warnf("read from workConn for sudp error: %v", errRet)
 				return
 			}
+			udpMsg, ok := rawMsg.(*udp.Packet)
+			if !ok {
+				continue
+			}
 
 			if errRet := errors.PanicToError(func() {
-				readCh <- &udpMsg
+				readCh <- udpMsg
 			}); errRet != nil {
 				xl.Warnf("reader goroutine for sudp work connection closed: %v", errRet)
 				return
@@ -140,7 +144,7 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 	}
 
 	// udp service -> frpc -> frps -> frpc visitor -> user
-	workConnSenderFn := func(conn net.Conn, sendCh chan msg.Message) {
+	workConnSenderFn := func(conn *udp.FramedConn, sendCh chan msg.Message) {
 		defer func() {
 			closeFn()
 			xl.Infof("writer goroutine for sudp work connection closed")
@@ -149,14 +153,14 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 		var errRet error
 		for rawMsg := range sendCh {
 			switch m := rawMsg.(type) {
-			case *msg.UDPPacket:
-				xl.Tracef("frpc send udp package to frpc visitor, [udp local: %v, remote: %v], [tcp work conn local: %v, remote: %v]",
-					m.LocalAddr.String(), m.RemoteAddr.String(), conn.LocalAddr().String(), conn.RemoteAddr().String())
+			case *udp.Packet:
+				xl.Tracef("frpc send udp package to frpc visitor, [udp remote: %v], [tcp work conn local: %v, remote: %v]",
+					m.RemoteAddr.String(), conn.LocalAddr().String(), conn.RemoteAddr().String())
 			case *msg.Ping:
 				xl.Tracef("frpc send ping message to frpc visitor")
 			}
 
-			if errRet = msg.WriteMsg(conn, rawMsg); errRet != nil {
+			if errRet = conn.WriteMsg(rawMsg); errRet != nil {
 				xl.Errorf("sudp work write error: %v", errRet)
 				return
 			}
@@ -187,8 +191,9 @@ func (pxy *SUDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 		}
 	}
 
-	go workConnSenderFn(workConn, sendCh)
-	go workConnReaderFn(workConn, readCh)
+	framedConn := udp.NewFramedConn(workConn)
+	go workConnSenderFn(framedConn, sendCh)
+	go workConnReaderFn(framedConn, readCh)
 	go heartbeatFn(sendCh)
 
 	udp.Forwarder(pxy.localAddr, readCh, sendCh, int(pxy.clientCfg.UDPPacketSize))
@@ -3,6 +3,7 @@
 package proxy
 
 import (
+	"context"
 	"io"
 	"net"
 	"reflect"
@@ -29,12 +30,16 @@ type UDPProxy struct {
 	cfg *v1.UDPProxyConfig
 
 	localAddr *net.UDPAddr
-	readCh    chan *msg.UDPPacket
+	readCh    chan *udp.Packet
 
-	// include msg.UDPPacket and msg.Ping
+	// include udp.Packet and msg.Ping
 	sendCh   chan msg.Message
 	workConn net.Conn
 	closed   bool
+
+	// datagramMux carries packets in QUIC datagrams of datagramFlowID if frps allocated the flow
+	datagramMux    *udp.DatagramMux
+	datagramFlowID uint32
 }
 
 func NewUDPProxy(baseProxy *BaseProxy, cfg v1.ProxyConfigurer) Proxy {
@@ -71,10 +76,14 @@ func (pxy *UDPProxy) Close() {
 		if pxy.sendCh != nil {
 			close(pxy.sendCh)
 		}
+		if pxy.datagramMux != nil {
+			pxy.datagramMux.Unregister(pxy.datagramFlowID)
+			pxy.datagramMux = nil
+		}
 	}
 }
 
-func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
+func (pxy *UDPProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 	xl := pxy.xl
 	xl.Infof("incoming a new work connection for udp proxy, %s", conn.RemoteAddr().String())
 	// close resources related with old workConn
@@ -102,40 +111,81 @@ func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 
 	pxy.mu.Lock()
 	pxy.workConn = conn
-	pxy.readCh = make(chan *msg.UDPPacket, 1024)
+	pxy.readCh = make(chan *udp.Packet, 1024)
 	pxy.sendCh = make(chan msg.Message, 1024)
 	pxy.closed = false
+	// frps doesn't allocate flows for proxies using encryption or compression, which datagrams skip
+	if m.DatagramFlowID != 0 && !pxy.cfg.Transport.UseEncryption && !pxy.cfg.Transport.UseCompression {
+		pxy.datagramMux = udp.DatagramMuxFromContext(pxy.ctx)
+		pxy.datagramFlowID = m.DatagramFlowID
+	}
+	datagramMux, datagramFlowID := pxy.datagramMux, pxy.datagramFlowID
 	pxy.mu.Unlock()
 
-	workConnReaderFn := func(conn net.Conn, readCh chan *msg.UDPPacket) {
+	if datagramMux != nil {
+		readCh := pxy.readCh
+		// the handler must not block, it's called by the goroutine receiving datagrams of all proxies
+		datagramMux.Register(datagramFlowID, func(p *udp.Packet) {
+			length := len(p.Content)
+			if pxy.limiter != nil && !pxy.limiter.AllowN(time.Now(), length) {
+				xl.Tracef("drop udp datagram exceeding the bandwidth limit, length: %d", length)
+				return
+			}
+			_ = errors.PanicToError(func() {
+				select {
+				case readCh <- p:
+					xl.Tracef("get udp datagram, length: %d", length)
+					pxy.stats.AddTrafficIn(int64(length))
+				default:
+					xl.Tracef("drop udp datagram, too many packets to send to the local service")
+				}
+			})
+		})
+		// frps also knows the flow is registered when it receives other datagrams, in case this one is lost
+		if err := datagramMux.Confirm(datagramFlowID); err != nil {
+			xl.Warnf("confirm datagram flow [%d] error: %v", datagramFlowID, err)
+		}
+	}
+
+	workConnReaderFn := func(conn *udp.FramedConn, readCh chan *udp.Packet) {
 		for {
-			var udpMsg msg.UDPPacket
-			if errRet := msg.ReadMsgInto(conn, &udpMsg); errRet != nil {
+			rawMsg, errRet := conn.ReadMsg()
+			if errRet != nil {
 				xl.Warnf("read from workConn for udp error: %v", errRet)
 				return
 			}
+			udpMsg, ok := rawMsg.(*udp.Packet)
+			if !ok {
+				continue
+			}
 			if errRet := errors.PanicToError(func() {
-				xl.Tracef("get udp package from workConn: %s", udpMsg.Content)
-				readCh <- &udpMsg
+				xl.Tracef("get udp package from workConn, length: %d", len(udpMsg.Content))
+				readCh <- udpMsg
 			}); errRet != nil {
 				xl.Infof("reader goroutine for udp work connection closed: %v", errRet)
 				return
 			}
 		}
 	}
-	workConnSenderFn := func(conn net.Conn, sendCh chan msg.Message) {
+	workConnSenderFn := func(conn *udp.FramedConn, sendCh chan msg.Message) {
 		defer func() {
 			xl.Infof("writer goroutine for udp work connection closed")
 		}()
 		var errRet error
 		for rawMsg := range sendCh {
 			switch m := rawMsg.(type) {
-			case *msg.UDPPacket:
-				xl.Tracef("send udp package to workConn: %s", m.Content)
+			case *udp.Packet:
+				// the packet is released after it's sent
+				if length := len(m.Content); datagramMux != nil && pxy.sendDatagram(datagramMux, datagramFlowID, m) {
+					xl.Tracef("send udp datagram, length: %d", length)
+					pxy.stats.AddTrafficOut(int64(length))
+					continue
+				}
+				xl.Tracef("send udp package to workConn, length: %d", len(m.Content))
 			case *msg.Ping:
 				xl.Tracef("send ping message to udp workConn")
 			}
-			if errRet = msg.WriteMsg(conn, rawMsg); errRet != nil {
+			if errRet = conn.WriteMsg(rawMsg); errRet != nil {
 				xl.Errorf("udp work write error: %v", errRet)
 				return
 			}
@@ -154,8 +204,20 @@ func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 		}
 	}
 
-	go workConnSenderFn(pxy.workConn, pxy.sendCh)
-	go workConnReaderFn(pxy.workConn, pxy.readCh)
+	framedConn := udp.NewFramedConn(pxy.workConn)
+	go workConnSenderFn(framedConn, pxy.sendCh)
+	go workConnReaderFn(framedConn, pxy.readCh)
 	go heartbeatFn(pxy.sendCh)
 	udp.Forwarder(pxy.localAddr, pxy.readCh, pxy.sendCh, int(pxy.clientCfg.UDPPacketSize))
 }
+
+// sendDatagram sends p in a datagram of the flow after waiting for the bandwidth limit. It returns false if
+// p should be sent through the work connection.
+func (pxy *UDPProxy) sendDatagram(m *udp.DatagramMux, flowID uint32, p *udp.Packet) bool {
+	if pxy.limiter != nil {
+		if err := limit.WaitN(context.Background(), pxy.limiter, len(p.Content)); err != nil {
+			return false
+		}
+	}
+	return m.Send(flowID, p)
+}
@@ -80,8 +80,19 @@ func (pxy *XTCPProxy) InWorkConn(conn net.Conn, startWorkConnMsg *msg.StartWorkC
 		natHoleRespMsg.Sid, natHoleRespMsg.Protocol, natHoleRespMsg.CandidateAddrs,
 		natHoleRespMsg.AssistedAddrs, natHoleRespMsg.DetectBehavior)
 
+	// the visitor signs and encrypts with the secret key of its credential if it uses one
+	secretKey := pxy.cfg.Secretkey
+	if natHoleRespMsg.Credential != "" {
+		c, ok := v1.FindVisitorCredential(pxy.cfg.Credentials, natHoleRespMsg.Credential)
+		if !ok {
+			xl.Warnf("unknown credential [%s] of visitor", natHoleRespMsg.Credential)
+			return
+		}
+		secretKey = c.SecretKey
+	}
+
 	listenConn := prepareResult.ListenConn
-	newListenConn, raddr, err := nathole.MakeHole(pxy.ctx, listenConn, natHoleRespMsg, []byte(pxy.cfg.Secretkey))
+	newListenConn, raddr, err := nathole.MakeHole(pxy.ctx, listenConn, natHoleRespMsg, []byte(secretKey))
 	if err != nil {
 		listenConn.Close()
 		xl.Warnf("make hole error: %v", err)
@@ -100,15 +111,15 @@ func (pxy *XTCPProxy) InWorkConn(conn net.Conn, startWorkConnMsg *msg.StartWorkC
 	})
 
 	if natHoleRespMsg.Protocol == "kcp" {
-		pxy.listenByKCP(listenConn, raddr, startWorkConnMsg)
+		pxy.listenByKCP(listenConn, raddr, startWorkConnMsg, secretKey)
 		return
 	}
 
 	// default is quic
-	pxy.listenByQUIC(listenConn, raddr, startWorkConnMsg)
+	pxy.listenByQUIC(listenConn, raddr, startWorkConnMsg, secretKey)
 }
 
-func (pxy *XTCPProxy) listenByKCP(listenConn *net.UDPConn, raddr *net.UDPAddr, startWorkConnMsg *msg.StartWorkConn) {
+func (pxy *XTCPProxy) listenByKCP(listenConn *net.UDPConn, raddr *net.UDPAddr, startWorkConnMsg *msg.StartWorkConn, secretKey string) {
 	xl := pxy.xl
 	listenConn.Close()
 	laddr, _ := net.ResolveUDPAddr("udp", listenConn.LocalAddr().String())
@@ -142,11 +153,11 @@ func (pxy *XTCPProxy) listenByKCP(listenConn *net.UDPConn, raddr *net.UDPAddr, s
 			xl.Errorf("accept connection error: %v", err)
 			return
 		}
-		go pxy.HandleTCPWorkConnection(muxConn, startWorkConnMsg, []byte(pxy.cfg.Secretkey))
+		go pxy.HandleTCPWorkConnection(muxConn, startWorkConnMsg, []byte(secretKey))
 	}
 }
 
-func (pxy *XTCPProxy) listenByQUIC(listenConn *net.UDPConn, _ *net.UDPAddr, startWorkConnMsg *msg.StartWorkConn) {
+func (pxy *XTCPProxy) listenByQUIC(listenConn *net.UDPConn, _ *net.UDPAddr, startWorkConnMsg *msg.StartWorkConn, secretKey string) {
 	xl := pxy.xl
 	defer listenConn.Close()
 
@@ -180,6 +191,6 @@ func (pxy *XTCPProxy) listenByQUIC(listenConn *net.UDPConn, _ *net.UDPAddr, star
 			_ = c.CloseWithError(0, "")
 			return
 		}
-		go pxy.HandleTCPWorkConnection(netpkg.QuicStreamToNetConn(stream, c), startWorkConnMsg, []byte(pxy.cfg.Secretkey))
+		go pxy.HandleTCPWorkConnection(netpkg.QuicStreamToNetConn(stream, c), startWorkConnMsg, []byte(secretKey))
 	}
 }
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/config/v1/validation"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+func (ctl *Control) handlePushProxies(m msg.Message) {
+	xl := ctl.xl
+	inMsg := m.(*msg.PushProxies)
+
+	resp := &msg.PushProxiesResp{
+		TransactionID: inMsg.TransactionID,
+	}
+	if err := ctl.applyPushedProxies(inMsg); err != nil {
+		xl.Warnf("reject proxies pushed by server: %v", err)
+		resp.Error = err.Error()
+	}
+	_ = ctl.msgDispatcher.Send(resp)
+}
+
+// applyPushedProxies validates all proxies and visitors in m against the proxyPush policy.
+// Either all of them are started or none.
+func (ctl *Control) applyPushedProxies(m *msg.PushProxies) error {
+	common := ctl.sessionCtx.Common
+	pushCfg := &common.ProxyPush
+	if !pushCfg.Enable {
+		return errors.New("proxy push is disabled")
+	}
+
+	proxyCfgs := make([]v1.ProxyConfigurer, 0, len(m.Proxies))
+	for _, raw := range m.Proxies {
+		var c v1.TypedProxyConfig
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return err
+		}
+		cfg := c.ProxyConfigurer
+		cfg.Complete(common.User)
+		if err := checkPushedProxy(pushCfg, cfg); err != nil {
+			return fmt.Errorf("proxy [%s]: %v", cfg.GetBaseConfig().Name, err)
+		}
+		proxyCfgs = append(proxyCfgs, cfg)
+	}
+
+	visitorCfgs := make([]v1.VisitorConfigurer, 0, len(m.Visitors))
+	for _, raw := range m.Visitors {
+		var c v1.TypedVisitorConfig
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return err
+		}
+		cfg := c.VisitorConfigurer
+		cfg.Complete(common)
+		if err := checkPushedVisitor(pushCfg, cfg); err != nil {
+			return fmt.Errorf("visitor [%s]: %v", cfg.GetBaseConfig().Name, err)
+		}
+		visitorCfgs = append(visitorCfgs, cfg)
+	}
+
+	ctl.cfgMu.Lock()
+	defer ctl.cfgMu.Unlock()
+	for _, cfg := range proxyCfgs {
+		name := cfg.GetBaseConfig().Name
+		if slices.ContainsFunc(ctl.proxyCfgs, func(c v1.ProxyConfigurer) bool { return c.GetBaseConfig().Name == name }) {
+			return fmt.Errorf("proxy [%s] is already defined in the configuration", name)
+		}
+	}
+	for _, cfg := range visitorCfgs {
+		name := cfg.GetBaseConfig().Name
+		if slices.ContainsFunc(ctl.visitorCfgs, func(c v1.VisitorConfigurer) bool { return c.GetBaseConfig().Name == name }) {
+			return fmt.Errorf("visitor [%s] is already defined in the configuration", name)
+		}
+	}
+
+	for _, cfg := range proxyCfgs {
+		ctl.pushedProxyCfgs[cfg.GetBaseConfig().Name] = cfg
+	}
+	for _, cfg := range visitorCfgs {
+		ctl.pushedVisitorCfgs[cfg.GetBaseConfig().Name] = cfg
+	}
+	ctl.updateAllLocked()
+	ctl.xl.Infof("start %d proxies and %d visitors pushed by server", len(proxyCfgs), len(visitorCfgs))
+	return nil
+}
+
+func checkPushedProxy(pushCfg *v1.ProxyPushConfig, cfg v1.ProxyConfigurer) error {
+	base := cfg.GetBaseConfig()
+	if !pushCfg.IsTypeAllowed(base.Type) {
+		return fmt.Errorf("proxy type [%s] is not allowed", base.Type)
+	}
+	if base.Plugin.ClientPluginOptions != nil {
+		return errors.New("client plugins are not allowed")
+	}
+	if !pushCfg.IsLocalTargetAllowed(base.LocalIP, base.LocalPort) {
+		return fmt.Errorf("local target [%s:%d] is not allowed", base.LocalIP, base.LocalPort)
+	}
+	return validation.ValidateProxyConfigurerForClient(cfg)
+}
+
+func checkPushedVisitor(pushCfg *v1.ProxyPushConfig, cfg v1.VisitorConfigurer) error {
+	base := cfg.GetBaseConfig()
+	if !pushCfg.IsTypeAllowed(base.Type) {
+		return fmt.Errorf("visitor type [%s] is not allowed", base.Type)
+	}
+	if base.BindPort > 0 && !pushCfg.IsLocalTargetAllowed(base.BindAddr, base.BindPort) {
+		return fmt.Errorf("bind address [%s:%d] is not allowed", base.BindAddr, base.BindPort)
+	}
+	return validation.ValidateVisitorConfigurer(cfg)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"runtime"
@@ -82,6 +83,12 @@ type ServiceOptions struct {
 	//
 	// If it is not set, the default frpc implementation will be used.
 	HandleWorkConnCb func(*v1.ProxyBaseConfig, net.Conn, *msg.StartWorkConn) bool
+
+	// LogHandler receives all logs of the service as structured records, with run ID, proxy name and
+	// proxy type attached as attributes.
+	//
+	// If it is not set, logs are written by the global logger.
+	LogHandler slog.Handler
 }
 
 // setServiceOptionsDefault sets the default values for ServiceOptions.
@@ -96,6 +103,9 @@ func setServiceOptionsDefault(options *ServiceOptions) {
 
 // Service is the client service that connects to frps and provides proxy services.
 type Service struct {
+	// root logger of the service, it's used before Run and by admin apis
+	xl *xlog.Logger
+
 	ctlMu sync.RWMutex
 	// manager control connection with server
 	ctl *Control
@@ -107,6 +117,8 @@ type Service struct {
 
 	// web server for admin UI and apis
 	webServer *httppkg.Server
+	// read-only server of the status of proxies
+	statusServer *httppkg.Server
 
 	cfgMu       sync.RWMutex
 	common      *v1.ClientCommonConfig
@@ -132,17 +144,32 @@ func NewService(options ServiceOptions) (*Service, error) {
 	setServiceOptionsDefault(&options)
 
 	var webServer *httppkg.Server
-	if options.Common.WebServer.Port > 0 {
+	if options.Common.EnableAdminServer && options.Common.WebServer.Port > 0 {
 		ws, err := httppkg.NewServer(options.Common.WebServer)
 		if err != nil {
 			return nil, err
 		}
 		webServer = ws
 	}
+	var statusServer *httppkg.Server
+	if options.Common.StatusServer.Port > 0 {
+		ss, err := httppkg.NewServer(v1.WebServerConfig{
+			Addr:     options.Common.StatusServer.Addr,
+			Port:     options.Common.StatusServer.Port,
+			User:     options.Common.StatusServer.User,
+			Password: options.Common.StatusServer.Password,
+		})
+		if err != nil {
+			return nil, err
+		}
+		statusServer = ss
+	}
 	s := &Service{
+		xl:               xlog.New().SetHandler(options.LogHandler),
 		ctx:              context.Background(),
 		authSetter:       auth.NewAuthSetter(options.Common.Auth),
 		webServer:        webServer,
+		statusServer:     statusServer,
 		common:           options.Common,
 		configFilePath:   options.ConfigFilePath,
 		proxyCfgs:        options.ProxyCfgs,
@@ -154,12 +181,19 @@ func NewService(options ServiceOptions) (*Service, error) {
 	if webServer != nil {
 		webServer.RouteRegister(s.registerRouteHandlers)
 	}
+	if statusServer != nil {
+		statusServer.RouteRegister(s.registerStatusRouteHandlers)
+	}
 	return s, nil
 }
 
 func (svr *Service) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancelCause(ctx)
-	svr.ctx = xlog.NewContext(ctx, xlog.FromContextSafe(ctx))
+	xl := xlog.FromContextSafe(ctx)
+	if h := svr.xl.Handler(); h != nil {
+		xl = xl.Spawn().SetHandler(h)
+	}
+	svr.ctx = xlog.NewContext(ctx, xl)
 	svr.cancel = cancel
 
 	// set custom DNSServer
@@ -167,6 +201,25 @@ func (svr *Service) Run(ctx context.Context) error {
 		netpkg.SetDefaultDNSAddress(svr.common.DNSServer)
 	}
 
+	if svr.webServer != nil {
+		go func() {
+			xl.Infof("admin server listen on %s", svr.webServer.Address())
+			if err := svr.webServer.Run(); err != nil {
+				xl.Warnf("admin server exit with error: %v", err)
+			}
+		}()
+	}
+
+	// 运行只读的状态服务器，frpc top 依赖它。
+	if svr.statusServer != nil {
+		go func() {
+			xl.Infof("status server listen on %s", svr.statusServer.Address())
+			if err := svr.statusServer.Run(); err != nil {
+				xl.Warnf("status server exit with error: %v", err)
+			}
+		}()
+	}
+
 	// first login to frps
 	svr.loopLoginUntilSuccess(10*time.Second, lo.FromPtr(svr.common.LoginFailExit))
 	if svr.ctl == nil {
@@ -176,6 +229,9 @@ func (svr *Service) Run(ctx context.Context) error {
 	}
 
 	go svr.keepControllerWorking()
+	if svr.common.ConfigWatch.Enable && svr.configFilePath != "" {
+		go svr.watchConfig()
+	}
 
 	<-svr.ctx.Done()
 	svr.stop()
@@ -240,6 +296,13 @@ func (svr *Service) login() (conn net.Conn, connector Connector, err error) {
 		Timestamp: time.Now().Unix(),
 		RunID:     svr.runID,
 		Metas:     svr.common.Metadatas,
+		Features:  []string{msg.FeatureQuotaNotify},
+	}
+	if svr.common.ProxyPush.Enable {
+		loginMsg.Features = append(loginMsg.Features, msg.FeatureProxyPush)
+	}
+	if quicConn, ok := netpkg.QuicConnFromConn(conn); ok && quicConn.ConnectionState().SupportsDatagrams {
+		loginMsg.Features = append(loginMsg.Features, msg.FeatureQUICDatagram)
 	}
 	if svr.clientSpec != nil {
 		loginMsg.ClientSpec = *svr.clientSpec
@@ -349,6 +412,28 @@ func (svr *Service) UpdateAllConfigurer(proxyCfgs []v1.ProxyConfigurer, visitorC
 	return nil
 }
 
+// IsVisitorRunning returns whether the visitor has been started after the client logged in.
+func (svr *Service) IsVisitorRunning(name string) bool {
+	svr.ctlMu.RLock()
+	ctl := svr.ctl
+	svr.ctlMu.RUnlock()
+
+	return ctl != nil && ctl.vm.IsRunning(name)
+}
+
+// TransferVisitorConn transfers conn to the visitor, so the visitor forwards it like the connections accepted
+// by itself.
+func (svr *Service) TransferVisitorConn(name string, conn net.Conn) error {
+	svr.ctlMu.RLock()
+	ctl := svr.ctl
+	svr.ctlMu.RUnlock()
+
+	if ctl == nil {
+		return fmt.Errorf("client hasn't logged in")
+	}
+	return ctl.vm.TransferConn(name, conn)
+}
+
 func (svr *Service) Close() {
 	svr.GracefulClose(time.Duration(0))
 }
@@ -365,6 +450,12 @@ func (svr *Service) stop() {
 		svr.ctl.GracefulClose(svr.gracefulShutdownDuration)
 		svr.ctl = nil
 	}
+	if svr.webServer != nil {
+		_ = svr.webServer.Close()
+	}
+	if svr.statusServer != nil {
+		_ = svr.statusServer.Close()
+	}
 }
 
 func (svr *Service) getProxyStatus(name string) (*proxy.WorkingStatus, bool) {
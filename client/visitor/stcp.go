@@ -94,6 +94,7 @@ func (sv *STCPVisitor) handleConn(userConn net.Conn) {
 		Timestamp:      now,
 		UseEncryption:  sv.cfg.Transport.UseEncryption,
 		UseCompression: sv.cfg.Transport.UseCompression,
+		Credential:     sv.cfg.Credential,
 	}
 	err = msg.WriteMsg(visitorConn, newVisitorConnMsg)
 	if err != nil {
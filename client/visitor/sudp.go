@@ -39,8 +39,8 @@ type SUDPVisitor struct {
 	checkCloseCh chan struct{}
 	// udpConn is the listener of udp packet
 	udpConn *net.UDPConn
-	readCh  chan *msg.UDPPacket
-	sendCh  chan *msg.UDPPacket
+	readCh  chan *udp.Packet
+	sendCh  chan *udp.Packet
 
 	cfg *v1.SUDPVisitorConfig
 }
@@ -59,8 +59,8 @@ func (sv *SUDPVisitor) Run() (err error) {
 		return fmt.Errorf("listen udp port %s error: %v", addr.String(), err)
 	}
 
-	sv.sendCh = make(chan *msg.UDPPacket, 1024)
-	sv.readCh = make(chan *msg.UDPPacket, 1024)
+	sv.sendCh = make(chan *udp.Packet, 1024)
+	sv.readCh = make(chan *udp.Packet, 1024)
 
 	xl.Infof("sudp start to work, listen on %s", addr)
 
@@ -77,7 +77,7 @@ func (sv *SUDPVisitor) dispatcher() {
 		visitorConn net.Conn
 		err         error
 
-		firstPacket *msg.UDPPacket
+		firstPacket *udp.Packet
 	)
 
 	for {
@@ -109,7 +109,7 @@ func (sv *SUDPVisitor) dispatcher() {
 	}
 }
 
-func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
+func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *udp.Packet) {
 	xl := xlog.FromContextSafe(sv.ctx)
 	xl.Tracef("starting sudp proxy worker")
 
@@ -118,7 +118,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 	closeCh := make(chan struct{})
 
 	// udp service -> frpc -> frps -> frpc visitor -> user
-	workConnReaderFn := func(conn net.Conn) {
+	workConnReaderFn := func(conn *udp.FramedConn) {
 		defer func() {
 			conn.Close()
 			close(closeCh)
@@ -133,7 +133,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 
 			// frpc will send heartbeat in workConn to frpc visitor for keeping alive
 			_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-			if rawMsg, errRet = msg.ReadMsg(conn); errRet != nil {
+			if rawMsg, errRet = conn.ReadMsg(); errRet != nil {
 				xl.Warnf("read from workconn for user udp conn error: %v", errRet)
 				return
 			}
@@ -143,10 +143,10 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 			case *msg.Ping:
 				xl.Tracef("frpc visitor get ping message from frpc")
 				continue
-			case *msg.UDPPacket:
+			case *udp.Packet:
 				if errRet := errors.PanicToError(func() {
+					xl.Tracef("frpc visitor get udp packet from workConn, length: %d", len(m.Content))
 					sv.readCh <- m
-					xl.Tracef("frpc visitor get udp packet from workConn: %s", m.Content)
 				}); errRet != nil {
 					xl.Infof("reader goroutine for udp work connection closed")
 					return
@@ -156,7 +156,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 	}
 
 	// udp service <- frpc <- frps <- frpc visitor <- user
-	workConnSenderFn := func(conn net.Conn) {
+	workConnSenderFn := func(conn *udp.FramedConn) {
 		defer func() {
 			conn.Close()
 			wg.Done()
@@ -164,11 +164,11 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 
 		var errRet error
 		if firstPacket != nil {
-			if errRet = msg.WriteMsg(conn, firstPacket); errRet != nil {
+			xl.Tracef("send udp package to workConn, length: %d", len(firstPacket.Content))
+			if errRet = conn.WriteMsg(firstPacket); errRet != nil {
 				xl.Warnf("sender goroutine for udp work connection closed: %v", errRet)
 				return
 			}
-			xl.Tracef("send udp package to workConn: %s", firstPacket.Content)
 		}
 
 		for {
@@ -179,19 +179,20 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 					return
 				}
 
-				if errRet = msg.WriteMsg(conn, udpMsg); errRet != nil {
+				xl.Tracef("send udp package to workConn, length: %d", len(udpMsg.Content))
+				if errRet = conn.WriteMsg(udpMsg); errRet != nil {
 					xl.Warnf("sender goroutine for udp work connection closed: %v", errRet)
 					return
 				}
-				xl.Tracef("send udp package to workConn: %s", udpMsg.Content)
 			case <-closeCh:
 				return
 			}
 		}
 	}
 
-	go workConnReaderFn(workConn)
-	go workConnSenderFn(workConn)
+	framedConn := udp.NewFramedConn(workConn)
+	go workConnReaderFn(framedConn)
+	go workConnSenderFn(framedConn)
 
 	wg.Wait()
 	xl.Infof("sudp worker is closed")
@@ -212,6 +213,7 @@ func (sv *SUDPVisitor) getNewVisitorConn() (net.Conn, error) {
 		Timestamp:      now,
 		UseEncryption:  sv.cfg.Transport.UseEncryption,
 		UseCompression: sv.cfg.Transport.UseCompression,
+		Credential:     sv.cfg.Credential,
 	}
 	err = msg.WriteMsg(visitorConn, newVisitorConnMsg)
 	if err != nil {
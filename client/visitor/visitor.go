@@ -51,7 +51,9 @@ func NewVisitor(
 	clientCfg *v1.ClientCommonConfig,
 	helper Helper,
 ) (visitor Visitor) {
-	xl := xlog.FromContextSafe(ctx).Spawn().AppendPrefix(cfg.GetBaseConfig().Name)
+	xl := xlog.FromContextSafe(ctx).Spawn().
+		AddPrefix(xlog.LogPrefix{Name: "visitorName", Value: cfg.GetBaseConfig().Name}).
+		AddPrefix(xlog.LogPrefix{Name: "visitorType", Value: cfg.GetBaseConfig().Type, Hidden: true})
 	baseVisitor := BaseVisitor{
 		clientCfg:  clientCfg,
 		helper:     helper,
@@ -184,6 +184,14 @@ func (vm *Manager) TransferConn(name string, conn net.Conn) error {
 	return v.AcceptConn(conn)
 }
 
+// IsRunning returns whether the visitor has been started.
+func (vm *Manager) IsRunning(name string) bool {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+	_, ok := vm.visitors[name]
+	return ok
+}
+
 type visitorHelperImpl struct {
 	connectServerFn func() (net.Conn, error)
 	msgTransporter  transport.MessageTransporter
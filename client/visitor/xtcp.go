@@ -296,6 +296,7 @@ func (sv *XTCPVisitor) makeNatHole() {
 		Timestamp:     now,
 		MappedAddrs:   prepareResult.Addrs,
 		AssistedAddrs: prepareResult.AssistedAddrs,
+		Credential:    sv.cfg.Credential,
 	}
 
 	xl.Tracef("nathole exchange info start")
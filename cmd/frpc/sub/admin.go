@@ -47,6 +47,10 @@ func NewAdminCommand(name, short string, handler func(*v1.ClientCommonConfig) er
 				fmt.Println("web server port should be set if you want to use this feature")
 				os.Exit(1)
 			}
+			if !cfg.EnableAdminServer {
+				fmt.Println("enableAdminServer should be true if you want to use this feature")
+				os.Exit(1)
+			}
 
 			if err := handler(cfg); err != nil {
 				fmt.Println(err)
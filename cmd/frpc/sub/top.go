@@ -0,0 +1,126 @@
+package sub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/iami317/hepx/client"
+	"github.com/iami317/hepx/pkg/config"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	clientsdk "github.com/iami317/hepx/pkg/sdk/client"
+	"github.com/iami317/hepx/pkg/util/util"
+)
+
+var topInterval time.Duration
+
+func init() {
+	topCmd := &cobra.Command{
+		Use:   "top",
+		Short: "实时显示所有代理的状态和流量",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, _, _, err := config.LoadClientConfig(cfgFile, strictConfigMode)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			// top only needs the read-only status server, the admin api isn't required
+			if cfg.StatusServer.Port <= 0 {
+				fmt.Println("status server port should be set if you want to use this feature")
+				os.Exit(1)
+			}
+
+			if err := TopHandler(cfg); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+	topCmd.Flags().DurationVarP(&topInterval, "interval", "", 2*time.Second, "刷新间隔")
+	rootCmd.AddCommand(topCmd)
+}
+
+// trafficSample is the traffic of a proxy at a time, throughput is computed from two samples.
+type trafficSample struct {
+	in, out int64
+	at      time.Time
+}
+
+// TopHandler polls /api/status of the status server and redraws the status of all proxies until it's
+// interrupted.
+func TopHandler(clientCfg *v1.ClientCommonConfig) error {
+	if topInterval <= 0 {
+		return fmt.Errorf("interval should be greater than 0")
+	}
+	client := clientsdk.New(clientCfg.StatusServer.Addr, clientCfg.StatusServer.Port)
+	client.SetAuth(clientCfg.StatusServer.User, clientCfg.StatusServer.Password)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+
+	samples := make(map[string]trafficSample)
+	for {
+		res, err := client.GetAllProxyStatus()
+		now := time.Now()
+
+		var b bytes.Buffer
+		// move to the top left and clear the screen
+		b.WriteString("\x1b[H\x1b[2J")
+		fmt.Fprintf(&b, "frpc top - %s, refresh every %s (Ctrl+C to quit)\n\n", now.Format("15:04:05"), topInterval)
+		if err != nil {
+			fmt.Fprintf(&b, "get proxy status error: %v\n", err)
+		} else {
+			samples = renderTop(&b, res, samples, now)
+		}
+		_, _ = os.Stdout.Write(b.Bytes())
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderTop writes the status of the proxies grouped by type, the throughput is computed from the previous
+// samples. It returns the samples of this time.
+func renderTop(b *bytes.Buffer, res client.StatusResp, prev map[string]trafficSample, now time.Time) map[string]trafficSample {
+	samples := make(map[string]trafficSample)
+	for _, typ := range proxyTypes {
+		arrs := res[string(typ)]
+		if len(arrs) == 0 {
+			continue
+		}
+
+		b.WriteString(strings.ToUpper(string(typ)) + "\n")
+		tbl := table.New("Name", "Status", "RemoteAddr", "Conns", "In/s", "Out/s", "TotalIn", "TotalOut", "Error").
+			WithWriter(b)
+		for _, ps := range arrs {
+			inRate, outRate := "-", "-"
+			if p, ok := prev[ps.Name]; ok && now.After(p.at) && ps.TrafficIn >= p.in && ps.TrafficOut >= p.out {
+				seconds := now.Sub(p.at).Seconds()
+				inRate = util.FormatBytes(int64(float64(ps.TrafficIn-p.in)/seconds)) + "/s"
+				outRate = util.FormatBytes(int64(float64(ps.TrafficOut-p.out)/seconds)) + "/s"
+			}
+			samples[ps.Name] = trafficSample{in: ps.TrafficIn, out: ps.TrafficOut, at: now}
+
+			tbl.AddRow(ps.Name, ps.Status, ps.RemoteAddr, ps.CurConns, inRate, outRate,
+				util.FormatBytes(ps.TrafficIn), util.FormatBytes(ps.TrafficOut), ps.Err)
+		}
+		tbl.Print()
+		b.WriteString("\n")
+	}
+	return samples
+}
@@ -7,8 +7,8 @@ import (
 	_ "github.com/iami317/hepx/assets/frps"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	_ "github.com/iami317/hepx/pkg/metrics"
-	"github.com/iami317/hepx/pkg/msg"
 	"github.com/iami317/hepx/server"
+	"github.com/iami317/hepx/server/event"
 )
 
 func main() {
@@ -24,9 +24,10 @@ func main() {
 		return
 	}
 
-	svr.OnLoginFn = func(ctx context.Context, loginMsg *msg.NewProxy) {
-		fmt.Println("----------------", loginMsg.String())
-	}
+	sub := svr.SubscribeFunc(0, func(e *event.Event) {
+		fmt.Println("----------------", e.Type, e.RunID, e.ProxyName, e.RemoteAddr)
+	}, event.TypeClientLogin, event.TypeProxyRegistered, event.TypeProxyClosed)
+	defer sub.Close()
 	logx.Verbosef("frps started successfully")
 	svr.Run(context.Background())
 	return
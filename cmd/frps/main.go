@@ -21,6 +21,8 @@ import (
 	"github.com/iami317/hepx/pkg/config"
 	"github.com/iami317/hepx/pkg/util/system"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
@@ -28,6 +30,7 @@ import (
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/config/v1/validation"
 	_ "github.com/iami317/hepx/pkg/metrics"
+	"github.com/iami317/hepx/pkg/util/xlog"
 	"github.com/iami317/hepx/server"
 )
 
@@ -97,16 +100,30 @@ func Execute() {
 	}
 }
 
+// handleReloadSignal reloads the config file when frps receives SIGHUP.
+func handleReloadSignal(svr *server.Service) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		// the result and errors are logged by the service
+		_, _ = svr.Reload()
+	}
+}
+
 func runServer(cfg *v1.ServerConfig) (err error) {
 	if cfgFile != "" {
 		logx.Verbosef("frps uses config file: %s", cfgFile)
 	} else {
 		logx.Verbosef("frps uses command line arguments for config")
 	}
-	svr, err := server.NewService(cfg)
+	xlog.SetLevel(cfg.Log.Level)
+	svr, err := server.NewService(cfg, server.WithConfigFile(cfgFile, strictConfigMode))
 	if err != nil {
 		return err
 	}
+	if cfgFile != "" {
+		go handleReloadSignal(svr)
+	}
 	logx.Verbosef("frps started successfully")
 	svr.Run(context.Background())
 	return
@@ -15,6 +15,7 @@
 package auth
 
 import (
+	"crypto/tls"
 	"fmt"
 
 	v1 "github.com/iami317/hepx/pkg/config/v1"
@@ -33,6 +34,10 @@ func NewAuthSetter(cfg v1.AuthClientConfig) (authProvider Setter) {
 		authProvider = NewTokenAuth(cfg.AdditionalScopes, cfg.Token)
 	case v1.AuthMethodOIDC:
 		authProvider = NewOidcAuthSetter(cfg.AdditionalScopes, cfg.OIDC)
+	case v1.AuthMethodMTLS:
+		authProvider = &MTLSAuthSetter{}
+	case v1.AuthMethodJWT:
+		authProvider = NewJWTAuthSetter(cfg.AdditionalScopes, cfg.JWT)
 	default:
 		panic(fmt.Sprintf("wrong method: '%s'", cfg.Method))
 	}
@@ -45,12 +50,38 @@ type Verifier interface {
 	VerifyNewWorkConn(*msg.NewWorkConn) error
 }
 
-func NewAuthVerifier(cfg v1.AuthServerConfig) (authVerifier Verifier) {
+// TLSVerifier is implemented by verifiers which authenticate clients by the TLS connections of their logins
+// and work connections, VerifyLoginTLS and VerifyNewWorkConnTLS are called instead of VerifyLogin and
+// VerifyNewWorkConn for them. The state is nil if the connection isn't TLS.
+type TLSVerifier interface {
+	VerifyLoginTLS(*msg.Login, *tls.ConnectionState) error
+	// VerifyNewWorkConnTLS verifies a work connection of the client which logged in with the login.
+	VerifyNewWorkConnTLS(*msg.Login, *msg.NewWorkConn, *tls.ConnectionState) error
+}
+
+// ProxyVerifier is implemented by verifiers which limit the proxies registered by clients.
+type ProxyVerifier interface {
+	VerifyNewProxy(*msg.Login, *msg.NewProxy) error
+}
+
+func NewAuthVerifier(cfg v1.AuthServerConfig) (Verifier, error) {
 	switch cfg.Method {
 	case v1.AuthMethodToken:
-		authVerifier = NewTokenAuth(cfg.AdditionalScopes, cfg.Token)
+		return NewTokenAuth(cfg.AdditionalScopes, cfg.Token), nil
 	case v1.AuthMethodOIDC:
-		authVerifier = NewOidcAuthVerifier(cfg.AdditionalScopes, cfg.OIDC)
+		oidcVerifier, err := NewOidcAuthVerifier(cfg.AdditionalScopes, cfg.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		return oidcVerifier, nil
+	case v1.AuthMethodMTLS:
+		return NewMTLSAuthVerifier(cfg.MTLS), nil
+	case v1.AuthMethodJWT:
+		jwtVerifier, err := NewJWTAuthVerifier(cfg.AdditionalScopes, cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		return jwtVerifier, nil
 	}
-	return authVerifier
+	return nil, fmt.Errorf("wrong method: '%s'", cfg.Method)
 }
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/iami317/hepx/pkg/config/types"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+// JWTAuthSetter sends a signed token issued in advance, it's read from the token file every time so it can
+// be renewed without restarting frpc.
+type JWTAuthSetter struct {
+	additionalAuthScopes []v1.AuthScope
+
+	token     string
+	tokenFile string
+}
+
+func NewJWTAuthSetter(additionalAuthScopes []v1.AuthScope, cfg v1.AuthJWTClientConfig) *JWTAuthSetter {
+	return &JWTAuthSetter{
+		additionalAuthScopes: additionalAuthScopes,
+		token:                cfg.Token,
+		tokenFile:            cfg.TokenFile,
+	}
+}
+
+func (auth *JWTAuthSetter) getToken() (string, error) {
+	if auth.tokenFile == "" {
+		return auth.token, nil
+	}
+	b, err := os.ReadFile(auth.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read JWT token file: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (auth *JWTAuthSetter) SetLogin(loginMsg *msg.Login) (err error) {
+	loginMsg.PrivilegeKey, err = auth.getToken()
+	return err
+}
+
+func (auth *JWTAuthSetter) SetPing(pingMsg *msg.Ping) (err error) {
+	if !slices.Contains(auth.additionalAuthScopes, v1.AuthScopeHeartBeats) {
+		return nil
+	}
+
+	pingMsg.PrivilegeKey, err = auth.getToken()
+	return err
+}
+
+func (auth *JWTAuthSetter) SetNewWorkConn(newWorkConnMsg *msg.NewWorkConn) (err error) {
+	if !slices.Contains(auth.additionalAuthScopes, v1.AuthScopeNewWorkConns) {
+		return nil
+	}
+
+	newWorkConnMsg.PrivilegeKey, err = auth.getToken()
+	return err
+}
+
+// JWTClaims are the claims of a token which limit the proxies of the client. Empty claims don't limit
+// anything. The token must also have the "exp" claim.
+type JWTClaims struct {
+	// Subject is the frp user the token is issued to, it's required and must be the user of the login.
+	Subject string `json:"sub"`
+	// ProxyTypes are the allowed proxy types.
+	ProxyTypes []string `json:"proxyTypes,omitempty"`
+	// AllowPorts are the allowed remote ports of tcp and udp proxies.
+	AllowPorts []types.PortsRange `json:"allowPorts,omitempty"`
+	// Subdomains are the allowed subdomains, proxies with custom domains are rejected if it's set.
+	Subdomains []string `json:"subdomains,omitempty"`
+}
+
+// JWTAuthVerifier verifies tokens signed with Ed25519 or RS256 by the keys of a local JWKS file, no issuer
+// needs to be reachable.
+type JWTAuthVerifier struct {
+	additionalAuthScopes []v1.AuthScope
+
+	verifier *oidc.IDTokenVerifier
+}
+
+var _ ProxyVerifier = &JWTAuthVerifier{}
+
+func NewJWTAuthVerifier(additionalAuthScopes []v1.AuthScope, cfg v1.AuthJWTServerConfig) (*JWTAuthVerifier, error) {
+	keys, err := loadJWKSFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	keySet := &oidc.StaticKeySet{PublicKeys: keys}
+	verifierConf := &oidc.Config{
+		ClientID:             cfg.Audience,
+		SupportedSigningAlgs: []string{oidc.EdDSA, oidc.RS256},
+		SkipClientIDCheck:    cfg.Audience == "",
+		SkipIssuerCheck:      cfg.Issuer == "",
+	}
+	return &JWTAuthVerifier{
+		additionalAuthScopes: additionalAuthScopes,
+		verifier:             oidc.NewVerifier(cfg.Issuer, keySet, verifierConf),
+	}, nil
+}
+
+func loadJWKSFile(path string) ([]crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS file error: %v", err)
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, fmt.Errorf("parse JWKS file error: %v", err)
+	}
+
+	keys := make([]crypto.PublicKey, 0, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if !k.Valid() || !k.IsPublic() {
+			return nil, fmt.Errorf("invalid public key [%s] in JWKS file", k.KeyID)
+		}
+		keys = append(keys, k.Key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in JWKS file")
+	}
+	return keys, nil
+}
+
+func (auth *JWTAuthVerifier) verify(token string) (*JWTClaims, error) {
+	idToken, err := auth.verifier.Verify(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+	claims := &JWTClaims{}
+	if err := idToken.Claims(claims); err != nil {
+		return nil, fmt.Errorf("parse claims error: %v", err)
+	}
+	return claims, nil
+}
+
+// verifyLogin verifies the token of the login, which must be issued to the user of the login.
+func (auth *JWTAuthVerifier) verifyLogin(loginMsg *msg.Login) (*JWTClaims, error) {
+	claims, err := auth.verify(loginMsg.PrivilegeKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("sub claim is required")
+	}
+	if claims.Subject != loginMsg.User {
+		return nil, fmt.Errorf("token is issued to user [%s], not [%s]", claims.Subject, loginMsg.User)
+	}
+	return claims, nil
+}
+
+func (auth *JWTAuthVerifier) VerifyLogin(loginMsg *msg.Login) error {
+	if _, err := auth.verifyLogin(loginMsg); err != nil {
+		return fmt.Errorf("invalid JWT token in login: %v", err)
+	}
+	return nil
+}
+
+func (auth *JWTAuthVerifier) VerifyPing(pingMsg *msg.Ping) error {
+	if !slices.Contains(auth.additionalAuthScopes, v1.AuthScopeHeartBeats) {
+		return nil
+	}
+
+	if _, err := auth.verify(pingMsg.PrivilegeKey); err != nil {
+		return fmt.Errorf("invalid JWT token in ping: %v", err)
+	}
+	return nil
+}
+
+func (auth *JWTAuthVerifier) VerifyNewWorkConn(newWorkConnMsg *msg.NewWorkConn) error {
+	if !slices.Contains(auth.additionalAuthScopes, v1.AuthScopeNewWorkConns) {
+		return nil
+	}
+
+	if _, err := auth.verify(newWorkConnMsg.PrivilegeKey); err != nil {
+		return fmt.Errorf("invalid JWT token in NewWorkConn: %v", err)
+	}
+	return nil
+}
+
+// VerifyNewProxy checks the proxy against the claims of the login token. The token is verified again, so
+// clients can't register new proxies after it expires.
+func (auth *JWTAuthVerifier) VerifyNewProxy(loginMsg *msg.Login, pxyMsg *msg.NewProxy) error {
+	claims, err := auth.verifyLogin(loginMsg)
+	if err != nil {
+		return fmt.Errorf("invalid JWT token: %v", err)
+	}
+	return claims.verifyNewProxy(pxyMsg)
+}
+
+func (c *JWTClaims) verifyNewProxy(pxyMsg *msg.NewProxy) error {
+	if len(c.ProxyTypes) > 0 && !slices.Contains(c.ProxyTypes, pxyMsg.ProxyType) {
+		return fmt.Errorf("proxy type [%s] is not allowed by token", pxyMsg.ProxyType)
+	}
+
+	if len(c.AllowPorts) > 0 && (pxyMsg.ProxyType == string(v1.ProxyTypeTCP) || pxyMsg.ProxyType == string(v1.ProxyTypeUDP)) {
+		// random ports are allocated from allowPorts of frps, which may be out of the claims
+		if pxyMsg.RemotePort == 0 {
+			return fmt.Errorf("remote port must be specified in the ports %s allowed by token", types.PortsRangeSlice(c.AllowPorts))
+		}
+		if !portAllowed(c.AllowPorts, pxyMsg.RemotePort) {
+			return fmt.Errorf("remote port [%d] is not allowed by token", pxyMsg.RemotePort)
+		}
+	}
+
+	if len(c.Subdomains) > 0 {
+		if len(pxyMsg.CustomDomains) > 0 {
+			return fmt.Errorf("custom domains are not allowed by token")
+		}
+		if pxyMsg.SubDomain != "" && !slices.Contains(c.Subdomains, pxyMsg.SubDomain) {
+			return fmt.Errorf("subdomain [%s] is not allowed by token", pxyMsg.SubDomain)
+		}
+	}
+	return nil
+}
+
+func portAllowed(ranges []types.PortsRange, port int) bool {
+	for _, r := range ranges {
+		if r.Single > 0 && r.Single == port {
+			return true
+		}
+		if r.Start > 0 && port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/config/types"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+func TestJWTAuthVerifier(t *testing.T) {
+	require := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: pub, KeyID: "k1", Algorithm: string(jose.EdDSA)}}})
+	require.NoError(err)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(os.WriteFile(jwksFile, jwks, 0o600))
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: priv}, nil)
+	require.NoError(err)
+	sign := func(claims map[string]any) string {
+		payload, err := json.Marshal(claims)
+		require.NoError(err)
+		jws, err := signer.Sign(payload)
+		require.NoError(err)
+		token, err := jws.CompactSerialize()
+		require.NoError(err)
+		return token
+	}
+
+	v, err := NewJWTAuthVerifier(nil, v1.AuthJWTServerConfig{JWKSFile: jwksFile, Issuer: "frp-admin"})
+	require.NoError(err)
+
+	token := sign(map[string]any{
+		"iss":        "frp-admin",
+		"sub":        "alice",
+		"exp":        time.Now().Add(time.Hour).Unix(),
+		"proxyTypes": []string{"tcp", "http"},
+		"allowPorts": []types.PortsRange{{Start: 6000, End: 6010}},
+		"subdomains": []string{"app"},
+	})
+	login := &msg.Login{User: "alice", PrivilegeKey: token}
+	require.NoError(v.VerifyLogin(login))
+	// the token can't be used to log in as other users
+	require.ErrorContains(v.VerifyLogin(&msg.Login{User: "bob", PrivilegeKey: token}), "issued to user [alice]")
+	require.ErrorContains(v.VerifyNewProxy(&msg.Login{User: "bob", PrivilegeKey: token},
+		&msg.NewProxy{ProxyType: "tcp", RemotePort: 6005}), "issued to user [alice]")
+	noSubject := sign(map[string]any{"iss": "frp-admin", "exp": time.Now().Add(time.Hour).Unix()})
+	require.ErrorContains(v.VerifyLogin(&msg.Login{PrivilegeKey: noSubject}), "sub claim is required")
+
+	require.NoError(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "tcp", RemotePort: 6005}))
+	require.ErrorContains(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "tcp", RemotePort: 7000}), "not allowed")
+	require.ErrorContains(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "tcp"}), "must be specified")
+	require.ErrorContains(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "udp", RemotePort: 6005}), "proxy type")
+	require.NoError(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "http", SubDomain: "app"}))
+	require.Error(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "http", SubDomain: "other"}))
+	require.Error(v.VerifyNewProxy(login, &msg.NewProxy{ProxyType: "http", CustomDomains: []string{"example.com"}}))
+
+	expired := sign(map[string]any{"iss": "frp-admin", "sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()})
+	require.Error(v.VerifyLogin(&msg.Login{User: "alice", PrivilegeKey: expired}))
+	otherIssuer := sign(map[string]any{"iss": "other", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	require.Error(v.VerifyLogin(&msg.Login{User: "alice", PrivilegeKey: otherIssuer}))
+
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	signer, err = jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: otherPriv}, nil)
+	require.NoError(err)
+	require.Error(v.VerifyLogin(&msg.Login{User: "alice", PrivilegeKey: sign(map[string]any{
+		"iss": "frp-admin",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})}))
+}
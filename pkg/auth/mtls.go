@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+// MTLSAuthSetter sets nothing, the client is authenticated by its TLS client certificate.
+type MTLSAuthSetter struct{}
+
+func (*MTLSAuthSetter) SetLogin(*msg.Login) error { return nil }
+
+func (*MTLSAuthSetter) SetPing(*msg.Ping) error { return nil }
+
+func (*MTLSAuthSetter) SetNewWorkConn(*msg.NewWorkConn) error { return nil }
+
+// MTLSAuthVerifier uses the identity of the verified client certificate as the user of the client.
+type MTLSAuthVerifier struct {
+	identityFrom   string
+	proxyNameRules []v1.MTLSProxyNameRule
+}
+
+var (
+	_ TLSVerifier   = &MTLSAuthVerifier{}
+	_ ProxyVerifier = &MTLSAuthVerifier{}
+)
+
+func NewMTLSAuthVerifier(cfg v1.AuthMTLSServerConfig) *MTLSAuthVerifier {
+	return &MTLSAuthVerifier{
+		identityFrom:   cfg.IdentityFrom,
+		proxyNameRules: cfg.ProxyNameRules,
+	}
+}
+
+// VerifyLogin fails because the login can't be verified without the TLS connection.
+func (auth *MTLSAuthVerifier) VerifyLogin(*msg.Login) error {
+	return fmt.Errorf("client certificate is required")
+}
+
+// VerifyLoginTLS sets the user of the login to the identity of the client certificate, a different user in
+// the login is rejected.
+func (auth *MTLSAuthVerifier) VerifyLoginTLS(loginMsg *msg.Login, state *tls.ConnectionState) error {
+	// the certificate chain has been verified against trustedCaFile during the handshake
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate is required")
+	}
+	identities := auth.identities(state)
+	if len(identities) == 0 {
+		return fmt.Errorf("no identity found in client certificate by %s", auth.identityFrom)
+	}
+
+	if loginMsg.User == "" {
+		loginMsg.User = identities[0]
+		return nil
+	}
+	if !slices.Contains(identities, loginMsg.User) {
+		return fmt.Errorf("user [%s] doesn't match identities %v of client certificate", loginMsg.User, identities)
+	}
+	return nil
+}
+
+func (auth *MTLSAuthVerifier) identities(state *tls.ConnectionState) []string {
+	cert := state.PeerCertificates[0]
+	if auth.identityFrom != v1.MTLSIdentityFromSAN {
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	}
+
+	identities := slices.Clone(cert.DNSNames)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		identities = append(identities, u.String())
+	}
+	return identities
+}
+
+// VerifyNewProxy checks the proxy name by the rule of the identity, which is the user of the login. Rules of
+// the identity take precedence over the rule of "*".
+func (auth *MTLSAuthVerifier) VerifyNewProxy(loginMsg *msg.Login, pxyMsg *msg.NewProxy) error {
+	var rule *v1.MTLSProxyNameRule
+	for i := range auth.proxyNameRules {
+		r := &auth.proxyNameRules[i]
+		if r.Identity == loginMsg.User {
+			rule = r
+			break
+		}
+		if r.Identity == "*" {
+			rule = r
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+	for _, prefix := range rule.AllowedPrefixes {
+		if strings.HasPrefix(pxyMsg.ProxyName, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("proxy name [%s] is not allowed for identity [%s]", pxyMsg.ProxyName, loginMsg.User)
+}
+
+// VerifyNewWorkConnTLS checks that the client certificate of the work connection has the identity of the
+// login which owns it, so a run ID alone can't be used to take over the work connections of a client.
+func (auth *MTLSAuthVerifier) VerifyNewWorkConnTLS(loginMsg *msg.Login, _ *msg.NewWorkConn, state *tls.ConnectionState) error {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate is required")
+	}
+	if !slices.Contains(auth.identities(state), loginMsg.User) {
+		return fmt.Errorf("client certificate doesn't match identity [%s] of the client", loginMsg.User)
+	}
+	return nil
+}
+
+// Pings are sent in the control connection, which is authenticated by its client certificate.
+func (auth *MTLSAuthVerifier) VerifyPing(*msg.Ping) error { return nil }
+
+// VerifyNewWorkConn fails because the work connection can't be verified without the TLS connection.
+func (auth *MTLSAuthVerifier) VerifyNewWorkConn(*msg.NewWorkConn) error {
+	return fmt.Errorf("client certificate is required")
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+func newTestTLSState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+}
+
+func TestMTLSAuthVerifierLogin(t *testing.T) {
+	require := require.New(t)
+
+	spiffe, _ := url.Parse("spiffe://example.org/frpc")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "alice"},
+		DNSNames: []string{"alice.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	v := NewMTLSAuthVerifier(v1.AuthMTLSServerConfig{IdentityFrom: v1.MTLSIdentityFromCommonName})
+	require.Error(v.VerifyLogin(&msg.Login{}))
+	require.Error(v.VerifyLoginTLS(&msg.Login{}, nil))
+	// the certificate isn't verified
+	require.Error(v.VerifyLoginTLS(&msg.Login{}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+
+	login := &msg.Login{}
+	require.NoError(v.VerifyLoginTLS(login, newTestTLSState(cert)))
+	require.Equal("alice", login.User)
+	require.ErrorContains(v.VerifyLoginTLS(&msg.Login{User: "bob"}, newTestTLSState(cert)), "doesn't match")
+
+	v = NewMTLSAuthVerifier(v1.AuthMTLSServerConfig{IdentityFrom: v1.MTLSIdentityFromSAN})
+	login = &msg.Login{}
+	require.NoError(v.VerifyLoginTLS(login, newTestTLSState(cert)))
+	require.Equal("alice.example.com", login.User)
+	require.NoError(v.VerifyLoginTLS(&msg.Login{User: "spiffe://example.org/frpc"}, newTestTLSState(cert)))
+	require.Error(v.VerifyLoginTLS(&msg.Login{User: "alice"}, newTestTLSState(cert)))
+}
+
+func TestMTLSAuthVerifierNewProxy(t *testing.T) {
+	require := require.New(t)
+
+	v := NewMTLSAuthVerifier(v1.AuthMTLSServerConfig{
+		ProxyNameRules: []v1.MTLSProxyNameRule{
+			{Identity: "*", AllowedPrefixes: []string{"public-"}},
+			{Identity: "alice", AllowedPrefixes: []string{"alice-", "shared-"}},
+		},
+	})
+
+	alice := &msg.Login{User: "alice"}
+	require.NoError(v.VerifyNewProxy(alice, &msg.NewProxy{ProxyName: "alice-web"}))
+	require.NoError(v.VerifyNewProxy(alice, &msg.NewProxy{ProxyName: "shared-db"}))
+	require.Error(v.VerifyNewProxy(alice, &msg.NewProxy{ProxyName: "public-web"}))
+
+	bob := &msg.Login{User: "bob"}
+	require.NoError(v.VerifyNewProxy(bob, &msg.NewProxy{ProxyName: "public-web"}))
+	require.ErrorContains(v.VerifyNewProxy(bob, &msg.NewProxy{ProxyName: "alice-web"}), "not allowed")
+
+	// identities without rules can register any proxies
+	v = NewMTLSAuthVerifier(v1.AuthMTLSServerConfig{})
+	require.NoError(v.VerifyNewProxy(bob, &msg.NewProxy{ProxyName: "alice-web"}))
+}
+
+func TestMTLSAuthVerifierNewWorkConn(t *testing.T) {
+	require := require.New(t)
+
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	bob := &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}}
+	v := NewMTLSAuthVerifier(v1.AuthMTLSServerConfig{IdentityFrom: v1.MTLSIdentityFromCommonName})
+	login := &msg.Login{User: "alice"}
+
+	require.Error(v.VerifyNewWorkConn(&msg.NewWorkConn{}))
+	require.Error(v.VerifyNewWorkConnTLS(login, &msg.NewWorkConn{}, nil))
+	require.NoError(v.VerifyNewWorkConnTLS(login, &msg.NewWorkConn{}, newTestTLSState(alice)))
+	require.ErrorContains(v.VerifyNewWorkConnTLS(login, &msg.NewWorkConn{}, newTestTLSState(bob)), "doesn't match")
+}
@@ -94,10 +94,10 @@ type OidcAuthConsumer struct {
 	subjectFromLogin string
 }
 
-func NewOidcAuthVerifier(additionalAuthScopes []v1.AuthScope, cfg v1.AuthOIDCServerConfig) *OidcAuthConsumer {
+func NewOidcAuthVerifier(additionalAuthScopes []v1.AuthScope, cfg v1.AuthOIDCServerConfig) (*OidcAuthConsumer, error) {
 	provider, err := oidc.NewProvider(context.Background(), cfg.Issuer)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("create OIDC provider error: %v", err)
 	}
 	verifierConf := oidc.Config{
 		ClientID:          cfg.Audience,
@@ -108,7 +108,7 @@ func NewOidcAuthVerifier(additionalAuthScopes []v1.AuthScope, cfg v1.AuthOIDCSer
 	return &OidcAuthConsumer{
 		additionalAuthScopes: additionalAuthScopes,
 		verifier:             provider.Verifier(&verifierConf),
-	}
+	}, nil
 }
 
 func (auth *OidcAuthConsumer) VerifyLogin(loginMsg *msg.Login) (err error) {
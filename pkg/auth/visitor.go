@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/util/util"
+)
+
+// VisitorRevocations records the revoked visitor credentials of proxies by proxy name. Revocations are kept
+// when proxies are restarted.
+type VisitorRevocations struct {
+	revoked map[string][]string
+	mu      sync.RWMutex
+}
+
+func NewVisitorRevocations() *VisitorRevocations {
+	return &VisitorRevocations{
+		revoked: make(map[string][]string),
+	}
+}
+
+// Revoke rejects new visitor connections using the credential of the proxy.
+func (r *VisitorRevocations) Revoke(proxyName, credential string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.revoked[proxyName], credential) {
+		r.revoked[proxyName] = append(r.revoked[proxyName], credential)
+	}
+}
+
+// Restore cancels the revocation of the credential of the proxy, it returns false if it isn't revoked.
+func (r *VisitorRevocations) Restore(proxyName, credential string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	revoked := r.revoked[proxyName]
+	i := slices.Index(revoked, credential)
+	if i < 0 {
+		return false
+	}
+	revoked = slices.Delete(revoked, i, i+1)
+	if len(revoked) == 0 {
+		delete(r.revoked, proxyName)
+	} else {
+		r.revoked[proxyName] = revoked
+	}
+	return true
+}
+
+func (r *VisitorRevocations) IsRevoked(proxyName, credential string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Contains(r.revoked[proxyName], credential)
+}
+
+// VisitorVerifier verifies the sign keys of visitors of a stcp, xtcp or sudp proxy. Visitors use either the
+// secret key of the proxy or one of its named credentials.
+type VisitorVerifier struct {
+	proxyName   string
+	sk          string
+	credentials []v1.VisitorCredential
+	revocations *VisitorRevocations
+}
+
+// NewVisitorVerifier creates a verifier of the proxy, revocations can be nil.
+func NewVisitorVerifier(proxyName, sk string, credentials []v1.VisitorCredential, revocations *VisitorRevocations) *VisitorVerifier {
+	return &VisitorVerifier{
+		proxyName:   proxyName,
+		sk:          sk,
+		credentials: credentials,
+		revocations: revocations,
+	}
+}
+
+// Verify checks the sign key of a visitor using the credential, or the secret key of the proxy if credential
+// is empty. It returns the secret key used by the visitor.
+func (v *VisitorVerifier) Verify(credential string, timestamp int64, signKey string) (string, error) {
+	sk := v.sk
+	if credential == "" {
+		// anyone could sign with an empty secret key if visitors must use credentials
+		if sk == "" && len(v.credentials) > 0 {
+			return "", fmt.Errorf("credential is required")
+		}
+	} else {
+		c, ok := v1.FindVisitorCredential(v.credentials, credential)
+		if !ok {
+			return "", fmt.Errorf("unknown credential [%s]", credential)
+		}
+		if v.revocations.IsRevoked(v.proxyName, credential) {
+			return "", fmt.Errorf("credential [%s] is revoked", credential)
+		}
+		if credentialExpired(c) {
+			return "", fmt.Errorf("credential [%s] is expired", credential)
+		}
+		sk = c.SecretKey
+	}
+	if !util.ConstantTimeEqString(signKey, util.GetAuthKey(sk, timestamp)) {
+		return "", fmt.Errorf("invalid sign key")
+	}
+	return sk, nil
+}
+
+// VisitorCredentialStatus is the status of a credential of a proxy.
+type VisitorCredentialStatus struct {
+	Name      string
+	ExpiresAt string
+	Expired   bool
+	Revoked   bool
+}
+
+// GetVisitorCredentialStatus returns the status of the credentials of the proxy.
+func GetVisitorCredentialStatus(
+	proxyName string,
+	credentials []v1.VisitorCredential,
+	revocations *VisitorRevocations,
+) []VisitorCredentialStatus {
+	ret := make([]VisitorCredentialStatus, 0, len(credentials))
+	for _, c := range credentials {
+		ret = append(ret, VisitorCredentialStatus{
+			Name:      c.Name,
+			ExpiresAt: c.ExpiresAt,
+			Expired:   credentialExpired(c),
+			Revoked:   revocations.IsRevoked(proxyName, c.Name),
+		})
+	}
+	return ret
+}
+
+func credentialExpired(c v1.VisitorCredential) bool {
+	if c.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, c.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/util/util"
+)
+
+func TestVisitorVerifier(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now().Unix()
+	credentials := []v1.VisitorCredential{
+		{Name: "alice", SecretKey: "alice-sk"},
+		{Name: "bob", SecretKey: "bob-sk", ExpiresAt: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+	}
+	revocations := NewVisitorRevocations()
+	v := NewVisitorVerifier("secret_tcp", "shared-sk", credentials, revocations)
+
+	sk, err := v.Verify("", now, util.GetAuthKey("shared-sk", now))
+	require.NoError(err)
+	require.Equal("shared-sk", sk)
+
+	sk, err = v.Verify("alice", now, util.GetAuthKey("alice-sk", now))
+	require.NoError(err)
+	require.Equal("alice-sk", sk)
+
+	_, err = v.Verify("alice", now, util.GetAuthKey("shared-sk", now))
+	require.ErrorContains(err, "invalid sign key")
+	_, err = v.Verify("bob", now, util.GetAuthKey("bob-sk", now))
+	require.ErrorContains(err, "expired")
+	_, err = v.Verify("carol", now, util.GetAuthKey("shared-sk", now))
+	require.ErrorContains(err, "unknown credential")
+
+	revocations.Revoke("secret_tcp", "alice")
+	_, err = v.Verify("alice", now, util.GetAuthKey("alice-sk", now))
+	require.ErrorContains(err, "revoked")
+	require.True(revocations.Restore("secret_tcp", "alice"))
+	require.False(revocations.Restore("secret_tcp", "alice"))
+	_, err = v.Verify("alice", now, util.GetAuthKey("alice-sk", now))
+	require.NoError(err)
+
+	// visitors must use credentials if the proxy has no secret key
+	v = NewVisitorVerifier("secret_tcp", "", credentials, nil)
+	_, err = v.Verify("", now, util.GetAuthKey("", now))
+	require.ErrorContains(err, "credential is required")
+}
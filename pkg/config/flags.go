@@ -117,17 +117,26 @@ func RegisterVisitorFlags(cmd *cobra.Command, c v1.VisitorConfigurer, opts ...Re
 	// add visitor flags if exist
 }
 
-func registerVisitorBaseConfigFlags(cmd *cobra.Command, c *v1.VisitorBaseConfig, _ ...RegisterFlagOption) {
+func registerVisitorBaseConfigFlags(cmd *cobra.Command, c *v1.VisitorBaseConfig, opts ...RegisterFlagOption) {
 	if c == nil {
 		return
 	}
+	options := &registerFlagOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	cmd.Flags().StringVarP(&c.Name, "visitor_name", "n", "", "访客姓名")
 	cmd.Flags().BoolVarP(&c.Transport.UseEncryption, "ue", "", false, "使用加密")
 	cmd.Flags().BoolVarP(&c.Transport.UseCompression, "uc", "", false, "使用压缩")
 	cmd.Flags().StringVarP(&c.SecretKey, "sk", "", "", "密钥")
+	cmd.Flags().StringVarP(&c.ServerUser, "server_user", "", "", "服务器用户")
 	cmd.Flags().StringVarP(&c.ServerName, "server_name", "", "", "服务器名称")
-	cmd.Flags().StringVarP(&c.BindAddr, "bind_addr", "", "", "绑定 ADDR")
-	cmd.Flags().IntVarP(&c.BindPort, "bind_port", "", 0, "绑定端口")
+
+	if !options.sshMode {
+		cmd.Flags().StringVarP(&c.BindAddr, "bind_addr", "", "", "绑定 ADDR")
+		cmd.Flags().IntVarP(&c.BindPort, "bind_port", "", 0, "绑定端口")
+	}
 }
 
 func RegisterClientCommonConfigFlags(cmd *cobra.Command, c *v1.ClientCommonConfig, opts ...RegisterFlagOption) {
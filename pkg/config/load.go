@@ -217,6 +217,15 @@ func LoadClientConfig(path string, strict bool) (*v1.ClientCommonConfig, []v1.Pr
 		visitorCfgs = append(visitorCfgs, c.VisitorConfigurer)
 	}
 
+	if len(commonCfg.IncludeConfigFiles) > 0 {
+		extProxyCfgs, extVisitorCfgs, err := LoadAdditionalClientConfigs(commonCfg.IncludeConfigFiles, strict)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		proxyCfgs = append(proxyCfgs, extProxyCfgs...)
+		visitorCfgs = append(visitorCfgs, extVisitorCfgs...)
+	}
+
 	// Filter by start
 	if len(commonCfg.Start) > 0 {
 		startSet := sets.New(commonCfg.Start...)
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -150,3 +152,41 @@ unixPath = "/tmp/uds.sock"
 	err = LoadConfigure([]byte(pluginStr), &clientCfg, true)
 	require.Error(err)
 }
+
+func TestLoadClientConfigIncludes(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	confd := filepath.Join(dir, "confd")
+	require.NoError(os.Mkdir(confd, 0o755))
+	require.NoError(os.WriteFile(filepath.Join(confd, "web.toml"), []byte(`
+[[proxies]]
+name = "web"
+type = "http"
+localPort = 80
+customDomains = ["example.com"]
+`), 0o600))
+	require.NoError(os.WriteFile(filepath.Join(confd, "web.bak"), []byte("invalid"), 0o600))
+
+	path := filepath.Join(dir, "frpc.toml")
+	require.NoError(os.WriteFile(path, []byte(fmt.Sprintf(`
+includes = [%q]
+
+[[proxies]]
+name = "ssh"
+type = "tcp"
+localPort = 22
+`, filepath.Join(confd, "*.toml"))), 0o600))
+
+	_, proxyCfgs, _, err := LoadClientConfig(path, true)
+	require.NoError(err)
+	names := make([]string, 0, len(proxyCfgs))
+	for _, c := range proxyCfgs {
+		names = append(names, c.GetBaseConfig().Name)
+	}
+	require.Equal([]string{"ssh", "web"}, names)
+
+	require.NoError(os.WriteFile(filepath.Join(confd, "web.toml"), []byte("unknownField = 1"), 0o600))
+	_, _, _, err = LoadClientConfig(path, true)
+	require.Error(err)
+}
@@ -23,6 +23,7 @@ import (
 )
 
 const (
+	GB = 1024 * 1024 * 1024
 	MB = 1024 * 1024
 	KB = 1024
 
@@ -31,7 +32,7 @@ const (
 )
 
 type BandwidthQuantity struct {
-	s string // MB or KB
+	s string // GB, MB or KB
 
 	i int64 // bytes
 }
@@ -71,6 +72,13 @@ func (q *BandwidthQuantity) UnmarshalString(s string) error {
 		err  error
 	)
 	switch {
+	case strings.HasSuffix(s, "GB"):
+		base = GB
+		fstr := strings.TrimSuffix(s, "GB")
+		f, err = strconv.ParseFloat(fstr, 64)
+		if err != nil {
+			return err
+		}
 	case strings.HasSuffix(s, "MB"):
 		base = MB
 		fstr := strings.TrimSuffix(s, "MB")
@@ -37,6 +37,10 @@ func TestBandwidthQuantity(t *testing.T) {
 	buf, err := json.Marshal(&w)
 	require.NoError(err)
 	require.Equal(`{"b":"1KB","int":5}`, string(buf))
+
+	err = json.Unmarshal([]byte(`{"b":"1.5GB"}`), &w)
+	require.NoError(err)
+	require.EqualValues(1.5*GB, w.B.Bytes())
 }
 
 func TestPortsRangeSlice2String(t *testing.T) {
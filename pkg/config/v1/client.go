@@ -1,10 +1,13 @@
 package v1
 
 import (
+	"net"
 	"os"
+	"slices"
 
 	"github.com/samber/lo"
 
+	"github.com/iami317/hepx/pkg/config/types"
 	"github.com/iami317/hepx/pkg/util/util"
 )
 
@@ -47,6 +50,13 @@ type ClientCommonConfig struct {
 	Log       LogConfig             `json:"log,omitempty"`
 	WebServer WebServerConfig       `json:"webServer,omitempty"`
 	Transport ClientTransportConfig `json:"transport,omitempty"`
+	// EnableAdminServer starts the admin UI and APIs such as reload, stop and
+	// config on webServer. By default, this value is false and webServer is
+	// not served.
+	EnableAdminServer bool `json:"enableAdminServer,omitempty"`
+	// StatusServer serves the read-only status, connections and traffic of
+	// proxies used by "frpc top", without the other admin APIs.
+	StatusServer StatusServerConfig `json:"statusServer,omitempty"`
 
 	// UDPPacketSize specifies the udp packet size
 	// By default, this value is 1500
@@ -56,6 +66,11 @@ type ClientCommonConfig struct {
 
 	// Include other config files for proxies.
 	IncludeConfigFiles []string `json:"includes,omitempty"`
+	// ConfigWatch reloads proxies and visitors when the config file or included files change.
+	ConfigWatch ConfigWatchConfig `json:"configWatch,omitempty"`
+
+	// ProxyPush controls which proxies and visitors frps is allowed to start on this client at runtime.
+	ProxyPush ProxyPushConfig `json:"proxyPush,omitempty"`
 }
 
 func (c *ClientCommonConfig) Complete() {
@@ -70,7 +85,101 @@ func (c *ClientCommonConfig) Complete() {
 	//client 不进行 ui 展示了
 	//c.WebServer.Complete()
 
+	c.StatusServer.Complete()
+
 	c.UDPPacketSize = util.EmptyOr(c.UDPPacketSize, 1500)
+	c.ConfigWatch.Complete()
+}
+
+type ConfigWatchConfig struct {
+	// Enable specifies whether to watch the config file and included files. Only proxies and
+	// visitors are reloaded, changes of other options are ignored. By default, this value is false.
+	Enable bool `json:"enable,omitempty"`
+	// IntervalMs specifies the interval in milliseconds to check the files.
+	// By default, this value is 1000.
+	IntervalMs int64 `json:"intervalMs,omitempty"`
+	// DebounceMs specifies how long in milliseconds the files must stay unchanged before
+	// the changes are applied. By default, this value is 500.
+	DebounceMs int64 `json:"debounceMs,omitempty"`
+}
+
+func (c *ConfigWatchConfig) Complete() {
+	c.IntervalMs = util.EmptyOr(c.IntervalMs, 1000)
+	c.DebounceMs = util.EmptyOr(c.DebounceMs, 500)
+}
+
+type ProxyPushConfig struct {
+	// Enable specifies whether to accept proxies and visitors pushed by the server.
+	// By default, this value is false.
+	Enable bool `json:"enable,omitempty"`
+	// AllowTypes specifies the proxy and visitor types that can be pushed, for example "tcp" or "stcp".
+	// If it's empty, all types are allowed.
+	AllowTypes []string `json:"allowTypes,omitempty"`
+	// AllowLocalTargets specifies the local addresses pushed proxies can forward to and pushed visitors
+	// can bind to, in the form of "host:port", "host:from-to" or "host:*". If it's empty, all addresses
+	// are allowed. Pushed proxies using client plugins are always rejected.
+	AllowLocalTargets []string `json:"allowLocalTargets,omitempty"`
+}
+
+func (c *ProxyPushConfig) IsTypeAllowed(typ string) bool {
+	return len(c.AllowTypes) == 0 || slices.Contains(c.AllowTypes, typ)
+}
+
+func (c *ProxyPushConfig) IsLocalTargetAllowed(host string, port int) bool {
+	if len(c.AllowLocalTargets) == 0 {
+		return true
+	}
+	for _, target := range c.AllowLocalTargets {
+		allowHost, ports, err := ParseLocalTarget(target)
+		if err != nil || allowHost != host {
+			continue
+		}
+		if ports == nil {
+			return true
+		}
+		for _, r := range ports {
+			if (r.Single > 0 && r.Single == port) || (r.Single == 0 && port >= r.Start && port <= r.End) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseLocalTarget parses a local target like "127.0.0.1:8080", "127.0.0.1:8000-9000" or "127.0.0.1:*".
+// The returned ports is nil if all ports are allowed.
+func ParseLocalTarget(target string) (host string, ports []types.PortsRange, err error) {
+	host, portsStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if portsStr == "*" {
+		return host, nil, nil
+	}
+	ports, err = types.NewPortsRangeSliceFromString(portsStr)
+	if err != nil {
+		return "", nil, err
+	}
+	return host, ports, nil
+}
+
+type StatusServerConfig struct {
+	// Addr is the network address to bind on. By default, this value is
+	// "127.0.0.1".
+	Addr string `json:"addr,omitempty"`
+	// Port specifies the port to listen on. If this value is 0, the status
+	// server will not be started.
+	Port int `json:"port,omitempty"`
+	// User and Password protect the status server with basic auth if they
+	// are not empty.
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (c *StatusServerConfig) Complete() {
+	if c.Port > 0 {
+		c.Addr = util.EmptyOr(c.Addr, "127.0.0.1")
+	}
 }
 
 type ClientTransportConfig struct {
@@ -158,7 +267,10 @@ type AuthClientConfig struct {
 	// Method specifies what authentication method to use to
 	// authenticate frpc with frps. If "token" is specified - token will be
 	// read into login message. If "oidc" is specified - OIDC (Open ID Connect)
-	// token will be issued using OIDC settings. By default, this value is "token".
+	// token will be issued using OIDC settings. If "mtls" is specified - the client
+	// certificate in transport.tls is used as the identity of the client. If
+	// "jwt" is specified - a signed token issued in advance will be read into
+	// login message. By default, this value is "token".
 	Method AuthMethod `json:"method,omitempty"`
 	// Specify whether to include auth info in additional scope.
 	// Current supported scopes are: "HeartBeats", "NewWorkConns".
@@ -168,12 +280,21 @@ type AuthClientConfig struct {
 	// to succeed.  By default, this value is "".
 	Token string               `json:"token,omitempty"`
 	OIDC  AuthOIDCClientConfig `json:"oidc,omitempty"`
+	JWT   AuthJWTClientConfig  `json:"jwt,omitempty"`
 }
 
 func (c *AuthClientConfig) Complete() {
 	c.Method = util.EmptyOr(c.Method, "token")
 }
 
+type AuthJWTClientConfig struct {
+	// Token is a JWT signed by a key in the JWKS file of frps.
+	Token string `json:"token,omitempty"`
+	// TokenFile is the path of the file with the token, it takes precedence over
+	// Token and is read on every login so the token can be renewed.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
 type AuthOIDCClientConfig struct {
 	// ClientID specifies the client ID to use to get a token in OIDC authentication.
 	ClientID string `json:"clientID,omitempty"`
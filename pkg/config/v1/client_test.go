@@ -18,4 +18,31 @@ func TestClientConfigComplete(t *testing.T) {
 	require.Equal(true, lo.FromPtr(c.Transport.TLS.Enable))
 	require.Equal(true, lo.FromPtr(c.Transport.TLS.DisableCustomTLSFirstByte))
 	require.NotEmpty(c.NatHoleSTUNServer)
+	require.Empty(c.StatusServer.Addr)
+
+	c = &ClientConfig{}
+	c.StatusServer.Port = 7401
+	c.Complete()
+	require.Equal("127.0.0.1", c.StatusServer.Addr)
+}
+
+func TestProxyPushConfigAllowLocalTarget(t *testing.T) {
+	require := require.New(t)
+	c := &ProxyPushConfig{
+		AllowTypes:        []string{"tcp", "stcp"},
+		AllowLocalTargets: []string{"127.0.0.1:22", "127.0.0.1:8000-8100", "192.168.1.10:*"},
+	}
+
+	require.True(c.IsTypeAllowed("tcp"))
+	require.False(c.IsTypeAllowed("http"))
+
+	require.True(c.IsLocalTargetAllowed("127.0.0.1", 22))
+	require.True(c.IsLocalTargetAllowed("127.0.0.1", 8050))
+	require.False(c.IsLocalTargetAllowed("127.0.0.1", 8101))
+	require.True(c.IsLocalTargetAllowed("192.168.1.10", 3306))
+	require.False(c.IsLocalTargetAllowed("192.168.1.11", 22))
+
+	empty := &ProxyPushConfig{}
+	require.True(empty.IsTypeAllowed("udp"))
+	require.True(empty.IsLocalTargetAllowed("10.0.0.1", 80))
 }
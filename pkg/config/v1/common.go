@@ -30,6 +30,8 @@ type AuthMethod string
 const (
 	AuthMethodToken AuthMethod = "token"
 	AuthMethodOIDC  AuthMethod = "oidc"
+	AuthMethodMTLS  AuthMethod = "mtls"
+	AuthMethodJWT   AuthMethod = "jwt"
 )
 
 // QUIC protocol options
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/samber/lo"
 
@@ -43,8 +44,109 @@ type LoadBalancerConfig struct {
 	// GroupKey specifies a group key, which should be the same among proxies
 	// of the same group.
 	GroupKey string `json:"groupKey,omitempty"`
+	// Strategy specifies how the server chooses a proxy of the group for new
+	// connections. Valid values include "roundRobin", "leastConn", "weighted",
+	// "sourceIPHash" and "stickyCookie" (http only). It should be the same
+	// among proxies of the same group. By default, this value is "roundRobin".
+	// For http groups, "leastConn" counts in-flight requests.
+	Strategy string `json:"strategy,omitempty"`
+	// Weight is the weight of this proxy in the group for the "weighted" and
+	// "sourceIPHash" strategies. By default, this value is 1.
+	Weight int `json:"weight,omitempty"`
+	// HealthCheck specifies how the server checks this proxy through work
+	// connections. Unhealthy proxies are not chosen for new connections of
+	// the group until they recover.
+	HealthCheck GroupHealthCheckConfig `json:"healthCheck,omitempty"`
+}
+
+type GroupHealthCheckConfig struct {
+	// Type specifies how to check the proxy. Valid values include "tcp",
+	// "http", "payload" and "". If this value is "", health checking will
+	// not be performed.
+	//
+	// If the type is "tcp", the check fails if the work connection is closed
+	// by the client within TimeoutSeconds, e.g. because the local service
+	// can't be connected. frpc gives up connecting after 10 seconds, so
+	// TimeoutSeconds should be longer to detect services which don't answer.
+	//
+	// If the type is "http", a GET request will be sent to Path. If the
+	// response is not a 2xx, the check fails.
+	//
+	// If the type is "payload", Send will be sent and the response must start
+	// with Expect.
+	Type string `json:"type"`
+	// TimeoutSeconds specifies the number of seconds to wait for a check. By
+	// default, this value is 3.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// MaxFailed specifies the number of continuous failures before the proxy
+	// is considered unhealthy. By default, this value is 1.
+	MaxFailed int `json:"maxFailed,omitempty"`
+	// IntervalSeconds specifies the time in seconds between checks. By
+	// default, this value is 10.
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// Path specifies the path of http checks.
+	Path string `json:"path,omitempty"`
+	// HTTPHeaders specifies the headers of http checks.
+	HTTPHeaders []HTTPHeader `json:"httpHeaders,omitempty"`
+	// Send specifies the payload of payload checks.
+	Send string `json:"send,omitempty"`
+	// Expect specifies the prefix of the response of payload checks.
+	Expect string `json:"expect,omitempty"`
+}
+
+func (c *GroupHealthCheckConfig) Complete() {
+	if c.Type == "" {
+		return
+	}
+	c.TimeoutSeconds = util.EmptyOr(c.TimeoutSeconds, 3)
+	c.MaxFailed = util.EmptyOr(c.MaxFailed, 1)
+	c.IntervalSeconds = util.EmptyOr(c.IntervalSeconds, 10)
 }
 
+func (c *GroupHealthCheckConfig) MarshalToMsg() *msg.GroupHealthCheck {
+	if c.Type == "" {
+		return nil
+	}
+	m := &msg.GroupHealthCheck{
+		Type:            c.Type,
+		TimeoutSeconds:  c.TimeoutSeconds,
+		MaxFailed:       c.MaxFailed,
+		IntervalSeconds: c.IntervalSeconds,
+		Path:            c.Path,
+		Send:            c.Send,
+		Expect:          c.Expect,
+	}
+	for _, h := range c.HTTPHeaders {
+		m.HTTPHeaders = append(m.HTTPHeaders, msg.HTTPHeader{Name: h.Name, Value: h.Value})
+	}
+	return m
+}
+
+func (c *GroupHealthCheckConfig) UnmarshalFromMsg(m *msg.GroupHealthCheck) {
+	*c = GroupHealthCheckConfig{}
+	if m == nil {
+		return
+	}
+	c.Type = m.Type
+	c.TimeoutSeconds = m.TimeoutSeconds
+	c.MaxFailed = m.MaxFailed
+	c.IntervalSeconds = m.IntervalSeconds
+	c.Path = m.Path
+	c.Send = m.Send
+	c.Expect = m.Expect
+	for _, h := range m.HTTPHeaders {
+		c.HTTPHeaders = append(c.HTTPHeaders, HTTPHeader{Name: h.Name, Value: h.Value})
+	}
+}
+
+const (
+	LoadBalancerStrategyRoundRobin   = "roundRobin"
+	LoadBalancerStrategyLeastConn    = "leastConn"
+	LoadBalancerStrategyWeighted     = "weighted"
+	LoadBalancerStrategySourceIPHash = "sourceIPHash"
+	LoadBalancerStrategyStickyCookie = "stickyCookie"
+)
+
 type ProxyBackend struct {
 	// LocalIP specifies the IP address or host name of the backend.
 	LocalIP string `json:"localIP,omitempty"`
@@ -102,6 +204,10 @@ type ProxyBaseConfig struct {
 	Metadatas    map[string]string  `json:"metadatas,omitempty"`
 	LoadBalancer LoadBalancerConfig `json:"loadBalancer,omitempty"`
 	HealthCheck  HealthCheckConfig  `json:"healthCheck,omitempty"`
+	// AllowSourceCIDRs and DenySourceCIDRs filter the source IPs of user connections on frps.
+	// They can only narrow the server-wide lists in the frps configuration.
+	AllowSourceCIDRs []string `json:"allowSourceCIDRs,omitempty"`
+	DenySourceCIDRs  []string `json:"denySourceCIDRs,omitempty"`
 	ProxyBackend
 }
 
@@ -113,6 +219,7 @@ func (c *ProxyBaseConfig) Complete(namePrefix string) {
 	c.Name = lo.Ternary(namePrefix == "", "", namePrefix+".") + c.Name
 	c.LocalIP = util.EmptyOr(c.LocalIP, "127.0.0.1")
 	c.Transport.BandwidthLimitMode = util.EmptyOr(c.Transport.BandwidthLimitMode, types.BandwidthLimitModeClient)
+	c.LoadBalancer.HealthCheck.Complete()
 }
 
 func (c *ProxyBaseConfig) MarshalToMsg(m *msg.NewProxy) {
@@ -127,8 +234,13 @@ func (c *ProxyBaseConfig) MarshalToMsg(m *msg.NewProxy) {
 	}
 	m.Group = c.LoadBalancer.Group
 	m.GroupKey = c.LoadBalancer.GroupKey
+	m.LoadBalancerStrategy = c.LoadBalancer.Strategy
+	m.Weight = c.LoadBalancer.Weight
+	m.GroupHealthCheck = c.LoadBalancer.HealthCheck.MarshalToMsg()
 	m.Metas = c.Metadatas
 	m.Annotations = c.Annotations
+	m.AllowSourceCIDRs = c.AllowSourceCIDRs
+	m.DenySourceCIDRs = c.DenySourceCIDRs
 }
 
 func (c *ProxyBaseConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -144,8 +256,13 @@ func (c *ProxyBaseConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 	}
 	c.LoadBalancer.Group = m.Group
 	c.LoadBalancer.GroupKey = m.GroupKey
+	c.LoadBalancer.Strategy = m.LoadBalancerStrategy
+	c.LoadBalancer.Weight = m.Weight
+	c.LoadBalancer.HealthCheck.UnmarshalFromMsg(m.GroupHealthCheck)
 	c.Metadatas = m.Metas
 	c.Annotations = m.Annotations
+	c.AllowSourceCIDRs = m.AllowSourceCIDRs
+	c.DenySourceCIDRs = m.DenySourceCIDRs
 }
 
 type TypedProxyConfig struct {
@@ -283,6 +400,13 @@ type HTTPProxyConfig struct {
 	RequestHeaders    HeaderOperations `json:"requestHeaders,omitempty"`
 	ResponseHeaders   HeaderOperations `json:"responseHeaders,omitempty"`
 	RouteByHTTPUser   string           `json:"routeByHTTPUser,omitempty"`
+	// Methods limits the proxy to these request methods, all methods are
+	// routed if it's empty.
+	Methods []string `json:"methods,omitempty"`
+	// RoutePriority decides which proxy is used when the domains or locations
+	// of several proxies match a request, the highest one wins. By default,
+	// this value is 0.
+	RoutePriority int `json:"routePriority,omitempty"`
 }
 
 func (c *HTTPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
@@ -297,6 +421,8 @@ func (c *HTTPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
 	m.Headers = c.RequestHeaders.Set
 	m.ResponseHeaders = c.ResponseHeaders.Set
 	m.RouteByHTTPUser = c.RouteByHTTPUser
+	m.Methods = c.Methods
+	m.RoutePriority = c.RoutePriority
 }
 
 func (c *HTTPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -311,6 +437,8 @@ func (c *HTTPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 	c.RequestHeaders.Set = m.Headers
 	c.ResponseHeaders.Set = m.ResponseHeaders
 	c.RouteByHTTPUser = m.RouteByHTTPUser
+	c.Methods = m.Methods
+	c.RoutePriority = m.RoutePriority
 }
 
 var _ ProxyConfigurer = &HTTPSProxyConfig{}
@@ -374,6 +502,51 @@ func (c *TCPMuxProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 	c.RouteByHTTPUser = m.RouteByHTTPUser
 }
 
+// VisitorCredential is a named secret key of a visitor of a stcp, xtcp or sudp
+// proxy. The visitor uses it by setting credential and secretKey.
+type VisitorCredential struct {
+	Name      string `json:"name"`
+	SecretKey string `json:"secretKey"`
+	// ExpiresAt is the time in RFC3339 format after which the credential is
+	// rejected, e.g. "2025-01-02T15:04:05Z". If it's empty, the credential
+	// never expires.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// FindVisitorCredential returns the credential with the given name.
+func FindVisitorCredential(credentials []VisitorCredential, name string) (VisitorCredential, bool) {
+	for _, c := range credentials {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return VisitorCredential{}, false
+}
+
+func marshalVisitorCredentials(credentials []VisitorCredential) []msg.VisitorCredential {
+	var ret []msg.VisitorCredential
+	for _, c := range credentials {
+		m := msg.VisitorCredential{Name: c.Name, Sk: c.SecretKey}
+		if t, err := time.Parse(time.RFC3339, c.ExpiresAt); err == nil {
+			m.ExpiresAt = t.Unix()
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func unmarshalVisitorCredentials(credentials []msg.VisitorCredential) []VisitorCredential {
+	var ret []VisitorCredential
+	for _, m := range credentials {
+		c := VisitorCredential{Name: m.Name, SecretKey: m.Sk}
+		if m.ExpiresAt != 0 {
+			c.ExpiresAt = time.Unix(m.ExpiresAt, 0).UTC().Format(time.RFC3339)
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
 var _ ProxyConfigurer = &STCPProxyConfig{}
 
 type STCPProxyConfig struct {
@@ -381,6 +554,8 @@ type STCPProxyConfig struct {
 
 	Secretkey  string   `json:"secretKey,omitempty"`
 	AllowUsers []string `json:"allowUsers,omitempty"`
+	// Credentials are named secret keys of visitors, they can be revoked on the server separately.
+	Credentials []VisitorCredential `json:"credentials,omitempty"`
 }
 
 func (c *STCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
@@ -388,6 +563,7 @@ func (c *STCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
 
 	m.Sk = c.Secretkey
 	m.AllowUsers = c.AllowUsers
+	m.Credentials = marshalVisitorCredentials(c.Credentials)
 }
 
 func (c *STCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -395,6 +571,7 @@ func (c *STCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 
 	c.Secretkey = m.Sk
 	c.AllowUsers = m.AllowUsers
+	c.Credentials = unmarshalVisitorCredentials(m.Credentials)
 }
 
 var _ ProxyConfigurer = &XTCPProxyConfig{}
@@ -404,6 +581,8 @@ type XTCPProxyConfig struct {
 
 	Secretkey  string   `json:"secretKey,omitempty"`
 	AllowUsers []string `json:"allowUsers,omitempty"`
+	// Credentials are named secret keys of visitors, they can be revoked on the server separately.
+	Credentials []VisitorCredential `json:"credentials,omitempty"`
 }
 
 func (c *XTCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
@@ -411,6 +590,7 @@ func (c *XTCPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
 
 	m.Sk = c.Secretkey
 	m.AllowUsers = c.AllowUsers
+	m.Credentials = marshalVisitorCredentials(c.Credentials)
 }
 
 func (c *XTCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -418,6 +598,7 @@ func (c *XTCPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 
 	c.Secretkey = m.Sk
 	c.AllowUsers = m.AllowUsers
+	c.Credentials = unmarshalVisitorCredentials(m.Credentials)
 }
 
 var _ ProxyConfigurer = &SUDPProxyConfig{}
@@ -427,6 +608,8 @@ type SUDPProxyConfig struct {
 
 	Secretkey  string   `json:"secretKey,omitempty"`
 	AllowUsers []string `json:"allowUsers,omitempty"`
+	// Credentials are named secret keys of visitors, they can be revoked on the server separately.
+	Credentials []VisitorCredential `json:"credentials,omitempty"`
 }
 
 func (c *SUDPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
@@ -434,6 +617,7 @@ func (c *SUDPProxyConfig) MarshalToMsg(m *msg.NewProxy) {
 
 	m.Sk = c.Secretkey
 	m.AllowUsers = c.AllowUsers
+	m.Credentials = marshalVisitorCredentials(c.Credentials)
 }
 
 func (c *SUDPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -441,4 +625,5 @@ func (c *SUDPProxyConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 
 	c.Secretkey = m.Sk
 	c.AllowUsers = m.AllowUsers
+	c.Credentials = unmarshalVisitorCredentials(m.Credentials)
 }
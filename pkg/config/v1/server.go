@@ -50,9 +50,18 @@ type ServerConfig struct {
 	// value is set to "frps.com" and the client requested the subdomain
 	// "test", the resulting URL would be "test.frps.com".
 	SubDomainHost string `json:"subDomainHost,omitempty"`
+	// AllowRegexDomains allows the custom domains of proxies to be regular
+	// expressions starting with "~". By default, this value is false.
+	AllowRegexDomains bool `json:"allowRegexDomains,omitempty"`
+	// MaxRoutePriority specifies the maximum routePriority of http proxies.
+	// By default, this value is 0 and routePriority can't be set.
+	MaxRoutePriority int `json:"maxRoutePriority,omitempty"`
 	// Custom404Page specifies a path to a custom 404 page to display. If this
 	// value is "", a default page will be displayed.
 	Custom404Page string `json:"custom404Page,omitempty"`
+	// ACME terminates TLS on vhostHTTPSPort for http proxies with certificates
+	// obtained over ACME.
+	ACME ACMEConfig `json:"acme,omitempty"`
 
 	SSHTunnelGateway SSHTunnelGateway `json:"sshTunnelGateway,omitempty"`
 
@@ -60,8 +69,13 @@ type ServerConfig struct {
 	// EnablePrometheus will export prometheus metrics on webserver address
 	// in /metrics api.
 	EnablePrometheus bool `json:"enablePrometheus,omitempty"`
+	// Metrics configures the statistics of proxies shown in the dashboard.
+	Metrics MetricsConfig `json:"metrics,omitempty"`
 
 	Log LogConfig `json:"log,omitempty"`
+	// AccessLog writes one line for every user connection of proxies, and for
+	// every request of http proxies.
+	AccessLog AccessLogConfig `json:"accessLog,omitempty"`
 
 	Transport ServerTransportConfig `json:"transport,omitempty"`
 
@@ -81,6 +95,13 @@ type ServerConfig struct {
 	NatHoleAnalysisDataReserveHours int64 `json:"natholeAnalysisDataReserveHours,omitempty"`
 
 	AllowPorts []types.PortsRange `json:"allowPorts,omitempty"`
+	// AllowSourceCIDRs and DenySourceCIDRs filter the source IPs of user connections
+	// of all proxies. Proxies can only add their own lists on top of them.
+	AllowSourceCIDRs []string `json:"allowSourceCIDRs,omitempty"`
+	DenySourceCIDRs  []string `json:"denySourceCIDRs,omitempty"`
+
+	// TrafficQuota caps the total traffic of clients.
+	TrafficQuota TrafficQuotaConfig `json:"trafficQuota,omitempty"`
 
 	HTTPPlugins []HTTPPluginOptions `json:"httpPlugins,omitempty"`
 }
@@ -91,6 +112,10 @@ func (c *ServerConfig) Complete() {
 	c.Transport.Complete()
 	c.WebServer.Complete()
 	c.SSHTunnelGateway.Complete()
+	c.TrafficQuota.Complete()
+	c.Metrics.Complete()
+	c.AccessLog.Complete()
+	c.ACME.Complete()
 
 	c.BindAddr = util.EmptyOr(c.BindAddr, "0.0.0.0")
 	c.BindPort = util.EmptyOr(c.BindPort, 5000)
@@ -119,11 +144,16 @@ type AuthServerConfig struct {
 	AdditionalScopes []AuthScope          `json:"additionalScopes,omitempty"`
 	Token            string               `json:"token,omitempty"`
 	OIDC             AuthOIDCServerConfig `json:"oidc,omitempty"`
+	MTLS             AuthMTLSServerConfig `json:"mtls,omitempty"`
+	JWT              AuthJWTServerConfig  `json:"jwt,omitempty"`
 }
 
 func (c *AuthServerConfig) Complete() {
 	c.Method = util.EmptyOr(c.Method, "token")
 	c.Token = util.EmptyOr(c.Token, "admin")
+	if c.Method == AuthMethodMTLS {
+		c.MTLS.IdentityFrom = util.EmptyOr(c.MTLS.IdentityFrom, MTLSIdentityFromCommonName)
+	}
 }
 
 type AuthOIDCServerConfig struct {
@@ -142,6 +172,41 @@ type AuthOIDCServerConfig struct {
 	SkipIssuerCheck bool `json:"skipIssuerCheck,omitempty"`
 }
 
+// AuthJWTServerConfig verifies signed tokens offline by the public keys in a local file. The claims of tokens
+// limit the proxies of clients, see auth.JWTClaims.
+type AuthJWTServerConfig struct {
+	// JWKSFile is the path of the JSON Web Key Set file with the Ed25519 or RSA public keys to verify tokens.
+	JWKSFile string `json:"jwksFile,omitempty"`
+	// Issuer is compared with the issuer claim of tokens if it's not empty.
+	Issuer string `json:"issuer,omitempty"`
+	// Audience should be contained in the audience claim of tokens if it's not empty.
+	Audience string `json:"audience,omitempty"`
+}
+
+const (
+	MTLSIdentityFromCommonName = "commonName"
+	MTLSIdentityFromSAN        = "san"
+)
+
+// AuthMTLSServerConfig authenticates clients by the client certificates verified with
+// transport.tls.trustedCaFile. The identity of the certificate is used as the user of the client.
+type AuthMTLSServerConfig struct {
+	// IdentityFrom specifies where the identity is taken from the client certificate, "commonName" for the
+	// common name of the subject or "san" for the DNS names, email addresses and URIs of the subject
+	// alternative names. By default, this value is "commonName".
+	IdentityFrom string `json:"identityFrom,omitempty"`
+	// ProxyNameRules limit the names of proxies registered by identities, identities without rules can
+	// register any proxies.
+	ProxyNameRules []MTLSProxyNameRule `json:"proxyNameRules,omitempty"`
+}
+
+type MTLSProxyNameRule struct {
+	// Identity of the client certificate, "*" matches all identities.
+	Identity string `json:"identity"`
+	// AllowedPrefixes are the allowed prefixes of proxy names.
+	AllowedPrefixes []string `json:"allowedPrefixes"`
+}
+
 type ServerTransportConfig struct {
 	// TCPMux toggles TCP stream multiplexing. This allows multiple requests
 	// from a client to share a single TCP connection. By default, this value
@@ -201,3 +266,128 @@ type SSHTunnelGateway struct {
 func (c *SSHTunnelGateway) Complete() {
 	c.AutoGenPrivateKeyPath = util.EmptyOr(c.AutoGenPrivateKeyPath, "./.autogen_ssh_key")
 }
+
+type AccessLogConfig struct {
+	// To specifies where access logs are written. If "console" is used, they are
+	// printed to stdout, otherwise they are written to the specified file. By
+	// default, this value is "" and access logs are disabled.
+	To string `json:"to,omitempty"`
+	// MaxSize specifies the size in megabytes of the file before it's rotated.
+	// By default, this value is 100.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MaxBackups specifies the number of rotated files to keep. By default,
+	// this value is 7.
+	MaxBackups int `json:"maxBackups,omitempty"`
+}
+
+func (c *AccessLogConfig) Complete() {
+	c.MaxSize = util.EmptyOr(c.MaxSize, 100)
+	c.MaxBackups = util.EmptyOr(c.MaxBackups, 7)
+}
+
+type ACMEConfig struct {
+	// Enable specifies whether to obtain certificates for the domains of http
+	// proxies and serve them on vhostHTTPSPort. HTTP-01 challenges are answered
+	// on vhostHTTPPort, so both ports must be set. Domains routed to https
+	// proxies are still passed through to the clients.
+	Enable bool `json:"enable,omitempty"`
+	// Email is the contact address of the ACME account.
+	Email string `json:"email,omitempty"`
+	// DirectoryURL is the directory of the ACME server. By default, Let's
+	// Encrypt is used.
+	DirectoryURL string `json:"directoryURL,omitempty"`
+	// TrustedCaFile is the CA of the ACME server, it's used to test against a
+	// local ACME server such as Pebble.
+	TrustedCaFile string `json:"trustedCaFile,omitempty"`
+	// CacheDir stores the account key and the certificates, certificates are
+	// renewed automatically before they expire. By default, this value is
+	// "./frps_acme".
+	CacheDir string `json:"cacheDir,omitempty"`
+	// Domains limits the domains to request certificates for, "*.example.com"
+	// matches all subdomains of example.com. Certificates are only requested
+	// for domains routed to http proxies in any case.
+	Domains []string `json:"domains,omitempty"`
+}
+
+func (c *ACMEConfig) Complete() {
+	c.CacheDir = util.EmptyOr(c.CacheDir, "./frps_acme")
+}
+
+type MetricsConfig struct {
+	// RetentionDays specifies how many days of traffic are kept for each proxy.
+	// By default, this value is 7.
+	RetentionDays int64 `json:"retentionDays,omitempty"`
+	// StoreFile is an append-only file to persist statistics across restarts. If
+	// this value is "", statistics are only kept in memory.
+	StoreFile string `json:"storeFile,omitempty"`
+	// FlushInterval specifies the interval in seconds to write changed statistics
+	// to StoreFile. By default, this value is 60.
+	FlushInterval int64 `json:"flushInterval,omitempty"`
+}
+
+func (c *MetricsConfig) Complete() {
+	c.RetentionDays = util.EmptyOr(c.RetentionDays, 7)
+	c.FlushInterval = util.EmptyOr(c.FlushInterval, 60)
+}
+
+const (
+	TrafficQuotaPeriodDaily   = "daily"
+	TrafficQuotaPeriodMonthly = "monthly"
+	TrafficQuotaPeriodTotal   = "total"
+
+	TrafficQuotaActionReject   = "reject"
+	TrafficQuotaActionThrottle = "throttle"
+
+	TrafficQuotaKeyUser  = "user"
+	TrafficQuotaKeyRunID = "runID"
+	// TrafficQuotaKeyMetaPrefix is followed by the metadata key, like "meta:team".
+	TrafficQuotaKeyMetaPrefix = "meta:"
+)
+
+type TrafficQuotaConfig struct {
+	// StoreFile is the file to persist usage counters across restarts. By default,
+	// this value is "./frps_traffic_quota.json".
+	StoreFile string `json:"storeFile,omitempty"`
+	// SaveInterval specifies the interval in seconds to save usage counters. By default,
+	// this value is 60.
+	SaveInterval int64 `json:"saveInterval,omitempty"`
+	// Rules are checked independently, a client is limited when any rule it matches
+	// is exceeded.
+	Rules []TrafficQuotaRule `json:"rules,omitempty"`
+}
+
+func (c *TrafficQuotaConfig) Complete() {
+	c.StoreFile = util.EmptyOr(c.StoreFile, "./frps_traffic_quota.json")
+	c.SaveInterval = util.EmptyOr(c.SaveInterval, 60)
+	for i := range c.Rules {
+		c.Rules[i].Complete()
+	}
+}
+
+type TrafficQuotaRule struct {
+	// Name identifies the rule in logs, notifications and the store.
+	Name string `json:"name"`
+	// Key specifies what the usage is counted by, optional values are "user", "runID"
+	// and "meta:<key>" for a metadata of the client. Every distinct value has its own
+	// counter, clients with an empty value are not limited by this rule.
+	Key string `json:"key"`
+	// Values restricts the rule to these values of the key. If it's empty, the rule
+	// applies to all values.
+	Values []string `json:"values,omitempty"`
+	// Period specifies when counters are reset, optional values are "daily", "monthly"
+	// and "total". By default, this value is "total" and counters are never reset.
+	Period string `json:"period,omitempty"`
+	// Limit is the quota of inbound and outbound traffic in a period, like "10GB".
+	Limit types.BandwidthQuantity `json:"limit"`
+	// Action specifies what happens to new user connections when the quota is exceeded,
+	// optional values are "reject" and "throttle". By default, this value is "reject".
+	Action string `json:"action,omitempty"`
+	// ThrottleRate is the bandwidth shared by all new user connections limited by the
+	// rule when Action is "throttle", like "100KB".
+	ThrottleRate types.BandwidthQuantity `json:"throttleRate,omitempty"`
+}
+
+func (c *TrafficQuotaRule) Complete() {
+	c.Period = util.EmptyOr(c.Period, TrafficQuotaPeriodTotal)
+	c.Action = util.EmptyOr(c.Action, TrafficQuotaActionReject)
+}
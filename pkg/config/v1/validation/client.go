@@ -2,6 +2,7 @@ package validation
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"slices"
@@ -23,6 +24,14 @@ func ValidateClientCommonConfig(c *v1.ClientCommonConfig) (Warning, error) {
 		errs = AppendError(errs, fmt.Errorf("invalid auth additional scopes, optional values are %v", SupportedAuthAdditionalScopes))
 	}
 
+	if c.Auth.Method == v1.AuthMethodMTLS &&
+		(!lo.FromPtr(c.Transport.TLS.Enable) || c.Transport.TLS.CertFile == "" || c.Transport.TLS.KeyFile == "") {
+		errs = AppendError(errs, fmt.Errorf("auth method mtls requires transport.tls.enable, transport.tls.certFile and transport.tls.keyFile"))
+	}
+	if c.Auth.Method == v1.AuthMethodJWT && c.Auth.JWT.Token == "" && c.Auth.JWT.TokenFile == "" {
+		errs = AppendError(errs, fmt.Errorf("auth method jwt requires auth.jwt.token or auth.jwt.tokenFile"))
+	}
+
 	if err := validateLogConfig(&c.Log); err != nil {
 		errs = AppendError(errs, err)
 	}
@@ -30,6 +39,13 @@ func ValidateClientCommonConfig(c *v1.ClientCommonConfig) (Warning, error) {
 	if err := validateWebServerConfig(&c.WebServer); err != nil {
 		errs = AppendError(errs, err)
 	}
+	if c.EnableAdminServer && c.WebServer.Port > 0 && !isLoopbackAddr(c.WebServer.Addr) &&
+		(c.WebServer.User == "" || c.WebServer.Password == "") {
+		errs = AppendError(errs, fmt.Errorf("enableAdminServer requires webServer.user and webServer.password when webServer.addr is not a loopback address"))
+	}
+	if err := ValidatePort(c.StatusServer.Port, "statusServer.port"); err != nil {
+		errs = AppendError(errs, err)
+	}
 
 	if c.Transport.HeartbeatTimeout > 0 && c.Transport.HeartbeatInterval > 0 {
 		if c.Transport.HeartbeatTimeout < c.Transport.HeartbeatInterval {
@@ -54,6 +70,16 @@ func ValidateClientCommonConfig(c *v1.ClientCommonConfig) (Warning, error) {
 		errs = AppendError(errs, fmt.Errorf("invalid transport.protocol, optional values are %v", SupportedTransportProtocols))
 	}
 
+	for _, target := range c.ProxyPush.AllowLocalTargets {
+		if _, _, err := v1.ParseLocalTarget(target); err != nil {
+			errs = AppendError(errs, fmt.Errorf("invalid proxyPush.allowLocalTargets %s: %v", target, err))
+		}
+	}
+
+	if c.ConfigWatch.IntervalMs < 0 || c.ConfigWatch.DebounceMs < 0 {
+		errs = AppendError(errs, fmt.Errorf("invalid configWatch, intervalMs and debounceMs should not be negative"))
+	}
+
 	for _, f := range c.IncludeConfigFiles {
 		absDir, err := filepath.Abs(filepath.Dir(f))
 		if err != nil {
@@ -90,3 +116,11 @@ func ValidateAllClientConfig(c *v1.ClientCommonConfig, proxyCfgs []v1.ProxyConfi
 	}
 	return warnings, nil
 }
+
+func isLoopbackAddr(addr string) bool {
+	if addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
@@ -3,12 +3,16 @@ package validation
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/validation"
 
 	v1 "github.com/iami317/hepx/pkg/config/v1"
+	netpkg "github.com/iami317/hepx/pkg/util/net"
+	"github.com/iami317/hepx/pkg/util/vhost"
 )
 
 func validateProxyBaseConfigForClient(c *v1.ProxyBaseConfig) error {
@@ -47,13 +51,72 @@ func validateProxyBaseConfigForClient(c *v1.ProxyBaseConfig) error {
 			return fmt.Errorf("plugin %s: %v", c.Plugin.Type, err)
 		}
 	}
-	return nil
+	if err := validateLoadBalancerConfig(c); err != nil {
+		return err
+	}
+	return validateSourceCIDRs(c.AllowSourceCIDRs, c.DenySourceCIDRs)
 }
 
 func validateProxyBaseConfigForServer(c *v1.ProxyBaseConfig) error {
 	if err := ValidateAnnotations(c.Annotations); err != nil {
 		return err
 	}
+	if err := validateLoadBalancerConfig(c); err != nil {
+		return err
+	}
+	return validateSourceCIDRs(c.AllowSourceCIDRs, c.DenySourceCIDRs)
+}
+
+func validateLoadBalancerConfig(c *v1.ProxyBaseConfig) error {
+	lb := c.LoadBalancer
+	if lb.Weight < 0 {
+		return fmt.Errorf("loadBalancer.weight should not be negative")
+	}
+	if err := validateGroupHealthCheckConfig(c); err != nil {
+		return err
+	}
+	if lb.Strategy == "" {
+		return nil
+	}
+	if !slices.Contains(SupportedLoadBalancerStrategies, lb.Strategy) {
+		return fmt.Errorf("invalid loadBalancer.strategy [%s], optional values are %v", lb.Strategy, SupportedLoadBalancerStrategies)
+	}
+	switch {
+	case lb.Strategy == v1.LoadBalancerStrategyStickyCookie && c.Type != string(v1.ProxyTypeHTTP):
+		return fmt.Errorf("loadBalancer.strategy [%s] is only supported by http proxies", lb.Strategy)
+	case c.Type != string(v1.ProxyTypeTCP) && c.Type != string(v1.ProxyTypeHTTP):
+		return fmt.Errorf("loadBalancer.strategy is only supported by tcp and http proxies")
+	}
+	return nil
+}
+
+func validateSourceCIDRs(allow []string, deny []string) error {
+	if _, err := netpkg.ParseCIDRs(allow); err != nil {
+		return fmt.Errorf("allowSourceCIDRs: %v", err)
+	}
+	if _, err := netpkg.ParseCIDRs(deny); err != nil {
+		return fmt.Errorf("denySourceCIDRs: %v", err)
+	}
+	return nil
+}
+
+func validateGroupHealthCheckConfig(c *v1.ProxyBaseConfig) error {
+	hc := c.LoadBalancer.HealthCheck
+	if hc.Type == "" {
+		return nil
+	}
+	if !slices.Contains(SupportedGroupHealthCheckTypes, hc.Type) {
+		return fmt.Errorf("not support loadBalancer.healthCheck.type: %s", hc.Type)
+	}
+	if c.LoadBalancer.Group == "" || (c.Type != string(v1.ProxyTypeTCP) && c.Type != string(v1.ProxyTypeHTTP)) {
+		return fmt.Errorf("loadBalancer.healthCheck is only supported by tcp and http proxies in a group")
+	}
+	if hc.Type == "http" && hc.Path == "" {
+		return fmt.Errorf("loadBalancer.healthCheck.path should not be empty")
+	}
+	if hc.Type == "payload" && hc.Send == "" {
+		return fmt.Errorf("loadBalancer.healthCheck.send should not be empty")
+	}
 	return nil
 }
 
@@ -61,11 +124,32 @@ func validateDomainConfigForClient(c *v1.DomainConfig) error {
 	if c.SubDomain == "" && len(c.CustomDomains) == 0 {
 		return errors.New("subdomain and custom domains should not be both empty")
 	}
+	for _, domain := range c.CustomDomains {
+		if err := validateRouteRegexp(domain); err != nil {
+			return fmt.Errorf("invalid custom domain: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateRouteRegexp checks domains and locations starting with vhost.RegexPrefix.
+func validateRouteRegexp(s string) error {
+	if expr, ok := strings.CutPrefix(s, vhost.RegexPrefix); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid regexp [%s]: %v", expr, err)
+		}
+	}
 	return nil
 }
 
 func validateDomainConfigForServer(c *v1.DomainConfig, s *v1.ServerConfig) error {
 	for _, domain := range c.CustomDomains {
+		if strings.HasPrefix(domain, vhost.RegexPrefix) {
+			if err := validateRegexDomainForServer(domain, s); err != nil {
+				return err
+			}
+			continue
+		}
 		if s.SubDomainHost != "" && len(strings.Split(s.SubDomainHost, ".")) < len(strings.Split(domain, ".")) {
 			if strings.Contains(domain, s.SubDomainHost) {
 				return fmt.Errorf("custom domain [%s] should not belong to subdomain host [%s]", domain, s.SubDomainHost)
@@ -85,6 +169,23 @@ func validateDomainConfigForServer(c *v1.DomainConfig, s *v1.ServerConfig) error
 	return nil
 }
 
+func validateRegexDomainForServer(domain string, s *v1.ServerConfig) error {
+	if !s.AllowRegexDomains {
+		return fmt.Errorf("custom domain [%s] is a regexp, which is not allowed by server", domain)
+	}
+	suffix, ok := vhost.RegexDomainSuffix(domain)
+	if !ok || !strings.Contains(suffix, ".") {
+		return fmt.Errorf("custom domain [%s] should end with a literal domain, e.g. \\.example\\.com$", domain)
+	}
+	if s.SubDomainHost != "" {
+		host := "." + s.SubDomainHost
+		if strings.HasSuffix(host, suffix) || strings.HasSuffix(suffix, host) {
+			return fmt.Errorf("custom domain [%s] should not match subdomain host [%s]", domain, s.SubDomainHost)
+		}
+	}
+	return nil
+}
+
 func ValidateProxyConfigurerForClient(c v1.ProxyConfigurer) error {
 	base := c.GetBaseConfig()
 	if err := validateProxyBaseConfigForClient(base); err != nil {
@@ -132,6 +233,18 @@ func validateTCPMuxProxyConfigForClient(c *v1.TCPMuxProxyConfig) error {
 }
 
 func validateHTTPProxyConfigForClient(c *v1.HTTPProxyConfig) error {
+	for _, location := range c.Locations {
+		if err := validateRouteRegexp(location); err != nil {
+			return fmt.Errorf("invalid location: %v", err)
+		}
+	}
+	for _, method := range c.Methods {
+		if method == "" || strings.ContainsFunc(method, func(r rune) bool {
+			return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+		}) {
+			return fmt.Errorf("invalid method [%s]", method)
+		}
+	}
 	return validateDomainConfigForClient(&c.DomainConfig)
 }
 
@@ -140,14 +253,36 @@ func validateHTTPSProxyConfigForClient(c *v1.HTTPSProxyConfig) error {
 }
 
 func validateSTCPProxyConfigForClient(c *v1.STCPProxyConfig) error {
-	return nil
+	return validateVisitorCredentials(c.Credentials)
 }
 
 func validateXTCPProxyConfigForClient(c *v1.XTCPProxyConfig) error {
-	return nil
+	return validateVisitorCredentials(c.Credentials)
 }
 
 func validateSUDPProxyConfigForClient(c *v1.SUDPProxyConfig) error {
+	return validateVisitorCredentials(c.Credentials)
+}
+
+func validateVisitorCredentials(credentials []v1.VisitorCredential) error {
+	names := make(map[string]struct{}, len(credentials))
+	for _, c := range credentials {
+		if c.Name == "" {
+			return errors.New("credential name should not be empty")
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("duplicate credential name: %s", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if c.SecretKey == "" {
+			return fmt.Errorf("credential %s: secretKey should not be empty", c.Name)
+		}
+		if c.ExpiresAt != "" {
+			if _, err := time.Parse(time.RFC3339, c.ExpiresAt); err != nil {
+				return fmt.Errorf("credential %s: invalid expiresAt, it should be in RFC3339 format: %v", c.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -200,6 +335,9 @@ func validateHTTPProxyConfigForServer(c *v1.HTTPProxyConfig, s *v1.ServerConfig)
 	if s.VhostHTTPPort == 0 {
 		return fmt.Errorf("type [http] not supported when vhost http port is not set")
 	}
+	if c.RoutePriority < 0 || c.RoutePriority > s.MaxRoutePriority {
+		return fmt.Errorf("routePriority should be between 0 and %d, which is maxRoutePriority of server", s.MaxRoutePriority)
+	}
 
 	return validateDomainConfigForServer(&c.DomainConfig, s)
 }
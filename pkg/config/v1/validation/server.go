@@ -3,6 +3,7 @@ package validation
 import (
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/samber/lo"
 
@@ -21,6 +22,13 @@ func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
 		errs = AppendError(errs, fmt.Errorf("invalid auth additional scopes, optional values are %v", SupportedAuthAdditionalScopes))
 	}
 
+	if c.Auth.Method == v1.AuthMethodMTLS {
+		errs = AppendError(errs, validateAuthMTLSServerConfig(c))
+	}
+	if c.Auth.Method == v1.AuthMethodJWT && c.Auth.JWT.JWKSFile == "" {
+		errs = AppendError(errs, fmt.Errorf("auth method jwt requires auth.jwt.jwksFile"))
+	}
+
 	if err := validateLogConfig(&c.Log); err != nil {
 		errs = AppendError(errs, err)
 	}
@@ -36,6 +44,28 @@ func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
 	errs = AppendError(errs, ValidatePort(c.VhostHTTPSPort, "vhostHTTPSPort"))
 	errs = AppendError(errs, ValidatePort(c.TCPMuxHTTPConnectPort, "tcpMuxHTTPConnectPort"))
 
+	if c.AccessLog.MaxSize < 0 || c.AccessLog.MaxBackups < 0 {
+		errs = AppendError(errs, fmt.Errorf("accessLog.maxSize and accessLog.maxBackups should not be negative"))
+	}
+	if c.Metrics.RetentionDays < 0 {
+		errs = AppendError(errs, fmt.Errorf("metrics.retentionDays should not be negative"))
+	}
+	if c.Metrics.FlushInterval < 0 {
+		errs = AppendError(errs, fmt.Errorf("metrics.flushInterval should not be negative"))
+	}
+
+	if err := validateSourceCIDRs(c.AllowSourceCIDRs, c.DenySourceCIDRs); err != nil {
+		errs = AppendError(errs, err)
+	}
+
+	if c.ACME.Enable && (c.VhostHTTPPort <= 0 || c.VhostHTTPSPort <= 0) {
+		errs = AppendError(errs, fmt.Errorf("acme requires both vhostHTTPPort and vhostHTTPSPort"))
+	}
+
+	if err := validateTrafficQuotaConfig(&c.TrafficQuota); err != nil {
+		errs = AppendError(errs, err)
+	}
+
 	for _, p := range c.HTTPPlugins {
 		if !lo.Every(SupportedHTTPPluginOps, p.Ops) {
 			errs = AppendError(errs, fmt.Errorf("invalid http plugin ops, optional values are %v", SupportedHTTPPluginOps))
@@ -43,3 +73,64 @@ func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
 	}
 	return warnings, errs
 }
+
+func validateTrafficQuotaConfig(c *v1.TrafficQuotaConfig) error {
+	var errs error
+	names := make(map[string]struct{})
+	for _, r := range c.Rules {
+		if r.Name == "" {
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules: name should not be empty"))
+			continue
+		}
+		if _, ok := names[r.Name]; ok {
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules: duplicate name [%s]", r.Name))
+		}
+		names[r.Name] = struct{}{}
+
+		if r.Key != v1.TrafficQuotaKeyUser && r.Key != v1.TrafficQuotaKeyRunID &&
+			(!strings.HasPrefix(r.Key, v1.TrafficQuotaKeyMetaPrefix) || r.Key == v1.TrafficQuotaKeyMetaPrefix) {
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules [%s]: invalid key [%s], optional values are user, runID and meta:<key>", r.Name, r.Key))
+		}
+		if !slices.Contains(SupportedTrafficQuotaPeriods, r.Period) {
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules [%s]: invalid period, optional values are %v", r.Name, SupportedTrafficQuotaPeriods))
+		}
+		if r.Limit.Bytes() <= 0 {
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules [%s]: limit should be greater than 0", r.Name))
+		}
+		switch r.Action {
+		case v1.TrafficQuotaActionReject:
+		case v1.TrafficQuotaActionThrottle:
+			if r.ThrottleRate.Bytes() <= 0 {
+				errs = AppendError(errs, fmt.Errorf("trafficQuota.rules [%s]: throttleRate should be greater than 0", r.Name))
+			}
+		default:
+			errs = AppendError(errs, fmt.Errorf("trafficQuota.rules [%s]: invalid action, optional values are %v", r.Name, SupportedTrafficQuotaActions))
+		}
+	}
+	return errs
+}
+
+func validateAuthMTLSServerConfig(c *v1.ServerConfig) error {
+	var errs error
+	if c.Transport.TLS.TrustedCaFile == "" {
+		errs = AppendError(errs, fmt.Errorf("auth method mtls requires transport.tls.trustedCaFile to verify client certificates"))
+	}
+	if !c.Transport.TLS.Force {
+		errs = AppendError(errs, fmt.Errorf("auth method mtls requires transport.tls.force to reject connections without client certificates"))
+	}
+	if !slices.Contains(SupportedMTLSIdentityFrom, c.Auth.MTLS.IdentityFrom) {
+		errs = AppendError(errs, fmt.Errorf("invalid auth.mtls.identityFrom, optional values are %v", SupportedMTLSIdentityFrom))
+	}
+	identities := make(map[string]struct{})
+	for _, r := range c.Auth.MTLS.ProxyNameRules {
+		if r.Identity == "" {
+			errs = AppendError(errs, fmt.Errorf("auth.mtls.proxyNameRules: identity should not be empty"))
+			continue
+		}
+		if _, ok := identities[r.Identity]; ok {
+			errs = AppendError(errs, fmt.Errorf("auth.mtls.proxyNameRules: duplicate identity [%s]", r.Identity))
+		}
+		identities[r.Identity] = struct{}{}
+	}
+	return errs
+}
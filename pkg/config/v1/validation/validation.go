@@ -19,6 +19,13 @@ var (
 	SupportedAuthMethods = []v1.AuthMethod{
 		"token",
 		"oidc",
+		"mtls",
+		"jwt",
+	}
+
+	SupportedMTLSIdentityFrom = []string{
+		v1.MTLSIdentityFromCommonName,
+		v1.MTLSIdentityFromSAN,
 	}
 
 	SupportedAuthAdditionalScopes = []v1.AuthScope{
@@ -41,6 +48,32 @@ var (
 		splugin.OpPing,
 		splugin.OpNewWorkConn,
 		splugin.OpNewUserConn,
+		splugin.OpNewHTTPRequest,
+	}
+
+	SupportedTrafficQuotaPeriods = []string{
+		v1.TrafficQuotaPeriodDaily,
+		v1.TrafficQuotaPeriodMonthly,
+		v1.TrafficQuotaPeriodTotal,
+	}
+
+	SupportedTrafficQuotaActions = []string{
+		v1.TrafficQuotaActionReject,
+		v1.TrafficQuotaActionThrottle,
+	}
+
+	SupportedLoadBalancerStrategies = []string{
+		v1.LoadBalancerStrategyRoundRobin,
+		v1.LoadBalancerStrategyLeastConn,
+		v1.LoadBalancerStrategyWeighted,
+		v1.LoadBalancerStrategySourceIPHash,
+		v1.LoadBalancerStrategyStickyCookie,
+	}
+
+	SupportedGroupHealthCheckTypes = []string{
+		"tcp",
+		"http",
+		"payload",
 	}
 )
 
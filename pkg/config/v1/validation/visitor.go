@@ -37,6 +37,10 @@ func validateVisitorBaseConfig(c *v1.VisitorBaseConfig) error {
 	if c.BindPort == 0 {
 		return errors.New("bind port is required")
 	}
+
+	if c.Credential != "" && c.SecretKey == "" {
+		return errors.New("secret key of the credential is required")
+	}
 	return nil
 }
 
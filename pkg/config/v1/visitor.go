@@ -22,6 +22,9 @@ type VisitorBaseConfig struct {
 	Type      string           `json:"type"`
 	Transport VisitorTransport `json:"transport,omitempty"`
 	SecretKey string           `json:"secretKey,omitempty"`
+	// Credential is the name of the credential of this visitor in the proxy,
+	// SecretKey is the secret key of the credential if it's set.
+	Credential string `json:"credential,omitempty"`
 	// if the server user is not set, it defaults to the current user
 	ServerUser string `json:"serverUser,omitempty"`
 	ServerName string `json:"serverName,omitempty"`
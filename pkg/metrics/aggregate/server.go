@@ -91,3 +91,9 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 		v.AddTrafficOut(name, proxyType, trafficBytes)
 	}
 }
+
+func (m *serverMetrics) RejectConnection(name string, proxyType string, reason string) {
+	for _, v := range m.ms {
+		v.RejectConnection(name, proxyType, reason)
+	}
+}
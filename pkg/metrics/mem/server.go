@@ -15,11 +15,12 @@
 package mem
 
 import (
-	"gitee.com/menciis/logx"
+	"context"
 	"sync"
 	"time"
 
 	"github.com/iami317/hepx/pkg/util/metric"
+	"github.com/iami317/hepx/pkg/util/xlog"
 	server "github.com/iami317/hepx/server/metrics"
 )
 
@@ -37,12 +38,24 @@ func init() {
 }
 
 type serverMetrics struct {
-	info *ServerStatistics
-	mu   sync.Mutex
+	info        *ServerStatistics
+	reserveDays int64
+	xl          *xlog.Logger
+
+	// names of proxies changed or removed since the last flush, only tracked if storage is set
+	storage Storage
+	dirty   map[string]struct{}
+	removed map[string]struct{}
+
+	mu sync.Mutex
 }
 
 func newServerMetrics() *serverMetrics {
 	return &serverMetrics{
+		reserveDays: ReserveDays,
+		xl:          xlog.New(),
+		dirty:       make(map[string]struct{}),
+		removed:     make(map[string]struct{}),
 		info: &ServerStatistics{
 			TotalTrafficIn:  metric.NewDateCounter(ReserveDays),
 			TotalTrafficOut: metric.NewDateCounter(ReserveDays),
@@ -61,8 +74,12 @@ func (m *serverMetrics) run() {
 		for {
 			time.Sleep(12 * time.Hour)
 			start := time.Now()
-			count, total := m.clearUselessInfo(time.Duration(7*24) * time.Hour)
-			logx.Verbosef("clear useless proxy statistics data count %d/%d, cost %v", count, total, time.Since(start))
+			m.mu.Lock()
+			offlineDuration := time.Duration(m.reserveDays*24) * time.Hour
+			xl := m.xl
+			m.mu.Unlock()
+			count, total := m.clearUselessInfo(offlineDuration)
+			xl.Debugf("clear useless proxy statistics data count %d/%d, cost %v", count, total, time.Since(start))
 		}
 	}()
 }
@@ -79,8 +96,10 @@ func (m *serverMetrics) clearUselessInfo(continuousOfflineDuration time.Duration
 			data.LastStartTime.Before(data.LastCloseTime) &&
 			time.Since(data.LastCloseTime) > continuousOfflineDuration {
 			delete(m.info.ProxyStatistics, name)
+			m.markRemoved(name)
 			count++
-			logx.Verbosef("clear proxy [%s]'s statistics data, lastCloseTime: [%s]", name, data.LastCloseTime.String())
+			m.xl.Spawn().AddPrefix(xlog.LogPrefix{Name: "proxyName", Value: name, Hidden: true}).
+				Debugf("clear proxy [%s]'s statistics data, lastCloseTime: [%s]", name, data.LastCloseTime.String())
 		}
 	}
 	return count, total
@@ -111,15 +130,17 @@ func (m *serverMetrics) NewProxy(name string, proxyType string) {
 	proxyStats, ok := m.info.ProxyStatistics[name]
 	if !(ok && proxyStats.ProxyType == proxyType) {
 		proxyStats = &ProxyStatistics{
-			Name:       name,
-			ProxyType:  proxyType,
-			CurConns:   metric.NewCounter(),
-			TrafficIn:  metric.NewDateCounter(ReserveDays),
-			TrafficOut: metric.NewDateCounter(ReserveDays),
+			Name:          name,
+			ProxyType:     proxyType,
+			CurConns:      metric.NewCounter(),
+			RejectedConns: metric.NewCounter(),
+			TrafficIn:     metric.NewDateCounter(m.reserveDays),
+			TrafficOut:    metric.NewDateCounter(m.reserveDays),
 		}
 		m.info.ProxyStatistics[name] = proxyStats
 	}
 	proxyStats.LastStartTime = time.Now()
+	m.markDirty(name)
 }
 
 func (m *serverMetrics) CloseProxy(name string, proxyType string) {
@@ -130,6 +151,7 @@ func (m *serverMetrics) CloseProxy(name string, proxyType string) {
 	}
 	if proxyStats, ok := m.info.ProxyStatistics[name]; ok {
 		proxyStats.LastCloseTime = time.Now()
+		m.markDirty(name)
 	}
 }
 
@@ -167,6 +189,7 @@ func (m *serverMetrics) AddTrafficIn(name string, _ string, trafficBytes int64)
 	if ok {
 		proxyStats.TrafficIn.Inc(trafficBytes)
 		m.info.ProxyStatistics[name] = proxyStats
+		m.markDirty(name)
 	}
 }
 
@@ -180,6 +203,15 @@ func (m *serverMetrics) AddTrafficOut(name string, _ string, trafficBytes int64)
 	if ok {
 		proxyStats.TrafficOut.Inc(trafficBytes)
 		m.info.ProxyStatistics[name] = proxyStats
+		m.markDirty(name)
+	}
+}
+
+func (m *serverMetrics) RejectConnection(name string, _ string, _ string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if proxyStats, ok := m.info.ProxyStatistics[name]; ok {
+		proxyStats.RejectedConns.Inc(1)
 	}
 }
 
@@ -217,6 +249,7 @@ func (m *serverMetrics) GetProxiesByType(proxyType string) []*ProxyStats {
 			TodayTrafficIn:  proxyStats.TrafficIn.TodayCount(),
 			TodayTrafficOut: proxyStats.TrafficOut.TodayCount(),
 			CurConns:        int64(proxyStats.CurConns.Count()),
+			RejectedConns:   int64(proxyStats.RejectedConns.Count()),
 		}
 		if !proxyStats.LastStartTime.IsZero() {
 			ps.LastStartTime = proxyStats.LastStartTime.Format("01-02 15:04:05")
@@ -248,6 +281,7 @@ func (m *serverMetrics) GetProxiesByTypeAndName(proxyType string, proxyName stri
 			TodayTrafficIn:  proxyStats.TrafficIn.TodayCount(),
 			TodayTrafficOut: proxyStats.TrafficOut.TodayCount(),
 			CurConns:        int64(proxyStats.CurConns.Count()),
+			RejectedConns:   int64(proxyStats.RejectedConns.Count()),
 		}
 		if !proxyStats.LastStartTime.IsZero() {
 			res.LastStartTime = proxyStats.LastStartTime.Format("01-02 15:04:05")
@@ -269,8 +303,170 @@ func (m *serverMetrics) GetProxyTraffic(name string) (res *ProxyTrafficInfo) {
 		res = &ProxyTrafficInfo{
 			Name: name,
 		}
-		res.TrafficIn = proxyStats.TrafficIn.GetLastDaysCount(ReserveDays)
-		res.TrafficOut = proxyStats.TrafficOut.GetLastDaysCount(ReserveDays)
+		res.TrafficIn = proxyStats.TrafficIn.GetLastDaysCount(m.reserveDays)
+		res.TrafficOut = proxyStats.TrafficOut.GetLastDaysCount(m.reserveDays)
 	}
 	return
 }
+
+// SetRetentionDays sets how many days of traffic are kept. It must be called before any proxy is registered.
+func SetRetentionDays(days int64) {
+	if days <= 0 {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.reserveDays = days
+	sm.info.TotalTrafficIn = metric.NewDateCounter(days)
+	sm.info.TotalTrafficOut = metric.NewDateCounter(days)
+}
+
+// SetLogger sets the logger of clearing statistics of offline proxies.
+func SetLogger(xl *xlog.Logger) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.xl = xl
+}
+
+// EnablePersistence restores statistics from storage and saves changes to it every interval until ctx is done.
+// It must be called before any proxy is registered.
+func EnablePersistence(ctx context.Context, storage Storage, interval time.Duration) error {
+	snapshot, err := storage.Load()
+	if err != nil {
+		return err
+	}
+	sm.restore(snapshot, time.Now())
+	sm.mu.Lock()
+	sm.storage = storage
+	sm.mu.Unlock()
+
+	xl := xlog.FromContextSafe(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := sm.flush(time.Now()); err != nil {
+					xl.Warnf("save proxy statistics error: %v", err)
+				}
+			case <-ctx.Done():
+				if err := sm.flush(time.Now()); err != nil {
+					xl.Warnf("save proxy statistics error: %v", err)
+				}
+				storage.Close()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// markDirty must be called with m.mu held.
+func (m *serverMetrics) markDirty(name string) {
+	if m.storage == nil {
+		return
+	}
+	m.dirty[name] = struct{}{}
+	delete(m.removed, name)
+}
+
+// markRemoved must be called with m.mu held.
+func (m *serverMetrics) markRemoved(name string) {
+	if m.storage == nil {
+		return
+	}
+	m.removed[name] = struct{}{}
+	delete(m.dirty, name)
+}
+
+func (m *serverMetrics) restore(s *Snapshot, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.info.TotalTrafficIn, m.info.TotalTrafficOut = dailyToCounters(s.Server, m.reserveDays, now)
+	for _, record := range s.Proxies {
+		proxyStats := &ProxyStatistics{
+			Name:          record.Name,
+			ProxyType:     record.Type,
+			CurConns:      metric.NewCounter(),
+			RejectedConns: metric.NewCounter(),
+			LastStartTime: record.LastStartTime,
+			LastCloseTime: record.LastCloseTime,
+		}
+		proxyStats.TrafficIn, proxyStats.TrafficOut = dailyToCounters(record.Traffic, m.reserveDays, now)
+		// proxies online before the restart are offline now
+		if !proxyStats.LastStartTime.IsZero() && !proxyStats.LastStartTime.Before(proxyStats.LastCloseTime) {
+			proxyStats.LastCloseTime = now
+		}
+		m.info.ProxyStatistics[record.Name] = proxyStats
+	}
+}
+
+func (m *serverMetrics) flush(now time.Time) error {
+	m.mu.Lock()
+	if m.storage == nil {
+		m.mu.Unlock()
+		return nil
+	}
+	change := &Snapshot{
+		Server: countersToDaily(m.info.TotalTrafficIn, m.info.TotalTrafficOut, m.reserveDays, now),
+	}
+	for name := range m.dirty {
+		proxyStats, ok := m.info.ProxyStatistics[name]
+		if !ok {
+			continue
+		}
+		change.Proxies = append(change.Proxies, &ProxyRecord{
+			Name:          name,
+			Type:          proxyStats.ProxyType,
+			LastStartTime: proxyStats.LastStartTime,
+			LastCloseTime: proxyStats.LastCloseTime,
+			Traffic:       countersToDaily(proxyStats.TrafficIn, proxyStats.TrafficOut, m.reserveDays, now),
+		})
+	}
+	for name := range m.removed {
+		change.Removed = append(change.Removed, name)
+	}
+	m.dirty = make(map[string]struct{})
+	m.removed = make(map[string]struct{})
+	storage := m.storage
+	m.mu.Unlock()
+
+	return storage.Save(change)
+}
+
+func dailyToCounters(traffic []DailyTraffic, reserveDays int64, now time.Time) (in metric.DateCounter, out metric.DateCounter) {
+	today, _ := time.ParseInLocation(dateLayout, now.Format(dateLayout), now.Location())
+	inCounts := make([]int64, reserveDays)
+	outCounts := make([]int64, reserveDays)
+	for _, t := range traffic {
+		date, err := time.ParseInLocation(dateLayout, t.Date, now.Location())
+		if err != nil {
+			continue
+		}
+		days := int64(today.Sub(date).Hours()+12) / 24
+		if days < 0 || days >= reserveDays {
+			continue
+		}
+		inCounts[days] = t.TrafficIn
+		outCounts[days] = t.TrafficOut
+	}
+	return metric.NewDateCounterWithCounts(reserveDays, inCounts), metric.NewDateCounterWithCounts(reserveDays, outCounts)
+}
+
+func countersToDaily(in metric.DateCounter, out metric.DateCounter, reserveDays int64, now time.Time) []DailyTraffic {
+	inCounts := in.GetLastDaysCount(reserveDays)
+	outCounts := out.GetLastDaysCount(reserveDays)
+	traffic := make([]DailyTraffic, 0)
+	for i := range inCounts {
+		if inCounts[i] == 0 && outCounts[i] == 0 {
+			continue
+		}
+		traffic = append(traffic, DailyTraffic{
+			Date:       now.AddDate(0, 0, -i).Format(dateLayout),
+			TrafficIn:  inCounts[i],
+			TrafficOut: outCounts[i],
+		})
+	}
+	return traffic
+}
@@ -0,0 +1,180 @@
+package mem
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+type DailyTraffic struct {
+	// Date is formatted as "2006-01-02" in local time.
+	Date       string `json:"date"`
+	TrafficIn  int64  `json:"in,omitempty"`
+	TrafficOut int64  `json:"out,omitempty"`
+}
+
+type ProxyRecord struct {
+	Name          string         `json:"name"`
+	Type          string         `json:"type"`
+	LastStartTime time.Time      `json:"lastStartTime,omitempty"`
+	LastCloseTime time.Time      `json:"lastCloseTime,omitempty"`
+	Traffic       []DailyTraffic `json:"traffic,omitempty"`
+}
+
+// Snapshot is a set of changes of the statistics. Records in Proxies replace the saved records with
+// the same name, and the proxies in Removed are deleted.
+type Snapshot struct {
+	Server  []DailyTraffic `json:"server,omitempty"`
+	Proxies []*ProxyRecord `json:"proxies,omitempty"`
+	Removed []string       `json:"removed,omitempty"`
+}
+
+// Storage persists statistics of the mem collector, so GetProxyTraffic and GetProxiesByType
+// still have data after frps restarts.
+type Storage interface {
+	// Load returns all saved statistics merged into one snapshot.
+	Load() (*Snapshot, error)
+	// Save persists the changes in s.
+	Save(s *Snapshot) error
+	Close() error
+}
+
+// FileStorage is a Storage that appends every snapshot to a file as a JSON line.
+// The file is compacted into one line when it's loaded and when it has grown too large.
+type FileStorage struct {
+	path string
+
+	f      *os.File
+	merged *Snapshot
+	lines  int
+	mu     sync.Mutex
+}
+
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{
+		path: path,
+	}
+}
+
+func (s *FileStorage) Load() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.merged = &Snapshot{}
+	f, err := os.Open(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var change Snapshot
+			// skip the broken line left by a crash while appending
+			if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+				continue
+			}
+			mergeSnapshot(s.merged, &change)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s.merged, nil
+}
+
+func (s *FileStorage) Save(change *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return errors.New("file storage is not loaded")
+	}
+	mergeSnapshot(s.merged, change)
+	buf, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	s.lines++
+
+	if s.lines > 2*len(s.merged.Proxies)+100 {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// compact rewrites the file with the merged snapshot, s.mu must be held.
+func (s *FileStorage) compact() error {
+	buf, err := json.Marshal(s.merged)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(buf, '\n'), 0o600); err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.lines = 1
+	return nil
+}
+
+func mergeSnapshot(dst *Snapshot, change *Snapshot) {
+	if len(change.Server) > 0 {
+		dst.Server = change.Server
+	}
+	for _, record := range change.Proxies {
+		replaced := false
+		for i, old := range dst.Proxies {
+			if old.Name == record.Name {
+				dst.Proxies[i] = record
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.Proxies = append(dst.Proxies, record)
+		}
+	}
+	for _, name := range change.Removed {
+		for i, old := range dst.Proxies {
+			if old.Name == name {
+				dst.Proxies = append(dst.Proxies[:i], dst.Proxies[i+1:]...)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "metrics.log")
+
+	s := NewFileStorage(path)
+	snapshot, err := s.Load()
+	require.NoError(err)
+	require.Empty(snapshot.Proxies)
+
+	require.NoError(s.Save(&Snapshot{
+		Proxies: []*ProxyRecord{
+			{Name: "a", Type: "tcp", Traffic: []DailyTraffic{{Date: "2024-01-02", TrafficIn: 1}}},
+			{Name: "b", Type: "udp"},
+		},
+	}))
+	require.NoError(s.Save(&Snapshot{
+		Proxies: []*ProxyRecord{
+			{Name: "a", Type: "tcp", Traffic: []DailyTraffic{{Date: "2024-01-02", TrafficIn: 5}}},
+		},
+		Removed: []string{"b"},
+	}))
+	// a line broken by a crash is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(err)
+	_, _ = f.WriteString(`{"proxies":[{"na`)
+	f.Close()
+	require.NoError(s.Close())
+
+	s = NewFileStorage(path)
+	snapshot, err = s.Load()
+	require.NoError(err)
+	require.Len(snapshot.Proxies, 1)
+	require.Equal("a", snapshot.Proxies[0].Name)
+	require.EqualValues(5, snapshot.Proxies[0].Traffic[0].TrafficIn)
+	require.NoError(s.Close())
+}
+
+func TestRestoreAndFlush(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "metrics.log")
+	now := time.Now()
+
+	m := newServerMetrics()
+	m.storage = NewFileStorage(path)
+	_, err := m.storage.Load()
+	require.NoError(err)
+	m.NewProxy("ssh", "tcp")
+	m.AddTrafficIn("ssh", "tcp", 100)
+	m.AddTrafficOut("ssh", "tcp", 200)
+	require.NoError(m.flush(now))
+	require.NoError(m.storage.Close())
+
+	storage := NewFileStorage(path)
+	snapshot, err := storage.Load()
+	require.NoError(err)
+	restored := newServerMetrics()
+	restored.restore(snapshot, now)
+
+	traffic := restored.GetProxyTraffic("ssh")
+	require.NotNil(traffic)
+	require.EqualValues(100, traffic.TrafficIn[0])
+	require.EqualValues(200, traffic.TrafficOut[0])
+	stats := restored.GetProxiesByType("tcp")
+	require.Len(stats, 1)
+	require.NotEmpty(stats[0].LastCloseTime)
+	require.EqualValues(100, restored.GetServer().TotalTrafficIn)
+}
@@ -40,6 +40,7 @@ type ProxyStats struct {
 	LastStartTime   string
 	LastCloseTime   string
 	CurConns        int64
+	RejectedConns   int64
 }
 
 type ProxyTrafficInfo struct {
@@ -54,6 +55,7 @@ type ProxyStatistics struct {
 	TrafficIn     metric.DateCounter
 	TrafficOut    metric.DateCounter
 	CurConns      metric.Counter
+	RejectedConns metric.Counter
 	LastStartTime time.Time
 	LastCloseTime time.Time
 }
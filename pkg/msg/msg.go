@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"net"
 	"reflect"
+	"slices"
 )
 
 const (
@@ -39,6 +40,10 @@ const (
 	TypeNatHoleResp        = 'm'
 	TypeNatHoleSid         = '5'
 	TypeNatHoleReport      = '6'
+	TypeCloseControl       = '7'
+	TypePushProxies        = '8'
+	TypePushProxiesResp    = '9'
+	TypeQuotaExceeded      = 'q'
 )
 
 var msgTypeMap = map[byte]interface{}{
@@ -60,9 +65,27 @@ var msgTypeMap = map[byte]interface{}{
 	TypeNatHoleResp:        NatHoleResp{},
 	TypeNatHoleSid:         NatHoleSid{},
 	TypeNatHoleReport:      NatHoleReport{},
+	TypeCloseControl:       CloseControl{},
+	TypePushProxies:        PushProxies{},
+	TypePushProxiesResp:    PushProxiesResp{},
+	TypeQuotaExceeded:      QuotaExceeded{},
 }
 
-var TypeNameNatHoleResp = reflect.TypeOf(&NatHoleResp{}).Elem().Name()
+var (
+	TypeNameNatHoleResp     = reflect.TypeOf(&NatHoleResp{}).Elem().Name()
+	TypeNamePushProxiesResp = reflect.TypeOf(&PushProxiesResp{}).Elem().Name()
+)
+
+// Features announced by frpc in the login message. frps must not use a feature the client didn't announce,
+// old clients close the connection when they receive a message type they don't know.
+const (
+	// FeatureProxyPush means the client accepts PushProxies messages.
+	FeatureProxyPush = "proxy-push"
+	// FeatureQuotaNotify means the client accepts QuotaExceeded messages.
+	FeatureQuotaNotify = "quota-notify"
+	// FeatureQUICDatagram means the client can carry udp proxies in QUIC datagrams, see StartWorkConn.
+	FeatureQUICDatagram = "quic-datagram"
+)
 
 type ClientSpec struct {
 	// Due to the support of VirtualClient, frps needs to know the client type in order to
@@ -90,6 +113,13 @@ type Login struct {
 
 	// Some global configures.
 	PoolCount int `json:"pool_count,omitempty"`
+
+	// Optional features supported by the client.
+	Features []string `json:"features,omitempty"`
+}
+
+func (l *Login) HasFeature(feature string) bool {
+	return slices.Contains(l.Features, feature)
 }
 
 func (l *Login) String() string {
@@ -105,16 +135,21 @@ type LoginResp struct {
 
 // NewProxy 当 frpc 登录成功时，将此消息发送到 frps 以运行新代理。
 type NewProxy struct {
-	ProxyName          string            `json:"proxy_name,omitempty"`
-	ProxyType          string            `json:"proxy_type,omitempty"`
-	UseEncryption      bool              `json:"use_encryption,omitempty"`
-	UseCompression     bool              `json:"use_compression,omitempty"`
-	BandwidthLimit     string            `json:"bandwidth_limit,omitempty"`
-	BandwidthLimitMode string            `json:"bandwidth_limit_mode,omitempty"`
-	Group              string            `json:"group,omitempty"`
-	GroupKey           string            `json:"group_key,omitempty"`
-	Metas              map[string]string `json:"metas,omitempty"`
-	Annotations        map[string]string `json:"annotations,omitempty"`
+	ProxyName            string            `json:"proxy_name,omitempty"`
+	ProxyType            string            `json:"proxy_type,omitempty"`
+	UseEncryption        bool              `json:"use_encryption,omitempty"`
+	UseCompression       bool              `json:"use_compression,omitempty"`
+	BandwidthLimit       string            `json:"bandwidth_limit,omitempty"`
+	BandwidthLimitMode   string            `json:"bandwidth_limit_mode,omitempty"`
+	Group                string            `json:"group,omitempty"`
+	GroupKey             string            `json:"group_key,omitempty"`
+	LoadBalancerStrategy string            `json:"lb_strategy,omitempty"`
+	Weight               int               `json:"weight,omitempty"`
+	GroupHealthCheck     *GroupHealthCheck `json:"group_health_check,omitempty"`
+	Metas                map[string]string `json:"metas,omitempty"`
+	Annotations          map[string]string `json:"annotations,omitempty"`
+	AllowSourceCIDRs     []string          `json:"allow_source_cidrs,omitempty"`
+	DenySourceCIDRs      []string          `json:"deny_source_cidrs,omitempty"`
 
 	// tcp and udp only
 	RemotePort int `json:"remote_port,omitempty"`
@@ -129,15 +164,43 @@ type NewProxy struct {
 	Headers           map[string]string `json:"headers,omitempty"`
 	ResponseHeaders   map[string]string `json:"response_headers,omitempty"`
 	RouteByHTTPUser   string            `json:"route_by_http_user,omitempty"`
+	Methods           []string          `json:"methods,omitempty"`
+	RoutePriority     int               `json:"route_priority,omitempty"`
 
 	// stcp, sudp, xtcp
-	Sk         string   `json:"sk,omitempty"`
-	AllowUsers []string `json:"allow_users,omitempty"`
+	Sk          string              `json:"sk,omitempty"`
+	AllowUsers  []string            `json:"allow_users,omitempty"`
+	Credentials []VisitorCredential `json:"credentials,omitempty"`
 
 	// tcpmux
 	Multiplexer string `json:"multiplexer,omitempty"`
 }
 
+// VisitorCredential is a named secret key of a visitor of a stcp, xtcp or sudp proxy.
+type VisitorCredential struct {
+	Name string `json:"name,omitempty"`
+	Sk   string `json:"sk,omitempty"`
+	// ExpiresAt is a unix timestamp, zero means the credential never expires.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// GroupHealthCheck describes how frps checks a proxy of a group.
+type GroupHealthCheck struct {
+	Type            string       `json:"type,omitempty"`
+	TimeoutSeconds  int          `json:"timeout_seconds,omitempty"`
+	MaxFailed       int          `json:"max_failed,omitempty"`
+	IntervalSeconds int          `json:"interval_seconds,omitempty"`
+	Path            string       `json:"path,omitempty"`
+	HTTPHeaders     []HTTPHeader `json:"http_headers,omitempty"`
+	Send            string       `json:"send,omitempty"`
+	Expect          string       `json:"expect,omitempty"`
+}
+
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 func (newProxy *NewProxy) String() string {
 	ns, _ := json.Marshal(newProxy)
 	return string(ns)
@@ -149,8 +212,16 @@ type NewProxyResp struct {
 	Error      string `json:"error,omitempty"`
 }
 
+// CloseProxy is sent by frpc to close one of its proxies, or by frps when a proxy is closed on the server side.
 type CloseProxy struct {
 	ProxyName string `json:"proxy_name,omitempty"`
+	// Reason is only set by frps to tell frpc why the proxy was closed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CloseControl is sent by frps before it closes the control connection of a client.
+type CloseControl struct {
+	Reason string `json:"reason,omitempty"`
 }
 
 type NewWorkConn struct {
@@ -168,6 +239,8 @@ type StartWorkConn struct {
 	SrcPort   uint16 `json:"src_port,omitempty"`
 	DstPort   uint16 `json:"dst_port,omitempty"`
 	Error     string `json:"error,omitempty"`
+	// DatagramFlowID is set for udp proxies if their packets can be sent in QUIC datagrams of the flow.
+	DatagramFlowID uint32 `json:"datagram_flow_id,omitempty"`
 }
 
 type NewVisitorConn struct {
@@ -177,6 +250,7 @@ type NewVisitorConn struct {
 	Timestamp      int64  `json:"timestamp,omitempty"`
 	UseEncryption  bool   `json:"use_encryption,omitempty"`
 	UseCompression bool   `json:"use_compression,omitempty"`
+	Credential     string `json:"credential,omitempty"`
 }
 
 type NewVisitorConnResp struct {
@@ -197,6 +271,9 @@ type UDPPacket struct {
 	Content    string       `json:"c,omitempty"`
 	LocalAddr  *net.UDPAddr `json:"l,omitempty"`
 	RemoteAddr *net.UDPAddr `json:"r,omitempty"`
+	// BinaryFraming means the sender can read the binary packet frames of pkg/proto/udp, the receiver may
+	// send them instead of UDPPacket since then.
+	BinaryFraming bool `json:"b,omitempty"`
 }
 
 type NatHoleVisitor struct {
@@ -208,6 +285,7 @@ type NatHoleVisitor struct {
 	Timestamp     int64    `json:"timestamp,omitempty"`
 	MappedAddrs   []string `json:"mapped_addrs,omitempty"`
 	AssistedAddrs []string `json:"assisted_addrs,omitempty"`
+	Credential    string   `json:"credential,omitempty"`
 }
 
 type NatHoleClient struct {
@@ -242,6 +320,8 @@ type NatHoleResp struct {
 	AssistedAddrs  []string              `json:"assisted_addrs,omitempty"`
 	DetectBehavior NatHoleDetectBehavior `json:"detect_behavior,omitempty"`
 	Error          string                `json:"error,omitempty"`
+	// Credential is the credential of the visitor in the response to the client.
+	Credential string `json:"credential,omitempty"`
 }
 
 type NatHoleSid struct {
@@ -255,3 +335,25 @@ type NatHoleReport struct {
 	Sid     string `json:"sid,omitempty"`
 	Success bool   `json:"success,omitempty"`
 }
+
+// PushProxies is sent by frps to ask frpc to start proxies and visitors at runtime.
+// Proxies and Visitors are encoded in the same format as in the frpc configuration file.
+type PushProxies struct {
+	TransactionID string            `json:"transaction_id,omitempty"`
+	Proxies       []json.RawMessage `json:"proxies,omitempty"`
+	Visitors      []json.RawMessage `json:"visitors,omitempty"`
+}
+
+type PushProxiesResp struct {
+	TransactionID string `json:"transaction_id,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// QuotaExceeded is sent by frps when a traffic quota of the client is exceeded.
+type QuotaExceeded struct {
+	Rule   string `json:"rule,omitempty"`
+	Period string `json:"period,omitempty"`
+	Action string `json:"action,omitempty"`
+	Limit  int64  `json:"limit,omitempty"`
+	Used   int64  `json:"used,omitempty"`
+}
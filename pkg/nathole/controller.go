@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
-	"gitee.com/menciis/logx"
 	"net"
 	"slices"
 	"strconv"
@@ -16,9 +15,11 @@ import (
 	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/iami317/hepx/pkg/auth"
 	"github.com/iami317/hepx/pkg/msg"
 	"github.com/iami317/hepx/pkg/transport"
 	"github.com/iami317/hepx/pkg/util/util"
+	"github.com/iami317/hepx/pkg/util/xlog"
 )
 
 // NatHoleTimeout seconds.
@@ -31,7 +32,7 @@ func NewTransactionID() string {
 
 type ClientCfg struct {
 	name       string
-	sk         string
+	verifier   *auth.VisitorVerifier
 	allowUsers []string
 	sidCh      chan string
 }
@@ -94,6 +95,7 @@ func NewController(analysisDataReserveDuration time.Duration) (*Controller, erro
 }
 
 func (c *Controller) CleanWorker(ctx context.Context) {
+	xl := xlog.FromContextSafe(ctx)
 	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
 	for {
@@ -101,17 +103,17 @@ func (c *Controller) CleanWorker(ctx context.Context) {
 		case <-ticker.C:
 			start := time.Now()
 			count, total := c.analyzer.Clean()
-			logx.Verbosef("clean %d/%d nathole analysis data, cost %v", count, total, time.Since(start))
+			xl.Debugf("clean %d/%d nathole analysis data, cost %v", count, total, time.Since(start))
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (c *Controller) ListenClient(name string, sk string, allowUsers []string) (chan string, error) {
+func (c *Controller) ListenClient(name string, verifier *auth.VisitorVerifier, allowUsers []string) (chan string, error) {
 	cfg := &ClientCfg{
 		name:       name,
-		sk:         sk,
+		verifier:   verifier,
 		allowUsers: allowUsers,
 		sidCh:      make(chan string),
 	}
@@ -136,7 +138,12 @@ func (c *Controller) GenSid() string {
 	return fmt.Sprintf("%d%s", t, id)
 }
 
-func (c *Controller) HandleVisitor(m *msg.NatHoleVisitor, transporter transport.MessageTransporter, visitorUser string) {
+// sessionLogger returns the logger of ctx with the session id as a structured field.
+func sessionLogger(ctx context.Context, sid string) *xlog.Logger {
+	return xlog.FromContextSafe(ctx).Spawn().AddPrefix(xlog.LogPrefix{Name: "sid", Value: sid, Hidden: true})
+}
+
+func (c *Controller) HandleVisitor(ctx context.Context, m *msg.NatHoleVisitor, transporter transport.MessageTransporter, visitorUser string) {
 	if m.PreCheck {
 		cfg, ok := c.clientCfgs[m.ProxyName]
 		if !ok {
@@ -152,6 +159,7 @@ func (c *Controller) HandleVisitor(m *msg.NatHoleVisitor, transporter transport.
 	}
 
 	sid := c.GenSid()
+	xl := sessionLogger(ctx, sid)
 	session := &Session{
 		sid:                sid,
 		visitorMsg:         m,
@@ -170,18 +178,18 @@ func (c *Controller) HandleVisitor(m *msg.NatHoleVisitor, transporter transport.
 		if !ok {
 			return fmt.Errorf("xtcp server for [%s] doesn't exist", m.ProxyName)
 		}
-		if !util.ConstantTimeEqString(m.SignKey, util.GetAuthKey(clientCfg.sk, m.Timestamp)) {
-			return fmt.Errorf("xtcp connection of [%s] auth failed", m.ProxyName)
+		if _, err := clientCfg.verifier.Verify(m.Credential, m.Timestamp, m.SignKey); err != nil {
+			return fmt.Errorf("xtcp connection of [%s] user [%s] auth failed: %v", m.ProxyName, visitorUser, err)
 		}
 		c.sessions[sid] = session
 		return nil
 	}()
 	if err != nil {
-		logx.Warnf("handle visitorMsg error: %v", err)
+		xl.Warnf("handle visitorMsg error: %v", err)
 		_ = transporter.Send(c.GenNatHoleResponse(m.TransactionID, nil, err.Error()))
 		return
 	}
-	logx.Verbosef("handle visitor message, sid [%s], server name: %s", sid, m.ProxyName)
+	xl.Debugf("handle visitor message, sid [%s], server name: %s", sid, m.ProxyName)
 
 	defer func() {
 		c.mu.Lock()
@@ -199,14 +207,14 @@ func (c *Controller) HandleVisitor(m *msg.NatHoleVisitor, transporter transport.
 	select {
 	case <-session.notifyCh:
 	case <-time.After(time.Duration(NatHoleTimeout) * time.Second):
-		logx.Verbosef("wait for NatHoleClient message timeout, sid [%s]", sid)
+		xl.Debugf("wait for NatHoleClient message timeout, sid [%s]", sid)
 		return
 	}
 
 	// Make hole-punching decisions based on the NAT information of the client and visitor.
-	vResp, cResp, err := c.analysis(session)
+	vResp, cResp, err := c.analysis(xl, session)
 	if err != nil {
-		logx.Verbosef("sid [%s] analysis error: %v", err)
+		xl.Debugf("sid [%s] analysis error: %v", sid, err)
 		vResp = c.GenNatHoleResponse(session.visitorMsg.TransactionID, nil, err.Error())
 		cResp = c.GenNatHoleResponse(session.clientMsg.TransactionID, nil, err.Error())
 	}
@@ -236,14 +244,14 @@ func (c *Controller) HandleVisitor(m *msg.NatHoleVisitor, transporter transport.
 	time.Sleep(time.Duration(cResp.DetectBehavior.ReadTimeoutMs+30000) * time.Millisecond)
 }
 
-func (c *Controller) HandleClient(m *msg.NatHoleClient, transporter transport.MessageTransporter) {
+func (c *Controller) HandleClient(ctx context.Context, m *msg.NatHoleClient, transporter transport.MessageTransporter) {
 	c.mu.RLock()
 	session, ok := c.sessions[m.Sid]
 	c.mu.RUnlock()
 	if !ok {
 		return
 	}
-	logx.Verbosef("handle client message, sid [%s], server name: %s", session.sid, m.ProxyName)
+	sessionLogger(ctx, m.Sid).Debugf("handle client message, sid [%s], server name: %s", session.sid, m.ProxyName)
 	session.clientMsg = m
 	session.clientTransporter = transporter
 	select {
@@ -252,18 +260,19 @@ func (c *Controller) HandleClient(m *msg.NatHoleClient, transporter transport.Me
 	}
 }
 
-func (c *Controller) HandleReport(m *msg.NatHoleReport) {
+func (c *Controller) HandleReport(ctx context.Context, m *msg.NatHoleReport) {
+	xl := sessionLogger(ctx, m.Sid)
 	c.mu.RLock()
 	session, ok := c.sessions[m.Sid]
 	c.mu.RUnlock()
 	if !ok {
-		logx.Verbosef("sid [%s] report make hole success: %v, but session not found", m.Sid, m.Success)
+		xl.Debugf("sid [%s] report make hole success: %v, but session not found", m.Sid, m.Success)
 		return
 	}
 	if m.Success {
 		c.analyzer.ReportSuccess(session.analysisKey, session.recommandMode, session.recommandIndex)
 	}
-	logx.Verbosef("sid [%s] report make hole success: %v, mode %v, index %v",
+	xl.Debugf("sid [%s] report make hole success: %v, mode %v, index %v",
 		m.Sid, m.Success, session.recommandMode, session.recommandIndex)
 }
 
@@ -281,7 +290,7 @@ func (c *Controller) GenNatHoleResponse(transactionID string, session *Session,
 
 // analysis analyzes the NAT type and behavior of the visitor and client, then makes hole-punching decisions.
 // return the response to the visitor and client.
-func (c *Controller) analysis(session *Session) (*msg.NatHoleResp, *msg.NatHoleResp, error) {
+func (c *Controller) analysis(xl *xlog.Logger, session *Session) (*msg.NatHoleResp, *msg.NatHoleResp, error) {
 	cm := session.clientMsg
 	vm := session.visitorMsg
 
@@ -333,6 +342,7 @@ func (c *Controller) analysis(session *Session) (*msg.NatHoleResp, *msg.NatHoleR
 		Protocol:       protocol,
 		CandidateAddrs: slices.Compact(vm.MappedAddrs),
 		AssistedAddrs:  slices.Compact(vm.AssistedAddrs),
+		Credential:     vm.Credential,
 		DetectBehavior: msg.NatHoleDetectBehavior{
 			Mode:              mode,
 			Role:              cBehavior.Role,
@@ -345,10 +355,10 @@ func (c *Controller) analysis(session *Session) (*msg.NatHoleResp, *msg.NatHoleR
 		},
 	}
 
-	logx.Verbosef("sid [%s] visitor nat: %+v, candidateAddrs: %v; client nat: %+v, candidateAddrs: %v, protocol: %s",
+	xl.Debugf("sid [%s] visitor nat: %+v, candidateAddrs: %v; client nat: %+v, candidateAddrs: %v, protocol: %s",
 		session.sid, *vNatFeature, vm.MappedAddrs, *cNatFeature, cm.MappedAddrs, protocol)
-	logx.Verbosef("sid [%s] visitor detect behavior: %+v", session.sid, vResp.DetectBehavior)
-	logx.Verbosef("sid [%s] client detect behavior: %+v", session.sid, cResp.DetectBehavior)
+	xl.Debugf("sid [%s] visitor detect behavior: %+v", session.sid, vResp.DetectBehavior)
+	xl.Debugf("sid [%s] client detect behavior: %+v", session.sid, cResp.DetectBehavior)
 	return vResp, cResp, nil
 }
 
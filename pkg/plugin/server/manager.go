@@ -15,11 +15,15 @@
 package plugin
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 
+	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/util/util"
 	"github.com/iami317/hepx/pkg/util/xlog"
 )
@@ -31,6 +35,19 @@ type Manager struct {
 	pingPlugins        []Plugin
 	newWorkConnPlugins []Plugin
 	newUserConnPlugins []Plugin
+
+	newHTTPRequestPlugins []Plugin
+
+	xl *xlog.Logger
+
+	// registrations holds all registered plugins in registration order, the plugin slices are built from it.
+	registrations []registration
+	mu            sync.RWMutex
+}
+
+type registration struct {
+	plugin Plugin
+	opts   RegisterOptions
 }
 
 func NewManager() *Manager {
@@ -41,32 +58,109 @@ func NewManager() *Manager {
 		pingPlugins:        make([]Plugin, 0),
 		newWorkConnPlugins: make([]Plugin, 0),
 		newUserConnPlugins: make([]Plugin, 0),
+
+		newHTTPRequestPlugins: make([]Plugin, 0),
 	}
 }
 
-func (m *Manager) Register(p Plugin) {
-	if p.IsSupport(OpLogin) {
-		m.loginPlugins = append(m.loginPlugins, p)
-	}
-	if p.IsSupport(OpNewProxy) {
-		m.newProxyPlugins = append(m.newProxyPlugins, p)
-	}
-	if p.IsSupport(OpCloseProxy) {
-		m.closeProxyPlugins = append(m.closeProxyPlugins, p)
+// RegisterOptions controls which ops a plugin is registered for and where it's placed in the call chain.
+type RegisterOptions struct {
+	// Ops limits the registration to these ops, all ops supported by the plugin are used if it's empty.
+	Ops []string
+	// Plugins with a smaller Order are called first. Plugins with the same Order are called in registration
+	// order, HTTP plugins from the configuration have Order 0.
+	Order int
+}
+
+// SetLogger sets the logger from which the loggers of plugin requests are spawned.
+func (m *Manager) SetLogger(xl *xlog.Logger) {
+	m.xl = xl
+}
+
+func (m *Manager) newLogger(reqid string) *xlog.Logger {
+	xl := xlog.New()
+	if m.xl != nil {
+		xl = m.xl.Spawn()
 	}
-	if p.IsSupport(OpPing) {
-		m.pingPlugins = append(m.pingPlugins, p)
+	return xl.AddPrefix(xlog.LogPrefix{Name: "reqid", Value: reqid})
+}
+
+func (m *Manager) Register(p Plugin) {
+	m.RegisterWithOptions(p, RegisterOptions{})
+}
+
+func (m *Manager) RegisterWithOptions(p Plugin, opts RegisterOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registrations = append(m.registrations, registration{plugin: p, opts: opts})
+	m.build()
+}
+
+// ReplaceHTTPPlugins replaces the HTTP plugins from the configuration with plugins of options, plugins
+// registered by RegisterWithOptions are kept. Requests being handled keep using the old plugins.
+func (m *Manager) ReplaceHTTPPlugins(options []v1.HTTPPluginOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registrations = slices.DeleteFunc(m.registrations, func(r registration) bool {
+		_, ok := r.plugin.(*httpPlugin)
+		return ok
+	})
+	for _, o := range options {
+		m.registrations = append(m.registrations, registration{plugin: NewHTTPPluginOptions(o)})
 	}
-	if p.IsSupport(OpNewWorkConn) {
-		m.newWorkConnPlugins = append(m.newWorkConnPlugins, p)
+	m.build()
+}
+
+// build builds new plugin slices of all ops from registrations, m.mu must be held. Slices are never modified
+// in place, so they can be used without holding m.mu after they are read.
+func (m *Manager) build() {
+	build := func(op string) []Plugin {
+		regs := slices.DeleteFunc(slices.Clone(m.registrations), func(r registration) bool {
+			return !r.plugin.IsSupport(op) || (len(r.opts.Ops) > 0 && !slices.Contains(r.opts.Ops, op))
+		})
+		slices.SortStableFunc(regs, func(a, b registration) int {
+			return cmp.Compare(a.opts.Order, b.opts.Order)
+		})
+		plugins := make([]Plugin, 0, len(regs))
+		for _, r := range regs {
+			plugins = append(plugins, r.plugin)
+		}
+		return plugins
 	}
-	if p.IsSupport(OpNewUserConn) {
-		m.newUserConnPlugins = append(m.newUserConnPlugins, p)
+	m.loginPlugins = build(OpLogin)
+	m.newProxyPlugins = build(OpNewProxy)
+	m.closeProxyPlugins = build(OpCloseProxy)
+	m.pingPlugins = build(OpPing)
+	m.newWorkConnPlugins = build(OpNewWorkConn)
+	m.newUserConnPlugins = build(OpNewUserConn)
+	m.newHTTPRequestPlugins = build(OpNewHTTPRequest)
+}
+
+func (m *Manager) getPlugins(op string) []Plugin {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch op {
+	case OpLogin:
+		return m.loginPlugins
+	case OpNewProxy:
+		return m.newProxyPlugins
+	case OpCloseProxy:
+		return m.closeProxyPlugins
+	case OpPing:
+		return m.pingPlugins
+	case OpNewWorkConn:
+		return m.newWorkConnPlugins
+	case OpNewUserConn:
+		return m.newUserConnPlugins
+	case OpNewHTTPRequest:
+		return m.newHTTPRequestPlugins
 	}
+	return nil
 }
 
 func (m *Manager) Login(content *LoginContent) (*LoginContent, error) {
-	if len(m.loginPlugins) == 0 {
+	plugins := m.getPlugins(OpLogin)
+	if len(plugins) == 0 {
 		return content, nil
 	}
 
@@ -79,11 +173,11 @@ func (m *Manager) Login(content *LoginContent) (*LoginContent, error) {
 		err        error
 	)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.loginPlugins {
+	for _, p := range plugins {
 		res, retContent, err = p.Handle(ctx, OpLogin, *content)
 		if err != nil {
 			xl.Warnf("send Login request to plugin [%s] error: %v", p.Name(), err)
@@ -100,7 +194,8 @@ func (m *Manager) Login(content *LoginContent) (*LoginContent, error) {
 }
 
 func (m *Manager) NewProxy(content *NewProxyContent) (*NewProxyContent, error) {
-	if len(m.newProxyPlugins) == 0 {
+	plugins := m.getPlugins(OpNewProxy)
+	if len(plugins) == 0 {
 		return content, nil
 	}
 
@@ -113,11 +208,11 @@ func (m *Manager) NewProxy(content *NewProxyContent) (*NewProxyContent, error) {
 		err        error
 	)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.newProxyPlugins {
+	for _, p := range plugins {
 		res, retContent, err = p.Handle(ctx, OpNewProxy, *content)
 		if err != nil {
 			xl.Warnf("send NewProxy request to plugin [%s] error: %v", p.Name(), err)
@@ -134,17 +229,18 @@ func (m *Manager) NewProxy(content *NewProxyContent) (*NewProxyContent, error) {
 }
 
 func (m *Manager) CloseProxy(content *CloseProxyContent) error {
-	if len(m.closeProxyPlugins) == 0 {
+	plugins := m.getPlugins(OpCloseProxy)
+	if len(plugins) == 0 {
 		return nil
 	}
 
 	errs := make([]string, 0)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.closeProxyPlugins {
+	for _, p := range plugins {
 		_, _, err := p.Handle(ctx, OpCloseProxy, *content)
 		if err != nil {
 			xl.Warnf("send CloseProxy request to plugin [%s] error: %v", p.Name(), err)
@@ -159,7 +255,8 @@ func (m *Manager) CloseProxy(content *CloseProxyContent) error {
 }
 
 func (m *Manager) Ping(content *PingContent) (*PingContent, error) {
-	if len(m.pingPlugins) == 0 {
+	plugins := m.getPlugins(OpPing)
+	if len(plugins) == 0 {
 		return content, nil
 	}
 
@@ -172,11 +269,11 @@ func (m *Manager) Ping(content *PingContent) (*PingContent, error) {
 		err        error
 	)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.pingPlugins {
+	for _, p := range plugins {
 		res, retContent, err = p.Handle(ctx, OpPing, *content)
 		if err != nil {
 			xl.Warnf("send Ping request to plugin [%s] error: %v", p.Name(), err)
@@ -193,7 +290,8 @@ func (m *Manager) Ping(content *PingContent) (*PingContent, error) {
 }
 
 func (m *Manager) NewWorkConn(content *NewWorkConnContent) (*NewWorkConnContent, error) {
-	if len(m.newWorkConnPlugins) == 0 {
+	plugins := m.getPlugins(OpNewWorkConn)
+	if len(plugins) == 0 {
 		return content, nil
 	}
 
@@ -206,11 +304,11 @@ func (m *Manager) NewWorkConn(content *NewWorkConnContent) (*NewWorkConnContent,
 		err        error
 	)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.newWorkConnPlugins {
+	for _, p := range plugins {
 		res, retContent, err = p.Handle(ctx, OpNewWorkConn, *content)
 		if err != nil {
 			xl.Warnf("send NewWorkConn request to plugin [%s] error: %v", p.Name(), err)
@@ -227,7 +325,8 @@ func (m *Manager) NewWorkConn(content *NewWorkConnContent) (*NewWorkConnContent,
 }
 
 func (m *Manager) NewUserConn(content *NewUserConnContent) (*NewUserConnContent, error) {
-	if len(m.newUserConnPlugins) == 0 {
+	plugins := m.getPlugins(OpNewUserConn)
+	if len(plugins) == 0 {
 		return content, nil
 	}
 
@@ -240,11 +339,11 @@ func (m *Manager) NewUserConn(content *NewUserConnContent) (*NewUserConnContent,
 		err        error
 	)
 	reqid, _ := util.RandID()
-	xl := xlog.New().AppendPrefix("reqid: " + reqid)
+	xl := m.newLogger(reqid)
 	ctx := xlog.NewContext(context.Background(), xl)
 	ctx = NewReqidContext(ctx, reqid)
 
-	for _, p := range m.newUserConnPlugins {
+	for _, p := range plugins {
 		res, retContent, err = p.Handle(ctx, OpNewUserConn, *content)
 		if err != nil {
 			xl.Infof("send NewUserConn request to plugin [%s] error: %v", p.Name(), err)
@@ -259,3 +358,44 @@ func (m *Manager) NewUserConn(content *NewUserConnContent) (*NewUserConnContent,
 	}
 	return content, nil
 }
+
+// HasNewHTTPRequestPlugins reports whether any plugin handles NewHTTPRequest, so callers can skip building
+// the content for every request.
+func (m *Manager) HasNewHTTPRequestPlugins() bool {
+	return len(m.getPlugins(OpNewHTTPRequest)) > 0
+}
+
+func (m *Manager) NewHTTPRequest(content *NewHTTPRequestContent) (*NewHTTPRequestContent, error) {
+	plugins := m.getPlugins(OpNewHTTPRequest)
+	if len(plugins) == 0 {
+		return content, nil
+	}
+
+	var (
+		res = &Response{
+			Reject:   false,
+			Unchange: true,
+		}
+		retContent interface{}
+		err        error
+	)
+	reqid, _ := util.RandID()
+	xl := m.newLogger(reqid)
+	ctx := xlog.NewContext(context.Background(), xl)
+	ctx = NewReqidContext(ctx, reqid)
+
+	for _, p := range plugins {
+		res, retContent, err = p.Handle(ctx, OpNewHTTPRequest, *content)
+		if err != nil {
+			xl.Infof("send NewHTTPRequest request to plugin [%s] error: %v", p.Name(), err)
+			return nil, errors.New("send NewHTTPRequest request to plugin error")
+		}
+		if res.Reject {
+			return nil, fmt.Errorf("%s", res.RejectReason)
+		}
+		if !res.Unchange {
+			content = retContent.(*NewHTTPRequestContent)
+		}
+	}
+	return content, nil
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+type testPlugin struct {
+	name   string
+	ops    []string
+	calls  *[]string
+	reject bool
+}
+
+func (p *testPlugin) Name() string {
+	return p.name
+}
+
+func (p *testPlugin) IsSupport(op string) bool {
+	return slices.Contains(p.ops, op)
+}
+
+func (p *testPlugin) Handle(ctx context.Context, op string, content interface{}) (*Response, interface{}, error) {
+	if GetReqidFromContext(ctx) == "" {
+		return nil, nil, errors.New("no reqid")
+	}
+	*p.calls = append(*p.calls, p.name)
+	if p.reject {
+		return &Response{Reject: true, RejectReason: p.name + " rejected"}, nil, nil
+	}
+	c := content.(LoginContent)
+	c.User += "-" + p.name
+	return &Response{Unchange: false}, &c, nil
+}
+
+func TestManagerRegisterWithOptions(t *testing.T) {
+	require := require.New(t)
+
+	var calls []string
+	m := NewManager()
+	m.Register(&testPlugin{name: "http", ops: []string{OpLogin}, calls: &calls})
+	m.RegisterWithOptions(&testPlugin{name: "last", ops: []string{OpLogin}, calls: &calls}, RegisterOptions{Order: 10})
+	m.RegisterWithOptions(&testPlugin{name: "first", ops: []string{OpLogin}, calls: &calls}, RegisterOptions{Order: -10})
+	m.RegisterWithOptions(&testPlugin{name: "second", ops: []string{OpLogin}, calls: &calls}, RegisterOptions{Order: -10})
+	// not registered for Login since it's excluded by Ops
+	m.RegisterWithOptions(&testPlugin{name: "ping", ops: []string{OpLogin, OpPing}, calls: &calls, reject: true},
+		RegisterOptions{Ops: []string{OpPing}})
+
+	content, err := m.Login(&LoginContent{})
+	require.NoError(err)
+	require.Equal([]string{"first", "second", "http", "last"}, calls)
+	require.Equal("-first-second-http-last", content.User)
+
+	_, err = m.Ping(&PingContent{})
+	require.EqualError(err, "ping rejected")
+}
+
+func TestManagerReplaceHTTPPlugins(t *testing.T) {
+	require := require.New(t)
+
+	var calls []string
+	m := NewManager()
+	m.RegisterWithOptions(&testPlugin{name: "go", ops: []string{OpLogin}, calls: &calls}, RegisterOptions{Order: 10})
+	m.Register(NewHTTPPluginOptions(v1.HTTPPluginOptions{Name: "old", Addr: "127.0.0.1:9000", Ops: []string{OpLogin}}))
+	require.Len(m.getPlugins(OpLogin), 2)
+
+	m.ReplaceHTTPPlugins([]v1.HTTPPluginOptions{
+		{Name: "new", Addr: "127.0.0.1:9001", Ops: []string{OpNewProxy}},
+	})
+	// the go plugin is kept
+	_, err := m.Login(&LoginContent{})
+	require.NoError(err)
+	require.Equal([]string{"go"}, calls)
+	plugins := m.getPlugins(OpNewProxy)
+	require.Len(plugins, 1)
+	require.Equal("new", plugins[0].Name())
+}
@@ -13,8 +13,15 @@ const (
 	OpPing        = "Ping"
 	OpNewWorkConn = "NewWorkConn"
 	OpNewUserConn = "NewUserConn"
+
+	OpNewHTTPRequest = "NewHTTPRequest"
 )
 
+// Plugin handles server plugin ops. Besides HTTP plugins from the configuration, Go implementations can be
+// registered in process by Manager.RegisterWithOptions.
+//
+// Handle receives the content by value, e.g. LoginContent for OpLogin, and must return a pointer to the same type
+// as retContent if res.Unchange is false. ctx carries the request id, see GetReqidFromContext, and the xlog logger.
 type Plugin interface {
 	Name() string
 	IsSupport(op string) bool
@@ -15,6 +15,8 @@
 package plugin
 
 import (
+	"net/http"
+
 	"github.com/iami317/hepx/pkg/msg"
 )
 
@@ -69,3 +71,18 @@ type NewUserConnContent struct {
 	ProxyType  string   `json:"proxy_type"`
 	RemoteAddr string   `json:"remote_addr"`
 }
+
+// NewHTTPRequestContent is sent for every request to http proxies. Plugins can set SetHeaders to add or
+// override request headers and RouteTo to forward the request to another http proxy.
+type NewHTTPRequestContent struct {
+	User      UserInfo    `json:"user"`
+	ProxyName string      `json:"proxy_name"`
+	Method    string      `json:"method"`
+	Host      string      `json:"host"`
+	Path      string      `json:"path"`
+	Headers   http.Header `json:"headers"`
+	ClientIP  string      `json:"client_ip"`
+
+	SetHeaders map[string]string `json:"set_headers,omitempty"`
+	RouteTo    string            `json:"route_to,omitempty"`
+}
@@ -0,0 +1,126 @@
+package udp
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/fatedier/golib/pool"
+)
+
+// MaxDatagramSize is the max size of the datagrams sent by DatagramMux. Datagrams must fit in one QUIC
+// packet and quic-go drops the ones which don't fit silently, so the size is kept below the initial packet
+// size of QUIC, which every path supports. Larger packets are sent through the work connection.
+const MaxDatagramSize = 1150
+
+// DatagramConn sends and receives unreliable datagrams, it's implemented by quic.Connection.
+type DatagramConn interface {
+	SendDatagram(payload []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+}
+
+// DatagramMux carries the packets of udp proxies in the datagrams of one QUIC connection. Each proxy uses a
+// flow ID allocated by frps, datagrams are encoded as flow ID (uvarint) | address | content.
+//
+// A datagram without address and content confirms that the sender has registered the flow.
+type DatagramMux struct {
+	conn DatagramConn
+
+	mu         sync.RWMutex
+	flows      map[uint32]func(*Packet)
+	nextFlowID uint32
+}
+
+func NewDatagramMux(conn DatagramConn) *DatagramMux {
+	return &DatagramMux{
+		conn:  conn,
+		flows: make(map[uint32]func(*Packet)),
+	}
+}
+
+// Allocate registers handler for a new flow and returns the flow ID. Handlers are called by the goroutine
+// running Run, so they must not block.
+func (m *DatagramMux) Allocate(handler func(*Packet)) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextFlowID++
+	m.flows[m.nextFlowID] = handler
+	return m.nextFlowID
+}
+
+// Register registers handler for a flow allocated by the peer, it replaces the previous handler.
+func (m *DatagramMux) Register(flowID uint32, handler func(*Packet)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flows[flowID] = handler
+}
+
+func (m *DatagramMux) Unregister(flowID uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.flows, flowID)
+}
+
+// Send sends p in a datagram of the flow and releases it. It returns false without releasing p if p is too
+// large for a datagram or the datagram can't be sent, then p should be sent through the work connection.
+func (m *DatagramMux) Send(flowID uint32, p *Packet) bool {
+	buf := pool.GetBuf(MaxDatagramSize)
+	defer pool.PutBuf(buf)
+
+	b := binary.AppendUvarint(buf[:0], uint64(flowID))
+	b = appendUDPAddr(b, p.RemoteAddr)
+	if len(b)+len(p.Content) > MaxDatagramSize {
+		return false
+	}
+	b = append(b, p.Content...)
+	if err := m.conn.SendDatagram(b); err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// Confirm tells the peer that the flow is registered, so the peer can start sending datagrams of it.
+func (m *DatagramMux) Confirm(flowID uint32) error {
+	b := binary.AppendUvarint(nil, uint64(flowID))
+	return m.conn.SendDatagram(appendUDPAddr(b, nil))
+}
+
+// Run dispatches received datagrams to the handlers of their flows until ctx is done or the connection is
+// closed. Datagrams of unknown flows are dropped. Handlers are called inline, a blocking handler stalls the
+// datagrams of all flows, so handlers should drop packets they can't handle immediately.
+func (m *DatagramMux) Run(ctx context.Context) {
+	for {
+		b, err := m.conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		flowID, n := binary.Uvarint(b)
+		if n <= 0 {
+			continue
+		}
+		raddr, addrLen, err := parseUDPAddr(b[n:])
+		if err != nil {
+			continue
+		}
+
+		m.mu.RLock()
+		handler := m.flows[uint32(flowID)]
+		m.mu.RUnlock()
+		if handler != nil {
+			handler(&Packet{Content: b[n+addrLen:], RemoteAddr: raddr})
+		}
+	}
+}
+
+type datagramMuxKey struct{}
+
+func NewDatagramMuxContext(ctx context.Context, m *DatagramMux) context.Context {
+	return context.WithValue(ctx, datagramMuxKey{}, m)
+}
+
+// DatagramMuxFromContext returns the DatagramMux in ctx, nil if there is none.
+func DatagramMuxFromContext(ctx context.Context) *DatagramMux {
+	m, _ := ctx.Value(datagramMuxKey{}).(*DatagramMux)
+	return m
+}
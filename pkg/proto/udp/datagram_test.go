@@ -0,0 +1,75 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pipeDatagramConn delivers datagrams to its peer through a channel.
+type pipeDatagramConn struct {
+	out chan<- []byte
+	in  <-chan []byte
+}
+
+func (c *pipeDatagramConn) SendDatagram(payload []byte) error {
+	c.out <- append([]byte(nil), payload...)
+	return nil
+}
+
+func (c *pipeDatagramConn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-c.in:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDatagramMux(t *testing.T) {
+	require := require.New(t)
+
+	ch1, ch2 := make(chan []byte, 10), make(chan []byte, 10)
+	server := NewDatagramMux(&pipeDatagramConn{out: ch1, in: ch2})
+	client := NewDatagramMux(&pipeDatagramConn{out: ch2, in: ch1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	go client.Run(ctx)
+
+	serverCh := make(chan *Packet, 10)
+	flowID := server.Allocate(func(p *Packet) { serverCh <- p })
+	require.NotEqual(server.Allocate(func(*Packet) {}), flowID)
+
+	clientCh := make(chan *Packet, 10)
+	client.Register(flowID, func(p *Packet) { clientCh <- p })
+	require.NoError(client.Confirm(flowID))
+	select {
+	case p := <-serverCh:
+		require.Nil(p.RemoteAddr)
+		require.Empty(p.Content)
+	case <-time.After(time.Second):
+		require.FailNow("confirm datagram not received")
+	}
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}
+	p := NewPacket(5)
+	copy(p.Content, "hello")
+	p.RemoteAddr = raddr
+	require.True(server.Send(flowID, p))
+	select {
+	case p := <-clientCh:
+		require.Equal("hello", string(p.Content))
+		require.Equal(raddr.String(), p.RemoteAddr.String())
+	case <-time.After(time.Second):
+		require.FailNow("datagram not received")
+	}
+
+	// packets which don't fit in a datagram are sent by streams
+	p = NewPacket(MaxDatagramSize)
+	require.False(server.Send(flowID, p))
+	require.NotNil(p.Content)
+}
@@ -0,0 +1,224 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/fatedier/golib/pool"
+
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+// typeBinaryPacket is the first byte of binary packet frames, it must not be used by any type of pkg/msg.
+const typeBinaryPacket byte = 0x01
+
+const (
+	addrFamilyNone byte = 0
+	addrFamilyIPv4 byte = 4
+	addrFamilyIPv6 byte = 6
+)
+
+// maxFrameHeaderLen is the length of the frame header with an IPv6 address:
+// type(1) | family(1) | ip(16) | port(2) | length(2).
+const maxFrameHeaderLen = 22
+
+var ErrPacketTooLarge = errors.New("udp packet is too large")
+
+// Packet is a datagram forwarded through a work connection.
+type Packet struct {
+	Content    []byte
+	RemoteAddr *net.UDPAddr
+
+	// buf is the pooled buffer backing Content.
+	buf []byte
+}
+
+// NewPacket returns a packet with a pooled buffer of size bytes, Content should be set to a part of Buf.
+func NewPacket(size int) *Packet {
+	buf := pool.GetBuf(size)
+	return &Packet{Content: buf, buf: buf}
+}
+
+// Buf returns the whole buffer of the packet.
+func (p *Packet) Buf() []byte {
+	return p.buf
+}
+
+// Release returns the buffer to the pool, the packet can't be used any more.
+func (p *Packet) Release() {
+	if p.buf != nil {
+		pool.PutBuf(p.buf)
+		p.buf = nil
+	}
+	p.Content = nil
+}
+
+// FramedConn reads and writes the messages of a udp work connection. Packets are written as msg.UDPPacket
+// announcing that binary frames can be read, and as binary frames once the peer has announced the same or
+// has sent a binary frame. Old peers ignore the announcement, so they keep receiving msg.UDPPacket.
+//
+// ReadMsg and WriteMsg may be called in different goroutines, but each of them must not be called
+// concurrently.
+type FramedConn struct {
+	net.Conn
+
+	peerBinary atomic.Bool
+}
+
+func NewFramedConn(conn net.Conn) *FramedConn {
+	return &FramedConn{Conn: conn}
+}
+
+// PeerBinary returns whether the peer can read binary frames.
+func (c *FramedConn) PeerBinary() bool {
+	return c.peerBinary.Load()
+}
+
+// ReadMsg reads the next message, packets of both formats are returned as *Packet.
+func (c *FramedConn) ReadMsg() (msg.Message, error) {
+	for {
+		var typ [1]byte
+		if _, err := io.ReadFull(c.Conn, typ[:]); err != nil {
+			return nil, err
+		}
+		if typ[0] == typeBinaryPacket {
+			c.peerBinary.Store(true)
+			return readBinaryPacket(c.Conn)
+		}
+
+		m, err := msg.ReadMsg(io.MultiReader(bytes.NewReader(typ[:]), c.Conn))
+		if err != nil {
+			return nil, err
+		}
+		udpMsg, ok := m.(*msg.UDPPacket)
+		if !ok {
+			return m, nil
+		}
+		if udpMsg.BinaryFraming {
+			c.peerBinary.Store(true)
+		}
+		content, err := GetContent(udpMsg)
+		if err != nil {
+			// skip invalid packets like before
+			continue
+		}
+		return &Packet{Content: content, RemoteAddr: udpMsg.RemoteAddr}, nil
+	}
+}
+
+// WriteMsg writes m, packets are released after they are written.
+func (c *FramedConn) WriteMsg(m msg.Message) error {
+	p, ok := m.(*Packet)
+	if !ok {
+		return msg.WriteMsg(c.Conn, m)
+	}
+	defer p.Release()
+
+	if !c.peerBinary.Load() {
+		udpMsg := NewUDPPacket(p.Content, nil, p.RemoteAddr)
+		udpMsg.BinaryFraming = true
+		return msg.WriteMsg(c.Conn, udpMsg)
+	}
+
+	buf := pool.GetBuf(maxFrameHeaderLen + len(p.Content))
+	defer pool.PutBuf(buf)
+	frame, err := appendBinaryPacket(buf[:0], p)
+	if err != nil {
+		return err
+	}
+	_, err = c.Conn.Write(frame)
+	return err
+}
+
+func appendBinaryPacket(b []byte, p *Packet) ([]byte, error) {
+	if len(p.Content) > 0xffff {
+		return nil, ErrPacketTooLarge
+	}
+	b = append(b, typeBinaryPacket)
+	b = appendUDPAddr(b, p.RemoteAddr)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(p.Content)))
+	return append(b, p.Content...), nil
+}
+
+// appendUDPAddr appends the address family, the IP and the port of addr, or only addrFamilyNone if addr is nil.
+func appendUDPAddr(b []byte, addr *net.UDPAddr) []byte {
+	var ip net.IP
+	if addr != nil {
+		ip = addr.IP
+	}
+	switch {
+	case ip.To4() != nil:
+		b = append(b, addrFamilyIPv4)
+		b = append(b, ip.To4()...)
+	case len(ip) == net.IPv6len:
+		b = append(b, addrFamilyIPv6)
+		b = append(b, ip...)
+	default:
+		return append(b, addrFamilyNone)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// parseUDPAddr parses an address appended by appendUDPAddr and returns the number of bytes used.
+func parseUDPAddr(b []byte) (*net.UDPAddr, int, error) {
+	if len(b) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	var ipLen int
+	switch b[0] {
+	case addrFamilyNone:
+		return nil, 1, nil
+	case addrFamilyIPv4:
+		ipLen = net.IPv4len
+	case addrFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, 0, fmt.Errorf("unknown address family [%d] of udp packet", b[0])
+	}
+	n := 1 + ipLen + 2
+	if len(b) < n {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return &net.UDPAddr{
+		IP:   net.IP(bytes.Clone(b[1 : 1+ipLen])),
+		Port: int(binary.BigEndian.Uint16(b[1+ipLen : n])),
+	}, n, nil
+}
+
+// readBinaryPacket reads a binary frame whose type byte has been read.
+func readBinaryPacket(r io.Reader) (*Packet, error) {
+	var header [maxFrameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return nil, err
+	}
+
+	addrLen := 1
+	switch header[0] {
+	case addrFamilyIPv4:
+		addrLen += net.IPv4len + 2
+	case addrFamilyIPv6:
+		addrLen += net.IPv6len + 2
+	}
+	// the rest of the address and the length
+	if _, err := io.ReadFull(r, header[1:addrLen+2]); err != nil {
+		return nil, err
+	}
+	raddr, _, err := parseUDPAddr(header[:addrLen])
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[addrLen : addrLen+2]))
+
+	p := NewPacket(length)
+	p.RemoteAddr = raddr
+	if _, err := io.ReadFull(r, p.Content); err != nil {
+		p.Release()
+		return nil, err
+	}
+	return p, nil
+}
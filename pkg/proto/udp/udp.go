@@ -21,7 +21,6 @@ import (
 	"time"
 
 	"github.com/fatedier/golib/errors"
-	"github.com/fatedier/golib/pool"
 
 	"github.com/iami317/hepx/pkg/msg"
 )
@@ -39,37 +38,39 @@ func GetContent(m *msg.UDPPacket) (buf []byte, err error) {
 	return
 }
 
-func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh chan<- *msg.UDPPacket, bufSize int) {
+// ForwardUserConn forwards packets between the users of udpConn and the channels. Packets sent to sendCh
+// use pooled buffers, and packets from readCh are released after they are written to udpConn.
+func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *Packet, sendCh chan<- *Packet, bufSize int) {
 	// read
 	go func() {
-		for udpMsg := range readCh {
-			buf, err := GetContent(udpMsg)
-			if err != nil {
-				continue
-			}
-			_, _ = udpConn.WriteToUDP(buf, udpMsg.RemoteAddr)
+		for p := range readCh {
+			_, _ = udpConn.WriteToUDP(p.Content, p.RemoteAddr)
+			p.Release()
 		}
 	}()
 
 	// write
-	buf := pool.GetBuf(bufSize)
-	defer pool.PutBuf(buf)
 	for {
-		n, remoteAddr, err := udpConn.ReadFromUDP(buf)
+		p := NewPacket(bufSize)
+		n, remoteAddr, err := udpConn.ReadFromUDP(p.Buf())
 		if err != nil {
+			p.Release()
 			return
 		}
-		// buf[:n] will be encoded to string, so the bytes can be reused
-		udpMsg := NewUDPPacket(buf[:n], nil, remoteAddr)
+		p.Content = p.Buf()[:n]
+		p.RemoteAddr = remoteAddr
 
 		select {
-		case sendCh <- udpMsg:
+		case sendCh <- p:
 		default:
+			p.Release()
 		}
 	}
 }
 
-func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<- msg.Message, bufSize int) {
+// Forwarder forwards packets from readCh to dstAddr by a udp connection for each remote address, and the
+// responses to sendCh. Packets are handled the same way as ForwardUserConn.
+func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *Packet, sendCh chan<- msg.Message, bufSize int) {
 	var mu sync.RWMutex
 	udpConnMap := make(map[string]*net.UDPConn)
 
@@ -83,19 +84,22 @@ func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<-
 			udpConn.Close()
 		}()
 
-		buf := pool.GetBuf(bufSize)
 		for {
+			p := NewPacket(bufSize)
 			_ = udpConn.SetReadDeadline(time.Now().Add(30 * time.Second))
-			n, _, err := udpConn.ReadFromUDP(buf)
+			n, _, err := udpConn.ReadFromUDP(p.Buf())
 			if err != nil {
+				p.Release()
 				return
 			}
+			p.Content = p.Buf()[:n]
+			p.RemoteAddr = raddr
 
-			udpMsg := NewUDPPacket(buf[:n], nil, raddr)
 			if err = errors.PanicToError(func() {
 				select {
-				case sendCh <- udpMsg:
+				case sendCh <- p:
 				default:
+					p.Release()
 				}
 			}); err != nil {
 				return
@@ -105,31 +109,30 @@ func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<-
 
 	// read from readCh
 	go func() {
-		for udpMsg := range readCh {
-			buf, err := GetContent(udpMsg)
-			if err != nil {
-				continue
-			}
+		for p := range readCh {
 			mu.Lock()
-			udpConn, ok := udpConnMap[udpMsg.RemoteAddr.String()]
+			udpConn, ok := udpConnMap[p.RemoteAddr.String()]
 			if !ok {
+				var err error
 				udpConn, err = net.DialUDP("udp", nil, dstAddr)
 				if err != nil {
 					mu.Unlock()
+					p.Release()
 					continue
 				}
-				udpConnMap[udpMsg.RemoteAddr.String()] = udpConn
+				udpConnMap[p.RemoteAddr.String()] = udpConn
 			}
 			mu.Unlock()
 
-			_, err = udpConn.Write(buf)
+			_, err := udpConn.Write(p.Content)
 			if err != nil {
 				udpConn.Close()
 			}
 
 			if !ok {
-				go writerFn(udpMsg.RemoteAddr, udpConn)
+				go writerFn(p.RemoteAddr, udpConn)
 			}
+			p.Release()
 		}
 	}()
 }
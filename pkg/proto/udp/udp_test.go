@@ -1,9 +1,13 @@
 package udp
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/msg"
 )
 
 func TestUdpPacket(t *testing.T) {
@@ -16,3 +20,73 @@ func TestUdpPacket(t *testing.T) {
 	assert.NoError(err)
 	assert.EqualValues(buf, newBuf)
 }
+
+func TestFramedConn(t *testing.T) {
+	require := require.New(t)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	a, b := NewFramedConn(c1), NewFramedConn(c2)
+
+	send := func(from, to *FramedConn, content string, raddr *net.UDPAddr) *Packet {
+		p := NewPacket(len(content))
+		copy(p.Content, content)
+		p.RemoteAddr = raddr
+		errCh := make(chan error, 1)
+		go func() { errCh <- from.WriteMsg(p) }()
+		m, err := to.ReadMsg()
+		require.NoError(err)
+		require.NoError(<-errCh)
+		received, ok := m.(*Packet)
+		require.True(ok)
+		require.Equal(content, string(received.Content))
+		return received
+	}
+
+	raddr4 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+	require.False(a.PeerBinary())
+	// the first packet is in the legacy format and announces binary frames
+	received := send(a, b, "hello", raddr4)
+	require.Equal(raddr4.String(), received.RemoteAddr.String())
+	require.True(b.PeerBinary())
+
+	// binary frames
+	raddr6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}
+	received = send(b, a, "world", raddr6)
+	require.Equal(raddr6.String(), received.RemoteAddr.String())
+	require.True(a.PeerBinary())
+	received = send(a, b, "", nil)
+	require.Nil(received.RemoteAddr)
+
+	// other messages are still json messages
+	go func() { _ = a.WriteMsg(&msg.Ping{}) }()
+	m, err := b.ReadMsg()
+	require.NoError(err)
+	require.IsType(&msg.Ping{}, m)
+}
+
+func TestFramedConnLegacyPeer(t *testing.T) {
+	require := require.New(t)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	fc := NewFramedConn(c1)
+
+	// packets from old peers don't announce binary frames
+	go func() { _ = msg.WriteMsg(c2, NewUDPPacket([]byte("hello"), nil, nil)) }()
+	m, err := fc.ReadMsg()
+	require.NoError(err)
+	require.Equal("hello", string(m.(*Packet).Content))
+	require.False(fc.PeerBinary())
+
+	p := NewPacket(5)
+	copy(p.Content, "world")
+	go func() { _ = fc.WriteMsg(p) }()
+	var udpMsg msg.UDPPacket
+	require.NoError(msg.ReadMsgInto(c2, &udpMsg))
+	content, err := GetContent(&udpMsg)
+	require.NoError(err)
+	require.Equal("world", string(content))
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	httppkg "github.com/iami317/hepx/pkg/util/http"
+	"github.com/iami317/hepx/server"
+)
+
+// Client calls the dashboard APIs of frps. Responses are decoded into the same structs
+// the dashboard handlers use.
+type Client struct {
+	address  string
+	scheme   string
+	authUser string
+	authPwd  string
+
+	httpClient *http.Client
+}
+
+func New(host string, port int) *Client {
+	return &Client{
+		address:    net.JoinHostPort(host, strconv.Itoa(port)),
+		scheme:     "http",
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) SetAuth(user, pwd string) {
+	c.authUser = user
+	c.authPwd = pwd
+}
+
+// SetTLSConfig makes the client use https, for dashboards with webServer.tls configured.
+func (c *Client) SetTLSConfig(tlsConfig *tls.Config) {
+	c.scheme = "https"
+	c.httpClient = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+func (c *Client) Healthz() error {
+	req, err := http.NewRequest("GET", c.url("/healthz"), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *Client) GetServerInfo() (*server.ServerInfoResp, error) {
+	res := &server.ServerInfoResp{}
+	if err := c.getJSON("/api/serverinfo", res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetProxiesByType returns the stats of all proxies of proxyType, including offline ones.
+// The Conf of online proxies is decoded into the conf struct of the type, like *server.TCPOutConf.
+func (c *Client) GetProxiesByType(proxyType string) ([]*server.ProxyStatsInfo, error) {
+	res := &server.GetProxyInfoResp{}
+	if err := c.getJSON("/api/proxy/"+url.PathEscape(proxyType), res); err != nil {
+		return nil, err
+	}
+	return res.Proxies, nil
+}
+
+func (c *Client) GetProxy(proxyType string, name string) (*server.GetProxyStatsResp, error) {
+	res := &server.GetProxyStatsResp{}
+	if err := c.getJSON("/api/proxy/"+url.PathEscape(proxyType)+"/"+url.PathEscape(name), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetProxyTraffic returns the daily traffic of a proxy, the first element is today.
+func (c *Client) GetProxyTraffic(name string) (*server.GetProxyTrafficResp, error) {
+	res := &server.GetProxyTrafficResp{}
+	if err := c.getJSON("/api/traffic/"+url.PathEscape(name), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetGroups returns the load balancing groups of tcp, http and tcpmux proxies with their members.
+func (c *Client) GetGroups() ([]*server.GroupInfo, error) {
+	res := &server.GetGroupInfoResp{}
+	if err := c.getJSON("/api/groups", res); err != nil {
+		return nil, err
+	}
+	return res.Groups, nil
+}
+
+// GetVisitorCredentials returns the visitor credentials of a stcp, xtcp or sudp proxy.
+func (c *Client) GetVisitorCredentials(name string) (*server.GetVisitorCredentialsResp, error) {
+	res := &server.GetVisitorCredentialsResp{}
+	if err := c.getJSON("/api/credentials/"+url.PathEscape(name), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RevokeVisitorCredential rejects new visitors using the credential and closes the existing ones.
+func (c *Client) RevokeVisitorCredential(name string, credential string) error {
+	_, err := c.post("/api/credentials/" + url.PathEscape(name) + "/" + url.PathEscape(credential) + "/revoke")
+	return err
+}
+
+func (c *Client) RestoreVisitorCredential(name string, credential string) error {
+	_, err := c.post("/api/credentials/" + url.PathEscape(name) + "/" + url.PathEscape(credential) + "/restore")
+	return err
+}
+
+// Reload makes frps load its config file again, it fails if frps is not started from a config file.
+func (c *Client) Reload() (*server.ReloadResult, error) {
+	content, err := c.post("/api/reload")
+	if err != nil {
+		return nil, err
+	}
+	res := &server.ReloadResult{}
+	if err = json.Unmarshal([]byte(content), res); err != nil {
+		return nil, fmt.Errorf("unmarshal http response error: %s", strings.TrimSpace(content))
+	}
+	return res, nil
+}
+
+// ClearOfflineProxies removes the stats of all offline proxies.
+func (c *Client) ClearOfflineProxies() error {
+	req, err := http.NewRequest("DELETE", c.url("/api/proxies?status=offline"), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *Client) url(path string) string {
+	return c.scheme + "://" + c.address + path
+}
+
+func (c *Client) getJSON(path string, v any) error {
+	req, err := http.NewRequest("GET", c.url(path), nil)
+	if err != nil {
+		return err
+	}
+	content, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(content), v); err != nil {
+		return fmt.Errorf("unmarshal http response error: %s", strings.TrimSpace(content))
+	}
+	return nil
+}
+
+func (c *Client) post(path string) (string, error) {
+	req, err := http.NewRequest("POST", c.url(path), nil)
+	if err != nil {
+		return "", err
+	}
+	return c.do(req)
+}
+
+func (c *Client) setAuthHeader(req *http.Request) {
+	if c.authUser != "" || c.authPwd != "" {
+		req.Header.Set("Authorization", httppkg.BasicAuth(c.authUser, c.authPwd))
+	}
+}
+
+func (c *Client) do(req *http.Request) (string, error) {
+	c.setAuthHeader(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("api status code [%d]: %s", resp.StatusCode, strings.TrimSpace(string(buf)))
+	}
+	return string(buf), nil
+}
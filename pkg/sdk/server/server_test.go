@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/client"
+	"github.com/iami317/hepx/pkg/config"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/server"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTestServer runs frps from a config file and serves its dashboard handler by httptest instead of
+// webServer, a frpc with a tcp group and a stcp proxy is logged in.
+func startTestServer(t *testing.T) (c *Client, cfgFile string, cfgContent string) {
+	require := require.New(t)
+
+	bindPort := freePort(t)
+	cfgFile = filepath.Join(t.TempDir(), "frps.toml")
+	cfgContent = "bindAddr = \"127.0.0.1\"\n" +
+		"bindPort = " + strconv.Itoa(bindPort) + "\n" +
+		"auth.token = \"token\"\n" +
+		"webServer.addr = \"127.0.0.1\"\n" +
+		"webServer.port = " + strconv.Itoa(freePort(t)) + "\n" +
+		"webServer.user = \"admin\"\n" +
+		"webServer.password = \"admin\"\n"
+	require.NoError(os.WriteFile(cfgFile, []byte(cfgContent), 0o600))
+
+	cfg, _, err := config.LoadServerConfig(cfgFile, true)
+	require.NoError(err)
+	svr, err := server.NewService(cfg, server.WithConfigFile(cfgFile, true))
+	require.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go svr.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		_ = svr.Close()
+	})
+
+	ts := httptest.NewServer(svr.DashboardHandler())
+	t.Cleanup(ts.Close)
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(err)
+	c = New(host, portNum)
+	c.SetAuth("admin", "admin")
+
+	common := &v1.ClientCommonConfig{ServerAddr: "127.0.0.1", ServerPort: bindPort}
+	common.Auth.Token = "token"
+	common.Complete()
+	remotePort := freePort(t)
+	var proxyCfgs []v1.ProxyConfigurer
+	for _, name := range []string{"tcp-a", "tcp-b"} {
+		pxyCfg := &v1.TCPProxyConfig{RemotePort: remotePort}
+		pxyCfg.Name = name
+		pxyCfg.Type = string(v1.ProxyTypeTCP)
+		pxyCfg.LocalPort = 22
+		pxyCfg.LoadBalancer.Group = "ssh"
+		pxyCfg.LoadBalancer.GroupKey = "key"
+		proxyCfgs = append(proxyCfgs, pxyCfg)
+	}
+	stcpCfg := &v1.STCPProxyConfig{
+		Credentials: []v1.VisitorCredential{
+			{Name: "alice", SecretKey: "a"},
+			{Name: "bob", SecretKey: "b"},
+		},
+	}
+	stcpCfg.Name = "secret"
+	stcpCfg.Type = string(v1.ProxyTypeSTCP)
+	stcpCfg.LocalPort = 22
+	proxyCfgs = append(proxyCfgs, stcpCfg)
+	for _, pxyCfg := range proxyCfgs {
+		pxyCfg.Complete("")
+	}
+
+	cli, err := client.NewService(client.ServiceOptions{Common: common, ProxyCfgs: proxyCfgs})
+	require.NoError(err)
+	go func() {
+		_ = cli.Run(ctx)
+	}()
+
+	require.Eventually(func() bool {
+		proxies, err := c.GetProxiesByType("tcp")
+		if err != nil || len(proxies) != 2 {
+			return false
+		}
+		secret, err := c.GetProxy("stcp", "secret")
+		return err == nil && secret.Status == "online"
+	}, 10*time.Second, 100*time.Millisecond)
+	return c, cfgFile, cfgContent
+}
+
+func TestClient(t *testing.T) {
+	c, cfgFile, cfgContent := startTestServer(t)
+
+	t.Run("auth", func(t *testing.T) {
+		require := require.New(t)
+		anonymous := *c
+		anonymous.SetAuth("", "")
+		require.NoError(anonymous.Healthz())
+		_, err := anonymous.GetServerInfo()
+		require.ErrorContains(err, "api status code [401]")
+	})
+
+	t.Run("serverinfo", func(t *testing.T) {
+		require := require.New(t)
+		info, err := c.GetServerInfo()
+		require.NoError(err)
+		require.Equal(int64(1), info.ClientCounts)
+		require.Equal(int64(2), info.ProxyTypeCounts["tcp"])
+		require.Equal(int64(1), info.ProxyTypeCounts["stcp"])
+	})
+
+	t.Run("proxies", func(t *testing.T) {
+		require := require.New(t)
+		proxies, err := c.GetProxiesByType("tcp")
+		require.NoError(err)
+		require.Len(proxies, 2)
+		require.Equal("tcp-a", proxies[0].Name)
+		require.Equal("online", proxies[0].Status)
+		conf, ok := proxies[0].Conf.(*server.TCPOutConf)
+		require.True(ok)
+		require.Equal("ssh", conf.LoadBalancer.Group)
+		require.NotZero(conf.RemotePort)
+
+		secret, err := c.GetProxy("stcp", "secret")
+		require.NoError(err)
+		require.IsType(&server.STCPOutConf{}, secret.Conf)
+		_, err = c.GetProxy("tcp", "none")
+		require.ErrorContains(err, "api status code [404]")
+
+		traffic, err := c.GetProxyTraffic("tcp-a")
+		require.NoError(err)
+		require.Equal("tcp-a", traffic.Name)
+		require.NotEmpty(traffic.TrafficIn)
+
+		require.NoError(c.ClearOfflineProxies())
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		require := require.New(t)
+		groups, err := c.GetGroups()
+		require.NoError(err)
+		require.Len(groups, 1)
+		require.Equal("ssh", groups[0].Name)
+		require.Equal("tcp", groups[0].ProxyType)
+		require.Len(groups[0].Members, 2)
+		require.ElementsMatch([]string{"tcp-a", "tcp-b"},
+			[]string{groups[0].Members[0].Name, groups[0].Members[1].Name})
+	})
+
+	t.Run("credentials", func(t *testing.T) {
+		require := require.New(t)
+		isRevoked := func(name string) bool {
+			resp, err := c.GetVisitorCredentials("secret")
+			require.NoError(err)
+			require.Equal("secret", resp.ProxyName)
+			require.Len(resp.Credentials, 2)
+			for _, credential := range resp.Credentials {
+				if credential.Name == name {
+					return credential.Revoked
+				}
+			}
+			require.FailNow("credential not found", name)
+			return false
+		}
+		require.False(isRevoked("alice"))
+
+		require.NoError(c.RevokeVisitorCredential("secret", "alice"))
+		require.True(isRevoked("alice"))
+		require.False(isRevoked("bob"))
+
+		require.NoError(c.RestoreVisitorCredential("secret", "alice"))
+		require.False(isRevoked("alice"))
+		require.ErrorContains(c.RestoreVisitorCredential("secret", "alice"), "credential is not revoked")
+
+		require.ErrorContains(c.RevokeVisitorCredential("secret", "carol"), "api status code [404]")
+		_, err := c.GetVisitorCredentials("none")
+		require.ErrorContains(err, "api status code [404]")
+		_, err = c.GetVisitorCredentials("tcp-a")
+		require.ErrorContains(err, "api status code [400]")
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		require := require.New(t)
+		result, err := c.Reload()
+		require.NoError(err)
+		require.Empty(result.Changed)
+
+		require.NoError(os.WriteFile(cfgFile, []byte(cfgContent+"maxPortsPerClient = 10\n"), 0o600))
+		result, err = c.Reload()
+		require.NoError(err)
+		require.Equal([]string{"maxPortsPerClient"}, result.Changed)
+		require.Empty(result.ClosedProxies)
+
+		require.NoError(os.WriteFile(cfgFile, []byte(cfgContent+"vhostHTTPPort = 8080\n"), 0o600))
+		_, err = c.Reload()
+		require.ErrorContains(err, "api status code [400]")
+	})
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+// RequestTypeCancelForward cancels a tcpip-forward, e.g. by the -KR command of ssh.
+const RequestTypeCancelForward = "cancel-tcpip-forward"
+
+// forward is a tcpip-forward requested by the ssh client, each forward is served by a proxy.
+type forward struct {
+	addr *tcpipForward
+	pc   v1.ProxyConfigurer
+
+	stats forwardStats
+}
+
+func (f *forward) name() string {
+	return f.pc.GetBaseConfig().Name
+}
+
+// forwardStats counts the connections of a forward. TrafficIn is the bytes sent to the ssh client and
+// trafficOut is the bytes received from it.
+type forwardStats struct {
+	curConns   atomic.Int64
+	totalConns atomic.Int64
+	trafficIn  atomic.Int64
+	trafficOut atomic.Int64
+}
+
+// statsConn counts the traffic of a connection to the ssh client as it happens, so the status screen shows
+// the traffic of long connections.
+type statsConn struct {
+	net.Conn
+	stats *forwardStats
+}
+
+func newStatsConn(conn net.Conn, stats *forwardStats) *statsConn {
+	stats.curConns.Add(1)
+	stats.totalConns.Add(1)
+	return &statsConn{Conn: conn, stats: stats}
+}
+
+func (c *statsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.trafficOut.Add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.trafficIn.Add(int64(n))
+	return n, err
+}
+
+// done must be called once after the connection is closed.
+func (c *statsConn) done() {
+	c.stats.curConns.Add(-1)
+}
+
+// applyForward adapts pc to the n-th forward of the connection. The first forward uses the proxy of the
+// command as it is. Other forwards get the name of the command with the suffix "-n", tcp proxies use the
+// bind port of the forward as the remote port and http, https and tcpmux proxies use the bind address of
+// the forward as the custom domain, e.g. -R app.example.com:80:127.0.0.1:8080.
+func applyForward(pc v1.ProxyConfigurer, name string, n int, addr *tcpipForward) error {
+	base := pc.GetBaseConfig()
+	if n == 0 {
+		base.Name = name
+		return nil
+	}
+	base.Name = fmt.Sprintf("%s-%d", name, n)
+
+	switch c := pc.(type) {
+	case *v1.TCPProxyConfig:
+		c.RemotePort = int(addr.Port)
+	case *v1.HTTPProxyConfig:
+		return applyForwardDomain(&c.DomainConfig, addr)
+	case *v1.HTTPSProxyConfig:
+		return applyForwardDomain(&c.DomainConfig, addr)
+	case *v1.TCPMuxProxyConfig:
+		return applyForwardDomain(&c.DomainConfig, addr)
+	}
+	return nil
+}
+
+func applyForwardDomain(c *v1.DomainConfig, addr *tcpipForward) error {
+	if addr.Host == "" || addr.Host == "localhost" || net.ParseIP(addr.Host) != nil {
+		return fmt.Errorf("the bind address of the forward should be a domain, e.g. -R app.example.com:80:127.0.0.1:8080")
+	}
+	c.CustomDomains = []string{addr.Host}
+	c.SubDomain = ""
+	return nil
+}
@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func TestApplyForward(t *testing.T) {
+	require := require.New(t)
+
+	tcp := &v1.TCPProxyConfig{RemotePort: 6000}
+	require.NoError(applyForward(tcp, "ssh", 0, &tcpipForward{Port: 22}))
+	require.Equal("ssh", tcp.Name)
+	require.Equal(6000, tcp.RemotePort)
+
+	require.NoError(applyForward(tcp, "ssh", 2, &tcpipForward{Port: 6002}))
+	require.Equal("ssh-2", tcp.Name)
+	require.Equal(6002, tcp.RemotePort)
+
+	http := &v1.HTTPProxyConfig{}
+	http.SubDomain = "app"
+	require.NoError(applyForward(http, "web", 1, &tcpipForward{Host: "b.example.com", Port: 80}))
+	require.Equal([]string{"b.example.com"}, http.CustomDomains)
+	require.Empty(http.SubDomain)
+	require.Error(applyForward(http, "web", 1, &tcpipForward{Host: "localhost", Port: 80}))
+	require.Error(applyForward(http, "web", 1, &tcpipForward{Host: "0.0.0.0", Port: 80}))
+}
+
+func TestStatsConn(t *testing.T) {
+	require := require.New(t)
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	stats := &forwardStats{}
+	conn := newStatsConn(c1, stats)
+	require.EqualValues(1, stats.curConns.Load())
+
+	go func() {
+		_, _ = c2.Write([]byte("hello"))
+		buf := make([]byte, 3)
+		_, _ = c2.Read(buf)
+	}()
+	buf := make([]byte, 5)
+	_, err := conn.Read(buf)
+	require.NoError(err)
+	_, err = conn.Write([]byte("abc"))
+	require.NoError(err)
+	conn.Close()
+	conn.done()
+
+	require.EqualValues(0, stats.curConns.Load())
+	require.EqualValues(1, stats.totalConns.Load())
+	require.EqualValues(3, stats.trafficIn.Load())
+	require.EqualValues(5, stats.trafficOut.Load())
+}
@@ -1,8 +1,8 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
-	"gitee.com/menciis/logx"
 	"net"
 	"os"
 	"strconv"
@@ -13,21 +13,25 @@ import (
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/transport"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
+	"github.com/iami317/hepx/pkg/util/xlog"
 )
 
 type Gateway struct {
 	bindPort int
 	ln       net.Listener
+	xl       *xlog.Logger
 
 	peerServerListener *netpkg.InternalListener
 
 	sshConfig *ssh.ServerConfig
 }
 
+// NewGateway creates the ssh tunnel gateway, connections are logged by the logger of ctx.
 func NewGateway(
-	cfg v1.SSHTunnelGateway, bindAddr string,
+	ctx context.Context, cfg v1.SSHTunnelGateway, bindAddr string,
 	peerServerListener *netpkg.InternalListener,
 ) (*Gateway, error) {
+	xl := xlog.FromContextSafe(ctx)
 	sshConfig := &ssh.ServerConfig{}
 
 	// privateKey
@@ -61,19 +65,15 @@ func NewGateway(
 	sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 		authorizedKeysMap, err := loadAuthorizedKeysFromFile(cfg.AuthorizedKeysFile)
 		if err != nil {
-			logx.Errorf("load authorized keys file error: %v", err)
+			connLogger(xl, conn.RemoteAddr()).Errorf("load authorized keys file error: %v", err)
 			return nil, fmt.Errorf("internal error")
 		}
 
-		user, ok := authorizedKeysMap[string(key.Marshal())]
+		ak, ok := authorizedKeysMap[string(key.Marshal())]
 		if !ok {
 			return nil, fmt.Errorf("unknown public key for remoteAddr %q", conn.RemoteAddr())
 		}
-		return &ssh.Permissions{
-			Extensions: map[string]string{
-				"user": user,
-			},
-		}, nil
+		return ak.permissions(), nil
 	}
 
 	ln, err := net.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(cfg.BindPort)))
@@ -83,6 +83,7 @@ func NewGateway(
 	return &Gateway{
 		bindPort:           cfg.BindPort,
 		ln:                 ln,
+		xl:                 xl,
 		peerServerListener: peerServerListener,
 		sshConfig:          sshConfig,
 	}, nil
@@ -101,29 +102,117 @@ func (g *Gateway) Run() {
 func (g *Gateway) handleConn(conn net.Conn) {
 	defer conn.Close()
 
-	ts, err := NewTunnelServer(conn, g.sshConfig, g.peerServerListener)
+	xl := connLogger(g.xl, conn.RemoteAddr())
+	ts, err := NewTunnelServer(xlog.NewContext(context.Background(), xl), conn, g.sshConfig, g.peerServerListener)
 	if err != nil {
 		return
 	}
 	if err := ts.Run(); err != nil {
-		logx.Errorf("ssh tunnel server run error: %v", err)
+		xl.Errorf("ssh tunnel server run error: %v", err)
 	}
 }
 
-func loadAuthorizedKeysFromFile(path string) (map[string]string, error) {
-	authorizedKeysMap := make(map[string]string) // value is username
+// connLogger returns a logger spawned from xl with the remote address of an ssh connection as a structured field.
+func connLogger(xl *xlog.Logger, remoteAddr net.Addr) *xlog.Logger {
+	return xl.Spawn().AddPrefix(xlog.LogPrefix{Name: "remoteAddr", Value: remoteAddr.String(), Hidden: true})
+}
+
+// authorizedKey is the identity of a key in the authorized keys file. The comment of the key is the user and
+// the environment="KEY=VALUE" options of the key are the metadatas, they are used in the login of the virtual
+// client like the ones of frpc.
+type authorizedKey struct {
+	user  string
+	metas map[string]string
+}
+
+const (
+	permissionUser       = "user"
+	permissionMetaPrefix = "meta."
+)
+
+func (k *authorizedKey) permissions() *ssh.Permissions {
+	extensions := map[string]string{
+		permissionUser: k.user,
+	}
+	for name, value := range k.metas {
+		extensions[permissionMetaPrefix+name] = value
+	}
+	return &ssh.Permissions{Extensions: extensions}
+}
+
+// identityFromPermissions returns the user and the metadatas set by authorizedKey.permissions.
+func identityFromPermissions(p *ssh.Permissions) (string, map[string]string) {
+	if p == nil {
+		return "", nil
+	}
+	var metas map[string]string
+	for name, value := range p.Extensions {
+		if metaName, ok := strings.CutPrefix(name, permissionMetaPrefix); ok {
+			if metas == nil {
+				metas = make(map[string]string)
+			}
+			metas[metaName] = value
+		}
+	}
+	return p.Extensions[permissionUser], metas
+}
+
+// applyIdentity sets the user and the metadatas of the virtual client from the authorized key of the ssh
+// connection. Clients authenticated by keys skip the authentication of the virtual client, so they can't
+// choose another user by flags, keys without a user log in with the empty user.
+func applyIdentity(cfg *v1.ClientCommonConfig, p *ssh.Permissions, keyAuth bool) {
+	user, metas := identityFromPermissions(p)
+	if keyAuth {
+		cfg.User = user
+	}
+	cfg.Metadatas = metas
+}
+
+func loadAuthorizedKeysFromFile(path string) (map[string]*authorizedKey, error) {
+	authorizedKeysMap := make(map[string]*authorizedKey)
 	authorizedKeysBytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	for len(authorizedKeysBytes) > 0 {
-		pubKey, comment, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		pubKey, comment, options, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
 		if err != nil {
 			return nil, err
 		}
+		metas, err := parseMetasFromKeyOptions(options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options of key [%s]: %v", comment, err)
+		}
 
-		authorizedKeysMap[string(pubKey.Marshal())] = strings.TrimSpace(comment)
+		authorizedKeysMap[string(pubKey.Marshal())] = &authorizedKey{
+			user:  strings.TrimSpace(comment),
+			metas: metas,
+		}
 		authorizedKeysBytes = rest
 	}
 	return authorizedKeysMap, nil
 }
+
+// parseMetasFromKeyOptions parses the environment="KEY=VALUE" options, other options are ignored.
+func parseMetasFromKeyOptions(options []string) (map[string]string, error) {
+	var metas map[string]string
+	for _, option := range options {
+		name, value, _ := strings.Cut(option, "=")
+		if !strings.EqualFold(name, "environment") {
+			continue
+		}
+		value, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("environment option %s isn't quoted", option)
+		}
+		k, v, ok := strings.Cut(value, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("environment option %s should be KEY=VALUE", option)
+		}
+		if metas == nil {
+			metas = make(map[string]string)
+		}
+		metas[k] = v
+	}
+	return metas, nil
+}
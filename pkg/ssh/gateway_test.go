@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestLoadAuthorizedKeysFromFile(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob := newTestPublicKey(t), newTestPublicKey(t)
+	line := func(options string, key ssh.PublicKey, comment string) string {
+		return strings.TrimSpace(options + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment)
+	}
+	content := line(`environment="team=dev",no-pty,environment="region=us-east"`, alice, "alice") + "\n" +
+		line("", bob, "bob") + "\n"
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	require.NoError(os.WriteFile(path, []byte(content), 0o600))
+
+	keys, err := loadAuthorizedKeysFromFile(path)
+	require.NoError(err)
+	require.Len(keys, 2)
+
+	user, metas := identityFromPermissions(keys[string(alice.Marshal())].permissions())
+	require.Equal("alice", user)
+	require.Equal(map[string]string{"team": "dev", "region": "us-east"}, metas)
+
+	user, metas = identityFromPermissions(keys[string(bob.Marshal())].permissions())
+	require.Equal("bob", user)
+	require.Nil(metas)
+
+	user, metas = identityFromPermissions(nil)
+	require.Empty(user)
+	require.Nil(metas)
+
+	content = line(`environment="team"`, alice, "alice") + "\n"
+	require.NoError(os.WriteFile(path, []byte(content), 0o600))
+	_, err = loadAuthorizedKeysFromFile(path)
+	require.Error(err)
+}
+
+func TestApplyIdentity(t *testing.T) {
+	require := require.New(t)
+
+	// the user flag is ignored for clients authenticated by keys, even if the key has no user
+	cfg := &v1.ClientCommonConfig{User: "admin"}
+	applyIdentity(cfg, (&authorizedKey{}).permissions(), true)
+	require.Empty(cfg.User)
+
+	cfg = &v1.ClientCommonConfig{User: "admin"}
+	applyIdentity(cfg, (&authorizedKey{user: "alice", metas: map[string]string{"team": "dev"}}).permissions(), true)
+	require.Equal("alice", cfg.User)
+	require.Equal(map[string]string{"team": "dev"}, cfg.Metadatas)
+
+	// without authorized keys, the virtual client authenticates with the token and the user flag is kept
+	cfg = &v1.ClientCommonConfig{User: "admin"}
+	applyIdentity(cfg, nil, false)
+	require.Equal("admin", cfg.User)
+}
@@ -5,12 +5,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"gitee.com/menciis/logx"
-	"github.com/fatedier/golib/log"
 	"net"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	libio "github.com/fatedier/golib/io"
@@ -21,6 +21,7 @@ import (
 	"github.com/iami317/hepx/client/proxy"
 	"github.com/iami317/hepx/pkg/config"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/config/v1/validation"
 	"github.com/iami317/hepx/pkg/msg"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
 	"github.com/iami317/hepx/pkg/util/util"
@@ -31,9 +32,29 @@ import (
 const (
 	// https://datatracker.ietf.org/doc/html/rfc4254#page-16
 	ChannelTypeServerOpenChannel = "forwarded-tcpip"
+	ChannelTypeDirectTCPIP       = "direct-tcpip"
 	RequestTypeForward           = "tcpip-forward"
 )
 
+// CommandVisitor is the second argument of the command to create a visitor, e.g. "stcp visitor". Connections
+// forwarded by ssh -L are sent to the visitor.
+const CommandVisitor = "visitor"
+
+var (
+	supportProxyTypes   = []string{"tcp", "http", "https", "tcpmux", "stcp"}
+	supportVisitorTypes = []string{"stcp", "xtcp"}
+
+	// ssh -R and ssh -L only forward tcp connections, the reasons are shown to users who try other types.
+	unsupportedProxyTypes = map[string]string{
+		"udp":  "ssh only forwards tcp connections, udp packets can't be delivered to the ssh client",
+		"sudp": "ssh only forwards tcp connections, udp packets can't be delivered to the ssh client",
+		"xtcp": "xtcp proxies punch holes from the host of the ssh client, use stcp instead",
+	}
+	unsupportedVisitorTypes = map[string]string{
+		"sudp": "ssh only forwards tcp connections, udp packets can't be received from the ssh client",
+	}
+)
+
 type tcpipForward struct {
 	Host string
 	Port uint32
@@ -49,6 +70,7 @@ type forwardedTCPPayload struct {
 }
 
 type TunnelServer struct {
+	xl             *xlog.Logger
 	underlyingConn net.Conn
 	sshConn        *ssh.ServerConn
 	sc             *ssh.ServerConfig
@@ -58,14 +80,43 @@ type TunnelServer struct {
 	peerServerListener *netpkg.InternalListener
 	doneCh             chan struct{}
 	closeDoneChOnce    sync.Once
+
+	// visitorName is the name of the visitor in visitor mode, it's set before readyCh is closed.
+	visitorName string
+	// readyCh is closed when the proxy mode is determined or the visitor is running.
+	readyCh chan struct{}
+
+	// command is the command of the ssh client, it's parsed again for the proxy of each forward.
+	command   string
+	proxyName string
+	user      string
+	// pty is set if the ssh client requests a PTY for the first session.
+	pty  atomic.Bool
+	sess *session
+	// forwardCh receives the tcpip-forward and cancel-tcpip-forward requests of the ssh client.
+	forwardCh chan forwardRequest
+
+	mu         sync.RWMutex
+	forwards   []*forward
+	forwardSeq int
+}
+
+type forwardRequest struct {
+	addr   *tcpipForward
+	cancel bool
 }
 
-func NewTunnelServer(conn net.Conn, sc *ssh.ServerConfig, peerServerListener *netpkg.InternalListener) (*TunnelServer, error) {
+func NewTunnelServer(
+	ctx context.Context, conn net.Conn, sc *ssh.ServerConfig, peerServerListener *netpkg.InternalListener,
+) (*TunnelServer, error) {
 	s := &TunnelServer{
+		xl:                 xlog.FromContextSafe(ctx),
 		underlyingConn:     conn,
 		sc:                 sc,
 		peerServerListener: peerServerListener,
 		doneCh:             make(chan struct{}),
+		readyCh:            make(chan struct{}),
+		forwardCh:          make(chan forwardRequest),
 	}
 	return s, nil
 }
@@ -77,13 +128,18 @@ func (s *TunnelServer) Run() error {
 	}
 
 	s.sshConn = sshConn
+	defer s.close()
 
-	addr, extraPayload, err := s.waitForwardAddrAndExtraPayload(channels, requests, 3*time.Second)
+	addrs, extraPayload, err := s.waitForwardAddrsAndExtraPayload(channels, requests, 3*time.Second)
 	if err != nil {
 		return err
 	}
 
-	clientCfg, pc, helpMessage, err := s.parseClientAndProxyConfigurer(addr, extraPayload)
+	var firstAddr *tcpipForward
+	if len(addrs) > 0 {
+		firstAddr = addrs[0]
+	}
+	clientCfg, pc, visitorCfg, helpMessage, err := s.parseClientAndConfigurer(firstAddr, extraPayload)
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			s.writeToClient(helpMessage)
@@ -93,10 +149,18 @@ func (s *TunnelServer) Run() error {
 		return fmt.Errorf("parse flags from ssh client error: %v", err)
 	}
 	clientCfg.Complete()
-	if sshConn.Permissions != nil {
-		clientCfg.User = util.EmptyOr(sshConn.Permissions.Extensions["user"], clientCfg.User)
+	applyIdentity(clientCfg, sshConn.Permissions, !s.sc.NoClientAuth)
+	s.command = extraPayload
+	s.user = clientCfg.User
+	if pc != nil {
+		s.proxyName = pc.GetBaseConfig().Name
+	} else {
+		visitorCfg.Complete(clientCfg)
+		if err := validation.ValidateVisitorConfigurer(visitorCfg); err != nil {
+			s.writeToClient(err.Error())
+			return fmt.Errorf("invalid visitor from ssh client: %v", err)
+		}
 	}
-	pc.Complete(clientCfg.User)
 
 	vc, err := virtual.NewClient(virtual.ClientOptions{
 		Common: clientCfg,
@@ -107,14 +171,21 @@ func (s *TunnelServer) Run() error {
 			AlwaysAuthPass: !s.sc.NoClientAuth,
 		},
 		HandleWorkConnCb: func(base *v1.ProxyBaseConfig, workConn net.Conn, m *msg.StartWorkConn) bool {
+			f := s.getForward(base.Name)
+			if f == nil {
+				workConn.Close()
+				return false
+			}
 			// join workConn and ssh channel
-			c, err := s.openConn(addr)
+			c, err := s.openConn(f.addr)
 			if err != nil {
-				logx.Verbosef("open conn error: %v", err)
+				s.xl.Debugf("open conn error: %v", err)
 				workConn.Close()
 				return false
 			}
-			libio.Join(c, workConn)
+			sc := newStatsConn(c, &f.stats)
+			libio.Join(sc, workConn)
+			sc.done()
 			return false
 		},
 	})
@@ -134,7 +205,7 @@ func (s *TunnelServer) Run() error {
 			_ = s.peerServerListener.PutConn(conn)
 		}
 	}()
-	xl := xlog.New().AddPrefix(xlog.LogPrefix{Name: "sshVirtualClient", Value: "sshVirtualClient", Priority: 100})
+	xl := s.xl.Spawn().AddPrefix(xlog.LogPrefix{Name: "sshVirtualClient", Value: "sshVirtualClient", Priority: 100})
 	ctx := xlog.NewContext(context.Background(), xl)
 	go func() {
 		vcErr := s.vc.Run(ctx)
@@ -144,75 +215,132 @@ func (s *TunnelServer) Run() error {
 
 		// If vc.Run returns, it means that the virtual client has been closed, and the ssh tunnel connection should be closed.
 		// One scenario is that the virtual client exits due to login failure.
-		s.closeDoneChOnce.Do(func() {
-			_ = sshConn.Close()
-			close(s.doneCh)
-		})
+		s.close()
 	}()
 
-	s.vc.UpdateProxyConfigurer([]v1.ProxyConfigurer{pc})
+	if pc != nil {
+		close(s.readyCh)
+		s.sess = newSession(s, s.firstChannel, s.pty.Load())
 
-	if ps, err := s.waitProxyStatusReady(pc.GetBaseConfig().Name, time.Second); err != nil {
-		s.writeToClient(err.Error())
-		log.Warnf("wait proxy status ready error: %v", err)
+		// the connection is closed if the proxy of the first forward fails
+		var ps *proxy.WorkingStatus
+		f, err := s.addForward(firstAddr)
+		if err == nil {
+			ps, err = s.waitProxyStatusReady(f.name(), time.Second)
+		}
+		if err != nil {
+			s.writeToClient(err.Error())
+			s.xl.Warnf("wait proxy status ready error: %v", err)
+		} else {
+			// success
+			if !s.sess.pty {
+				s.writeToClient(createSuccessInfo(clientCfg.User, f.pc, ps))
+			}
+			for _, addr := range addrs[1:] {
+				go s.startForward(addr)
+			}
+			go s.handleForwardRequests()
+			go s.sess.serve()
+			_ = sshConn.Wait()
+		}
 	} else {
-		// success
-		s.writeToClient(createSuccessInfo(clientCfg.User, pc, ps))
-		_ = sshConn.Wait()
+		s.vc.UpdateVisitorConfigurer([]v1.VisitorConfigurer{visitorCfg})
+
+		name := visitorCfg.GetBaseConfig().Name
+		if err := s.waitVisitorRunning(name, time.Second); err != nil {
+			s.writeToClient(err.Error())
+			s.xl.Warnf("wait visitor running error: %v", err)
+		} else {
+			s.visitorName = name
+			close(s.readyCh)
+			s.writeToClient(createVisitorSuccessInfo(clientCfg.User, visitorCfg))
+			go s.handleForwardRequests()
+			_ = sshConn.Wait()
+		}
 	}
 
 	s.vc.Close()
-	log.Tracef("ssh tunnel connection from %v closed", sshConn.RemoteAddr())
+	s.xl.Tracef("ssh tunnel connection from %v closed", sshConn.RemoteAddr())
+	return nil
+}
+
+func (s *TunnelServer) close() {
 	s.closeDoneChOnce.Do(func() {
-		_ = sshConn.Close()
+		_ = s.sshConn.Close()
 		close(s.doneCh)
 	})
-	return nil
 }
 
 func (s *TunnelServer) writeToClient(data string) {
 	if s.firstChannel == nil {
 		return
 	}
-	_, _ = s.firstChannel.Write([]byte(data + "\n"))
+	data += "\n"
+	if s.pty.Load() {
+		data = strings.ReplaceAll(data, "\n", "\r\n")
+	}
+	_, _ = s.firstChannel.Write([]byte(data))
+}
+
+// notify shows a message about the forwards to the ssh client.
+func (s *TunnelServer) notify(message string) {
+	if s.sess != nil && s.sess.pty {
+		s.sess.setMessage(message)
+		return
+	}
+	s.writeToClient(message)
 }
 
-func (s *TunnelServer) waitForwardAddrAndExtraPayload(
+// waitForwardAddrsAndExtraPayload waits for the command and the first forward of the ssh client, forwards
+// requested in the meantime are returned too.
+func (s *TunnelServer) waitForwardAddrsAndExtraPayload(
 	channels <-chan ssh.NewChannel,
 	requests <-chan *ssh.Request,
 	timeout time.Duration,
-) (*tcpipForward, string, error) {
-	addrCh := make(chan *tcpipForward, 1)
+) ([]*tcpipForward, string, error) {
 	extraPayloadCh := make(chan string, 1)
 
-	// get forward address
+	// get forward addresses, forwards requested later are handled by handleForwardRequests
 	go func() {
-		addrGot := false
 		for req := range requests {
-			if req.Type == RequestTypeForward && !addrGot {
-				payload := tcpipForward{}
-				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
-					return
+			if req.Type != RequestTypeForward && req.Type != RequestTypeCancelForward {
+				if req.WantReply {
+					_ = req.Reply(true, nil)
 				}
-				addrGot = true
-				addrCh <- &payload
+				continue
+			}
+			payload := tcpipForward{}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				if req.WantReply {
+					_ = req.Reply(false, nil)
+				}
+				continue
 			}
 			if req.WantReply {
 				_ = req.Reply(true, nil)
 			}
+			select {
+			case s.forwardCh <- forwardRequest{addr: &payload, cancel: req.Type == RequestTypeCancelForward}:
+			case <-s.doneCh:
+				return
+			}
 		}
 	}()
 
 	// get extra payload
 	go func() {
 		for newChannel := range channels {
+			if newChannel.ChannelType() == ChannelTypeDirectTCPIP {
+				go s.handleDirectTCPIPChannel(newChannel)
+				continue
+			}
 			// extraPayload will send to extraPayloadCh
 			go s.handleNewChannel(newChannel, extraPayloadCh)
 		}
 	}()
 
 	var (
-		addr         *tcpipForward
+		addrs        []*tcpipForward
 		extraPayload string
 	)
 
@@ -220,21 +348,36 @@ func (s *TunnelServer) waitForwardAddrAndExtraPayload(
 	defer timer.Stop()
 	for {
 		select {
-		case v := <-addrCh:
-			addr = v
+		case req := <-s.forwardCh:
+			if req.cancel {
+				addrs = slices.DeleteFunc(addrs, func(addr *tcpipForward) bool { return *addr == *req.addr })
+			} else {
+				addrs = append(addrs, req.addr)
+			}
 		case extra := <-extraPayloadCh:
 			extraPayload = extra
 		case <-timer.C:
 			return nil, "", fmt.Errorf("get addr and extra payload timeout")
 		}
-		if addr != nil && extraPayload != "" {
+		// ssh -L doesn't request forwarding, visitors don't need the address
+		if extraPayload != "" && (len(addrs) > 0 || isVisitorCommand(extraPayload)) {
 			break
 		}
 	}
-	return addr, extraPayload, nil
+	return addrs, extraPayload, nil
+}
+
+func isVisitorCommand(extraPayload string) bool {
+	args := strings.Fields(extraPayload)
+	return len(args) > 1 && args[1] == CommandVisitor
 }
 
-func (s *TunnelServer) parseClientAndProxyConfigurer(_ *tcpipForward, extraPayload string) (*v1.ClientCommonConfig, v1.ProxyConfigurer, string, error) {
+// parseClientAndConfigurer parses the command of the ssh client, it returns a proxy configurer for commands
+// like "tcp --remote_port 6000" and a visitor configurer for commands like "stcp visitor --server_name ssh".
+func (s *TunnelServer) parseClientAndConfigurer(
+	_ *tcpipForward,
+	extraPayload string,
+) (*v1.ClientCommonConfig, v1.ProxyConfigurer, v1.VisitorConfigurer, string, error) {
 	helpMessage := ""
 	cmd := &cobra.Command{
 		Use:   "ssh v0@{address} [command]",
@@ -245,18 +388,39 @@ func (s *TunnelServer) parseClientAndProxyConfigurer(_ *tcpipForward, extraPaylo
 
 	args := strings.Split(extraPayload, " ")
 	if len(args) < 1 {
-		return nil, nil, helpMessage, fmt.Errorf("invalid extra payload")
-	}
-	proxyType := strings.TrimSpace(args[0])
-	supportTypes := []string{"tcp", "http", "https", "tcpmux", "stcp"}
-	if !slices.Contains(supportTypes, proxyType) {
-		return nil, nil, helpMessage, fmt.Errorf("invalid proxy type: %s, support types: %v", proxyType, supportTypes)
+		return nil, nil, nil, helpMessage, fmt.Errorf("invalid extra payload")
 	}
-	pc := v1.NewProxyConfigurerByType(v1.ProxyType(proxyType))
-	if pc == nil {
-		return nil, nil, helpMessage, fmt.Errorf("new proxy configurer error")
+	typ := strings.TrimSpace(args[0])
+
+	var (
+		pc         v1.ProxyConfigurer
+		visitorCfg v1.VisitorConfigurer
+	)
+	if isVisitorCommand(extraPayload) {
+		if reason, ok := unsupportedVisitorTypes[typ]; ok {
+			return nil, nil, nil, helpMessage, fmt.Errorf("visitor type %s isn't supported: %s", typ, reason)
+		}
+		if !slices.Contains(supportVisitorTypes, typ) {
+			return nil, nil, nil, helpMessage, fmt.Errorf("invalid visitor type: %s, support types: %v", typ, supportVisitorTypes)
+		}
+		visitorCfg = v1.NewVisitorConfigurerByType(v1.VisitorType(typ))
+		if visitorCfg == nil {
+			return nil, nil, nil, helpMessage, fmt.Errorf("new visitor configurer error")
+		}
+		config.RegisterVisitorFlags(cmd, visitorCfg, config.WithSSHMode())
+	} else {
+		if reason, ok := unsupportedProxyTypes[typ]; ok {
+			return nil, nil, nil, helpMessage, fmt.Errorf("proxy type %s isn't supported: %s", typ, reason)
+		}
+		if !slices.Contains(supportProxyTypes, typ) {
+			return nil, nil, nil, helpMessage, fmt.Errorf("invalid proxy type: %s, support types: %v", typ, supportProxyTypes)
+		}
+		pc = v1.NewProxyConfigurerByType(v1.ProxyType(typ))
+		if pc == nil {
+			return nil, nil, nil, helpMessage, fmt.Errorf("new proxy configurer error")
+		}
+		config.RegisterProxyFlags(cmd, pc, config.WithSSHMode())
 	}
-	config.RegisterProxyFlags(cmd, pc, config.WithSSHMode())
 
 	clientCfg := v1.ClientCommonConfig{}
 	config.RegisterClientCommonConfigFlags(cmd, &clientCfg, config.WithSSHMode())
@@ -266,17 +430,31 @@ func (s *TunnelServer) parseClientAndProxyConfigurer(_ *tcpipForward, extraPaylo
 		if errors.Is(err, flag.ErrHelp) {
 			helpMessage = cmd.UsageString()
 		}
-		return nil, nil, helpMessage, err
+		return nil, nil, nil, helpMessage, err
+	}
+
+	if visitorCfg != nil {
+		// only receive the connections forwarded by ssh -L
+		visitorCfg.GetBaseConfig().BindPort = -1
+		if visitorCfg.GetBaseConfig().Name == "" {
+			id, err := util.RandIDWithLen(8)
+			if err != nil {
+				return nil, nil, nil, helpMessage, fmt.Errorf("generate random id error: %v", err)
+			}
+			visitorCfg.GetBaseConfig().Name = fmt.Sprintf("sshtunnel-%s-visitor-%s", typ, id)
+		}
+		return &clientCfg, nil, visitorCfg, helpMessage, nil
 	}
+
 	// if name is not set, generate a random one
 	if pc.GetBaseConfig().Name == "" {
 		id, err := util.RandIDWithLen(8)
 		if err != nil {
-			return nil, nil, helpMessage, fmt.Errorf("generate random id error: %v", err)
+			return nil, nil, nil, helpMessage, fmt.Errorf("generate random id error: %v", err)
 		}
-		pc.GetBaseConfig().Name = fmt.Sprintf("sshtunnel-%s-%s", proxyType, id)
+		pc.GetBaseConfig().Name = fmt.Sprintf("sshtunnel-%s-%s", typ, id)
 	}
-	return &clientCfg, pc, helpMessage, nil
+	return &clientCfg, pc, nil, helpMessage, nil
 }
 
 func (s *TunnelServer) handleNewChannel(channel ssh.NewChannel, extraPayloadCh chan string) {
@@ -293,6 +471,9 @@ func (s *TunnelServer) handleNewChannel(channel ssh.NewChannel, extraPayloadCh c
 		if req.WantReply {
 			_ = req.Reply(true, nil)
 		}
+		if req.Type == "pty-req" && ch == s.firstChannel {
+			s.pty.Store(true)
+		}
 		if req.Type != "exec" || len(req.Payload) <= 4 {
 			continue
 		}
@@ -308,6 +489,32 @@ func (s *TunnelServer) handleNewChannel(channel ssh.NewChannel, extraPayloadCh c
 	}
 }
 
+// handleDirectTCPIPChannel sends the connection forwarded by ssh -L to the visitor.
+func (s *TunnelServer) handleDirectTCPIPChannel(newChannel ssh.NewChannel) {
+	select {
+	case <-s.readyCh:
+	case <-s.doneCh:
+		_ = newChannel.Reject(ssh.ConnectionFailed, "ssh tunnel closed")
+		return
+	}
+	if s.visitorName == "" {
+		_ = newChannel.Reject(ssh.Prohibited, "local forwarding is only supported by visitors")
+		return
+	}
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	conn := netpkg.WrapReadWriteCloserToConn(channel, s.underlyingConn)
+	if err := s.vc.Service().TransferVisitorConn(s.visitorName, conn); err != nil {
+		s.xl.Warnf("transfer ssh connection to visitor [%s] error: %v", s.visitorName, err)
+		conn.Close()
+	}
+}
+
 func (s *TunnelServer) keepAlive(ch ssh.Channel) {
 	tk := time.NewTicker(time.Second * 30)
 	defer tk.Stop()
@@ -372,3 +579,132 @@ func (s *TunnelServer) waitProxyStatusReady(name string, timeout time.Duration)
 		}
 	}
 }
+
+func (s *TunnelServer) waitVisitorRunning(name string, timeout time.Duration) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.vc.Service().IsVisitorRunning(name) {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("wait visitor running timeout")
+		case <-s.doneCh:
+			return fmt.Errorf("ssh tunnel server closed")
+		}
+	}
+}
+
+// handleForwardRequests handles the forwards requested after the first one until the connection is closed.
+func (s *TunnelServer) handleForwardRequests() {
+	for {
+		select {
+		case req := <-s.forwardCh:
+			if req.cancel {
+				s.cancelForward(req.addr)
+			} else {
+				go s.startForward(req.addr)
+			}
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+// startForward starts the proxy of a forward requested after the first one, the result is shown to the ssh
+// client.
+func (s *TunnelServer) startForward(addr *tcpipForward) {
+	f, err := s.addForward(addr)
+	if err == nil {
+		var ps *proxy.WorkingStatus
+		if ps, err = s.waitProxyStatusReady(f.name(), time.Second); err == nil {
+			s.notify(createSuccessInfo(s.user, f.pc, ps))
+			return
+		}
+		s.removeForward(f.name())
+	}
+	s.notify(fmt.Sprintf("forward [%s] error: %v", net.JoinHostPort(addr.Host, strconv.Itoa(int(addr.Port))), err))
+}
+
+func (s *TunnelServer) cancelForward(addr *tcpipForward) {
+	s.mu.RLock()
+	idx := slices.IndexFunc(s.forwards, func(f *forward) bool { return *f.addr == *addr })
+	var name string
+	if idx >= 0 {
+		name = s.forwards[idx].name()
+	}
+	s.mu.RUnlock()
+
+	if name != "" && s.removeForward(name) {
+		s.notify(fmt.Sprintf("forward [%s] closed", name))
+	}
+}
+
+// addForward creates the proxy of a forward from the command of the ssh client and starts it.
+func (s *TunnelServer) addForward(addr *tcpipForward) (*forward, error) {
+	if s.proxyName == "" {
+		return nil, fmt.Errorf("remote forwarding is only supported by proxies")
+	}
+	_, pc, _, _, err := s.parseClientAndConfigurer(addr, s.command)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := applyForward(pc, s.proxyName, s.forwardSeq, addr); err != nil {
+		return nil, err
+	}
+	s.forwardSeq++
+	pc.Complete(s.user)
+
+	f := &forward{addr: addr, pc: pc}
+	s.forwards = append(s.forwards, f)
+	s.updateProxiesLocked()
+	return f, nil
+}
+
+// removeForward closes the proxy of a forward, the forward isn't canceled in the ssh client.
+func (s *TunnelServer) removeForward(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.forwards)
+	s.forwards = slices.DeleteFunc(s.forwards, func(f *forward) bool { return f.name() == name })
+	if len(s.forwards) == n {
+		return false
+	}
+	s.updateProxiesLocked()
+	return true
+}
+
+func (s *TunnelServer) updateProxiesLocked() {
+	pcs := make([]v1.ProxyConfigurer, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		pcs = append(pcs, f.pc)
+	}
+	s.vc.UpdateProxyConfigurer(pcs)
+}
+
+func (s *TunnelServer) getForward(name string) *forward {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.forwards {
+		if f.name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// getForwards returns the current forwards in the order they are requested.
+func (s *TunnelServer) getForwards() []*forward {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.forwards)
+}
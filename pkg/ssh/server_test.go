@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func TestParseClientAndConfigurer(t *testing.T) {
+	require := require.New(t)
+	s := &TunnelServer{}
+
+	clientCfg, pc, vc, _, err := s.parseClientAndConfigurer(&tcpipForward{}, "tcp --remote_port 6000 --user alice")
+	require.NoError(err)
+	require.Nil(vc)
+	require.Equal("alice", clientCfg.User)
+	require.Equal(6000, pc.(*v1.TCPProxyConfig).RemotePort)
+
+	_, pc, vc, _, err = s.parseClientAndConfigurer(nil, "stcp visitor --server_name ssh --server_user bob --sk abc")
+	require.NoError(err)
+	require.Nil(pc)
+	base := vc.GetBaseConfig()
+	require.Equal("stcp", base.Type)
+	require.Equal("ssh", base.ServerName)
+	require.Equal("bob", base.ServerUser)
+	require.Equal("abc", base.SecretKey)
+	require.Equal(-1, base.BindPort)
+	require.NotEmpty(base.Name)
+
+	_, _, _, _, err = s.parseClientAndConfigurer(&tcpipForward{}, "udp --remote_port 6000")
+	require.ErrorContains(err, "isn't supported")
+	_, _, _, _, err = s.parseClientAndConfigurer(nil, "sudp visitor --server_name ssh")
+	require.ErrorContains(err, "isn't supported")
+	_, _, _, _, err = s.parseClientAndConfigurer(nil, "xtcp visitor --bind_port 6000")
+	require.Error(err)
+
+	require.True(isVisitorCommand("xtcp visitor --server_name ssh"))
+	require.False(isVisitorCommand("tcp --remote_port 6000"))
+}
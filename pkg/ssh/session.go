@@ -0,0 +1,230 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/iami317/hepx/pkg/util/util"
+)
+
+const sessionHelp = `commands:
+  list          list the proxies of the forwards
+  stats         show the connections and the traffic of the forwards
+  close <name>  close the proxy of a forward
+  help          show this message`
+
+// session serves the commands typed into the first session channel. Without a PTY, commands are read line
+// by line and their output is written after them. With a PTY, a status screen of the forwards is redrawn
+// every second, the output of the last command is shown under it.
+type session struct {
+	s   *TunnelServer
+	ch  ssh.Channel
+	pty bool
+
+	mu      sync.Mutex
+	line    []byte
+	message string
+}
+
+func newSession(s *TunnelServer, ch ssh.Channel, pty bool) *session {
+	return &session{
+		s:   s,
+		ch:  ch,
+		pty: pty,
+	}
+}
+
+func (sess *session) serve() {
+	if sess.ch == nil {
+		return
+	}
+	if !sess.pty {
+		scanner := bufio.NewScanner(sess.ch)
+		for scanner.Scan() {
+			if output := sess.execCommand(scanner.Text()); output != "" {
+				sess.s.writeToClient(output)
+			}
+		}
+		return
+	}
+
+	go sess.readPTY()
+
+	sess.draw()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sess.draw()
+		case <-sess.s.doneCh:
+			return
+		}
+	}
+}
+
+// readPTY handles the keys typed into the PTY, it supports simple line editing.
+func (sess *session) readPTY() {
+	var (
+		buf    [256]byte
+		escape bool
+	)
+	for {
+		n, err := sess.ch.Read(buf[:])
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			switch {
+			case escape:
+				// skip escape sequences like arrow keys, they end with a byte in 0x40-0x7e except '['
+				if b != '[' && b >= 0x40 && b <= 0x7e {
+					escape = false
+				}
+			case b == 0x1b:
+				escape = true
+			case b == 0x03 || b == 0x04:
+				// Ctrl+C or Ctrl+D
+				sess.s.close()
+				return
+			case b == '\r' || b == '\n':
+				sess.mu.Lock()
+				line := string(sess.line)
+				sess.line = sess.line[:0]
+				sess.mu.Unlock()
+				sess.setMessage(sess.execCommand(line))
+			case b == 0x7f || b == 0x08:
+				sess.mu.Lock()
+				if len(sess.line) > 0 {
+					sess.line = sess.line[:len(sess.line)-1]
+				}
+				sess.mu.Unlock()
+				sess.drawPrompt()
+			case b >= 0x20:
+				sess.mu.Lock()
+				sess.line = append(sess.line, b)
+				sess.mu.Unlock()
+				sess.drawPrompt()
+			}
+		}
+	}
+}
+
+func (sess *session) setMessage(message string) {
+	sess.mu.Lock()
+	sess.message = message
+	sess.mu.Unlock()
+	sess.draw()
+}
+
+// draw redraws the whole screen.
+func (sess *session) draw() {
+	var b bytes.Buffer
+	// move to the top left and clear the screen
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("frp (via SSH) (Ctrl+C to quit)\n\n")
+	b.WriteString("User: " + sess.s.user + "\n\n")
+	sess.writeStatus(&b)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.message != "" {
+		b.WriteString("\n" + strings.TrimRight(sess.message, "\n") + "\n")
+	}
+	b.WriteString("\n> " + string(sess.line))
+	_, _ = sess.ch.Write(bytes.ReplaceAll(b.Bytes(), []byte("\n"), []byte("\r\n")))
+}
+
+// drawPrompt redraws the line of the prompt.
+func (sess *session) drawPrompt() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, _ = sess.ch.Write([]byte("\r\x1b[K> " + string(sess.line)))
+}
+
+func (sess *session) execCommand(line string) string {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return ""
+	}
+	switch args[0] {
+	case "list":
+		return sess.list()
+	case "stats":
+		return sess.stats()
+	case "close":
+		if len(args) != 2 {
+			return "usage: close <name>"
+		}
+		return sess.closeForward(args[1])
+	case "help":
+		return sessionHelp
+	}
+	return fmt.Sprintf("unknown command [%s]\n%s", args[0], sessionHelp)
+}
+
+func (sess *session) list() string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSTATUS\tREMOTE ADDRESS\tERROR")
+	statusExporter := sess.s.vc.Service().StatusExporter()
+	for _, f := range sess.s.getForwards() {
+		status, remoteAddr, errMsg := "-", "-", ""
+		if ps, ok := statusExporter.GetProxyStatus(f.name()); ok {
+			status, remoteAddr, errMsg = ps.Phase, util.EmptyOr(ps.RemoteAddr, "-"), ps.Err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.name(), f.pc.GetBaseConfig().Type, status, remoteAddr, errMsg)
+	}
+	_ = w.Flush()
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (sess *session) stats() string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCONNS\tTOTAL CONNS\tTRAFFIC IN\tTRAFFIC OUT")
+	for _, f := range sess.s.getForwards() {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", f.name(), f.stats.curConns.Load(), f.stats.totalConns.Load(),
+			util.FormatBytes(f.stats.trafficIn.Load()), util.FormatBytes(f.stats.trafficOut.Load()))
+	}
+	_ = w.Flush()
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// writeStatus writes the table of the status screen.
+func (sess *session) writeStatus(b *bytes.Buffer) {
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSTATUS\tREMOTE ADDRESS\tFORWARD\tCONNS\tTRAFFIC IN\tTRAFFIC OUT")
+	statusExporter := sess.s.vc.Service().StatusExporter()
+	for _, f := range sess.s.getForwards() {
+		status, remoteAddr := "-", "-"
+		if ps, ok := statusExporter.GetProxyStatus(f.name()); ok {
+			status, remoteAddr = ps.Phase, util.EmptyOr(ps.RemoteAddr, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", f.name(), f.pc.GetBaseConfig().Type, status, remoteAddr,
+			net.JoinHostPort(f.addr.Host, strconv.Itoa(int(f.addr.Port))), f.stats.curConns.Load(),
+			util.FormatBytes(f.stats.trafficIn.Load()), util.FormatBytes(f.stats.trafficOut.Load()))
+	}
+	_ = w.Flush()
+}
+
+func (sess *session) closeForward(name string) string {
+	for _, f := range sess.s.getForwards() {
+		if f.name() != name && f.name() != sess.s.user+"."+name {
+			continue
+		}
+		if sess.s.removeForward(f.name()) {
+			return fmt.Sprintf("forward [%s] closed", f.name())
+		}
+	}
+	return fmt.Sprintf("forward [%s] not found", name)
+}
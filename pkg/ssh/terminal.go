@@ -15,3 +15,15 @@ func createSuccessInfo(user string, pc v1.ProxyConfigurer, ps *proxy.WorkingStat
 	out += "RemoteAddress: " + ps.RemoteAddr + "\n"
 	return out
 }
+
+func createVisitorSuccessInfo(user string, vc v1.VisitorConfigurer) string {
+	base := vc.GetBaseConfig()
+	out := "\n"
+	out += "frp (via SSH) (Ctrl+C to quit)\n\n"
+	out += "User: " + user + "\n"
+	out += "VisitorName: " + base.Name + "\n"
+	out += "Type: " + base.Type + "\n"
+	out += "ServerName: " + base.ServerName + "\n"
+	out += "Connections forwarded by ssh -L are sent to the visitor.\n"
+	return out
+}
@@ -0,0 +1,24 @@
+package limit
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// WaitN blocks until limiter permits n bytes. Unlike rate.Limiter.WaitN, n may exceed the burst of limiter,
+// the wait is split into bursts then.
+func WaitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	b := limiter.Burst()
+	if b <= 0 {
+		return limiter.WaitN(ctx, n)
+	}
+	for n > 0 {
+		m := min(n, b)
+		if err := limiter.WaitN(ctx, m); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
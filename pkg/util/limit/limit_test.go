@@ -0,0 +1,25 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestWaitN(t *testing.T) {
+	require := require.New(t)
+
+	limiter := rate.NewLimiter(rate.Limit(1000), 1000)
+	require.Error(limiter.WaitN(context.Background(), 1500))
+
+	start := time.Now()
+	require.NoError(WaitN(context.Background(), limiter, 1500))
+	require.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(WaitN(ctx, limiter, 100))
+}
@@ -35,6 +35,16 @@ func NewDateCounter(reserveDays int64) DateCounter {
 	return newStandardDateCounter(reserveDays)
 }
 
+// NewDateCounterWithCounts creates a DateCounter restored from counts, counts[0] is the count of today.
+func NewDateCounterWithCounts(reserveDays int64, counts []int64) DateCounter {
+	if reserveDays <= 0 {
+		reserveDays = 1
+	}
+	c := newStandardDateCounter(reserveDays)
+	copy(c.counts, counts)
+	return c
+}
+
 type StandardDateCounter struct {
 	reserveDays int64
 	counts      []int64
@@ -25,3 +25,11 @@ func TestDateCounter(t *testing.T) {
 	dcTmp := dc.Snapshot()
 	assert.EqualValues(5, dcTmp.TodayCount())
 }
+
+func TestDateCounterWithCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := NewDateCounterWithCounts(3, []int64{1, 2, 3, 4})
+	assert.EqualValues(1, dc.TodayCount())
+	assert.EqualValues([]int64{1, 2, 3}, dc.GetLastDaysCount(3))
+}
@@ -0,0 +1,94 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// CIDRFilter checks source IPs against allow and deny lists of CIDRs.
+type CIDRFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewCIDRFilter parses allow and deny lists, plain IPs are treated as single host CIDRs.
+// An IP is allowed if it matches no deny entry and the allow list is empty or matched.
+func NewCIDRFilter(allow []string, deny []string) (*CIDRFilter, error) {
+	f := &CIDRFilter{}
+	var err error
+	if f.allow, err = ParseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = ParseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR [%s]", s)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR [%s]", s)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (f *CIDRFilter) IsEmpty() bool {
+	return f == nil || (len(f.allow) == 0 && len(f.deny) == 0)
+}
+
+// Allowed returns true if ip is allowed by f. A nil filter allows everything.
+func (f *CIDRFilter) Allowed(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr is like Allowed but takes an address like "1.2.3.4:80". Addresses without a valid IP are rejected
+// unless f is empty.
+func (f *CIDRFilter) AllowedAddr(addr string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return f.Allowed(ip)
+}
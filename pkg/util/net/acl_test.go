@@ -0,0 +1,36 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	require := require.New(t)
+
+	_, err := NewCIDRFilter([]string{"10.0.0.0/33"}, nil)
+	require.Error(err)
+	_, err = NewCIDRFilter(nil, []string{"abc"})
+	require.Error(err)
+
+	f, err := NewCIDRFilter([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}, []string{"10.0.1.0/24"})
+	require.NoError(err)
+	require.True(f.AllowedAddr("10.0.0.1:1000"))
+	require.False(f.AllowedAddr("10.0.1.1:1000"))
+	require.True(f.AllowedAddr("192.168.1.1:80"))
+	require.False(f.AllowedAddr("192.168.1.2:80"))
+	require.True(f.AllowedAddr("[fd00::1]:80"))
+	require.False(f.AllowedAddr("[fe80::1]:80"))
+	require.False(f.AllowedAddr("invalid"))
+
+	f, err = NewCIDRFilter(nil, []string{"1.1.1.1"})
+	require.NoError(err)
+	require.True(f.AllowedAddr("1.1.1.2:80"))
+	require.False(f.AllowedAddr("1.1.1.1:80"))
+
+	f, err = NewCIDRFilter(nil, nil)
+	require.NoError(err)
+	require.True(f.IsEmpty())
+	require.True(f.AllowedAddr("invalid"))
+}
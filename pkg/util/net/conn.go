@@ -207,6 +207,14 @@ func QuicStreamToNetConn(s quic.Stream, c quic.Connection) net.Conn {
 	}
 }
 
+// QuicConnFromConn returns the QUIC connection of a conn created by QuicStreamToNetConn.
+func QuicConnFromConn(conn net.Conn) (quic.Connection, bool) {
+	if c, ok := conn.(*wrapQuicStream); ok && c.c != nil {
+		return c.c, true
+	}
+	return nil, false
+}
+
 func (conn *wrapQuicStream) LocalAddr() net.Addr {
 	if conn.c != nil {
 		return conn.c.LocalAddr()
@@ -15,6 +15,7 @@
 package net
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -55,3 +56,22 @@ func CheckAndEnableTLSServerConnWithTimeout(
 	}
 	return
 }
+
+type tlsStateKey struct{}
+
+// NewTLSStateContext returns a context carrying the function which returns the state of the TLS connection
+// that connections of ctx come from.
+func NewTLSStateContext(ctx context.Context, stateFn func() tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, stateFn)
+}
+
+// TLSStateFromContext returns the state of the TLS connection in ctx, nil if there is none. It must be called
+// after the handshake.
+func TLSStateFromContext(ctx context.Context) *tls.ConnectionState {
+	stateFn, ok := ctx.Value(tlsStateKey{}).(func() tls.ConnectionState)
+	if !ok {
+		return nil
+	}
+	state := stateFn()
+	return &state
+}
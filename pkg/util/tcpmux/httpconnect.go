@@ -16,6 +16,7 @@ package tcpmux
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -26,6 +27,7 @@ import (
 
 	httppkg "github.com/iami317/hepx/pkg/util/http"
 	"github.com/iami317/hepx/pkg/util/vhost"
+	"github.com/iami317/hepx/pkg/util/xlog"
 )
 
 type HTTPConnectTCPMuxer struct {
@@ -34,14 +36,15 @@ type HTTPConnectTCPMuxer struct {
 	// If passthrough is set to true, the CONNECT request will be forwarded to the backend service.
 	// Otherwise, it will return an OK response to the client and forward the remaining content to the backend service.
 	passthrough bool
+	xl          *xlog.Logger
 }
 
-func NewHTTPConnectTCPMuxer(listener net.Listener, passthrough bool, timeout time.Duration) (*HTTPConnectTCPMuxer, error) {
-	ret := &HTTPConnectTCPMuxer{passthrough: passthrough}
-	mux, err := vhost.NewMuxer(listener, ret.getHostFromHTTPConnect, timeout)
+func NewHTTPConnectTCPMuxer(ctx context.Context, listener net.Listener, passthrough bool, timeout time.Duration) (*HTTPConnectTCPMuxer, error) {
+	ret := &HTTPConnectTCPMuxer{passthrough: passthrough, xl: xlog.FromContextSafe(ctx)}
+	mux, err := vhost.NewMuxer(ctx, listener, ret.getHostFromHTTPConnect, timeout)
 	mux.SetCheckAuthFunc(ret.auth).
 		SetSuccessHookFunc(ret.sendConnectResponse).
-		SetFailHookFunc(vhostFailed)
+		SetFailHookFunc(ret.vhostFailed)
 	ret.Muxer = mux
 	return ret, err
 }
@@ -93,8 +96,8 @@ func (muxer *HTTPConnectTCPMuxer) auth(c net.Conn, username, password string, re
 	return false, nil
 }
 
-func vhostFailed(c net.Conn) {
-	res := vhost.NotFoundResponse()
+func (muxer *HTTPConnectTCPMuxer) vhostFailed(c net.Conn) {
+	res := vhost.NotFoundResponse(muxer.xl)
 	if res.Body != nil {
 		defer res.Body.Close()
 	}
@@ -134,3 +134,19 @@ func RandomSleep(duration time.Duration, minRatio, maxRatio float64) time.Durati
 func ConstantTimeEqString(a, b string) bool {
 	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
+
+// FormatBytes formats n bytes in B, KB, MB, GB or TB, e.g. 1536 is "1.50 KB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit {
+			return fmt.Sprintf("%.2f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.2f TB", value)
+}
@@ -45,3 +45,12 @@ func TestParseRangeNumbers(t *testing.T) {
 	_, err = ParseRangeNumbers("3-a")
 	assert.Error(err)
 }
+
+func TestFormatBytes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("0 B", FormatBytes(0))
+	assert.Equal("1023 B", FormatBytes(1023))
+	assert.Equal("1.50 KB", FormatBytes(1536))
+	assert.Equal("2.00 MB", FormatBytes(2*1024*1024))
+	assert.Equal("1.00 TB", FormatBytes(1024*1024*1024*1024))
+}
@@ -19,29 +19,45 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"gitee.com/menciis/logx"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	libio "github.com/fatedier/golib/io"
 	"github.com/fatedier/golib/pool"
 
 	httppkg "github.com/iami317/hepx/pkg/util/http"
+	"github.com/iami317/hepx/pkg/util/xlog"
 )
 
 var ErrNoRouteFound = errors.New("no route found")
 
 type HTTPReverseProxyOptions struct {
 	ResponseHeaderTimeoutS int64
+	// Logger is the logger of requests, a logger writing to the global output is used if it's nil.
+	Logger *xlog.Logger
 }
 
+// RequestHookFunc is called for every routed request before it is forwarded. It can modify the request
+// headers, return the name of another proxy to route the request to, or return an error to reject the request.
+type RequestHookFunc func(req *http.Request, rc *RouteConfig) (routeTo string, err error)
+
 type HTTPReverseProxy struct {
 	proxy       *httputil.ReverseProxy
 	vhostRouter *Routers
+	requestHook RequestHookFunc
+	xl          *xlog.Logger
+
+	// routesByName indexes registered route configs by proxy name for rerouting.
+	routesByName map[string][]*RouteConfig
+	mu           sync.RWMutex
 
 	responseHeaderTimeout time.Duration
 }
@@ -50,9 +66,14 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 	if option.ResponseHeaderTimeoutS <= 0 {
 		option.ResponseHeaderTimeoutS = 60
 	}
+	if option.Logger == nil {
+		option.Logger = xlog.New()
+	}
 	rp := &HTTPReverseProxy{
 		responseHeaderTimeout: time.Duration(option.ResponseHeaderTimeoutS) * time.Second,
 		vhostRouter:           vhostRouter,
+		xl:                    option.Logger,
+		routesByName:          make(map[string][]*RouteConfig),
 	}
 	proxy := &httputil.ReverseProxy{
 		// Modify incoming requests by route policies.
@@ -67,22 +88,26 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 			rc := req.Context().Value(RouteConfigKey).(*RouteConfig)
 			if rc != nil {
 				if rc.RewriteHost != "" {
-					req.Host = rc.RewriteHost
+					req.Host = ExpandCaptures(rc.RewriteHost, reqRouteInfo.Captures)
 				}
 
 				var endpoint string
 				if rc.ChooseEndpointFn != nil {
 					// ignore error here, it will use CreateConnFn instead later
-					endpoint, _ = rc.ChooseEndpointFn()
+					endpoint, _ = rc.ChooseEndpointFn(r.In)
 					reqRouteInfo.Endpoint = endpoint
-					logx.Verbosef("choose endpoint name [%s] for http request host [%s] path [%s] httpuser [%s]",
+					rp.requestLogger(originalHost, reqRouteInfo.URL).Debugf(
+						"choose endpoint name [%s] for http request host [%s] path [%s] httpuser [%s]",
 						endpoint, originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
 				}
-				// Set {domain}.{location}.{routeByHTTPUser}.{endpoint} as URL host here to let http transport reuse connections.
+				// Set {domain}.{location}.{routeByHTTPUser}.{endpoint}.{proxyName} as URL host here to let http transport
+				// reuse connections. The proxy name keeps routes which only differ by methods, and rerouted requests,
+				// from sharing connections of other proxies.
 				req.URL.Host = rc.Domain + "." +
 					base64.StdEncoding.EncodeToString([]byte(rc.Location)) + "." +
 					base64.StdEncoding.EncodeToString([]byte(rc.RouteByHTTPUser)) + "." +
-					base64.StdEncoding.EncodeToString([]byte(endpoint))
+					base64.StdEncoding.EncodeToString([]byte(endpoint)) + "." +
+					base64.StdEncoding.EncodeToString([]byte(rc.ProxyName))
 
 				for k, v := range rc.Headers {
 					req.Header.Set(k, v)
@@ -97,6 +122,14 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 				for k, v := range rc.ResponseHeaders {
 					r.Header.Set(k, v)
 				}
+				if rc.EndpointCookieFn != nil {
+					endpoint := r.Request.Context().Value(RouteInfoKey).(*RequestRouteInfo).Endpoint
+					if endpoint != "" {
+						if c := rc.EndpointCookieFn(r.Request, endpoint); c != nil {
+							r.Header.Add("Set-Cookie", c.String())
+						}
+					}
+				}
 			}
 			return nil
 		},
@@ -125,7 +158,8 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 		},
 		BufferPool: pool.NewBuffer(32 * 1024),
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
-			logx.Verbosef("do http proxy request [host: %s] error: %v", req.Host, err)
+			rp.requestLogger(req.Host, req.URL.Path).Debugf("do http proxy request [host: %s] error: %v", req.Host, err)
+			req.Context().Value(RouteInfoKey).(*RequestRouteInfo).err = err
 			if err != nil {
 				if e, ok := err.(net.Error); ok && e.Timeout() {
 					rw.WriteHeader(http.StatusGatewayTimeout)
@@ -133,7 +167,7 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 				}
 			}
 			rw.WriteHeader(http.StatusNotFound)
-			_, _ = rw.Write(getNotFoundPageContent())
+			_, _ = rw.Write(getNotFoundPageContent(rp.xl))
 		},
 	}
 	rp.proxy = proxy
@@ -143,22 +177,74 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 // Register register the route config to reverse proxy
 // reverse proxy will use CreateConnFn from routeCfg to create a connection to the remote service
 func (rp *HTTPReverseProxy) Register(routeCfg RouteConfig) error {
-	err := rp.vhostRouter.Add(routeCfg.Domain, routeCfg.Location, routeCfg.RouteByHTTPUser, &routeCfg)
+	err := rp.vhostRouter.AddRoute(routeCfg.RouteSpec(), &routeCfg)
 	if err != nil {
 		return err
 	}
+	if routeCfg.ProxyName != "" {
+		rp.mu.Lock()
+		rp.routesByName[routeCfg.ProxyName] = append(rp.routesByName[routeCfg.ProxyName], &routeCfg)
+		rp.mu.Unlock()
+	}
 	return nil
 }
 
+// OverlappingRegexRoutes returns the proxies whose regular expression routes may match the same requests as
+// routeCfg with the same priority.
+func (rp *HTTPReverseProxy) OverlappingRegexRoutes(routeCfg RouteConfig) []string {
+	return rp.vhostRouter.OverlappingRegexRoutes(routeCfg.RouteSpec())
+}
+
 // UnRegister unregister route config by domain and location
 func (rp *HTTPReverseProxy) UnRegister(routeCfg RouteConfig) {
-	rp.vhostRouter.Del(routeCfg.Domain, routeCfg.Location, routeCfg.RouteByHTTPUser)
+	rp.vhostRouter.DelRoute(routeCfg.RouteSpec())
+	if routeCfg.ProxyName == "" {
+		return
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	routes := rp.routesByName[routeCfg.ProxyName]
+	newRoutes := make([]*RouteConfig, 0, len(routes))
+	for _, r := range routes {
+		if r.Domain != routeCfg.Domain || r.Location != routeCfg.Location || r.RouteByHTTPUser != routeCfg.RouteByHTTPUser ||
+			!slices.Equal(r.Methods, routeCfg.Methods) {
+			newRoutes = append(newRoutes, r)
+		}
+	}
+	if len(newRoutes) == 0 {
+		delete(rp.routesByName, routeCfg.ProxyName)
+	} else {
+		rp.routesByName[routeCfg.ProxyName] = newRoutes
+	}
+}
+
+// SetRequestHook sets the hook called for every routed request, it must be set before serving.
+// requestLogger returns the logger of rp with the host and path of a request as structured fields.
+func (rp *HTTPReverseProxy) requestLogger(host, path string) *xlog.Logger {
+	return rp.xl.Spawn().
+		AddPrefix(xlog.LogPrefix{Name: "host", Value: host, Hidden: true}).
+		AddPrefix(xlog.LogPrefix{Name: "path", Value: path, Hidden: true})
+}
+
+func (rp *HTTPReverseProxy) SetRequestHook(fn RequestHookFunc) {
+	rp.requestHook = fn
+}
+
+func (rp *HTTPReverseProxy) getRouteByName(name string) *RouteConfig {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	routes := rp.routesByName[name]
+	if len(routes) == 0 {
+		return nil
+	}
+	return routes[0]
 }
 
-func (rp *HTTPReverseProxy) GetRouteConfig(domain, location, routeByHTTPUser string) *RouteConfig {
-	vr, ok := rp.getVhost(domain, location, routeByHTTPUser)
+func (rp *HTTPReverseProxy) GetRouteConfig(domain, location, routeByHTTPUser, method string) *RouteConfig {
+	vr, _, ok := rp.getVhost(domain, location, routeByHTTPUser, method)
 	if ok {
-		logx.Verbosef("get new HTTP request host [%s] path [%s] httpuser [%s]", domain, location, routeByHTTPUser)
+		rp.requestLogger(domain, location).Debugf("get new HTTP request host [%s] path [%s] httpuser [%s]", domain, location, routeByHTTPUser)
 		return vr.payload.(*RouteConfig)
 	}
 	return nil
@@ -167,15 +253,20 @@ func (rp *HTTPReverseProxy) GetRouteConfig(domain, location, routeByHTTPUser str
 // CreateConnection create a new connection by route config
 func (rp *HTTPReverseProxy) CreateConnection(reqRouteInfo *RequestRouteInfo, byEndpoint bool) (net.Conn, error) {
 	host, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
-	vr, ok := rp.getVhost(host, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
-	if ok {
+	rc := reqRouteInfo.routeTo
+	if rc == nil {
+		if vr, _, ok := rp.getVhost(host, reqRouteInfo.URL, reqRouteInfo.HTTPUser, reqRouteInfo.Method); ok {
+			rc = vr.payload.(*RouteConfig)
+		}
+	}
+	if rc != nil {
 		if byEndpoint {
-			fn := vr.payload.(*RouteConfig).CreateConnByEndpointFn
+			fn := rc.CreateConnByEndpointFn
 			if fn != nil {
 				return fn(reqRouteInfo.Endpoint, reqRouteInfo.RemoteAddr)
 			}
 		}
-		fn := vr.payload.(*RouteConfig).CreateConnFn
+		fn := rc.CreateConnFn
 		if fn != nil {
 			return fn(reqRouteInfo.RemoteAddr)
 		}
@@ -183,8 +274,8 @@ func (rp *HTTPReverseProxy) CreateConnection(reqRouteInfo *RequestRouteInfo, byE
 	return nil, fmt.Errorf("%v: %s %s %s", ErrNoRouteFound, host, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
 }
 
-func (rp *HTTPReverseProxy) CheckAuth(domain, location, routeByHTTPUser, user, passwd string) bool {
-	vr, ok := rp.getVhost(domain, location, routeByHTTPUser)
+func (rp *HTTPReverseProxy) CheckAuth(domain, location, routeByHTTPUser, method, user, passwd string) bool {
+	vr, _, ok := rp.getVhost(domain, location, routeByHTTPUser, method)
 	if ok {
 		checkUser := vr.payload.(*RouteConfig).Username
 		checkPasswd := vr.payload.(*RouteConfig).Password
@@ -195,75 +286,57 @@ func (rp *HTTPReverseProxy) CheckAuth(domain, location, routeByHTTPUser, user, p
 	return true
 }
 
-// getVhost tries to get vhost router by route policy.
-func (rp *HTTPReverseProxy) getVhost(domain, location, routeByHTTPUser string) (*Router, bool) {
-	findRouter := func(inDomain, inLocation, inRouteByHTTPUser string) (*Router, bool) {
-		vr, ok := rp.vhostRouter.Get(inDomain, inLocation, inRouteByHTTPUser)
-		if ok {
-			return vr, ok
-		}
-		// Try to check if there is one proxy that doesn't specify routerByHTTPUser, it means match all.
-		vr, ok = rp.vhostRouter.Get(inDomain, inLocation, "")
-		if ok {
-			return vr, ok
-		}
-		return nil, false
-	}
+// HasDomain returns true if requests for domain are routed to a proxy by its full name, a wildcard domain or
+// a regular expression. The catch-all domain "*" is not taken into account.
+func (rp *HTTPReverseProxy) HasDomain(domain string) bool {
+	return rp.HasDomainName(domain) || rp.vhostRouter.MatchDomainRegexp(domain)
+}
 
-	// First we check the full hostname
-	// if not exist, then check the wildcard_domain such as *.example.com
-	vr, ok := findRouter(domain, location, routeByHTTPUser)
-	if ok {
-		return vr, ok
+// HasDomainName is like HasDomain, but regular expressions are not taken into account.
+func (rp *HTTPReverseProxy) HasDomainName(domain string) bool {
+	if rp.vhostRouter.HasDomain(domain) {
+		return true
 	}
-
-	// e.g. domain = test.example.com, try to match wildcard domains.
-	// *.example.com
-	// *.com
 	domainSplit := strings.Split(domain, ".")
-	for {
-		if len(domainSplit) < 3 {
-			break
-		}
-
+	for len(domainSplit) >= 3 {
 		domainSplit[0] = "*"
-		domain = strings.Join(domainSplit, ".")
-		vr, ok = findRouter(domain, location, routeByHTTPUser)
-		if ok {
-			return vr, true
+		if rp.vhostRouter.HasDomain(strings.Join(domainSplit, ".")) {
+			return true
 		}
 		domainSplit = domainSplit[1:]
 	}
-
-	// Finally, try to check if there is one proxy that domain is "*" means match all domains.
-	vr, ok = findRouter("*", location, routeByHTTPUser)
-	if ok {
-		return vr, true
-	}
-	return nil, false
+	return false
 }
 
-func (rp *HTTPReverseProxy) connectHandler(rw http.ResponseWriter, req *http.Request) {
-	hj, ok := rw.(http.Hijacker)
-	if !ok {
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+// getVhost tries to get vhost router by route policy, it also returns the named capture groups of the route.
+func (rp *HTTPReverseProxy) getVhost(domain, location, routeByHTTPUser, method string) (*Router, map[string]string, bool) {
+	return rp.vhostRouter.Find(domain, location, routeByHTTPUser, method)
+}
 
-	client, _, err := hj.Hijack()
+// connectHandler tunnels the connection of a CONNECT request to the route, the bytes tunneled are recorded
+// in rw.
+func (rp *HTTPReverseProxy) connectHandler(rw *statsResponseWriter, req *http.Request) {
+	client, _, err := http.NewResponseController(rw.ResponseWriter).Hijack()
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	remote, err := rp.CreateConnection(req.Context().Value(RouteInfoKey).(*RequestRouteInfo), false)
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	byEndpoint := false
+	if rc, _ := req.Context().Value(RouteConfigKey).(*RouteConfig); rc != nil && rc.ChooseEndpointFn != nil {
+		reqRouteInfo.Endpoint, _ = rc.ChooseEndpointFn(req)
+		byEndpoint = reqRouteInfo.Endpoint != ""
+	}
+	remote, err := rp.CreateConnection(reqRouteInfo, byEndpoint)
 	if err != nil {
-		_ = NotFoundResponse().Write(client)
+		reqRouteInfo.err = err
+		_ = NotFoundResponse(rp.xl).Write(client)
 		client.Close()
 		return
 	}
 	_ = req.Write(remote)
-	go libio.Join(remote, client)
+	rw.bytesIn, rw.bytesOut, _ = libio.Join(remote, client)
 }
 
 func parseBasicAuth(auth string) (username, password string, ok bool) {
@@ -303,10 +376,15 @@ func (rp *HTTPReverseProxy) injectRequestInfoToCtx(req *http.Request) *http.Requ
 		HTTPUser:   user,
 		RemoteAddr: req.RemoteAddr,
 		URLHost:    req.URL.Host,
+		Method:     req.Method,
 	}
 
 	originalHost, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
-	rc := rp.GetRouteConfig(originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
+	var rc *RouteConfig
+	if vr, captures, ok := rp.getVhost(originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser, req.Method); ok {
+		rc = vr.payload.(*RouteConfig)
+		reqRouteInfo.Captures = captures
+	}
 
 	newctx := req.Context()
 	newctx = context.WithValue(newctx, RouteInfoKey, reqRouteInfo)
@@ -318,16 +396,128 @@ func (rp *HTTPReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	domain, _ := httppkg.CanonicalHost(req.Host)
 	location := req.URL.Path
 	user, passwd, _ := req.BasicAuth()
-	if !rp.CheckAuth(domain, location, user, user, passwd) {
+	if !rp.CheckAuth(domain, location, user, req.Method, user, passwd) {
 		rw.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
 
 	newreq := rp.injectRequestInfoToCtx(req)
+	srw := &statsResponseWriter{ResponseWriter: rw}
+	if newreq.Body != nil && newreq.Body != http.NoBody {
+		newreq.Body = &countedReadCloser{ReadCloser: newreq.Body, n: &srw.bodyIn}
+	}
+	// the reverse proxy panics to abort the response if it fails to copy the body, report the request anyway
+	start := time.Now()
+	defer func() {
+		rp.requestDone(newreq, srw, start)
+	}()
+
+	forwardReq, ok := rp.handleRequestHook(srw, newreq)
+	if !ok {
+		return
+	}
+	newreq = forwardReq
 	if req.Method == http.MethodConnect {
-		rp.connectHandler(rw, newreq)
+		rp.connectHandler(srw, newreq)
 	} else {
-		rp.proxy.ServeHTTP(rw, newreq)
+		rp.proxy.ServeHTTP(srw, newreq)
+	}
+}
+
+// requestDone calls the RequestDoneFn of the route which served req.
+func (rp *HTTPReverseProxy) requestDone(req *http.Request, rw *statsResponseWriter, start time.Time) {
+	rc := req.Context().Value(RouteConfigKey).(*RouteConfig)
+	if rc == nil || rc.RequestDoneFn == nil {
+		return
+	}
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	stats := &RequestStats{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.Method,
+		Host:       req.Host,
+		Path:       req.URL.Path,
+		Endpoint:   reqRouteInfo.Endpoint,
+		StatusCode: rw.statusCode,
+		BytesIn:    rw.bytesIn + rw.bodyIn.Load(),
+		BytesOut:   rw.bytesOut,
+		Err:        reqRouteInfo.err,
+	}
+	rc.RequestDoneFn(stats)
+}
+
+// handleRequestHook runs the request hook and returns the request to forward, or false if the request
+// has been answered.
+func (rp *HTTPReverseProxy) handleRequestHook(rw http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	rc := req.Context().Value(RouteConfigKey).(*RouteConfig)
+	if rp.requestHook == nil || rc == nil {
+		return req, true
+	}
+
+	routeTo, err := rp.requestHook(req, rc)
+	if err != nil {
+		rp.requestLogger(req.Host, req.URL.Path).Debugf("http request [host: %s] path [%s] rejected: %v", req.Host, req.URL.Path, err)
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	if routeTo == "" || routeTo == rc.ProxyName {
+		return req, true
+	}
+
+	target := rp.getRouteByName(routeTo)
+	if target == nil {
+		rp.requestLogger(req.Host, req.URL.Path).Debugf("http request [host: %s] path [%s] rerouted to unknown proxy [%s]", req.Host, req.URL.Path, routeTo)
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write(getNotFoundPageContent(rp.xl))
+		return nil, false
+	}
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	reqRouteInfo.routeTo = target
+	return req.WithContext(context.WithValue(req.Context(), RouteConfigKey, target)), true
+}
+
+// statsResponseWriter records the status code and the size of the response body written to the user, and the
+// size of the request body read from the user.
+type statsResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+	bytesIn    int64
+	bytesOut   int64
+	// bodyIn is updated by the transport while it sends the request body.
+	bodyIn atomic.Int64
+}
+
+func (w *statsResponseWriter) WriteHeader(code int) {
+	// informational responses may precede the final one
+	if w.statusCode == 0 && code >= http.StatusOK {
+		w.statusCode = code
 	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statsResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytesOut += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController flush and hijack the underlying writer.
+func (w *statsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type countedReadCloser struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *countedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
 }
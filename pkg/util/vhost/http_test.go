@@ -0,0 +1,202 @@
+package vhost
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/util/xlog"
+)
+
+func newTestBackend(name string) (CreateConnFunc, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name + ":" + r.Header.Get("X-Sso-User")))
+	}))
+	return func(string) (net.Conn, error) {
+		return net.Dial("tcp", srv.Listener.Addr().String())
+	}, srv.Close
+}
+
+// chanWriter sends every write to the channel, it's used to receive logs written in other goroutines.
+type chanWriter chan []byte
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestHTTPReverseProxyRequestHook(t *testing.T) {
+	require := require.New(t)
+
+	logs := make(chanWriter, 100)
+	xl := xlog.New().SetHandler(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{Logger: xl}, NewRouters())
+	webFn, closeWeb := newTestBackend("web")
+	defer closeWeb()
+	loginFn, closeLogin := newTestBackend("login")
+	defer closeLogin()
+	require.NoError(rp.Register(RouteConfig{ProxyName: "web", Domain: "example.com", CreateConnFn: webFn}))
+	require.NoError(rp.Register(RouteConfig{ProxyName: "login", Domain: "login.example.com", CreateConnFn: loginFn}))
+	require.True(rp.HasDomain("login.example.com"))
+	require.False(rp.HasDomain("www.example.com"))
+	require.NoError(rp.Register(RouteConfig{ProxyName: "apps", Domain: `~^[a-z]+\.apps\.example\.com$`, CreateConnFn: webFn}))
+	require.True(rp.HasDomain("shop.apps.example.com"))
+	require.False(rp.HasDomainName("shop.apps.example.com"))
+	require.True(rp.HasDomainName("login.example.com"))
+
+	rp.SetRequestHook(func(req *http.Request, rc *RouteConfig) (string, error) {
+		switch req.URL.Path {
+		case "/deny":
+			return "", errors.New("not allowed")
+		case "/private":
+			return "login", nil
+		case "/unknown":
+			return "none", nil
+		}
+		req.Header.Set("X-Sso-User", "alice")
+		return "", nil
+	})
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		require.NoError(err)
+		req.Host = "example.com"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/")
+	require.Equal(http.StatusOK, code)
+	require.Equal("web:alice", body)
+
+	code, body = get("/deny")
+	require.Equal(http.StatusForbidden, code)
+	require.Contains(body, "not allowed")
+
+	code, body = get("/private")
+	require.Equal(http.StatusOK, code)
+	require.Equal("login:", body)
+
+	code, _ = get("/unknown")
+	require.Equal(http.StatusNotFound, code)
+	// the request is logged through the logger of options with its host and path as fields
+	var rerouted map[string]string
+	for len(logs) > 0 {
+		var record map[string]string
+		require.NoError(json.Unmarshal(<-logs, &record))
+		if strings.Contains(record["msg"], "rerouted to unknown proxy [none]") {
+			rerouted = record
+		}
+	}
+	require.NotNil(rerouted)
+	require.Equal("example.com", rerouted["host"])
+	require.Equal("/unknown", rerouted["path"])
+
+	rp.UnRegister(RouteConfig{ProxyName: "login", Domain: "login.example.com"})
+	require.Nil(rp.getRouteByName("login"))
+	code, _ = get("/private")
+	require.Equal(http.StatusNotFound, code)
+}
+
+func TestHTTPReverseProxyMethodRoutes(t *testing.T) {
+	require := require.New(t)
+
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	getFn, closeGet := newTestBackend("get")
+	defer closeGet()
+	postFn, closePost := newTestBackend("post")
+	defer closePost()
+	require.NoError(rp.Register(RouteConfig{ProxyName: "a", Domain: "example.com", Methods: []string{"GET"}, CreateConnFn: getFn}))
+	require.NoError(rp.Register(RouteConfig{ProxyName: "b", Domain: "example.com", Methods: []string{"POST"}, CreateConnFn: postFn}))
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	// connections to backends are kept alive, requests of b must not reuse the connections of a
+	for i := 0; i < 3; i++ {
+		for _, method := range []string{"GET", "POST"} {
+			req, err := http.NewRequest(method, srv.URL, nil)
+			require.NoError(err)
+			req.Host = "example.com"
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(err)
+			require.Equal(strings.ToLower(method)+":", string(body))
+		}
+	}
+}
+
+func TestHTTPReverseProxyRequestDone(t *testing.T) {
+	require := require.New(t)
+
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+	webFn, closeWeb := newTestBackend("web")
+	defer closeWeb()
+	errDial := errors.New("dial failed")
+	done := make(chan *RequestStats, 10)
+	requestDone := func(stats *RequestStats) {
+		done <- stats
+	}
+	require.NoError(rp.Register(RouteConfig{ProxyName: "web", Domain: "example.com", CreateConnFn: webFn, RequestDoneFn: requestDone}))
+	require.NoError(rp.Register(RouteConfig{
+		ProxyName:     "down",
+		Domain:        "down.example.com",
+		CreateConnFn:  func(string) (net.Conn, error) { return nil, errDial },
+		RequestDoneFn: requestDone,
+	}))
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	post := func(host string) int {
+		req, err := http.NewRequest("POST", srv.URL+"/upload", strings.NewReader("hello"))
+		require.NoError(err)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	next := func() *RequestStats {
+		select {
+		case stats := <-done:
+			return stats
+		case <-time.After(time.Second):
+			require.FailNow("request isn't reported")
+			return nil
+		}
+	}
+
+	// every request on a kept alive connection is reported
+	for i := 0; i < 3; i++ {
+		require.Equal(http.StatusOK, post("example.com"))
+		stats := next()
+		require.Equal("POST", stats.Method)
+		require.Equal("/upload", stats.Path)
+		require.Equal(http.StatusOK, stats.StatusCode)
+		require.EqualValues(5, stats.BytesIn)
+		require.EqualValues(len("web:"), stats.BytesOut)
+		require.NoError(stats.Err)
+	}
+
+	require.Equal(http.StatusNotFound, post("down.example.com"))
+	stats := next()
+	require.Equal(http.StatusNotFound, stats.StatusCode)
+	require.ErrorIs(stats.Err, errDial)
+}
@@ -16,13 +16,21 @@ package vhost
 
 import (
 	"bytes"
-	"gitee.com/menciis/logx"
 	"io"
 	"net/http"
 	"os"
+	"sync/atomic"
+
+	"github.com/iami317/hepx/pkg/util/xlog"
 )
 
-var NotFoundPagePath = ""
+// notFoundPagePath is the path of the custom 404 page, it's empty if the default page is used.
+var notFoundPagePath atomic.Value
+
+// SetNotFoundPagePath sets the path of the custom 404 page, an empty path restores the default page.
+func SetNotFoundPagePath(path string) {
+	notFoundPagePath.Store(path)
+}
 
 const (
 	NotFound = `<!DOCTYPE html>
@@ -48,15 +56,15 @@ Please try again later.</p>
 `
 )
 
-func getNotFoundPageContent() []byte {
+func getNotFoundPageContent(xl *xlog.Logger) []byte {
 	var (
 		buf []byte
 		err error
 	)
-	if NotFoundPagePath != "" {
-		buf, err = os.ReadFile(NotFoundPagePath)
+	if path, _ := notFoundPagePath.Load().(string); path != "" {
+		buf, err = os.ReadFile(path)
 		if err != nil {
-			logx.Warnf("read custom 404 page error: %v", err)
+			xl.Warnf("read custom 404 page error: %v", err)
 			buf = []byte(NotFound)
 		}
 	} else {
@@ -65,12 +73,13 @@ func getNotFoundPageContent() []byte {
 	return buf
 }
 
-func NotFoundResponse() *http.Response {
+// NotFoundResponse returns the response of the 404 page, xl logs errors of reading the custom page.
+func NotFoundResponse(xl *xlog.Logger) *http.Response {
 	header := make(http.Header)
 	header.Set("server", "frp/v0.58.1")
 	header.Set("Content-Type", "text/html")
 
-	content := getNotFoundPageContent()
+	content := getNotFoundPageContent(xl)
 	res := &http.Response{
 		Status:        "Not Found",
 		StatusCode:    404,
@@ -3,6 +3,10 @@ package vhost
 import (
 	"cmp"
 	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"regexp/syntax"
 	"slices"
 	"strings"
 	"sync"
@@ -10,18 +14,77 @@ import (
 
 var ErrRouterConfigConflict = errors.New("router config conflict")
 
+// RegexPrefix marks domains and locations that are regular expressions, e.g. "~^(?P<app>[a-z]+)\.example\.com$".
+const RegexPrefix = "~"
+
+// RouteConflictError is returned by Routers.AddRoute if an existing route matches the same requests.
+type RouteConflictError struct {
+	Domain   string
+	Location string
+	HTTPUser string
+	// Owner is the owner of the existing route, usually a proxy name.
+	Owner string
+}
+
+func (e *RouteConflictError) Error() string {
+	msg := fmt.Sprintf("%v: domain [%s] location [%s] httpUser [%s]", ErrRouterConfigConflict, e.Domain, e.Location, e.HTTPUser)
+	if e.Owner != "" {
+		msg += fmt.Sprintf(" is already used by [%s]", e.Owner)
+	}
+	return msg
+}
+
+func (e *RouteConflictError) Is(target error) bool {
+	return target == ErrRouterConfigConflict
+}
+
+// RouteSpec describes the requests matched by a router.
+type RouteSpec struct {
+	// Domain is a full domain, a wildcard domain like "*.example.com", "*" for all domains or a regular
+	// expression with RegexPrefix. Regular expressions are matched case-insensitively.
+	Domain string
+	// Location is a path prefix or a regular expression with RegexPrefix.
+	Location string
+	HTTPUser string
+	// Methods limits the route to these request methods, all methods are matched if it's empty.
+	Methods []string
+	// Routes with a higher priority are preferred when several routes of the same user match a request.
+	Priority int
+	// Owner is reported when another route conflicts with this one.
+	Owner string
+	// User is the user of the client which owns the route. Regular expressions of domains must not match
+	// the requests of routes of other users.
+	User string
+}
+
 type routerByHTTPUser map[string][]*Router
 
 type Routers struct {
 	indexByDomain map[string]routerByHTTPUser
+	// regexDomains holds the regular expressions of domains with RegexPrefix, sorted by domain.
+	regexDomains []*regexDomain
 
 	mutex sync.RWMutex
 }
 
+type regexDomain struct {
+	domain string
+	re     *regexp.Regexp
+}
+
 type Router struct {
 	domain   string
 	location string
 	httpUser string
+	methods  []string
+	priority int
+	owner    string
+	user     string
+
+	domainRe *regexp.Regexp
+	// domainSuffix is the literal suffix of the hosts matched by domainRe.
+	domainSuffix string
+	locationRe   *regexp.Regexp
 
 	// store any object here
 	payload interface{}
@@ -33,44 +96,139 @@ func NewRouters() *Routers {
 	}
 }
 
+func normalizeDomain(domain string) string {
+	if strings.HasPrefix(domain, RegexPrefix) {
+		return domain
+	}
+	return strings.ToLower(domain)
+}
+
+func normalizeMethods(methods []string) []string {
+	if len(methods) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(methods))
+	for _, m := range methods {
+		ret = append(ret, strings.ToUpper(m))
+	}
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
+
+func newRouter(spec RouteSpec, payload interface{}) (*Router, error) {
+	vr := &Router{
+		domain:   normalizeDomain(spec.Domain),
+		location: spec.Location,
+		httpUser: spec.HTTPUser,
+		methods:  normalizeMethods(spec.Methods),
+		priority: spec.Priority,
+		owner:    spec.Owner,
+		user:     spec.User,
+		payload:  payload,
+	}
+	var err error
+	if expr, ok := strings.CutPrefix(vr.domain, RegexPrefix); ok {
+		if vr.domainRe, err = regexp.Compile("(?i)" + expr); err != nil {
+			return nil, fmt.Errorf("invalid domain regexp [%s]: %v", expr, err)
+		}
+		vr.domainSuffix, _ = RegexDomainSuffix(vr.domain)
+	}
+	if expr, ok := strings.CutPrefix(vr.location, RegexPrefix); ok {
+		if vr.locationRe, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid location regexp [%s]: %v", expr, err)
+		}
+	}
+	return vr, nil
+}
+
+// RegexDomainSuffix returns the literal suffix of the hosts matched by a domain with RegexPrefix, e.g.
+// ".example.com" for "~^[a-z]+\.example\.com$". It returns false if the regular expression isn't anchored
+// to the end of hosts by a literal.
+func RegexDomainSuffix(domain string) (string, bool) {
+	expr, ok := strings.CutPrefix(domain, RegexPrefix)
+	if !ok {
+		return "", false
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[len(re.Sub)-1].Op != syntax.OpEndText {
+		return "", false
+	}
+	var suffix string
+	for i := len(re.Sub) - 2; i >= 0 && re.Sub[i].Op == syntax.OpLiteral; i-- {
+		suffix = string(re.Sub[i].Rune) + suffix
+	}
+	return strings.ToLower(suffix), suffix != ""
+}
+
+// compareRouters sorts routers by location in reverse order, so longer prefixes are tried first and regular
+// expressions are tried before prefixes.
+func compareRouters(a, b *Router) int {
+	return -cmp.Compare(a.location, b.location)
+}
+
 func (r *Routers) Add(domain, location, httpUser string, payload interface{}) error {
-	domain = strings.ToLower(domain)
+	return r.AddRoute(RouteSpec{Domain: domain, Location: location, HTTPUser: httpUser}, payload)
+}
+
+func (r *Routers) AddRoute(spec RouteSpec, payload interface{}) error {
+	vr, err := newRouter(spec, payload)
+	if err != nil {
+		return err
+	}
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if _, exist := r.exist(domain, location, httpUser); exist {
-		return ErrRouterConfigConflict
+	route, exist := r.exist(vr)
+	if !exist && vr.domainRe != nil {
+		route, exist = r.regexConflict(vr)
+	}
+	if exist {
+		return &RouteConflictError{
+			Domain:   route.domain,
+			Location: route.location,
+			HTTPUser: route.httpUser,
+			Owner:    route.owner,
+		}
 	}
 
-	routersByHTTPUser, found := r.indexByDomain[domain]
+	routersByHTTPUser, found := r.indexByDomain[vr.domain]
 	if !found {
 		routersByHTTPUser = make(map[string][]*Router)
 	}
-	vrs, found := routersByHTTPUser[httpUser]
+	vrs, found := routersByHTTPUser[vr.httpUser]
 	if !found {
 		vrs = make([]*Router, 0, 1)
 	}
-
-	vr := &Router{
-		domain:   domain,
-		location: location,
-		httpUser: httpUser,
-		payload:  payload,
-	}
 	vrs = append(vrs, vr)
+	slices.SortStableFunc(vrs, compareRouters)
 
-	slices.SortFunc(vrs, func(a, b *Router) int {
-		return -cmp.Compare(a.location, b.location)
-	})
+	routersByHTTPUser[vr.httpUser] = vrs
+	r.indexByDomain[vr.domain] = routersByHTTPUser
 
-	routersByHTTPUser[httpUser] = vrs
-	r.indexByDomain[domain] = routersByHTTPUser
+	if vr.domainRe != nil {
+		i, found := slices.BinarySearchFunc(r.regexDomains, vr.domain, func(d *regexDomain, domain string) int {
+			return cmp.Compare(d.domain, domain)
+		})
+		if !found {
+			r.regexDomains = slices.Insert(r.regexDomains, i, &regexDomain{domain: vr.domain, re: vr.domainRe})
+		}
+	}
 	return nil
 }
 
 func (r *Routers) Del(domain, location, httpUser string) {
-	domain = strings.ToLower(domain)
+	r.DelRoute(RouteSpec{Domain: domain, Location: location, HTTPUser: httpUser})
+}
+
+// DelRoute deletes the router added by spec, the domain, location, HTTP user and methods must be the same.
+func (r *Routers) DelRoute(spec RouteSpec) {
+	domain := normalizeDomain(spec.Domain)
+	methods := normalizeMethods(spec.Methods)
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -80,21 +238,29 @@ func (r *Routers) Del(domain, location, httpUser string) {
 		return
 	}
 
-	vrs, found := routersByHTTPUser[httpUser]
+	vrs, found := routersByHTTPUser[spec.HTTPUser]
 	if !found {
 		return
 	}
 	newVrs := make([]*Router, 0)
 	for _, vr := range vrs {
-		if vr.location != location {
+		if vr.location != spec.Location || !slices.Equal(vr.methods, methods) {
 			newVrs = append(newVrs, vr)
 		}
 	}
-	routersByHTTPUser[httpUser] = newVrs
+	routersByHTTPUser[spec.HTTPUser] = newVrs
+
+	if strings.HasPrefix(domain, RegexPrefix) && !r.hasDomain(domain) {
+		r.regexDomains = slices.DeleteFunc(r.regexDomains, func(d *regexDomain) bool {
+			return d.domain == domain
+		})
+	}
 }
 
+// Get returns the router registered for exactly the host and the HTTP user with the longest location
+// prefix of path, request methods are not taken into account.
 func (r *Routers) Get(host, path, httpUser string) (vr *Router, exist bool) {
-	host = strings.ToLower(host)
+	host = normalizeDomain(host)
 
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -110,27 +276,264 @@ func (r *Routers) Get(host, path, httpUser string) (vr *Router, exist bool) {
 	}
 
 	for _, vr = range vrs {
-		if strings.HasPrefix(path, vr.location) {
+		if _, ok := vr.match(path, ""); ok {
 			return vr, true
 		}
 	}
-	return
+	return nil, false
 }
 
-func (r *Routers) exist(host, path, httpUser string) (route *Router, exist bool) {
-	routersByHTTPUser, found := r.indexByDomain[host]
+// Find returns the router of a request and the named capture groups of the regular expressions it matched.
+// An empty method matches routers of all methods.
+//
+// Routers are preferred in this order: the full host, wildcard domains from the most specific one, regular
+// expressions of domains and finally the domain "*". Routers of the HTTP user are preferred to those without
+// HTTP user and longer locations are preferred to shorter ones. A router with a higher priority only wins
+// over a preferred router of the same user.
+func (r *Routers) Find(host, path, httpUser, method string) (*Router, map[string]string, bool) {
+	host = strings.ToLower(host)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var (
+		best         *Router
+		bestCaptures map[string]string
+	)
+	find := func(domain string, domainCaptures map[string]string) {
+		routersByHTTPUser, found := r.indexByDomain[domain]
+		if !found {
+			return
+		}
+		users := []string{httpUser}
+		if httpUser != "" {
+			// Try to check if there is one proxy that doesn't specify routerByHTTPUser, it means match all.
+			users = append(users, "")
+		}
+		for _, user := range users {
+			for _, vr := range routersByHTTPUser[user] {
+				if best != nil && (vr.user != best.user || vr.priority <= best.priority) {
+					continue
+				}
+				if captures, ok := vr.match(path, method); ok {
+					best = vr
+					bestCaptures = mergeCaptures(domainCaptures, captures)
+				}
+			}
+		}
+	}
+
+	// First we check the full hostname
+	find(host, nil)
+
+	// e.g. domain = test.example.com, try to match wildcard domains.
+	// *.example.com
+	// *.com
+	domainSplit := strings.Split(host, ".")
+	for len(domainSplit) >= 3 {
+		domainSplit[0] = "*"
+		find(strings.Join(domainSplit, "."), nil)
+		domainSplit = domainSplit[1:]
+	}
+
+	for _, d := range r.regexDomains {
+		if m := d.re.FindStringSubmatch(host); m != nil {
+			find(d.domain, namedCaptures(d.re, m))
+		}
+	}
+
+	// Finally, try to check if there is one proxy that domain is "*" means match all domains.
+	find("*", nil)
+	return best, bestCaptures, best != nil
+}
+
+// HasDomain returns true if any router is registered for domain.
+func (r *Routers) HasDomain(domain string) bool {
+	domain = normalizeDomain(domain)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.hasDomain(domain)
+}
+
+func (r *Routers) hasDomain(domain string) bool {
+	for _, vrs := range r.indexByDomain[domain] {
+		if len(vrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDomainRegexp returns true if host matches the regular expression of any registered domain.
+func (r *Routers) MatchDomainRegexp(host string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, d := range r.regexDomains {
+		if d.re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// exist returns the router matching the same requests as vr, r.mutex must be held.
+func (r *Routers) exist(vr *Router) (route *Router, exist bool) {
+	routersByHTTPUser, found := r.indexByDomain[vr.domain]
 	if !found {
 		return
 	}
-	routers, found := routersByHTTPUser[httpUser]
+	routers, found := routersByHTTPUser[vr.httpUser]
 	if !found {
 		return
 	}
 
 	for _, route = range routers {
-		if path == route.location {
+		if vr.location == route.location && methodsOverlap(vr.methods, route.methods) {
 			return route, true
 		}
 	}
-	return
+	return nil, false
+}
+
+// regexConflict returns the route of another user which may match the same requests as vr, whose domain is a
+// regular expression, r.mutex must be held. Two regular expressions may match the same hosts if the suffix of
+// one ends with the suffix of the other.
+func (r *Routers) regexConflict(vr *Router) (*Router, bool) {
+	for domain, routersByHTTPUser := range r.indexByDomain {
+		for _, route := range routersByHTTPUser[vr.httpUser] {
+			if route.user == vr.user || !methodsOverlap(route.methods, vr.methods) || !locationsOverlap(route, vr) {
+				continue
+			}
+			var overlap bool
+			switch {
+			case route.domainRe != nil:
+				overlap = strings.HasSuffix(route.domainSuffix, vr.domainSuffix) ||
+					strings.HasSuffix(vr.domainSuffix, route.domainSuffix)
+			case domain == "*":
+			case strings.HasPrefix(domain, "*."):
+				overlap = strings.HasSuffix(vr.domainSuffix, domain[1:]) || strings.HasSuffix(domain[1:], vr.domainSuffix)
+			default:
+				overlap = vr.domainRe.MatchString(domain)
+			}
+			if overlap {
+				return route, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// OverlappingRegexRoutes returns the owners of the routes of the same user which may match the same requests
+// as spec by regular expressions of domains or locations with the same priority. Which of them serves a request then
+// depends on the order of the expressions, so a different priority should be set. Overlaps of regular
+// expressions can't be decided in general, so routes which may overlap are reported.
+func (r *Routers) OverlappingRegexRoutes(spec RouteSpec) []string {
+	vr, err := newRouter(spec, nil)
+	if err != nil {
+		return nil
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var owners []string
+	for domain, routersByHTTPUser := range r.indexByDomain {
+		for _, route := range routersByHTTPUser[vr.httpUser] {
+			if domain == vr.domain && route.location == vr.location && slices.Equal(route.methods, vr.methods) {
+				// the route of spec itself
+				continue
+			}
+			if route.user != vr.user || route.priority != vr.priority || !methodsOverlap(route.methods, vr.methods) {
+				continue
+			}
+			var overlap bool
+			switch {
+			case domain == vr.domain:
+				overlap = route.locationRe != nil && vr.locationRe != nil
+			case route.domainRe != nil && vr.domainRe != nil:
+				overlap = locationsOverlap(route, vr)
+			}
+			if overlap {
+				owners = append(owners, route.owner)
+			}
+		}
+	}
+	slices.Sort(owners)
+	return slices.Compact(owners)
+}
+
+// locationsOverlap returns true if the locations of a and b may match the same paths.
+func locationsOverlap(a, b *Router) bool {
+	if a.locationRe != nil || b.locationRe != nil {
+		return true
+	}
+	return strings.HasPrefix(a.location, b.location) || strings.HasPrefix(b.location, a.location)
+}
+
+func methodsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, m := range a {
+		if slices.Contains(b, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (vr *Router) match(path, method string) (map[string]string, bool) {
+	if method != "" && len(vr.methods) > 0 && !slices.Contains(vr.methods, strings.ToUpper(method)) {
+		return nil, false
+	}
+	if vr.locationRe != nil {
+		m := vr.locationRe.FindStringSubmatch(path)
+		if m == nil {
+			return nil, false
+		}
+		return namedCaptures(vr.locationRe, m), true
+	}
+	return nil, strings.HasPrefix(path, vr.location)
+}
+
+func namedCaptures(re *regexp.Regexp, m []string) map[string]string {
+	var captures map[string]string
+	for i, name := range re.SubexpNames() {
+		if name == "" || i >= len(m) {
+			continue
+		}
+		if captures == nil {
+			captures = make(map[string]string)
+		}
+		captures[name] = m[i]
+	}
+	return captures
+}
+
+func mergeCaptures(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	ret := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		ret[k] = v
+	}
+	for k, v := range b {
+		ret[k] = v
+	}
+	return ret
+}
+
+// ExpandCaptures replaces ${name} in s with the named capture groups of a route match.
+func ExpandCaptures(s string, captures map[string]string) string {
+	if len(captures) == 0 || !strings.Contains(s, "$") {
+		return s
+	}
+	return os.Expand(s, func(name string) string {
+		return captures[name]
+	})
 }
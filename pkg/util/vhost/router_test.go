@@ -0,0 +1,178 @@
+package vhost
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutersFind(t *testing.T) {
+	require := require.New(t)
+
+	r := NewRouters()
+	add := func(spec RouteSpec) {
+		require.NoError(r.AddRoute(spec, spec.Owner))
+	}
+	find := func(host, path, httpUser, method string) (string, map[string]string) {
+		vr, captures, ok := r.Find(host, path, httpUser, method)
+		if !ok {
+			return "", nil
+		}
+		return vr.payload.(string), captures
+	}
+
+	add(RouteSpec{Domain: "a.example.com", Owner: "exact"})
+	add(RouteSpec{Domain: "a.example.com", Location: "/api", Methods: []string{"GET"}, Owner: "api"})
+	add(RouteSpec{Domain: "a.example.com", Location: "/api", Methods: []string{"post"}, Priority: 1, Owner: "api-post"})
+	add(RouteSpec{Domain: "*.example.com", Owner: "wildcard"})
+	add(RouteSpec{Domain: `~^(?P<app>[a-z]+)\.apps\.example\.org$`, Location: `~^/(?P<ver>v[0-9]+)/`, Owner: "regex"})
+	add(RouteSpec{Domain: "*", Owner: "all"})
+
+	owner, _ := find("a.example.com", "/", "", "GET")
+	require.Equal("exact", owner)
+	owner, _ = find("A.Example.com", "/api/x", "", "GET")
+	require.Equal("api", owner)
+	owner, _ = find("a.example.com", "/api/x", "", "POST")
+	require.Equal("api-post", owner)
+	owner, _ = find("b.example.com", "/", "", "GET")
+	require.Equal("wildcard", owner)
+	owner, _ = find("other.org", "/", "", "GET")
+	require.Equal("all", owner)
+
+	owner, captures := find("shop.apps.example.org", "/v2/items", "", "GET")
+	require.Equal("regex", owner)
+	require.Equal(map[string]string{"app": "shop", "ver": "v2"}, captures)
+	require.Equal("shop.internal:v2", ExpandCaptures("${app}.internal:${ver}", captures))
+	// the location doesn't match the regexp
+	owner, _ = find("shop.apps.example.org", "/items", "", "GET")
+	require.Equal("all", owner)
+
+	// a higher priority wins over a more specific domain
+	add(RouteSpec{Domain: "*.example.com", Location: "/admin", Priority: 10, Owner: "admin"})
+	owner, _ = find("a.example.com", "/admin", "", "GET")
+	require.Equal("admin", owner)
+
+	r.DelRoute(RouteSpec{Domain: "a.example.com", Location: "/api", Methods: []string{"POST"}})
+	owner, _ = find("a.example.com", "/api/x", "", "POST")
+	require.Equal("exact", owner)
+
+	r.DelRoute(RouteSpec{Domain: `~^(?P<app>[a-z]+)\.apps\.example\.org$`, Location: `~^/(?P<ver>v[0-9]+)/`})
+	require.False(r.MatchDomainRegexp("shop.apps.example.org"))
+}
+
+func TestRoutersConflict(t *testing.T) {
+	require := require.New(t)
+
+	r := NewRouters()
+	require.NoError(r.AddRoute(RouteSpec{Domain: "a.example.com", Location: "/api", Methods: []string{"GET"}, Owner: "web"}, nil))
+	require.NoError(r.AddRoute(RouteSpec{Domain: "a.example.com", Location: "/api", Methods: []string{"POST"}, Owner: "upload"}, nil))
+
+	err := r.AddRoute(RouteSpec{Domain: "A.example.com", Location: "/api", Owner: "other"}, nil)
+	require.ErrorIs(err, ErrRouterConfigConflict)
+	var conflictErr *RouteConflictError
+	require.True(errors.As(err, &conflictErr))
+	require.Equal("web", conflictErr.Owner)
+	require.Contains(err.Error(), "is already used by [web]")
+
+	require.Error(r.AddRoute(RouteSpec{Domain: "~(", Owner: "bad"}, nil))
+}
+
+func TestRoutersOverlappingRegexRoutes(t *testing.T) {
+	require := require.New(t)
+
+	r := NewRouters()
+	add := func(spec RouteSpec) {
+		require.NoError(r.AddRoute(spec, nil))
+	}
+	add(RouteSpec{Domain: `~^[a-z]+\.example\.com$`, Owner: "letters"})
+	add(RouteSpec{Domain: "a.example.com", Owner: "exact"})
+	add(RouteSpec{Domain: "b.example.com", Location: `~^/v[0-9]+/`, Owner: "versions"})
+
+	spec := RouteSpec{Domain: `~^app-[0-9]+\.example\.com$`, Owner: "apps"}
+	add(spec)
+	require.Equal([]string{"letters"}, r.OverlappingRegexRoutes(spec))
+	// a different priority decides which route wins
+	require.Empty(r.OverlappingRegexRoutes(RouteSpec{Domain: `~^app\.example\.com$`, Priority: 1}))
+	require.Empty(r.OverlappingRegexRoutes(RouteSpec{Domain: "a.example.com", Location: "/api"}))
+
+	require.Equal([]string{"versions"}, r.OverlappingRegexRoutes(RouteSpec{Domain: "b.example.com", Location: `~^/v1/`}))
+	require.Empty(r.OverlappingRegexRoutes(RouteSpec{Domain: "b.example.com", Location: "/v1/"}))
+}
+
+func TestRoutersPriorityOfUsers(t *testing.T) {
+	require := require.New(t)
+
+	r := NewRouters()
+	add := func(spec RouteSpec) {
+		require.NoError(r.AddRoute(spec, spec.Owner))
+	}
+	find := func(host, path string) string {
+		vr, _, ok := r.Find(host, path, "", "")
+		if !ok {
+			return ""
+		}
+		return vr.payload.(string)
+	}
+
+	add(RouteSpec{Domain: "a.example.com", Location: "/api", User: "alice", Owner: "alice-api"})
+	add(RouteSpec{Domain: "a.example.com", Location: "/", Priority: 10, User: "bob", Owner: "bob-root"})
+	add(RouteSpec{Domain: `~^[a-z]+\.example\.org$`, Priority: 10, User: "bob", Owner: "bob-regex"})
+	add(RouteSpec{Domain: "*.example.org", Location: "/admin", User: "alice", Owner: "alice-admin"})
+
+	// the priority of another user doesn't win over a longer location or a more specific domain
+	require.Equal("alice-api", find("a.example.com", "/api/x"))
+	require.Equal("bob-root", find("a.example.com", "/web"))
+	require.Equal("alice-admin", find("b.example.org", "/admin"))
+	require.Equal("bob-regex", find("b.example.org", "/"))
+
+	// the priority of the same user does
+	add(RouteSpec{Domain: "*.example.com", Location: "/", Priority: 1, User: "alice", Owner: "alice-wildcard"})
+	require.Equal("alice-wildcard", find("a.example.com", "/api/x"))
+}
+
+func TestRoutersRegexConflict(t *testing.T) {
+	require := require.New(t)
+
+	r := NewRouters()
+	require.NoError(r.AddRoute(RouteSpec{Domain: "a.example.com", Location: "/api", User: "alice", Owner: "alice-api"}, nil))
+	require.NoError(r.AddRoute(RouteSpec{Domain: "*.example.net", User: "alice", Owner: "alice-wildcard"}, nil))
+	require.NoError(r.AddRoute(RouteSpec{Domain: `~^[a-z]+\.apps\.example\.org$`, User: "alice", Owner: "alice-apps"}, nil))
+
+	conflict := func(spec RouteSpec, owner string) {
+		err := r.AddRoute(spec, nil)
+		require.ErrorIs(err, ErrRouterConfigConflict, spec.Domain)
+		var conflictErr *RouteConflictError
+		require.True(errors.As(err, &conflictErr))
+		require.Equal(owner, conflictErr.Owner)
+	}
+	conflict(RouteSpec{Domain: `~^.*\.example\.com$`, User: "bob"}, "alice-api")
+	conflict(RouteSpec{Domain: `~^.*\.example\.net$`, User: "bob"}, "alice-wildcard")
+	conflict(RouteSpec{Domain: `~^.*\.net$`, User: "bob"}, "alice-wildcard")
+	conflict(RouteSpec{Domain: `~^.*\.example\.org$`, User: "bob"}, "alice-apps")
+	conflict(RouteSpec{Domain: `~^x\.apps\.example\.org$`, User: "bob"}, "alice-apps")
+	require.ErrorIs(r.AddRoute(RouteSpec{Domain: `~.*`, User: "bob"}, nil), ErrRouterConfigConflict)
+
+	// different locations of a full domain don't conflict, neither do routes of the same user
+	require.NoError(r.AddRoute(RouteSpec{Domain: `~^[a-z]\.example\.com$`, Location: "/web", User: "bob"}, nil))
+	require.NoError(r.AddRoute(RouteSpec{Domain: `~^.*\.example\.net$`, User: "alice"}, nil))
+	require.NoError(r.AddRoute(RouteSpec{Domain: `~^[a-z]+\.example\.io$`, User: "bob"}, nil))
+}
+
+func TestRegexDomainSuffix(t *testing.T) {
+	require := require.New(t)
+
+	for domain, expected := range map[string]string{
+		`~^(?P<app>[a-z]+)\.Apps\.example\.com$`: ".apps.example.com",
+		`~^[a-z]+example\.com$`:                  "example.com",
+		`~^(a|b)\.example\.com$`:                 ".example.com",
+	} {
+		suffix, ok := RegexDomainSuffix(domain)
+		require.True(ok, domain)
+		require.Equal(expected, suffix, domain)
+	}
+	for _, domain := range []string{"a.example.com", `~.*`, `~^[a-z]+\.example\.com`, `~^a\.example\.(com|org)$`, `~(`} {
+		_, ok := RegexDomainSuffix(domain)
+		require.False(ok, domain)
+	}
+}
@@ -15,8 +15,8 @@ package vhost
 import (
 	"context"
 	"fmt"
-	"gitee.com/menciis/logx"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -40,6 +40,14 @@ type RequestRouteInfo struct {
 	RemoteAddr string
 	URLHost    string
 	Endpoint   string
+	Method     string
+	// Captures holds the named capture groups of the regular expressions matched by the route.
+	Captures map[string]string
+
+	// routeTo is set when a request hook reroutes the request to another proxy.
+	routeTo *RouteConfig
+	// err is the error of forwarding the request.
+	err error
 }
 
 type (
@@ -48,6 +56,7 @@ type (
 	hostRewriteFunc func(net.Conn, string) (net.Conn, error)
 	successHookFunc func(net.Conn, map[string]string) error
 	failHookFunc    func(net.Conn)
+	fallbackFunc    func(net.Conn, map[string]string) bool
 )
 
 // Muxer is a functional component used for https and tcpmux proxies.
@@ -56,16 +65,21 @@ type (
 type Muxer struct {
 	listener net.Listener
 	timeout  time.Duration
+	xl       *xlog.Logger
 
 	vhostFunc      muxFunc
 	checkAuth      authFunc
 	successHook    successHookFunc
 	failHook       failHookFunc
+	fallback       fallbackFunc
 	rewriteHost    hostRewriteFunc
 	registryRouter *Routers
 }
 
+// NewMuxer creates a muxer accepting connections from listener, errors of connections without a listener are
+// logged by the logger of ctx.
 func NewMuxer(
+	ctx context.Context,
 	listener net.Listener,
 	vhostFunc muxFunc,
 	timeout time.Duration,
@@ -73,6 +87,7 @@ func NewMuxer(
 	mux = &Muxer{
 		listener:       listener,
 		timeout:        timeout,
+		xl:             xlog.FromContextSafe(ctx),
 		vhostFunc:      vhostFunc,
 		registryRouter: NewRouters(),
 	}
@@ -95,19 +110,54 @@ func (v *Muxer) SetFailHookFunc(f failHookFunc) *Muxer {
 	return v
 }
 
+// SetFallbackFunc sets f to be called for connections without a matching listener. f takes over the
+// connection if it returns true, otherwise the fail hook is called.
+func (v *Muxer) SetFallbackFunc(f fallbackFunc) *Muxer {
+	v.fallback = f
+	return v
+}
+
 func (v *Muxer) SetRewriteHostFunc(f hostRewriteFunc) *Muxer {
 	v.rewriteHost = f
 	return v
 }
 
-type ChooseEndpointFunc func() (string, error)
+type ChooseEndpointFunc func(req *http.Request) (string, error)
+
+// EndpointCookieFunc returns the cookie to set in the response of req which was sent to endpoint, nil if no
+// cookie is needed.
+type EndpointCookieFunc func(req *http.Request, endpoint string) *http.Cookie
 
 type CreateConnFunc func(remoteAddr string) (net.Conn, error)
 
+// RequestStats describes a request forwarded by HTTPReverseProxy.
+type RequestStats struct {
+	// Time is when the request was received.
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	Host       string
+	Path       string
+	// Endpoint is the endpoint chosen by ChooseEndpointFn, empty if none was chosen.
+	Endpoint   string
+	StatusCode int
+	// BytesIn is the size of the request body read from the user and BytesOut the size of the response
+	// body written to the user.
+	BytesIn  int64
+	BytesOut int64
+	// Err is the error of forwarding the request, e.g. the one returned by CreateConnFn.
+	Err error
+}
+
+// RequestDoneFunc is called when a request forwarded to a route is done.
+type RequestDoneFunc func(stats *RequestStats)
+
 type CreateConnByEndpointFunc func(endpoint, remoteAddr string) (net.Conn, error)
 
 // RouteConfig is the params used to match HTTP requests
 type RouteConfig struct {
+	ProxyName       string
+	User            string
 	Domain          string
 	Location        string
 	RewriteHost     string
@@ -116,27 +166,43 @@ type RouteConfig struct {
 	Headers         map[string]string
 	ResponseHeaders map[string]string
 	RouteByHTTPUser string
+	Methods         []string
+	Priority        int
 
 	CreateConnFn           CreateConnFunc
 	ChooseEndpointFn       ChooseEndpointFunc
 	CreateConnByEndpointFn CreateConnByEndpointFunc
+	EndpointCookieFn       EndpointCookieFunc
+	RequestDoneFn          RequestDoneFunc
+}
+
+// RouteSpec returns the spec to register rc in Routers.
+func (rc *RouteConfig) RouteSpec() RouteSpec {
+	return RouteSpec{
+		Domain:   rc.Domain,
+		Location: rc.Location,
+		HTTPUser: rc.RouteByHTTPUser,
+		Methods:  rc.Methods,
+		Priority: rc.Priority,
+		Owner:    rc.ProxyName,
+		User:     rc.User,
+	}
 }
 
 // listen for a new domain name, if rewriteHost is not empty and rewriteHost func is not nil,
 // then rewrite the host header to rewriteHost
 func (v *Muxer) Listen(ctx context.Context, cfg *RouteConfig) (l *Listener, err error) {
 	l = &Listener{
-		name:            cfg.Domain,
-		location:        cfg.Location,
-		routeByHTTPUser: cfg.RouteByHTTPUser,
-		rewriteHost:     cfg.RewriteHost,
-		username:        cfg.Username,
-		password:        cfg.Password,
-		mux:             v,
-		accept:          make(chan net.Conn),
-		ctx:             ctx,
+		name:        cfg.Domain,
+		routeSpec:   cfg.RouteSpec(),
+		rewriteHost: cfg.RewriteHost,
+		username:    cfg.Username,
+		password:    cfg.Password,
+		mux:         v,
+		accept:      make(chan net.Conn),
+		ctx:         ctx,
 	}
-	err = v.registryRouter.Add(cfg.Domain, cfg.Location, cfg.RouteByHTTPUser, l)
+	err = v.registryRouter.AddRoute(l.routeSpec, l)
 	if err != nil {
 		return
 	}
@@ -144,47 +210,11 @@ func (v *Muxer) Listen(ctx context.Context, cfg *RouteConfig) (l *Listener, err
 }
 
 func (v *Muxer) getListener(name, path, httpUser string) (*Listener, bool) {
-	findRouter := func(inName, inPath, inHTTPUser string) (*Listener, bool) {
-		vr, ok := v.registryRouter.Get(inName, inPath, inHTTPUser)
-		if ok {
-			return vr.payload.(*Listener), true
-		}
-		// Try to check if there is one proxy that doesn't specify routerByHTTPUser, it means match all.
-		vr, ok = v.registryRouter.Get(inName, inPath, "")
-		if ok {
-			return vr.payload.(*Listener), true
-		}
+	vr, _, ok := v.registryRouter.Find(name, path, httpUser, "")
+	if !ok {
 		return nil, false
 	}
-
-	// first we check the full hostname
-	// if not exist, then check the wildcard_domain such as *.example.com
-	l, ok := findRouter(name, path, httpUser)
-	if ok {
-		return l, true
-	}
-
-	domainSplit := strings.Split(name, ".")
-	for {
-		if len(domainSplit) < 3 {
-			break
-		}
-
-		domainSplit[0] = "*"
-		name = strings.Join(domainSplit, ".")
-
-		l, ok = findRouter(name, path, httpUser)
-		if ok {
-			return l, true
-		}
-		domainSplit = domainSplit[1:]
-	}
-	// Finally, try to check if there is one proxy that domain is "*" means match all domains.
-	l, ok = findRouter("*", path, httpUser)
-	if ok {
-		return l, true
-	}
-	return nil, false
+	return vr.payload.(*Listener), true
 }
 
 func (v *Muxer) run() {
@@ -205,7 +235,8 @@ func (v *Muxer) handle(c net.Conn) {
 
 	sConn, reqInfoMap, err := v.vhostFunc(c)
 	if err != nil {
-		logx.Verbosef("get hostname from http/https request error: %v", err)
+		v.xl.Spawn().AddPrefix(xlog.LogPrefix{Name: "remoteAddr", Value: c.RemoteAddr().String(), Hidden: true}).
+			Debugf("get hostname from http/https request error: %v", err)
 		_ = c.Close()
 		return
 	}
@@ -215,7 +246,13 @@ func (v *Muxer) handle(c net.Conn) {
 	httpUser := reqInfoMap["HTTPUser"]
 	l, ok := v.getListener(name, path, httpUser)
 	if !ok {
-		logx.Verbosef("http request for host [%s] path [%s] httpUser [%s] not found", name, path, httpUser)
+		if v.fallback != nil && v.fallback(sConn, reqInfoMap) {
+			return
+		}
+		v.xl.Spawn().
+			AddPrefix(xlog.LogPrefix{Name: "host", Value: name, Hidden: true}).
+			AddPrefix(xlog.LogPrefix{Name: "path", Value: path, Hidden: true}).
+			Debugf("http request for host [%s] path [%s] httpUser [%s] not found", name, path, httpUser)
 		v.failHook(sConn)
 		return
 	}
@@ -256,15 +293,14 @@ func (v *Muxer) handle(c net.Conn) {
 }
 
 type Listener struct {
-	name            string
-	location        string
-	routeByHTTPUser string
-	rewriteHost     string
-	username        string
-	password        string
-	mux             *Muxer // for closing Muxer
-	accept          chan net.Conn
-	ctx             context.Context
+	name        string
+	routeSpec   RouteSpec
+	rewriteHost string
+	username    string
+	password    string
+	mux         *Muxer // for closing Muxer
+	accept      chan net.Conn
+	ctx         context.Context
 }
 
 func (l *Listener) Accept() (net.Conn, error) {
@@ -290,7 +326,7 @@ func (l *Listener) Accept() (net.Conn, error) {
 }
 
 func (l *Listener) Close() error {
-	l.mux.registryRouter.Del(l.name, l.location, l.routeByHTTPUser)
+	l.mux.registryRouter.DelRoute(l.routeSpec)
 	close(l.accept)
 	return nil
 }
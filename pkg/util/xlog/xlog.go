@@ -16,10 +16,18 @@ package xlog
 
 import (
 	"cmp"
+	"context"
+	"fmt"
 	"gitee.com/menciis/logx"
+	"log/slog"
+	"runtime"
 	"slices"
+	"time"
 )
 
+// LevelTrace is the slog level of Tracef, it's lower than slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
 type LogPrefix struct {
 	// Name is the name of the prefix, it won't be displayed in log but used to identify the prefix.
 	Name string
@@ -27,15 +35,32 @@ type LogPrefix struct {
 	Value string
 	// The prefix with higher priority will be displayed first, default is 10.
 	Priority int
+	// Hidden prefixes are not displayed in text logs, they are only attached to structured logs.
+	Hidden bool
 }
 
 // Logger is not thread safety for operations on prefix
+//
+// Logs are written by the global logx package with prefixes rendered as "[value] ". If a slog.Handler is set,
+// logs are sent to it instead and every prefix becomes an attribute keyed by its name.
 type Logger struct {
 	prefixes []LogPrefix
+	handler  slog.Handler
 
 	prefixString string
 }
 
+// SetLevel sets the level of the global logger to a log level of the configuration, trace and debug logs
+// are both written at the verbose level.
+func SetLevel(level string) {
+	switch level {
+	case "trace", "debug":
+		logx.SetLevel("verbose")
+	default:
+		logx.SetLevel(level)
+	}
+}
+
 func New() *Logger {
 	return &Logger{
 		prefixes: make([]LogPrefix, 0),
@@ -62,11 +87,12 @@ func (l *Logger) AddPrefix(prefix LogPrefix) *Logger {
 	if prefix.Priority <= 0 {
 		prefix.Priority = 10
 	}
-	for _, p := range l.prefixes {
-		if p.Name == prefix.Name {
+	for i := range l.prefixes {
+		if l.prefixes[i].Name == prefix.Name {
 			found = true
-			p.Value = prefix.Value
-			p.Priority = prefix.Priority
+			l.prefixes[i].Value = prefix.Value
+			l.prefixes[i].Priority = prefix.Priority
+			l.prefixes[i].Hidden = prefix.Hidden
 		}
 	}
 	if !found {
@@ -82,33 +108,82 @@ func (l *Logger) renderPrefixString() {
 	})
 	l.prefixString = ""
 	for _, v := range l.prefixes {
+		if v.Hidden {
+			continue
+		}
 		l.prefixString += "[" + v.Value + "] "
 	}
 }
 
+// SetHandler sends logs of l and loggers spawned from it to h, a nil h restores the global logx output.
+func (l *Logger) SetHandler(h slog.Handler) *Logger {
+	l.handler = h
+	return l
+}
+
+func (l *Logger) Handler() slog.Handler {
+	return l.handler
+}
+
 func (l *Logger) Spawn() *Logger {
 	nl := New()
 	nl.prefixes = append(nl.prefixes, l.prefixes...)
+	nl.handler = l.handler
 	nl.renderPrefixString()
 	return nl
 }
 
+// handle sends the log to the slog handler, it returns false if there is no handler.
+func (l *Logger) handle(level slog.Level, format string, v ...interface{}) bool {
+	if l.handler == nil {
+		return false
+	}
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, level) {
+		return true
+	}
+	var pcs [1]uintptr
+	// skip runtime.Callers, handle and the exported log method
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, v...), pcs[0])
+	for _, p := range l.prefixes {
+		r.AddAttrs(slog.String(p.Name, p.Value))
+	}
+	_ = l.handler.Handle(ctx, r)
+	return true
+}
+
 func (l *Logger) Errorf(format string, v ...interface{}) {
+	if l.handle(slog.LevelError, format, v...) {
+		return
+	}
 	logx.Errorf(l.prefixString+format, v...)
 }
 
 func (l *Logger) Warnf(format string, v ...interface{}) {
+	if l.handle(slog.LevelWarn, format, v...) {
+		return
+	}
 	logx.Warnf(l.prefixString+format, v...)
 }
 
 func (l *Logger) Infof(format string, v ...interface{}) {
+	if l.handle(slog.LevelInfo, format, v...) {
+		return
+	}
 	logx.Infof(l.prefixString+format, v...)
 }
 
 func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.handle(slog.LevelDebug, format, v...) {
+		return
+	}
 	logx.Verbosef(l.prefixString+format, v...)
 }
 
 func (l *Logger) Tracef(format string, v ...interface{}) {
+	if l.handle(LevelTrace, format, v...) {
+		return
+	}
 	logx.Verbosef(l.prefixString+format, v...)
 }
@@ -0,0 +1,40 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerHandler(t *testing.T) {
+	require := require.New(t)
+
+	buf := &bytes.Buffer{}
+	h := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: LevelTrace})
+	xl := New().SetHandler(h).AddPrefix(LogPrefix{Name: "runID", Value: "abc"})
+	pxl := xl.Spawn().
+		AddPrefix(LogPrefix{Name: "proxyName", Value: "ssh"}).
+		AddPrefix(LogPrefix{Name: "proxyType", Value: "tcp", Hidden: true})
+	require.Equal("[abc] [ssh] ", pxl.prefixString)
+
+	pxl.Infof("new user connection from %s", "1.1.1.1")
+	var record map[string]string
+	require.NoError(json.Unmarshal(buf.Bytes(), &record))
+	require.Equal("INFO", record["level"])
+	require.Equal("new user connection from 1.1.1.1", record["msg"])
+	require.Equal("abc", record["runID"])
+	require.Equal("ssh", record["proxyName"])
+	require.Equal("tcp", record["proxyType"])
+
+	// update the value of an existing prefix
+	buf.Reset()
+	xl.AddPrefix(LogPrefix{Name: "runID", Value: "def"})
+	xl.Tracef("relogin")
+	record = nil
+	require.NoError(json.Unmarshal(buf.Bytes(), &record))
+	require.Equal("def", record["runID"])
+	require.Equal("DEBUG-4", record["level"])
+}
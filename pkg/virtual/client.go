@@ -56,6 +56,10 @@ func (c *Client) UpdateProxyConfigurer(proxyCfgs []v1.ProxyConfigurer) {
 	_ = c.svr.UpdateAllConfigurer(proxyCfgs, nil)
 }
 
+func (c *Client) UpdateVisitorConfigurer(visitorCfgs []v1.VisitorConfigurer) {
+	_ = c.svr.UpdateAllConfigurer(nil, visitorCfgs)
+}
+
 func (c *Client) Run(ctx context.Context) error {
 	return c.svr.Run(ctx)
 }
@@ -0,0 +1,181 @@
+// Package accesslog writes one JSON line for every user connection handled by frps, and for every request
+// of http proxies.
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+// Close reasons of entries.
+const (
+	ReasonClosed         = "closed"
+	ReasonRejected       = "rejected by plugin"
+	ReasonSourceACL      = "rejected by source ACL"
+	ReasonQuotaExceeded  = "traffic quota exceeded"
+	ReasonNoWorkConn     = "no work connection"
+	ReasonIdleTimeout    = "idle timeout"
+	ReasonProxyClosed    = "proxy closed"
+	ReasonNatHoleSession = "nat hole session"
+)
+
+type Entry struct {
+	// Time is when the user connection was accepted or the http request was received.
+	Time      time.Time `json:"time"`
+	SrcAddr   string    `json:"srcAddr"`
+	ProxyName string    `json:"proxyName"`
+	ProxyType string    `json:"proxyType"`
+	RunID     string    `json:"runID"`
+	User      string    `json:"user,omitempty"`
+	// Method, Host, Path and Status are only set for requests of http proxies.
+	Method      string `json:"method,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	BytesIn     int64  `json:"bytesIn"`
+	BytesOut    int64  `json:"bytesOut"`
+	DurationMs  int64  `json:"durationMs"`
+	CloseReason string `json:"closeReason"`
+}
+
+// Logger writes entries as JSON lines. A nil Logger drops all entries.
+type Logger struct {
+	cfg *v1.AccessLogConfig
+
+	w    io.Writer
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// New returns nil if the access log is disabled in cfg.
+func New(cfg *v1.AccessLogConfig) (*Logger, error) {
+	if cfg.To == "" {
+		return nil, nil
+	}
+	l := &Logger{
+		cfg: cfg,
+	}
+	if cfg.To == "console" {
+		l.w = os.Stdout
+		return l, nil
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetWriter makes the logger write entries to w instead of the configured sink, without rotation.
+func (l *Logger) SetWriter(w io.Writer) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	l.w = w
+}
+
+// Log writes e, the duration is computed from e.Time if it's not set.
+func (l *Logger) Log(e *Entry) {
+	if l == nil {
+		return
+	}
+	if e.DurationMs == 0 && !e.Time.IsZero() {
+		e.DurationMs = time.Since(e.Time).Milliseconds()
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil && l.size+int64(len(buf)) > l.cfg.MaxSize*1024*1024 {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotate access log error: %v\n", err)
+		}
+	}
+	if l.w == nil {
+		return
+	}
+	n, _ := l.w.Write(buf)
+	l.size += int64(n)
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	l.w = nil
+	return err
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.cfg.To, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.w = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and removes the oldest backups
+// beyond MaxBackups, l.mu must be held. If the file can't be renamed, entries are still
+// appended to it.
+func (l *Logger) rotate() error {
+	l.f.Close()
+	l.f = nil
+	l.w = nil
+
+	backup := l.cfg.To + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(l.cfg.To, backup); err != nil {
+		if openErr := l.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	backups, err := filepath.Glob(l.cfg.To + ".*")
+	if err != nil {
+		return err
+	}
+	// timestamp suffixes sort in time order
+	slices.Sort(backups)
+	for len(backups) > l.cfg.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
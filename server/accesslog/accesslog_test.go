@@ -0,0 +1,102 @@
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func TestLogRotate(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := &v1.AccessLogConfig{To: path, MaxSize: 1, MaxBackups: 2}
+	l, err := New(cfg)
+	require.NoError(err)
+
+	src := strings.Repeat("a", 300*1024)
+	for i := 0; i < 10; i++ {
+		l.Log(&Entry{Time: time.Now(), SrcAddr: src, ProxyName: "ssh", CloseReason: ReasonClosed})
+		time.Sleep(2 * time.Millisecond)
+	}
+	require.NoError(l.Close())
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(err)
+	require.Len(backups, 2)
+
+	f, err := os.Open(path)
+	require.NoError(err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	require.True(scanner.Scan())
+	var e Entry
+	require.NoError(json.Unmarshal(scanner.Bytes(), &e))
+	require.Equal("ssh", e.ProxyName)
+}
+
+func TestLogRotateRenameFailed(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := New(&v1.AccessLogConfig{To: path, MaxSize: 1, MaxBackups: 2})
+	require.NoError(err)
+	defer l.Close()
+
+	src := strings.Repeat("a", 600*1024)
+	l.Log(&Entry{Time: time.Now(), SrcAddr: src, ProxyName: "ssh", CloseReason: ReasonClosed})
+	// renaming a removed file fails, entries are written to a reopened file
+	require.NoError(os.Remove(path))
+	l.Log(&Entry{Time: time.Now(), SrcAddr: src, ProxyName: "web", CloseReason: ReasonClosed})
+
+	buf, err := os.ReadFile(path)
+	require.NoError(err)
+	var e Entry
+	require.NoError(json.Unmarshal(bytes.TrimSpace(buf), &e))
+	require.Equal("web", e.ProxyName)
+}
+
+func TestDisabled(t *testing.T) {
+	require := require.New(t)
+	l, err := New(&v1.AccessLogConfig{})
+	require.NoError(err)
+	require.Nil(l)
+	l.Log(&Entry{})
+	require.Nil(NewSessions(l, Entry{}, time.Second))
+}
+
+func TestSessions(t *testing.T) {
+	require := require.New(t)
+	l, err := New(&v1.AccessLogConfig{To: "console"})
+	require.NoError(err)
+	buf := &bytes.Buffer{}
+	l.SetWriter(buf)
+
+	s := NewSessions(l, Entry{ProxyName: "dns", ProxyType: "udp"}, time.Hour)
+	s.Add("1.1.1.1:53", 10, 0)
+	s.Add("1.1.1.1:53", 0, 20)
+	s.Add("2.2.2.2:53", 5, 0)
+	s.Close()
+	s.Add("1.1.1.1:53", 10, 0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(lines, 2)
+	for _, line := range lines {
+		var e Entry
+		require.NoError(json.Unmarshal([]byte(line), &e))
+		require.Equal("dns", e.ProxyName)
+		require.Equal(ReasonProxyClosed, e.CloseReason)
+		if e.SrcAddr == "1.1.1.1:53" {
+			require.EqualValues(10, e.BytesIn)
+			require.EqualValues(20, e.BytesOut)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package accesslog
+
+import (
+	"sync"
+	"time"
+)
+
+// Sessions tracks UDP user addresses of a proxy as connections. An entry is logged when
+// an address has been idle for the timeout or when the sessions are closed.
+type Sessions struct {
+	l       *Logger
+	base    Entry
+	timeout time.Duration
+
+	sessions map[string]*Entry
+	lastSeen map[string]time.Time
+	closed   bool
+	closeCh  chan struct{}
+	mu       sync.Mutex
+}
+
+// NewSessions returns nil if l is nil. base holds the proxy fields of all entries.
+func NewSessions(l *Logger, base Entry, timeout time.Duration) *Sessions {
+	if l == nil {
+		return nil
+	}
+	s := &Sessions{
+		l:        l,
+		base:     base,
+		timeout:  timeout,
+		sessions: make(map[string]*Entry),
+		lastSeen: make(map[string]time.Time),
+		closeCh:  make(chan struct{}),
+	}
+	go s.sweep()
+	return s
+}
+
+// Add counts the bytes of a packet from or to srcAddr.
+func (s *Sessions) Add(srcAddr string, bytesIn int64, bytesOut int64) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	e, ok := s.sessions[srcAddr]
+	if !ok {
+		e = new(Entry)
+		*e = s.base
+		e.Time = now
+		e.SrcAddr = srcAddr
+		s.sessions[srcAddr] = e
+	}
+	e.BytesIn += bytesIn
+	e.BytesOut += bytesOut
+	s.lastSeen[srcAddr] = now
+}
+
+// Close logs all sessions with ReasonProxyClosed.
+func (s *Sessions) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.closeCh)
+	entries := make([]*Entry, 0, len(s.sessions))
+	for _, e := range s.sessions {
+		entries = append(entries, e)
+	}
+	s.sessions = nil
+	s.lastSeen = nil
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		e.CloseReason = ReasonProxyClosed
+		s.l.Log(e)
+	}
+}
+
+func (s *Sessions) sweep() {
+	ticker := time.NewTicker(s.timeout / 2)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-s.closeCh:
+			return
+		}
+
+		var expired []*Entry
+		s.mu.Lock()
+		for addr, last := range s.lastSeen {
+			if now.Sub(last) < s.timeout {
+				continue
+			}
+			e := s.sessions[addr]
+			e.DurationMs = last.Sub(e.Time).Milliseconds()
+			expired = append(expired, e)
+			delete(s.sessions, addr)
+			delete(s.lastSeen, addr)
+		}
+		s.mu.Unlock()
+
+		for _, e := range expired {
+			e.CloseReason = ReasonIdleTimeout
+			s.l.Log(e)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package acme obtains certificates for the domains of http proxies over ACME and terminates TLS for them
+// on the vhost https port.
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/transport"
+	netpkg "github.com/iami317/hepx/pkg/util/net"
+)
+
+// Manager answers HTTP-01 challenges and serves TLS terminated connections handed over by the https muxer.
+type Manager struct {
+	domains     []string
+	hasDomainFn func(domain string) bool
+
+	m        *autocert.Manager
+	listener *netpkg.InternalListener
+	server   *http.Server
+}
+
+// NewManager creates a manager for cfg, hasDomainFn reports whether a domain is routed to an http proxy.
+// Certificates are only requested for such domains.
+func NewManager(cfg *v1.ACMEConfig, hasDomainFn func(domain string) bool) (*Manager, error) {
+	client := &acme.Client{
+		DirectoryURL: cfg.DirectoryURL,
+	}
+	if cfg.TrustedCaFile != "" {
+		tlsConfig, err := transport.NewClientTLSConfig("", "", cfg.TrustedCaFile, "")
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
+
+	m := &Manager{
+		domains:     cfg.Domains,
+		hasDomainFn: hasDomainFn,
+		listener:    netpkg.NewInternalListener(),
+	}
+	m.m = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: m.hostPolicy,
+		Email:      cfg.Email,
+		Client:     client,
+	}
+	return m, nil
+}
+
+func (m *Manager) hostPolicy(_ context.Context, host string) error {
+	if !m.DomainAllowed(host) {
+		return errors.New("acme: host not allowed")
+	}
+	return nil
+}
+
+// DomainAllowed returns true if domain is routed to an http proxy and matches the configured domains.
+func (m *Manager) DomainAllowed(domain string) bool {
+	domain = strings.ToLower(domain)
+	if domain == "" || !m.hasDomainFn(domain) {
+		return false
+	}
+	if len(m.domains) == 0 {
+		return true
+	}
+	for _, d := range m.domains {
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*"); ok {
+			if strings.HasSuffix(domain, suffix) && len(domain) > len(suffix) {
+				return true
+			}
+		} else if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes other requests to fallback.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.m.HTTPHandler(fallback)
+}
+
+// Run terminates TLS for connections passed to HandleConn and serves their requests by handler in background.
+func (m *Manager) Run(handler http.Handler) {
+	m.server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 60 * time.Second,
+	}
+	go func() {
+		_ = m.server.Serve(tls.NewListener(m.listener, m.m.TLSConfig()))
+	}()
+}
+
+// HandleConn takes over an https connection if its SNI is an allowed domain. It can be used as the fallback of
+// the https muxer.
+func (m *Manager) HandleConn(c net.Conn, reqInfoMap map[string]string) bool {
+	if !m.DomainAllowed(reqInfoMap["Host"]) {
+		return false
+	}
+	// the deadline was set by the muxer to read the client hello
+	_ = c.SetDeadline(time.Time{})
+	_ = m.listener.PutConn(c)
+	return true
+}
+
+func (m *Manager) Close() error {
+	if m.server != nil {
+		return m.server.Close()
+	}
+	return m.listener.Close()
+}
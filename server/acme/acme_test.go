@@ -0,0 +1,59 @@
+package acme
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func TestDomainAllowed(t *testing.T) {
+	require := require.New(t)
+
+	routed := map[string]bool{"a.example.com": true, "b.example.com": true, "example.org": true}
+	hasDomainFn := func(domain string) bool { return routed[domain] }
+
+	cfg := &v1.ACMEConfig{CacheDir: t.TempDir()}
+	m, err := NewManager(cfg, hasDomainFn)
+	require.NoError(err)
+	require.True(m.DomainAllowed("a.example.com"))
+	require.True(m.DomainAllowed("A.Example.com"))
+	require.True(m.DomainAllowed("example.org"))
+	require.False(m.DomainAllowed("c.example.com"))
+	require.False(m.DomainAllowed(""))
+
+	cfg.Domains = []string{"*.example.com"}
+	m, err = NewManager(cfg, hasDomainFn)
+	require.NoError(err)
+	require.True(m.DomainAllowed("a.example.com"))
+	require.False(m.DomainAllowed("example.org"))
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	require.False(m.HandleConn(c1, map[string]string{"Host": "example.org"}))
+	require.True(m.HandleConn(c1, map[string]string{"Host": "b.example.com"}))
+	require.NoError(m.Close())
+}
+
+func TestHTTPHandler(t *testing.T) {
+	require := require.New(t)
+
+	m, err := NewManager(&v1.ACMEConfig{CacheDir: t.TempDir()}, func(string) bool { return true })
+	require.NoError(err)
+	h := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	// unknown challenge tokens are not passed to the fallback handler
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://a.example.com/.well-known/acme-challenge/token", nil))
+	require.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://a.example.com/index.html", nil))
+	require.Equal(http.StatusTeapot, w.Code)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+	"github.com/iami317/hepx/pkg/util/util"
+	"github.com/iami317/hepx/server/quota"
+)
+
+// pushProxiesTimeout is used to wait for the response of PushProxies if the caller's context has no deadline.
+const pushProxiesTimeout = 10 * time.Second
+
+// ClientInfo is a snapshot of an online client.
+type ClientInfo struct {
+	RunID      string            `json:"runID"`
+	User       string            `json:"user,omitempty"`
+	Hostname   string            `json:"hostname,omitempty"`
+	Os         string            `json:"os,omitempty"`
+	Arch       string            `json:"arch,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Metas      map[string]string `json:"metas,omitempty"`
+	ClientAddr string            `json:"clientAddr"`
+	LoginTime  time.Time         `json:"loginTime"`
+	LastPing   time.Time         `json:"lastPing"`
+	ProxyNames []string          `json:"proxyNames"`
+}
+
+// ProxyInfo is a snapshot of a proxy registered by an online client.
+type ProxyInfo struct {
+	Name  string             `json:"name"`
+	Type  string             `json:"type"`
+	RunID string             `json:"runID"`
+	User  string             `json:"user,omitempty"`
+	Conf  v1.ProxyConfigurer `json:"conf"`
+}
+
+// ProxyFilter selects proxies in ListProxies. Empty fields match everything.
+type ProxyFilter struct {
+	RunID string
+	User  string
+	Type  string
+}
+
+func (f *ProxyFilter) match(info *ProxyInfo) bool {
+	return (f.RunID == "" || f.RunID == info.RunID) &&
+		(f.User == "" || f.User == info.User) &&
+		(f.Type == "" || f.Type == info.Type)
+}
+
+func (ctl *Control) info() ClientInfo {
+	ctl.mu.RLock()
+	proxyNames := make([]string, 0, len(ctl.proxies))
+	for name := range ctl.proxies {
+		proxyNames = append(proxyNames, name)
+	}
+	ctl.mu.RUnlock()
+	slices.Sort(proxyNames)
+
+	return ClientInfo{
+		RunID:      ctl.loginMsg.RunID,
+		User:       ctl.loginMsg.User,
+		Hostname:   ctl.loginMsg.Hostname,
+		Os:         ctl.loginMsg.Os,
+		Arch:       ctl.loginMsg.Arch,
+		Version:    ctl.loginMsg.Version,
+		Metas:      ctl.loginMsg.Metas,
+		ClientAddr: ctl.conn.RemoteAddr().String(),
+		LoginTime:  ctl.loginTime,
+		LastPing:   ctl.lastPing.Load().(time.Time),
+		ProxyNames: proxyNames,
+	}
+}
+
+func (ctl *Control) proxyInfos() []*ProxyInfo {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	infos := make([]*ProxyInfo, 0, len(ctl.proxies))
+	for _, pxy := range ctl.proxies {
+		infos = append(infos, &ProxyInfo{
+			Name:  pxy.GetName(),
+			Type:  pxy.GetConfigurer().GetBaseConfig().Type,
+			RunID: ctl.loginMsg.RunID,
+			User:  ctl.loginMsg.User,
+			Conf:  pxy.GetConfigurer(),
+		})
+	}
+	return infos
+}
+
+// ListClients returns all online clients sorted by run id.
+func (svr *Service) ListClients() []ClientInfo {
+	ctls := svr.ctlManager.All()
+	clients := make([]ClientInfo, 0, len(ctls))
+	for _, ctl := range ctls {
+		clients = append(clients, ctl.info())
+	}
+	slices.SortFunc(clients, func(a, b ClientInfo) int {
+		return cmp.Compare(a.RunID, b.RunID)
+	})
+	return clients
+}
+
+// GetClient returns the online client with the given run id.
+func (svr *Service) GetClient(runID string) (ClientInfo, bool) {
+	ctl, ok := svr.ctlManager.GetByID(runID)
+	if !ok {
+		return ClientInfo{}, false
+	}
+	return ctl.info(), true
+}
+
+// ListProxies returns all proxies of online clients matched by filter, sorted by name.
+func (svr *Service) ListProxies(filter ProxyFilter) []*ProxyInfo {
+	var ctls []*Control
+	if filter.RunID != "" {
+		if ctl, ok := svr.ctlManager.GetByID(filter.RunID); ok {
+			ctls = append(ctls, ctl)
+		}
+	} else {
+		ctls = svr.ctlManager.All()
+	}
+
+	proxies := make([]*ProxyInfo, 0)
+	for _, ctl := range ctls {
+		for _, info := range ctl.proxyInfos() {
+			if filter.match(info) {
+				proxies = append(proxies, info)
+			}
+		}
+	}
+	slices.SortFunc(proxies, func(a, b *ProxyInfo) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return proxies
+}
+
+// CloseProxy closes the proxy named name of the client with the given run id.
+// The client is told that the proxy was closed by the server and won't register it again until it reconnects.
+func (svr *Service) CloseProxy(runID string, name string) error {
+	ctl, ok := svr.ctlManager.GetByID(runID)
+	if !ok {
+		return fmt.Errorf("no client control found for run id [%s]", runID)
+	}
+	return ctl.CloseProxyByServer(name, "closed by server administrator")
+}
+
+// DisconnectClient closes the control connection of the client with the given run id
+// after sending it the reason. All proxies of the client are released.
+func (svr *Service) DisconnectClient(runID string, reason string) error {
+	ctl, ok := svr.ctlManager.GetByID(runID)
+	if !ok {
+		return fmt.Errorf("no client control found for run id [%s]", runID)
+	}
+	ctl.Disconnect(reason)
+	return nil
+}
+
+// PushProxies asks the client with the given run id to start proxies and visitors.
+// The client must have enabled proxyPush and it validates them against its own allow-list.
+// The type of every proxy and visitor must be set in its base config. It blocks until the client responds or ctx is done.
+func (svr *Service) PushProxies(ctx context.Context, runID string, proxies []v1.ProxyConfigurer, visitors []v1.VisitorConfigurer) error {
+	ctl, ok := svr.ctlManager.GetByID(runID)
+	if !ok {
+		return fmt.Errorf("no client control found for run id [%s]", runID)
+	}
+	return ctl.PushProxies(ctx, proxies, visitors)
+}
+
+// PushProxiesByMetadata pushes proxies and visitors to all online clients whose metadatas contain
+// all key-value pairs in metas. The returned map holds the result for every matched run id.
+func (svr *Service) PushProxiesByMetadata(
+	ctx context.Context,
+	metas map[string]string,
+	proxies []v1.ProxyConfigurer,
+	visitors []v1.VisitorConfigurer,
+) map[string]error {
+	var (
+		results = make(map[string]error)
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for _, ctl := range svr.ctlManager.All() {
+		if !matchMetas(ctl.loginMsg.Metas, metas) {
+			continue
+		}
+		wg.Add(1)
+		go func(ctl *Control) {
+			defer wg.Done()
+			err := ctl.PushProxies(ctx, proxies, visitors)
+			mu.Lock()
+			results[ctl.loginMsg.RunID] = err
+			mu.Unlock()
+		}(ctl)
+	}
+	wg.Wait()
+	return results
+}
+
+func matchMetas(metas map[string]string, selector map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := metas[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (ctl *Control) PushProxies(ctx context.Context, proxies []v1.ProxyConfigurer, visitors []v1.VisitorConfigurer) error {
+	if !ctl.loginMsg.HasFeature(msg.FeatureProxyPush) {
+		return fmt.Errorf("client [%s] does not accept pushed proxies", ctl.loginMsg.RunID)
+	}
+
+	transactionID, err := util.RandID()
+	if err != nil {
+		return err
+	}
+	m := &msg.PushProxies{
+		TransactionID: transactionID,
+	}
+	for _, c := range proxies {
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		m.Proxies = append(m.Proxies, buf)
+	}
+	for _, c := range visitors {
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		m.Visitors = append(m.Visitors, buf)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pushProxiesTimeout)
+		defer cancel()
+	}
+	resp, err := ctl.msgTransporter.Do(ctx, m, transactionID, msg.TypeNamePushProxiesResp)
+	if err != nil {
+		return err
+	}
+	if respErr := resp.(*msg.PushProxiesResp).Error; respErr != "" {
+		return errors.New(respErr)
+	}
+	return nil
+}
+
+// SetTrafficQuotaStore replaces the local file store of traffic quota usages.
+// It must be called before Run.
+func (svr *Service) SetTrafficQuotaStore(store quota.Store) {
+	svr.rc.QuotaManager.SetStore(store)
+}
+
+// ListTrafficQuotas returns the usage of all traffic quota counters.
+func (svr *Service) ListTrafficQuotas() []quota.Status {
+	return svr.rc.QuotaManager.Statuses()
+}
+
+func (svr *Service) notifyQuotaExceeded(s quota.Status) {
+	for _, ctl := range svr.ctlManager.All() {
+		if ctl.quota.Has(s.Rule, s.Value) {
+			ctl.NotifyQuotaExceeded(s)
+		}
+	}
+}
@@ -19,8 +19,6 @@ import (
 	"fmt"
 	"net"
 	"runtime/debug"
-	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -33,16 +31,22 @@ import (
 	pkgerr "github.com/iami317/hepx/pkg/errors"
 	"github.com/iami317/hepx/pkg/msg"
 	plugin "github.com/iami317/hepx/pkg/plugin/server"
+	"github.com/iami317/hepx/pkg/proto/udp"
 	"github.com/iami317/hepx/pkg/transport"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
 	"github.com/iami317/hepx/pkg/util/util"
 	"github.com/iami317/hepx/pkg/util/wait"
 	"github.com/iami317/hepx/pkg/util/xlog"
 	"github.com/iami317/hepx/server/controller"
+	"github.com/iami317/hepx/server/event"
 	"github.com/iami317/hepx/server/metrics"
 	"github.com/iami317/hepx/server/proxy"
+	"github.com/iami317/hepx/server/quota"
 )
 
+// disconnectGracePeriod is the time to wait for the CloseControl message to be sent before closing the connection.
+const disconnectGracePeriod = 500 * time.Millisecond
+
 type ControlManager struct {
 	// controls indexed by run id
 	ctlsByRunID map[string]*Control
@@ -85,6 +89,17 @@ func (cm *ControlManager) GetByID(runID string) (ctl *Control, ok bool) {
 	return
 }
 
+// All returns all online controls.
+func (cm *ControlManager) All() []*Control {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ctls := make([]*Control, 0, len(cm.ctlsByRunID))
+	for _, ctl := range cm.ctlsByRunID {
+		ctls = append(ctls, ctl)
+	}
+	return ctls
+}
+
 func (cm *ControlManager) Close() error {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
@@ -146,11 +161,21 @@ type Control struct {
 	// Server configuration information
 	serverCfg *v1.ServerConfig
 
+	// publish lifecycle events of this client and its proxies
+	eventBus *event.Bus
+
+	// time when the client logged in
+	loginTime time.Time
+
+	// counts traffic of this client against traffic quotas
+	quota *quota.Tracker
+
+	// carries packets of udp proxies in QUIC datagrams, nil if the client doesn't support it
+	datagramMux *udp.DatagramMux
+
 	xl     *xlog.Logger
 	ctx    context.Context
 	doneCh chan struct{}
-	//有登录成功后的其他操作
-	OnLoginFn func(ctx context.Context, msg *msg.NewProxy)
 }
 
 // NewControl TODO(fatedier): Referencing the implementation of frpc, encapsulate the input parameters as SessionContext.
@@ -164,7 +189,7 @@ func NewControl(
 	ctlConnEncrypted bool,
 	loginMsg *msg.Login,
 	serverCfg *v1.ServerConfig,
-	onLoginFn func(ctx context.Context, msg *msg.NewProxy),
+	eventBus *event.Bus,
 ) (*Control, error) {
 	poolCount := loginMsg.PoolCount
 	if poolCount > int(serverCfg.Transport.MaxPoolCount) {
@@ -183,13 +208,20 @@ func NewControl(
 		portsUsedNum:  0,
 		runID:         loginMsg.RunID,
 		serverCfg:     serverCfg,
+		eventBus:      eventBus,
+		loginTime:     time.Now(),
+		quota:         rc.QuotaManager.Tracker(loginMsg),
 		xl:            xlog.FromContextSafe(ctx),
 		ctx:           ctx,
 		doneCh:        make(chan struct{}),
-		OnLoginFn:     onLoginFn,
 	}
 	ctl.lastPing.Store(time.Now())
 
+	if quicConn, ok := netpkg.QuicConnFromConn(ctlConn); ok && loginMsg.HasFeature(msg.FeatureQUICDatagram) &&
+		quicConn.ConnectionState().SupportsDatagrams {
+		ctl.datagramMux = udp.NewDatagramMux(quicConn)
+	}
+
 	if ctlConnEncrypted {
 		cryptoRW, err := netpkg.NewCryptoReadWriter(ctl.conn, []byte(ctl.serverCfg.Auth.Token))
 		if err != nil {
@@ -219,6 +251,14 @@ func (ctl *Control) Start() {
 			_ = ctl.msgDispatcher.Send(&msg.ReqWorkConn{})
 		}
 	}()
+	if ctl.datagramMux != nil {
+		ctx, cancel := context.WithCancel(ctl.ctx)
+		go func() {
+			<-ctl.doneCh
+			cancel()
+		}()
+		go ctl.datagramMux.Run(ctx)
+	}
 	go ctl.worker()
 }
 
@@ -227,9 +267,39 @@ func (ctl *Control) Close() error {
 	return nil
 }
 
+// Disconnect tells the client why it's disconnected and closes the control connection.
+func (ctl *Control) Disconnect(reason string) {
+	ctl.xl.Infof("disconnect client: %s", reason)
+	if err := ctl.msgDispatcher.Send(&msg.CloseControl{Reason: reason}); err != nil {
+		ctl.conn.Close()
+		return
+	}
+	// give the dispatcher a chance to flush the message before the connection is closed
+	time.AfterFunc(disconnectGracePeriod, func() {
+		ctl.conn.Close()
+	})
+}
+
+// NotifyQuotaExceeded tells the client that one of its traffic quotas is exceeded.
+func (ctl *Control) NotifyQuotaExceeded(s quota.Status) {
+	if !ctl.loginMsg.HasFeature(msg.FeatureQuotaNotify) {
+		return
+	}
+	_ = ctl.msgDispatcher.Send(&msg.QuotaExceeded{
+		Rule:   s.Rule,
+		Period: s.Period,
+		Action: s.Action,
+		Limit:  s.Limit,
+		Used:   s.Used,
+	})
+}
+
 func (ctl *Control) Replaced(newCtl *Control) {
 	xl := ctl.xl
 	xl.Infof("Replaced by client [%s]", newCtl.runID)
+	e := ctl.newEvent(event.TypeControlReplaced)
+	e.NewRunID = newCtl.runID
+	ctl.eventBus.Publish(e)
 	ctl.runID = ""
 	ctl.conn.Close()
 }
@@ -246,6 +316,7 @@ func (ctl *Control) RegisterWorkConn(conn net.Conn) error {
 	select {
 	case ctl.workConnCh <- conn:
 		xl.Tracef("new work connection registered")
+		ctl.eventBus.Publish(ctl.newEvent(event.TypeWorkConnOpened))
 		return nil
 	default:
 		xl.Tracef("work connection pool is full, discarding")
@@ -308,6 +379,7 @@ func (ctl *Control) heartbeatWorker() {
 	go wait.Until(func() {
 		if time.Since(ctl.lastPing.Load().(time.Time)) > time.Duration(ctl.serverCfg.Transport.HeartbeatTimeout)*time.Second {
 			xl.Warnf("heartbeat timeout")
+			ctl.eventBus.Publish(ctl.newEvent(event.TypeHeartbeatTimeout))
 			ctl.conn.Close()
 			return
 		}
@@ -354,10 +426,12 @@ func (ctl *Control) worker() {
 		go func() {
 			_ = ctl.pluginManager.CloseProxy(notifyContent)
 		}()
+		ctl.publishProxyClosed(pxy)
 	}
 
 	metrics.Server.CloseClient()
 	xl.Infof("client exit success")
+	ctl.eventBus.Publish(ctl.newEvent(event.TypeClientExit))
 	close(ctl.doneCh)
 }
 
@@ -368,6 +442,7 @@ func (ctl *Control) registerMsgHandlers() {
 	ctl.msgDispatcher.RegisterHandler(&msg.NatHoleClient{}, msg.AsyncHandler(ctl.handleNatHoleClient))
 	ctl.msgDispatcher.RegisterHandler(&msg.NatHoleReport{}, msg.AsyncHandler(ctl.handleNatHoleReport))
 	ctl.msgDispatcher.RegisterHandler(&msg.CloseProxy{}, ctl.handleCloseProxy)
+	ctl.msgDispatcher.RegisterHandler(&msg.PushProxiesResp{}, ctl.handlePushProxiesResp)
 }
 
 func (ctl *Control) handleNewProxy(m msg.Message) {
@@ -392,28 +467,24 @@ func (ctl *Control) handleNewProxy(m msg.Message) {
 	resp := &msg.NewProxyResp{
 		ProxyName: inMsg.ProxyName,
 	}
+	e := ctl.newEvent(event.TypeProxyRegistered)
+	e.ProxyName = inMsg.ProxyName
+	e.ProxyType = inMsg.ProxyType
+	e.NewProxy = event.RedactNewProxy(inMsg)
 	if err != nil {
 		xl.Warnf("new proxy [%s] type [%s] error: %v", inMsg.ProxyName, inMsg.ProxyType, err)
 		resp.Error = util.GenerateResponseErrorString(fmt.Sprintf("new proxy [%s] error", inMsg.ProxyName),
 			err, lo.FromPtr(ctl.serverCfg.DetailedErrorsToClient))
+		e.Type = event.TypeProxyRegisterFailed
+		e.Error = err.Error()
 	} else {
 		resp.RemoteAddr = remoteAddr
 		xl.Tracef("new proxy name:[%s] type:[%s]  remote_port:[%v] success", inMsg.ProxyName, inMsg.ProxyType, inMsg.RemotePort)
 
 		metrics.Server.NewProxy(inMsg.ProxyName, inMsg.ProxyType)
+		e.RemoteAddr = remoteAddr
 	}
-	if ctl.OnLoginFn != nil {
-		if inMsg.Metas == nil {
-			inMsg.Metas = ctl.loginMsg.Metas
-		}
-		if inMsg.RemotePort == 0 {
-			s := strings.SplitN(remoteAddr, ":", 2)
-			if len(s) == 2 {
-				inMsg.RemotePort, _ = strconv.Atoi(s[1])
-			}
-		}
-		ctl.OnLoginFn(ctl.ctx, inMsg)
-	}
+	ctl.eventBus.Publish(e)
 	_ = ctl.msgDispatcher.Send(resp)
 }
 
@@ -448,17 +519,17 @@ func (ctl *Control) handlePing(m msg.Message) {
 
 func (ctl *Control) handleNatHoleVisitor(m msg.Message) {
 	inMsg := m.(*msg.NatHoleVisitor)
-	ctl.rc.NatHoleController.HandleVisitor(inMsg, ctl.msgTransporter, ctl.loginMsg.User)
+	ctl.rc.NatHoleController.HandleVisitor(ctl.ctx, inMsg, ctl.msgTransporter, ctl.loginMsg.User)
 }
 
 func (ctl *Control) handleNatHoleClient(m msg.Message) {
 	inMsg := m.(*msg.NatHoleClient)
-	ctl.rc.NatHoleController.HandleClient(inMsg, ctl.msgTransporter)
+	ctl.rc.NatHoleController.HandleClient(ctl.ctx, inMsg, ctl.msgTransporter)
 }
 
 func (ctl *Control) handleNatHoleReport(m msg.Message) {
 	inMsg := m.(*msg.NatHoleReport)
-	ctl.rc.NatHoleController.HandleReport(inMsg)
+	ctl.rc.NatHoleController.HandleReport(ctl.ctx, inMsg)
 }
 
 func (ctl *Control) handleCloseProxy(m msg.Message) {
@@ -468,7 +539,34 @@ func (ctl *Control) handleCloseProxy(m msg.Message) {
 	xl.Infof("close proxy [%s] success", inMsg.ProxyName)
 }
 
+// CloseProxyByServer closes a proxy on the server side and tells the client the reason.
+func (ctl *Control) CloseProxyByServer(name string, reason string) error {
+	closeMsg := &msg.CloseProxy{
+		ProxyName: name,
+		Reason:    reason,
+	}
+	if err := ctl.CloseProxy(closeMsg); err != nil {
+		return err
+	}
+	ctl.xl.Infof("proxy [%s] closed by server: %s", name, reason)
+	_ = ctl.msgDispatcher.Send(closeMsg)
+	return nil
+}
+
+func (ctl *Control) handlePushProxiesResp(m msg.Message) {
+	inMsg := m.(*msg.PushProxiesResp)
+	if !ctl.msgTransporter.DispatchWithType(inMsg, msg.TypeNamePushProxiesResp, inMsg.TransactionID) {
+		ctl.xl.Tracef("dispatch PushProxiesResp message error, transaction id [%s]", inMsg.TransactionID)
+	}
+}
+
 func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err error) {
+	if v, ok := ctl.authVerifier.(auth.ProxyVerifier); ok {
+		if err = v.VerifyNewProxy(ctl.loginMsg, pxyMsg); err != nil {
+			return
+		}
+	}
+
 	var pxyConf v1.ProxyConfigurer
 	// Load configures from NewProxy message and validate.
 	pxyConf, err = config.NewProxyConfigurerFromMsg(pxyMsg, ctl.serverCfg)
@@ -493,30 +591,31 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		GetWorkConnFn:      ctl.GetWorkConn,
 		Configurer:         pxyConf,
 		ServerCfg:          ctl.serverCfg,
+		Quota:              ctl.quota,
+		DatagramMux:        ctl.datagramMux,
 	})
 	if err != nil {
 		return remoteAddr, err
 	}
 
-	// Check ports used number in each client
-	if ctl.serverCfg.MaxPortsPerClient > 0 {
-		ctl.mu.Lock()
-		if ctl.portsUsedNum+pxy.GetUsedPortsNum() > int(ctl.serverCfg.MaxPortsPerClient) {
-			ctl.mu.Unlock()
-			err = fmt.Errorf("exceed the max_ports_per_client")
-			return
-		}
-		ctl.portsUsedNum += pxy.GetUsedPortsNum()
+	// Check ports used number in each client, they are always counted because maxPortsPerClient can be
+	// changed by reloading.
+	ctl.mu.Lock()
+	if maxPorts := ctl.rc.ServerConfig().MaxPortsPerClient; maxPorts > 0 && ctl.portsUsedNum+pxy.GetUsedPortsNum() > int(maxPorts) {
 		ctl.mu.Unlock()
-
-		defer func() {
-			if err != nil {
-				ctl.mu.Lock()
-				ctl.portsUsedNum -= pxy.GetUsedPortsNum()
-				ctl.mu.Unlock()
-			}
-		}()
+		err = fmt.Errorf("exceed the max_ports_per_client")
+		return
 	}
+	ctl.portsUsedNum += pxy.GetUsedPortsNum()
+	ctl.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			ctl.mu.Lock()
+			ctl.portsUsedNum -= pxy.GetUsedPortsNum()
+			ctl.mu.Unlock()
+		}
+	}()
 
 	if ctl.pxyManager.Exist(pxyMsg.ProxyName) {
 		err = fmt.Errorf("proxy [%s] already exists", pxyMsg.ProxyName)
@@ -544,17 +643,21 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 	return remoteAddr, nil
 }
 
+func (ctl *Control) usedPortsNum() int {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	return ctl.portsUsedNum
+}
+
 func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) (err error) {
 	ctl.mu.Lock()
 	pxy, ok := ctl.proxies[closeMsg.ProxyName]
 	if !ok {
 		ctl.mu.Unlock()
-		return
+		return fmt.Errorf("proxy [%s] not found", closeMsg.ProxyName)
 	}
 
-	if ctl.serverCfg.MaxPortsPerClient > 0 {
-		ctl.portsUsedNum -= pxy.GetUsedPortsNum()
-	}
+	ctl.portsUsedNum -= pxy.GetUsedPortsNum()
 	pxy.Close()
 	ctl.pxyManager.Del(pxy.GetName())
 	delete(ctl.proxies, closeMsg.ProxyName)
@@ -575,5 +678,24 @@ func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) (err error) {
 	go func() {
 		_ = ctl.pluginManager.CloseProxy(notifyContent)
 	}()
+	ctl.publishProxyClosed(pxy)
 	return
 }
+
+// newEvent creates an event of type t filled with the information of this client.
+func (ctl *Control) newEvent(t event.Type) *event.Event {
+	return &event.Event{
+		Type:       t,
+		RunID:      ctl.loginMsg.RunID,
+		ClientAddr: ctl.conn.RemoteAddr().String(),
+		Login:      event.NewLoginInfo(ctl.loginMsg),
+		LoginTime:  ctl.loginTime,
+	}
+}
+
+func (ctl *Control) publishProxyClosed(pxy proxy.Proxy) {
+	e := ctl.newEvent(event.TypeProxyClosed)
+	e.ProxyName = pxy.GetName()
+	e.ProxyType = pxy.GetConfigurer().GetBaseConfig().Type
+	ctl.eventBus.Publish(e)
+}
@@ -15,12 +15,17 @@
 package controller
 
 import (
+	"github.com/iami317/hepx/pkg/auth"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/nathole"
 	plugin "github.com/iami317/hepx/pkg/plugin/server"
 	"github.com/iami317/hepx/pkg/util/tcpmux"
 	"github.com/iami317/hepx/pkg/util/vhost"
+	"github.com/iami317/hepx/server/accesslog"
+	"github.com/iami317/hepx/server/event"
 	"github.com/iami317/hepx/server/group"
 	"github.com/iami317/hepx/server/ports"
+	"github.com/iami317/hepx/server/quota"
 	"github.com/iami317/hepx/server/visitor"
 )
 
@@ -29,6 +34,9 @@ type ResourceController struct {
 	// Manage all visitor listeners
 	VisitorManager *visitor.Manager
 
+	// Revoked visitor credentials of stcp, xtcp and sudp proxies
+	VisitorRevocations *auth.VisitorRevocations
+
 	// TCP Group Controller
 	TCPGroupCtl *group.TCPGroupCtl
 
@@ -58,4 +66,16 @@ type ResourceController struct {
 
 	// All server manager plugin
 	PluginManager *plugin.Manager
+
+	// Publish lifecycle events of clients and proxies
+	EventBus *event.Bus
+
+	// Count traffic of clients against traffic quotas, nil if there is no quota rule
+	QuotaManager *quota.Manager
+
+	// Write one entry for every user connection, nil if the access log is disabled
+	AccessLog *accesslog.Logger
+
+	// ServerConfig returns the current server config, which is replaced when frps reloads it
+	ServerConfig func() *v1.ServerConfig
 }
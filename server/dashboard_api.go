@@ -17,13 +17,14 @@ package server
 import (
 	"cmp"
 	"encoding/json"
-	"gitee.com/menciis/logx"
 	"net/http"
 	"slices"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/iami317/hepx/assets"
+	"github.com/iami317/hepx/pkg/auth"
 	"github.com/iami317/hepx/pkg/config/types"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/metrics/mem"
@@ -43,7 +44,7 @@ func (svr *Service) registerRouteHandlers(helper *httppkg.RouterRegisterHelper)
 	subRouter.Use(helper.AuthMiddleware.Middleware)
 
 	// metrics
-	if svr.cfg.EnablePrometheus {
+	if svr.config().EnablePrometheus {
 		subRouter.Handle("/metrics", promhttp.Handler())
 	}
 
@@ -52,7 +53,12 @@ func (svr *Service) registerRouteHandlers(helper *httppkg.RouterRegisterHelper)
 	subRouter.HandleFunc("/api/proxy/{type}", svr.apiProxyByType).Methods("GET")
 	subRouter.HandleFunc("/api/proxy/{type}/{name}", svr.apiProxyByTypeAndName).Methods("GET")
 	subRouter.HandleFunc("/api/traffic/{name}", svr.apiProxyTraffic).Methods("GET")
+	subRouter.HandleFunc("/api/groups", svr.apiGroups).Methods("GET")
+	subRouter.HandleFunc("/api/credentials/{name}", svr.apiVisitorCredentials).Methods("GET")
+	subRouter.HandleFunc("/api/credentials/{name}/{credential}/revoke", svr.revokeVisitorCredential).Methods("POST")
+	subRouter.HandleFunc("/api/credentials/{name}/{credential}/restore", svr.restoreVisitorCredential).Methods("POST")
 	subRouter.HandleFunc("/api/proxies", svr.deleteProxies).Methods("DELETE")
+	subRouter.HandleFunc("/api/reload", svr.apiReload).Methods("POST")
 
 	// view
 	subRouter.Handle("/favicon.ico", http.FileServer(helper.AssetsFS)).Methods("GET")
@@ -65,7 +71,20 @@ func (svr *Service) registerRouteHandlers(helper *httppkg.RouterRegisterHelper)
 	})
 }
 
-type serverInfoResp struct {
+// DashboardHandler returns a handler serving the dashboard APIs and pages with the user and password of
+// webServer, it can be mounted on other http servers.
+func (svr *Service) DashboardHandler() http.Handler {
+	cfg := svr.config().WebServer
+	router := mux.NewRouter()
+	svr.registerRouteHandlers(&httppkg.RouterRegisterHelper{
+		Router:         router,
+		AssetsFS:       assets.FileSystem,
+		AuthMiddleware: netpkg.NewHTTPAuthMiddleware(cfg.User, cfg.Password).Middleware,
+	})
+	return router
+}
+
+type ServerInfoResp struct {
 	Version               string `json:"version"`
 	BindPort              int    `json:"bindPort"`
 	VhostHTTPPort         int    `json:"vhostHTTPPort"`
@@ -96,29 +115,30 @@ func (svr *Service) healthz(w http.ResponseWriter, _ *http.Request) {
 func (svr *Service) apiServerInfo(w http.ResponseWriter, r *http.Request) {
 	res := GeneralResponse{Code: 200}
 	defer func() {
-		logx.Verbosef("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
 		}
 	}()
 
-	logx.Verbosef("Http request: [%s]", r.URL.Path)
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
 	serverStats := mem.StatsCollector.GetServer()
-	svrResp := serverInfoResp{
+	cfg := svr.config()
+	svrResp := ServerInfoResp{
 		Version:               "v0.58.1",
-		BindPort:              svr.cfg.BindPort,
-		VhostHTTPPort:         svr.cfg.VhostHTTPPort,
-		VhostHTTPSPort:        svr.cfg.VhostHTTPSPort,
-		TCPMuxHTTPConnectPort: svr.cfg.TCPMuxHTTPConnectPort,
-		KCPBindPort:           svr.cfg.KCPBindPort,
-		QUICBindPort:          svr.cfg.QUICBindPort,
-		SubdomainHost:         svr.cfg.SubDomainHost,
-		MaxPoolCount:          svr.cfg.Transport.MaxPoolCount,
-		MaxPortsPerClient:     svr.cfg.MaxPortsPerClient,
-		HeartBeatTimeout:      svr.cfg.Transport.HeartbeatTimeout,
-		AllowPortsStr:         types.PortsRangeSlice(svr.cfg.AllowPorts).String(),
-		TLSForce:              svr.cfg.Transport.TLS.Force,
+		BindPort:              cfg.BindPort,
+		VhostHTTPPort:         cfg.VhostHTTPPort,
+		VhostHTTPSPort:        cfg.VhostHTTPSPort,
+		TCPMuxHTTPConnectPort: cfg.TCPMuxHTTPConnectPort,
+		KCPBindPort:           cfg.KCPBindPort,
+		QUICBindPort:          cfg.QUICBindPort,
+		SubdomainHost:         cfg.SubDomainHost,
+		MaxPoolCount:          cfg.Transport.MaxPoolCount,
+		MaxPortsPerClient:     cfg.MaxPortsPerClient,
+		HeartBeatTimeout:      cfg.Transport.HeartbeatTimeout,
+		AllowPortsStr:         types.PortsRangeSlice(cfg.AllowPorts).String(),
+		TLSForce:              cfg.Transport.TLS.Force,
 
 		TotalTrafficIn:  serverStats.TotalTrafficIn,
 		TotalTrafficOut: serverStats.TotalTrafficOut,
@@ -172,6 +192,29 @@ type XTCPOutConf struct {
 	BaseOutConf
 }
 
+// unmarshalOutConf decodes the conf of a proxy into the output conf struct of its type.
+func unmarshalOutConf(data []byte) (any, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	typeStruct := struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(data, &typeStruct); err != nil {
+		return nil, err
+	}
+	conf := getConfByType(typeStruct.Type)
+	if conf == nil {
+		var raw map[string]any
+		err := json.Unmarshal(data, &raw)
+		return raw, err
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 func getConfByType(proxyType string) any {
 	switch v1.ProxyType(proxyType) {
 	case v1.ProxyTypeTCP:
@@ -201,11 +244,30 @@ type ProxyStatsInfo struct {
 	TodayTrafficIn  int64       `json:"todayTrafficIn"`
 	TodayTrafficOut int64       `json:"todayTrafficOut"`
 	CurConns        int64       `json:"curConns"`
+	RejectedConns   int64       `json:"rejectedConns"`
 	LastStartTime   string      `json:"lastStartTime"`
 	LastCloseTime   string      `json:"lastCloseTime"`
 	Status          string      `json:"status"`
 }
 
+// UnmarshalJSON decodes Conf into the output conf struct of the proxy type, like *TCPOutConf.
+func (info *ProxyStatsInfo) UnmarshalJSON(data []byte) error {
+	type plain ProxyStatsInfo
+	aux := struct {
+		*plain
+		Conf json.RawMessage `json:"conf"`
+	}{plain: (*plain)(info)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	conf, err := unmarshalOutConf(aux.Conf)
+	if err != nil {
+		return err
+	}
+	info.Conf = conf
+	return nil
+}
+
 type GetProxyInfoResp struct {
 	Proxies []*ProxyStatsInfo `json:"proxies"`
 }
@@ -217,13 +279,13 @@ func (svr *Service) apiProxyByType(w http.ResponseWriter, r *http.Request) {
 	proxyType := params["type"]
 
 	defer func() {
-		logx.Verbosef("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
 		}
 	}()
-	logx.Verbosef("Http request: [%s]", r.URL.Path)
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
 
 	proxyInfoResp := GetProxyInfoResp{}
 	proxyInfoResp.Proxies = svr.getProxyStatsByType(proxyType)
@@ -243,12 +305,12 @@ func (svr *Service) getProxyStatsByType(proxyType string) (proxyInfos []*ProxySt
 		if pxy, ok := svr.pxyManager.GetByName(ps.Name); ok {
 			content, err := json.Marshal(pxy.GetConfigurer())
 			if err != nil {
-				logx.Warnf("marshal proxy [%s] conf info error: %v", ps.Name, err)
+				svr.xl.Warnf("marshal proxy [%s] conf info error: %v", ps.Name, err)
 				continue
 			}
 			proxyInfo.Conf = getConfByType(ps.Type)
 			if err = json.Unmarshal(content, &proxyInfo.Conf); err != nil {
-				logx.Warnf("unmarshal proxy [%s] conf info error: %v", ps.Name, err)
+				svr.xl.Warnf("unmarshal proxy [%s] conf info error: %v", ps.Name, err)
 				continue
 			}
 			proxyInfo.Status = "online"
@@ -262,6 +324,7 @@ func (svr *Service) getProxyStatsByType(proxyType string) (proxyInfos []*ProxySt
 		proxyInfo.TodayTrafficIn = ps.TodayTrafficIn
 		proxyInfo.TodayTrafficOut = ps.TodayTrafficOut
 		proxyInfo.CurConns = ps.CurConns
+		proxyInfo.RejectedConns = ps.RejectedConns
 		proxyInfo.LastStartTime = ps.LastStartTime
 		proxyInfo.LastCloseTime = ps.LastCloseTime
 		proxyInfos = append(proxyInfos, proxyInfo)
@@ -276,11 +339,30 @@ type GetProxyStatsResp struct {
 	TodayTrafficIn  int64       `json:"todayTrafficIn"`
 	TodayTrafficOut int64       `json:"todayTrafficOut"`
 	CurConns        int64       `json:"curConns"`
+	RejectedConns   int64       `json:"rejectedConns"`
 	LastStartTime   string      `json:"lastStartTime"`
 	LastCloseTime   string      `json:"lastCloseTime"`
 	Status          string      `json:"status"`
 }
 
+// UnmarshalJSON decodes Conf into the output conf struct of the proxy type, like *TCPOutConf.
+func (resp *GetProxyStatsResp) UnmarshalJSON(data []byte) error {
+	type plain GetProxyStatsResp
+	aux := struct {
+		*plain
+		Conf json.RawMessage `json:"conf"`
+	}{plain: (*plain)(resp)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	conf, err := unmarshalOutConf(aux.Conf)
+	if err != nil {
+		return err
+	}
+	resp.Conf = conf
+	return nil
+}
+
 // /api/proxy/:type/:name
 func (svr *Service) apiProxyByTypeAndName(w http.ResponseWriter, r *http.Request) {
 	res := GeneralResponse{Code: 200}
@@ -289,13 +371,13 @@ func (svr *Service) apiProxyByTypeAndName(w http.ResponseWriter, r *http.Request
 	name := params["name"]
 
 	defer func() {
-		logx.Verbosef("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
 		}
 	}()
-	logx.Verbosef("Http request: [%s]", r.URL.Path)
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
 
 	var proxyStatsResp GetProxyStatsResp
 	proxyStatsResp, res.Code, res.Msg = svr.getProxyStatsByTypeAndName(proxyType, name)
@@ -317,14 +399,14 @@ func (svr *Service) getProxyStatsByTypeAndName(proxyType string, proxyName strin
 		if pxy, ok := svr.pxyManager.GetByName(proxyName); ok {
 			content, err := json.Marshal(pxy.GetConfigurer())
 			if err != nil {
-				logx.Warnf("marshal proxy [%s] conf info error: %v", ps.Name, err)
+				svr.xl.Warnf("marshal proxy [%s] conf info error: %v", ps.Name, err)
 				code = 400
 				msg = "parse conf error"
 				return
 			}
 			proxyInfo.Conf = getConfByType(ps.Type)
 			if err = json.Unmarshal(content, &proxyInfo.Conf); err != nil {
-				logx.Warnf("unmarshal proxy [%s] conf info error: %v", ps.Name, err)
+				svr.xl.Warnf("unmarshal proxy [%s] conf info error: %v", ps.Name, err)
 				code = 400
 				msg = "parse conf error"
 				return
@@ -336,6 +418,7 @@ func (svr *Service) getProxyStatsByTypeAndName(proxyType string, proxyName strin
 		proxyInfo.TodayTrafficIn = ps.TodayTrafficIn
 		proxyInfo.TodayTrafficOut = ps.TodayTrafficOut
 		proxyInfo.CurConns = ps.CurConns
+		proxyInfo.RejectedConns = ps.RejectedConns
 		proxyInfo.LastStartTime = ps.LastStartTime
 		proxyInfo.LastCloseTime = ps.LastCloseTime
 		code = 200
@@ -357,13 +440,13 @@ func (svr *Service) apiProxyTraffic(w http.ResponseWriter, r *http.Request) {
 	name := params["name"]
 
 	defer func() {
-		logx.Verbosef("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
 		}
 	}()
-	logx.Verbosef("Http request: [%s]", r.URL.Path)
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
 
 	trafficResp := GetProxyTrafficResp{}
 	trafficResp.Name = name
@@ -381,13 +464,229 @@ func (svr *Service) apiProxyTraffic(w http.ResponseWriter, r *http.Request) {
 	res.Msg = string(buf)
 }
 
+// /api/groups
+type GroupMemberInfo struct {
+	Name        string `json:"name"`
+	Weight      int    `json:"weight"`
+	ActiveConns int64  `json:"activeConns"`
+	TotalConns  int64  `json:"totalConns"`
+	Healthy     bool   `json:"healthy"`
+	// UnhealthyReason is the error of the last failed health check by frps.
+	UnhealthyReason string `json:"unhealthyReason,omitempty"`
+}
+
+type GroupInfo struct {
+	Name      string            `json:"name"`
+	ProxyType string            `json:"proxyType"`
+	Strategy  string            `json:"strategy"`
+	Members   []GroupMemberInfo `json:"members"`
+}
+
+type GetGroupInfoResp struct {
+	Groups []*GroupInfo `json:"groups"`
+}
+
+func (svr *Service) apiGroups(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	defer func() {
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
+
+	groupInfoResp := GetGroupInfoResp{
+		Groups: make([]*GroupInfo, 0),
+	}
+	stats := append(svr.rc.TCPGroupCtl.Stats(), svr.rc.HTTPGroupCtl.Stats()...)
+	for _, gs := range stats {
+		info := &GroupInfo{
+			Name:      gs.Name,
+			ProxyType: gs.ProxyType,
+			Strategy:  gs.Strategy,
+			Members:   make([]GroupMemberInfo, 0, len(gs.Members)),
+		}
+		for _, m := range gs.Members {
+			info.Members = append(info.Members, GroupMemberInfo{
+				Name:            m.Name,
+				Weight:          m.Weight,
+				ActiveConns:     m.ActiveConns,
+				TotalConns:      m.TotalConns,
+				Healthy:         m.Healthy,
+				UnhealthyReason: m.UnhealthyReason,
+			})
+		}
+		groupInfoResp.Groups = append(groupInfoResp.Groups, info)
+	}
+	slices.SortFunc(groupInfoResp.Groups, func(a, b *GroupInfo) int {
+		return cmp.Or(cmp.Compare(a.ProxyType, b.ProxyType), cmp.Compare(a.Name, b.Name))
+	})
+
+	buf, _ := json.Marshal(&groupInfoResp)
+	res.Msg = string(buf)
+}
+
+// /api/credentials/:name
+type VisitorCredentialInfo struct {
+	Name      string `json:"name"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Expired   bool   `json:"expired"`
+	Revoked   bool   `json:"revoked"`
+}
+
+type GetVisitorCredentialsResp struct {
+	ProxyName   string                   `json:"proxyName"`
+	Credentials []*VisitorCredentialInfo `json:"credentials"`
+}
+
+func (svr *Service) apiVisitorCredentials(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	name := params["name"]
+
+	defer func() {
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
+
+	pxy, ok := svr.pxyManager.GetByName(name)
+	if !ok {
+		res.Code = 404
+		res.Msg = "no proxy info found"
+		return
+	}
+	credentials, ok := visitorCredentials(pxy.GetConfigurer())
+	if !ok {
+		res.Code = 400
+		res.Msg = "only stcp, xtcp and sudp proxies have visitor credentials"
+		return
+	}
+
+	credentialsResp := GetVisitorCredentialsResp{
+		ProxyName:   name,
+		Credentials: make([]*VisitorCredentialInfo, 0, len(credentials)),
+	}
+	for _, status := range auth.GetVisitorCredentialStatus(name, credentials, svr.rc.VisitorRevocations) {
+		credentialsResp.Credentials = append(credentialsResp.Credentials, &VisitorCredentialInfo{
+			Name:      status.Name,
+			ExpiresAt: status.ExpiresAt,
+			Expired:   status.Expired,
+			Revoked:   status.Revoked,
+		})
+	}
+
+	buf, _ := json.Marshal(&credentialsResp)
+	res.Msg = string(buf)
+}
+
+// POST /api/credentials/:name/:credential/revoke
+func (svr *Service) revokeVisitorCredential(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	name := params["name"]
+	credential := params["credential"]
+
+	defer func() {
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
+
+	pxy, ok := svr.pxyManager.GetByName(name)
+	if !ok {
+		res.Code = 404
+		res.Msg = "no proxy info found"
+		return
+	}
+	credentials, _ := visitorCredentials(pxy.GetConfigurer())
+	if _, ok := v1.FindVisitorCredential(credentials, credential); !ok {
+		res.Code = 404
+		res.Msg = "no credential found"
+		return
+	}
+
+	svr.rc.VisitorRevocations.Revoke(name, credential)
+	closed := svr.rc.VisitorManager.CloseCredentialConns(name, credential)
+	svr.xl.Infof("visitor credential [%s] of proxy [%s] is revoked, %d visitor connections are closed", credential, name, closed)
+}
+
+// visitorCredentials returns the visitor credentials of stcp, xtcp and sudp proxies, false for other proxies.
+func visitorCredentials(cfg v1.ProxyConfigurer) ([]v1.VisitorCredential, bool) {
+	switch cfg := cfg.(type) {
+	case *v1.STCPProxyConfig:
+		return cfg.Credentials, true
+	case *v1.XTCPProxyConfig:
+		return cfg.Credentials, true
+	case *v1.SUDPProxyConfig:
+		return cfg.Credentials, true
+	}
+	return nil, false
+}
+
+// POST /api/credentials/:name/:credential/restore
+func (svr *Service) restoreVisitorCredential(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	name := params["name"]
+	credential := params["credential"]
+
+	defer func() {
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
+
+	if !svr.rc.VisitorRevocations.Restore(name, credential) {
+		res.Code = 404
+		res.Msg = "credential is not revoked"
+		return
+	}
+	svr.xl.Infof("visitor credential [%s] of proxy [%s] is restored", credential, name)
+}
+
+// POST /api/reload
+func (svr *Service) apiReload(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
+	defer func() {
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+
+	result, err := svr.Reload()
+	if err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		return
+	}
+	buf, _ := json.Marshal(result)
+	res.Msg = string(buf)
+}
+
 // DELETE /api/proxies?status=offline
 func (svr *Service) deleteProxies(w http.ResponseWriter, r *http.Request) {
 	res := GeneralResponse{Code: 200}
 
-	logx.Verbosef("Http request: [%s]", r.URL.Path)
+	svr.xl.Debugf("Http request: [%s]", r.URL.Path)
 	defer func() {
-		logx.Verbosef("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		svr.xl.Debugf("Http response [%s]: code [%d]", r.URL.Path, res.Code)
 		w.WriteHeader(res.Code)
 		if len(res.Msg) > 0 {
 			_, _ = w.Write([]byte(res.Msg))
@@ -401,5 +700,5 @@ func (svr *Service) deleteProxies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cleared, total := mem.StatsCollector.ClearOfflineProxies()
-	logx.Verbosef("cleared [%d] offline proxies, total [%d] proxies", cleared, total)
+	svr.xl.Debugf("cleared [%d] offline proxies, total [%d] proxies", cleared, total)
 }
@@ -0,0 +1,250 @@
+package event
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+type Type string
+
+const (
+	// TypeClientLogin is published after a client passed authentication and its control started.
+	TypeClientLogin Type = "ClientLogin"
+	// TypeClientLoginRejected is published when a login message is refused by plugins or the auth verifier.
+	TypeClientLoginRejected Type = "ClientLoginRejected"
+	// TypeControlReplaced is published when a client logs in again with a run id that is already online.
+	TypeControlReplaced Type = "ControlReplaced"
+	// TypeClientExit is published after a control is closed and all its proxies are released.
+	TypeClientExit Type = "ClientExit"
+	// TypeHeartbeatTimeout is published when no ping was received from a client in time.
+	TypeHeartbeatTimeout Type = "HeartbeatTimeout"
+	// TypeProxyRegistered is published after a proxy is running on the server.
+	TypeProxyRegistered Type = "ProxyRegistered"
+	// TypeProxyRegisterFailed is published when a NewProxy request from a client is refused.
+	TypeProxyRegisterFailed Type = "ProxyRegisterFailed"
+	// TypeProxyClosed is published after a proxy is closed, either by the client or when its control exits.
+	TypeProxyClosed Type = "ProxyClosed"
+	// TypeWorkConnOpened is published when a work connection is put into the pool of a control.
+	TypeWorkConnOpened Type = "WorkConnOpened"
+	// TypeUserConnAccepted is published when a user connection to a proxy passed all plugin checks.
+	TypeUserConnAccepted Type = "UserConnAccepted"
+)
+
+// Event describes something that happened in the lifecycle of a client or one of its proxies.
+// Fields that do not apply to the event type are left empty. The same Event is shared by all
+// subscribers, so it must not be modified.
+type Event struct {
+	Type Type
+	// Time is when the event happened.
+	Time time.Time
+
+	RunID      string
+	ClientAddr string
+	// Login describes the client, including user and metadatas.
+	Login LoginInfo
+	// LoginTime is when the client control was created, zero if the login was rejected.
+	LoginTime time.Time
+
+	ProxyName string
+	ProxyType string
+	// RemoteAddr is the address allocated for a proxy on the server.
+	RemoteAddr string
+	// NewProxy is the message used to register the proxy, after plugins modified it. Secrets are removed,
+	// see RedactNewProxy.
+	NewProxy *msg.NewProxy
+
+	// UserAddr is the address of the user connection for TypeUserConnAccepted.
+	UserAddr string
+	// NewRunID is the run id of the control taking over for TypeControlReplaced.
+	NewRunID string
+
+	// Error is the reason why a request was rejected.
+	Error string
+}
+
+// LoginInfo is the login message of a client without its credentials, events are delivered to every
+// subscriber and must not carry privilege keys.
+type LoginInfo struct {
+	Version  string
+	Hostname string
+	Os       string
+	Arch     string
+	User     string
+	RunID    string
+	Metas    map[string]string
+	// PoolCount is the number of work connections the client keeps in the pool.
+	PoolCount int
+	Features  []string
+}
+
+// NewLoginInfo copies the fields of m which can be shared with subscribers.
+func NewLoginInfo(m *msg.Login) LoginInfo {
+	return LoginInfo{
+		Version:   m.Version,
+		Hostname:  m.Hostname,
+		Os:        m.Os,
+		Arch:      m.Arch,
+		User:      m.User,
+		RunID:     m.RunID,
+		Metas:     maps.Clone(m.Metas),
+		PoolCount: m.PoolCount,
+		Features:  slices.Clone(m.Features),
+	}
+}
+
+// RedactNewProxy returns a copy of m without the group key, the http password and the secret keys of
+// visitors.
+func RedactNewProxy(m *msg.NewProxy) *msg.NewProxy {
+	ret := *m
+	ret.GroupKey = ""
+	ret.HTTPPwd = ""
+	ret.Sk = ""
+	ret.Credentials = slices.Clone(m.Credentials)
+	for i := range ret.Credentials {
+		ret.Credentials[i].Sk = ""
+	}
+	return &ret
+}
+
+// Handler is called for every event delivered to a subscription.
+type Handler func(e *Event)
+
+const defaultBufferSize = 128
+
+// Subscription receives events from a Bus through a buffered channel.
+// If the subscriber does not keep up, new events are dropped instead of blocking the publisher.
+type Subscription struct {
+	bus     *Bus
+	id      uint64
+	types   map[Type]struct{}
+	ch      chan *Event
+	dropped atomic.Uint64
+
+	closeOnce sync.Once
+}
+
+// C returns the channel events are delivered to. It is closed after Close is called.
+func (s *Subscription) C() <-chan *Event {
+	return s.ch
+}
+
+// Dropped returns the number of events discarded because the buffer of this subscription was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.bus.remove(s.id)
+	})
+}
+
+func (s *Subscription) match(t Type) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	_, ok := s.types[t]
+	return ok
+}
+
+// Bus dispatches events to multiple subscribers. Publish never blocks.
+type Bus struct {
+	subs   map[uint64]*Subscription
+	nextID uint64
+	closed bool
+
+	mu sync.RWMutex
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[uint64]*Subscription),
+	}
+}
+
+// Subscribe registers a new subscription for the given event types, all types if none is given.
+// bufferSize is the number of pending events kept for a slow subscriber, a default is used if it's not positive.
+func (b *Bus) Subscribe(bufferSize int, types ...Type) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	s := &Subscription{
+		bus:   b,
+		types: make(map[Type]struct{}, len(types)),
+		ch:    make(chan *Event, bufferSize),
+	}
+	for _, t := range types {
+		s.types[t] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// subscriptions of a closed bus would never receive events or be closed
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.nextID++
+	s.id = b.nextID
+	b.subs[s.id] = s
+	return s
+}
+
+// SubscribeFunc calls handler in a dedicated goroutine for every matched event until the subscription is closed.
+func (b *Bus) SubscribeFunc(bufferSize int, handler Handler, types ...Type) *Subscription {
+	s := b.Subscribe(bufferSize, types...)
+	go func() {
+		for e := range s.C() {
+			handler(e)
+		}
+	}()
+	return s
+}
+
+// Publish delivers e to all matched subscriptions. It's a no-op on a nil Bus.
+func (b *Bus) Publish(e *Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		if !s.match(e.Type) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close removes all subscriptions and closes their channels, subscriptions created later are closed at once.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for id, s := range b.subs {
+		close(s.ch)
+		delete(b.subs, id)
+	}
+}
+
+func (b *Bus) remove(id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s, ok := b.subs[id]; ok {
+		close(s.ch)
+		delete(b.subs, id)
+	}
+}
@@ -0,0 +1,88 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+func TestBusPublish(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewBus()
+
+	all := bus.Subscribe(10)
+	login := bus.Subscribe(10, TypeClientLogin)
+
+	bus.Publish(&Event{Type: TypeClientLogin, RunID: "a"})
+	bus.Publish(&Event{Type: TypeProxyRegistered, RunID: "a", ProxyName: "ssh"})
+
+	assert.Len(all.C(), 2)
+	assert.Len(login.C(), 1)
+	e := <-login.C()
+	assert.Equal("a", e.RunID)
+	assert.False(e.Time.IsZero())
+
+	login.Close()
+	_, ok := <-login.C()
+	assert.False(ok)
+	bus.Publish(&Event{Type: TypeClientLogin})
+	assert.Len(all.C(), 3)
+}
+
+func TestBusDropWhenFull(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewBus()
+
+	s := bus.Subscribe(1)
+	bus.Publish(&Event{Type: TypeClientLogin})
+	bus.Publish(&Event{Type: TypeClientExit})
+	assert.EqualValues(1, s.Dropped())
+	e := <-s.C()
+	assert.Equal(TypeClientLogin, e.Type)
+
+	var nilBus *Bus
+	nilBus.Publish(&Event{Type: TypeClientLogin})
+}
+
+func TestBusClose(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewBus()
+
+	s := bus.Subscribe(10)
+	bus.Close()
+	_, ok := <-s.C()
+	assert.False(ok)
+	s.Close()
+
+	late := bus.Subscribe(10)
+	_, ok = <-late.C()
+	assert.False(ok)
+	bus.Publish(&Event{Type: TypeClientLogin})
+}
+
+func TestEventsWithoutSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	login := &msg.Login{User: "alice", PrivilegeKey: "token", RunID: "a", Metas: map[string]string{"k": "v"}}
+	info := NewLoginInfo(login)
+	assert.Equal("alice", info.User)
+	info.Metas["k"] = "changed"
+	assert.Equal("v", login.Metas["k"])
+
+	newProxy := &msg.NewProxy{
+		ProxyName:   "ssh",
+		GroupKey:    "group",
+		HTTPPwd:     "pwd",
+		Sk:          "sk",
+		Credentials: []msg.VisitorCredential{{Name: "bob", Sk: "bob-sk"}},
+	}
+	redacted := RedactNewProxy(newProxy)
+	assert.Equal("ssh", redacted.ProxyName)
+	assert.Empty(redacted.GroupKey)
+	assert.Empty(redacted.HTTPPwd)
+	assert.Empty(redacted.Sk)
+	assert.Equal([]msg.VisitorCredential{{Name: "bob"}}, redacted.Credentials)
+	assert.Equal("bob-sk", newProxy.Credentials[0].Sk)
+}
@@ -0,0 +1,327 @@
+package group
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/samber/lo"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+// hashRingReplicas is the number of virtual nodes of a member with weight 1 in the hash ring.
+const hashRingReplicas = 64
+
+// MemberStats is the statistics of a proxy in a group. ActiveConns and TotalConns count requests instead of
+// connections for http groups.
+type MemberStats struct {
+	Name        string
+	Weight      int
+	ActiveConns int64
+	TotalConns  int64
+	Healthy     bool
+	// UnhealthyReason is the error of the last failed health check if the member is unhealthy.
+	UnhealthyReason string
+}
+
+// GroupStats is the statistics of a group.
+type GroupStats struct {
+	Name      string
+	ProxyType string
+	Strategy  string
+	Members   []MemberStats
+}
+
+type member struct {
+	name   string
+	weight int
+	// id identifies the member in sticky cookies without exposing the proxy name.
+	id string
+
+	activeConns atomic.Int64
+	totalConns  atomic.Int64
+
+	// currentWeight is used by the smooth weighted round robin, it's protected by balancer.mu.
+	currentWeight int
+	// unhealthy members are not chosen for new connections, they are protected by balancer.mu.
+	unhealthy       bool
+	unhealthyReason string
+}
+
+type ringNode struct {
+	hash   uint32
+	member *member
+}
+
+// balancer chooses a member of a group for new connections by the load balancing strategy of the group.
+type balancer struct {
+	strategy string
+	members  []*member
+	ring     []ringNode
+	index    uint64
+	mu       sync.RWMutex
+}
+
+func newBalancer(strategy string) *balancer {
+	if strategy == "" {
+		strategy = v1.LoadBalancerStrategyRoundRobin
+	}
+	return &balancer{
+		strategy: strategy,
+	}
+}
+
+func sameStrategy(strategy, other string) bool {
+	if strategy == "" {
+		strategy = v1.LoadBalancerStrategyRoundRobin
+	}
+	if other == "" {
+		other = v1.LoadBalancerStrategyRoundRobin
+	}
+	return strategy == other
+}
+
+func (b *balancer) add(name string, weight int) *member {
+	if weight <= 0 {
+		weight = 1
+	}
+	sum := sha256.Sum256([]byte(name))
+	m := &member{
+		name:   name,
+		weight: weight,
+		id:     hex.EncodeToString(sum[:8]),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members = append(b.members, m)
+	b.buildRing()
+	return m
+}
+
+func (b *balancer) remove(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members = slices.DeleteFunc(b.members, func(m *member) bool {
+		return m.name == name
+	})
+	b.buildRing()
+}
+
+// buildRing builds the consistent hash ring for the sourceIPHash strategy, b.mu must be held.
+func (b *balancer) buildRing() {
+	if b.strategy != v1.LoadBalancerStrategySourceIPHash {
+		return
+	}
+	b.ring = b.ring[:0]
+	for _, m := range b.members {
+		for i := 0; i < m.weight*hashRingReplicas; i++ {
+			b.ring = append(b.ring, ringNode{
+				hash:   crc32.ChecksumIEEE([]byte(m.name + "#" + strconv.Itoa(i))),
+				member: m,
+			})
+		}
+	}
+	slices.SortFunc(b.ring, func(a, b ringNode) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+}
+
+// setHealthy updates the health status of the member, reason is the error of the failed health check.
+func (b *balancer) setHealthy(name string, healthy bool, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		if m.name == name {
+			m.unhealthy = !healthy
+			m.unhealthyReason = lo.Ternary(healthy, "", reason)
+			if healthy {
+				m.currentWeight = 0
+			}
+		}
+	}
+}
+
+func (b *balancer) get(name string) *member {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, m := range b.members {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// choose returns a healthy member for a new connection, nil if there is none. The source address is used by
+// the sourceIPHash strategy and the sticky id from the cookie of the request by the stickyCookie strategy.
+func (b *balancer) choose(srcAddr, stickyID string) *member {
+	switch b.strategy {
+	case v1.LoadBalancerStrategyLeastConn:
+		return b.chooseLeastConn()
+	case v1.LoadBalancerStrategyWeighted:
+		return b.chooseWeighted()
+	case v1.LoadBalancerStrategySourceIPHash:
+		return b.chooseByHash(srcAddr)
+	case v1.LoadBalancerStrategyStickyCookie:
+		if stickyID != "" {
+			if m := b.chooseByID(stickyID); m != nil {
+				return m
+			}
+		}
+	}
+	return b.chooseRoundRobin()
+}
+
+func (b *balancer) chooseRoundRobin() *member {
+	newIndex := atomic.AddUint64(&b.index, 1)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := range b.members {
+		m := b.members[int((newIndex+uint64(i))%uint64(len(b.members)))]
+		if !m.unhealthy {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *balancer) chooseLeastConn() *member {
+	// start from a different member every time to spread connections among members with equal counts
+	newIndex := atomic.AddUint64(&b.index, 1)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var chosen *member
+	for i := range b.members {
+		m := b.members[int((newIndex+uint64(i))%uint64(len(b.members)))]
+		if m.unhealthy {
+			continue
+		}
+		if chosen == nil || m.activeConns.Load() < chosen.activeConns.Load() {
+			chosen = m
+		}
+	}
+	return chosen
+}
+
+// chooseWeighted implements the smooth weighted round robin of nginx.
+func (b *balancer) chooseWeighted() *member {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var (
+		chosen *member
+		total  int
+	)
+	for _, m := range b.members {
+		if m.unhealthy {
+			continue
+		}
+		m.currentWeight += m.weight
+		total += m.weight
+		if chosen == nil || m.currentWeight > chosen.currentWeight {
+			chosen = m
+		}
+	}
+	if chosen != nil {
+		chosen.currentWeight -= total
+	}
+	return chosen
+}
+
+func (b *balancer) chooseByHash(srcAddr string) *member {
+	ip := srcAddr
+	if host, _, err := net.SplitHostPort(srcAddr); err == nil {
+		ip = host
+	}
+	hash := crc32.ChecksumIEEE([]byte(ip))
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.ring) == 0 {
+		return nil
+	}
+	i, _ := slices.BinarySearchFunc(b.ring, hash, func(n ringNode, hash uint32) int {
+		return cmp.Compare(n.hash, hash)
+	})
+	// walk the ring clockwise to the first healthy member
+	for j := range b.ring {
+		if m := b.ring[(i+j)%len(b.ring)].member; !m.unhealthy {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *balancer) chooseByID(id string) *member {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, m := range b.members {
+		if m.id == id && !m.unhealthy {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *balancer) stats() []MemberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ret := make([]MemberStats, 0, len(b.members))
+	for _, m := range b.members {
+		ret = append(ret, MemberStats{
+			Name:            m.name,
+			Weight:          m.weight,
+			ActiveConns:     m.activeConns.Load(),
+			TotalConns:      m.totalConns.Load(),
+			Healthy:         !m.unhealthy,
+			UnhealthyReason: m.unhealthyReason,
+		})
+	}
+	return ret
+}
+
+// trackConn counts c as an active connection of m until it's closed.
+func (m *member) trackConn(c net.Conn) net.Conn {
+	m.activeConns.Add(1)
+	m.totalConns.Add(1)
+	return &trackedConn{Conn: c, m: m}
+}
+
+// startRequest counts an in-flight request of an http group until endRequest is called. The reverse proxy
+// keeps connections to proxies alive when they are idle, so requests rather than connections show the load.
+func (m *member) startRequest() {
+	m.activeConns.Add(1)
+	m.totalConns.Add(1)
+}
+
+func (m *member) endRequest() {
+	// the member may have been registered again while the request was served
+	for {
+		n := m.activeConns.Load()
+		if n <= 0 || m.activeConns.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	m    *member
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.m.activeConns.Add(-1)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,95 @@
+package group
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func chooseN(b *balancer, n int, srcAddr, stickyID string) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[b.choose(srcAddr, stickyID).name]++
+	}
+	return counts
+}
+
+func TestBalancerStrategies(t *testing.T) {
+	require := require.New(t)
+
+	b := newBalancer("")
+	require.Nil(b.choose("", ""))
+	b.add("a", 0)
+	b.add("b", 0)
+	require.Equal(map[string]int{"a": 5, "b": 5}, chooseN(b, 10, "", ""))
+
+	b = newBalancer(v1.LoadBalancerStrategyWeighted)
+	b.add("a", 3)
+	b.add("b", 1)
+	require.Equal(map[string]int{"a": 6, "b": 2}, chooseN(b, 8, "", ""))
+
+	b = newBalancer(v1.LoadBalancerStrategyLeastConn)
+	a := b.add("a", 1)
+	b.add("b", 1)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := a.trackConn(c1)
+	require.Equal(map[string]int{"b": 4}, chooseN(b, 4, "", ""))
+	require.NoError(conn.Close())
+	require.NoError(conn.Close())
+	require.Equal([]MemberStats{
+		{Name: "a", Weight: 1, ActiveConns: 0, TotalConns: 1, Healthy: true},
+		{Name: "b", Weight: 1, Healthy: true},
+	}, b.stats())
+
+	b = newBalancer(v1.LoadBalancerStrategySourceIPHash)
+	b.add("a", 1)
+	b.add("b", 1)
+	b.add("c", 1)
+	name := b.choose("10.0.0.1:1234", "").name
+	require.Equal(map[string]int{name: 10}, chooseN(b, 10, "10.0.0.1:5678", ""))
+	// only the clients of the removed member are moved
+	other := b.choose("10.0.0.2:1234", "").name
+	for _, n := range []string{"a", "b", "c"} {
+		if n != name && n != other {
+			b.remove(n)
+			break
+		}
+	}
+	require.Equal(name, b.choose("10.0.0.1:1234", "").name)
+	require.Equal(other, b.choose("10.0.0.2:1234", "").name)
+
+	b = newBalancer(v1.LoadBalancerStrategyStickyCookie)
+	b.add("a", 1)
+	m := b.add("b", 1)
+	require.Equal(map[string]int{"b": 10}, chooseN(b, 10, "", m.id))
+	require.Equal(map[string]int{"a": 1, "b": 1}, chooseN(b, 2, "", "unknown"))
+}
+
+func TestBalancerSkipUnhealthy(t *testing.T) {
+	require := require.New(t)
+
+	for _, strategy := range []string{
+		v1.LoadBalancerStrategyRoundRobin,
+		v1.LoadBalancerStrategyLeastConn,
+		v1.LoadBalancerStrategyWeighted,
+		v1.LoadBalancerStrategySourceIPHash,
+		v1.LoadBalancerStrategyStickyCookie,
+	} {
+		b := newBalancer(strategy)
+		a := b.add("a", 1)
+		b.add("b", 1)
+		b.setHealthy("a", false, "connection refused")
+		require.Equal(map[string]int{"b": 10}, chooseN(b, 10, "10.0.0.1:1234", a.id), strategy)
+		require.Equal("connection refused", b.stats()[0].UnhealthyReason)
+
+		b.setHealthy("b", false, "connection refused")
+		require.Nil(b.choose("10.0.0.1:1234", a.id), strategy)
+
+		b.setHealthy("a", true, "")
+		require.Equal(map[string]int{"a": 10}, chooseN(b, 10, "10.0.0.1:1234", a.id), strategy)
+	}
+}
@@ -3,12 +3,18 @@ package group
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"slices"
 	"sync"
-	"sync/atomic"
 
+	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/util/vhost"
 )
 
+// StickyCookieName is the cookie which pins the requests of a client to a proxy of an http group with the
+// stickyCookie strategy.
+const StickyCookieName = "frp_lb_sticky"
+
 type HTTPGroupController struct {
 	// groups indexed by group name
 	groups map[string]*HTTPGroup
@@ -28,10 +34,11 @@ func NewHTTPGroupController(vhostRouter *vhost.Routers) *HTTPGroupController {
 }
 
 func (ctl *HTTPGroupController) Register(
-	proxyName, group, groupKey string,
+	proxyName string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (err error) {
-	indexKey := group
+	indexKey := lb.Group
 	ctl.mu.Lock()
 	g, ok := ctl.groups[indexKey]
 	if !ok {
@@ -40,7 +47,7 @@ func (ctl *HTTPGroupController) Register(
 	}
 	ctl.mu.Unlock()
 
-	return g.Register(proxyName, group, groupKey, routeConfig)
+	return g.Register(proxyName, lb, routeConfig)
 }
 
 func (ctl *HTTPGroupController) UnRegister(proxyName, group string, _ vhost.RouteConfig) {
@@ -58,31 +65,72 @@ func (ctl *HTTPGroupController) UnRegister(proxyName, group string, _ vhost.Rout
 	}
 }
 
+// SetMemberHealth updates the health status of a proxy in a group, unhealthy proxies don't get new requests.
+func (ctl *HTTPGroupController) SetMemberHealth(group, proxyName string, healthy bool, reason string) {
+	ctl.mu.Lock()
+	g, ok := ctl.groups[group]
+	ctl.mu.Unlock()
+	if !ok {
+		return
+	}
+	g.mu.RLock()
+	b := g.balancer
+	g.mu.RUnlock()
+	if b != nil {
+		b.setHealthy(proxyName, healthy, reason)
+	}
+}
+
+// Stats returns the statistics of all http groups.
+func (ctl *HTTPGroupController) Stats() []GroupStats {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ret := make([]GroupStats, 0, len(ctl.groups))
+	for name, g := range ctl.groups {
+		g.mu.RLock()
+		b := g.balancer
+		g.mu.RUnlock()
+		if b == nil {
+			continue
+		}
+		ret = append(ret, GroupStats{
+			Name:      name,
+			ProxyType: string(v1.ProxyTypeHTTP),
+			Strategy:  b.strategy,
+			Members:   b.stats(),
+		})
+	}
+	return ret
+}
+
 type HTTPGroup struct {
 	group           string
 	groupKey        string
 	domain          string
 	location        string
 	routeByHTTPUser string
+	routeSpec       vhost.RouteSpec
 
 	// CreateConnFuncs indexed by proxy name
 	createFuncs map[string]vhost.CreateConnFunc
-	pxyNames    []string
-	index       uint64
-	ctl         *HTTPGroupController
-	mu          sync.RWMutex
+	// RequestDoneFuncs indexed by proxy name
+	requestDoneFuncs map[string]vhost.RequestDoneFunc
+	balancer         *balancer
+	ctl              *HTTPGroupController
+	mu               sync.RWMutex
 }
 
 func NewHTTPGroup(ctl *HTTPGroupController) *HTTPGroup {
 	return &HTTPGroup{
-		createFuncs: make(map[string]vhost.CreateConnFunc),
-		pxyNames:    make([]string, 0),
-		ctl:         ctl,
+		createFuncs:      make(map[string]vhost.CreateConnFunc),
+		requestDoneFuncs: make(map[string]vhost.RequestDoneFunc),
+		ctl:              ctl,
 	}
 }
 
 func (g *HTTPGroup) Register(
-	proxyName, group, groupKey string,
+	proxyName string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (err error) {
 	g.mu.Lock()
@@ -93,23 +141,31 @@ func (g *HTTPGroup) Register(
 		tmp.CreateConnFn = g.createConn
 		tmp.ChooseEndpointFn = g.chooseEndpoint
 		tmp.CreateConnByEndpointFn = g.createConnByEndpoint
-		err = g.ctl.vhostRouter.Add(routeConfig.Domain, routeConfig.Location, routeConfig.RouteByHTTPUser, &tmp)
+		tmp.RequestDoneFn = g.requestDone
+		if lb.Strategy == v1.LoadBalancerStrategyStickyCookie {
+			tmp.EndpointCookieFn = g.endpointCookie
+		}
+		err = g.ctl.vhostRouter.AddRoute(routeConfig.RouteSpec(), &tmp)
 		if err != nil {
 			return
 		}
 
-		g.group = group
-		g.groupKey = groupKey
+		g.group = lb.Group
+		g.groupKey = lb.GroupKey
+		g.balancer = newBalancer(lb.Strategy)
 		g.domain = routeConfig.Domain
 		g.location = routeConfig.Location
 		g.routeByHTTPUser = routeConfig.RouteByHTTPUser
+		g.routeSpec = routeConfig.RouteSpec()
 	} else {
-		if g.group != group || g.domain != routeConfig.Domain ||
-			g.location != routeConfig.Location || g.routeByHTTPUser != routeConfig.RouteByHTTPUser {
+		if g.group != lb.Group || g.domain != routeConfig.Domain ||
+			g.location != routeConfig.Location || g.routeByHTTPUser != routeConfig.RouteByHTTPUser ||
+			!slices.Equal(g.routeSpec.Methods, routeConfig.Methods) || g.routeSpec.Priority != routeConfig.Priority ||
+			!sameStrategy(g.balancer.strategy, lb.Strategy) {
 			err = ErrGroupParamsInvalid
 			return
 		}
-		if g.groupKey != groupKey {
+		if g.groupKey != lb.GroupKey {
 			err = ErrGroupAuthFailed
 			return
 		}
@@ -119,7 +175,8 @@ func (g *HTTPGroup) Register(
 		return
 	}
 	g.createFuncs[proxyName] = routeConfig.CreateConnFn
-	g.pxyNames = append(g.pxyNames, proxyName)
+	g.requestDoneFuncs[proxyName] = routeConfig.RequestDoneFn
+	g.balancer.add(proxyName, lb.Weight)
 	return nil
 }
 
@@ -127,32 +184,27 @@ func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	delete(g.createFuncs, proxyName)
-	for i, name := range g.pxyNames {
-		if name == proxyName {
-			g.pxyNames = append(g.pxyNames[:i], g.pxyNames[i+1:]...)
-			break
-		}
-	}
+	delete(g.requestDoneFuncs, proxyName)
+	g.balancer.remove(proxyName)
 
 	if len(g.createFuncs) == 0 {
 		isEmpty = true
-		g.ctl.vhostRouter.Del(g.domain, g.location, g.routeByHTTPUser)
+		g.ctl.vhostRouter.DelRoute(g.routeSpec)
 	}
 	return
 }
 
 func (g *HTTPGroup) createConn(remoteAddr string) (net.Conn, error) {
 	var f vhost.CreateConnFunc
-	newIndex := atomic.AddUint64(&g.index, 1)
 
 	g.mu.RLock()
 	group := g.group
 	domain := g.domain
 	location := g.location
 	routeByHTTPUser := g.routeByHTTPUser
-	if len(g.pxyNames) > 0 {
-		name := g.pxyNames[int(newIndex)%len(g.pxyNames)]
-		f = g.createFuncs[name]
+	m := g.balancer.choose(remoteAddr, "")
+	if m != nil {
+		f = g.createFuncs[m.name]
 	}
 	g.mu.RUnlock()
 
@@ -161,28 +213,34 @@ func (g *HTTPGroup) createConn(remoteAddr string) (net.Conn, error) {
 			group, domain, location, routeByHTTPUser)
 	}
 
-	return f(remoteAddr)
+	conn, err := f(remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return m.trackConn(conn), nil
 }
 
-func (g *HTTPGroup) chooseEndpoint() (string, error) {
-	newIndex := atomic.AddUint64(&g.index, 1)
-	name := ""
+func (g *HTTPGroup) chooseEndpoint(req *http.Request) (string, error) {
+	var stickyID string
+	if c, err := req.Cookie(StickyCookieName); err == nil {
+		stickyID = c.Value
+	}
 
 	g.mu.RLock()
 	group := g.group
 	domain := g.domain
 	location := g.location
 	routeByHTTPUser := g.routeByHTTPUser
-	if len(g.pxyNames) > 0 {
-		name = g.pxyNames[int(newIndex)%len(g.pxyNames)]
-	}
+	m := g.balancer.choose(req.RemoteAddr, stickyID)
 	g.mu.RUnlock()
 
-	if name == "" {
+	if m == nil {
 		return "", fmt.Errorf("no healthy endpoint for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
 			group, domain, location, routeByHTTPUser)
 	}
-	return name, nil
+	// the request is done in requestDone
+	m.startRequest()
+	return m.name, nil
 }
 
 func (g *HTTPGroup) createConnByEndpoint(endpoint, remoteAddr string) (net.Conn, error) {
@@ -194,5 +252,40 @@ func (g *HTTPGroup) createConnByEndpoint(endpoint, remoteAddr string) (net.Conn,
 	if f == nil {
 		return nil, fmt.Errorf("no CreateConnFunc for endpoint [%s] in group [%s]", endpoint, g.group)
 	}
+	// connections created for endpoints may be kept alive for later requests, requests are counted instead
 	return f(remoteAddr)
 }
+
+// requestDone ends the request counted by chooseEndpoint and passes its stats to the proxy of the group which
+// served it.
+func (g *HTTPGroup) requestDone(stats *vhost.RequestStats) {
+	g.mu.RLock()
+	f := g.requestDoneFuncs[stats.Endpoint]
+	m := g.balancer.get(stats.Endpoint)
+	g.mu.RUnlock()
+	if m != nil {
+		m.endRequest()
+	}
+	if f != nil {
+		f(stats)
+	}
+}
+
+// endpointCookie returns the sticky cookie for endpoint if the request doesn't carry it yet.
+func (g *HTTPGroup) endpointCookie(req *http.Request, endpoint string) *http.Cookie {
+	g.mu.RLock()
+	m := g.balancer.get(endpoint)
+	g.mu.RUnlock()
+	if m == nil {
+		return nil
+	}
+	if c, err := req.Cookie(StickyCookieName); err == nil && c.Value == m.id {
+		return nil
+	}
+	return &http.Cookie{
+		Name:     StickyCookieName,
+		Value:    m.id,
+		Path:     "/",
+		HttpOnly: true,
+	}
+}
@@ -0,0 +1,57 @@
+package group
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/util/vhost"
+)
+
+func TestHTTPGroupLeastConnCountsRequests(t *testing.T) {
+	require := require.New(t)
+
+	ctl := NewHTTPGroupController(vhost.NewRouters())
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	createConn := func(string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		conns = append(conns, c1, c2)
+		return c1, nil
+	}
+	lb := v1.LoadBalancerConfig{Group: "web", Strategy: v1.LoadBalancerStrategyLeastConn}
+	for _, name := range []string{"a", "b"} {
+		require.NoError(ctl.Register(name, lb, vhost.RouteConfig{ProxyName: name, Domain: "example.com", CreateConnFn: createConn}))
+	}
+	g := ctl.groups["web"]
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+
+	// the connection of a finished request stays open in the pool of the reverse proxy
+	first, err := g.chooseEndpoint(req)
+	require.NoError(err)
+	_, err = g.createConnByEndpoint(first, "")
+	require.NoError(err)
+	g.requestDone(&vhost.RequestStats{Endpoint: first})
+	require.EqualValues(0, g.balancer.get(first).activeConns.Load())
+
+	inFlight, err := g.chooseEndpoint(req)
+	require.NoError(err)
+	for i := 0; i < 4; i++ {
+		endpoint, err := g.chooseEndpoint(req)
+		require.NoError(err)
+		require.NotEqual(inFlight, endpoint)
+		g.requestDone(&vhost.RequestStats{Endpoint: endpoint})
+	}
+	g.requestDone(&vhost.RequestStats{Endpoint: inFlight})
+
+	for _, s := range ctl.Stats()[0].Members {
+		require.EqualValues(0, s.ActiveConns, s.Name)
+	}
+}
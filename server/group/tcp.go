@@ -19,8 +19,7 @@ import (
 	"strconv"
 	"sync"
 
-	gerr "github.com/fatedier/golib/errors"
-
+	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/server/ports"
 )
 
@@ -43,16 +42,16 @@ func NewTCPGroupCtl(portManager *ports.Manager) *TCPGroupCtl {
 
 // Listen is the wrapper for TCPGroup's Listen
 // If there are no group, we will create one here
-func (tgc *TCPGroupCtl) Listen(proxyName string, group string, groupKey string, addr string, port int) (l net.Listener, realPort int, err error) {
+func (tgc *TCPGroupCtl) Listen(proxyName string, lb v1.LoadBalancerConfig, addr string, port int) (l net.Listener, realPort int, err error) {
 	tgc.mu.Lock()
-	tcpGroup, ok := tgc.groups[group]
+	tcpGroup, ok := tgc.groups[lb.Group]
 	if !ok {
 		tcpGroup = NewTCPGroup(tgc)
-		tgc.groups[group] = tcpGroup
+		tgc.groups[lb.Group] = tcpGroup
 	}
 	tgc.mu.Unlock()
 
-	return tcpGroup.Listen(proxyName, group, groupKey, addr, port)
+	return tcpGroup.Listen(proxyName, lb, addr, port)
 }
 
 // RemoveGroup remove TCPGroup from controller
@@ -62,6 +61,44 @@ func (tgc *TCPGroupCtl) RemoveGroup(group string) {
 	delete(tgc.groups, group)
 }
 
+// SetMemberHealth updates the health status of a proxy in a group, unhealthy proxies don't get new connections.
+func (tgc *TCPGroupCtl) SetMemberHealth(group, proxyName string, healthy bool, reason string) {
+	tgc.mu.Lock()
+	tg, ok := tgc.groups[group]
+	tgc.mu.Unlock()
+	if !ok {
+		return
+	}
+	tg.mu.Lock()
+	b := tg.balancer
+	tg.mu.Unlock()
+	if b != nil {
+		b.setHealthy(proxyName, healthy, reason)
+	}
+}
+
+// Stats returns the statistics of all tcp groups.
+func (tgc *TCPGroupCtl) Stats() []GroupStats {
+	tgc.mu.Lock()
+	defer tgc.mu.Unlock()
+	ret := make([]GroupStats, 0, len(tgc.groups))
+	for name, tg := range tgc.groups {
+		tg.mu.Lock()
+		b := tg.balancer
+		tg.mu.Unlock()
+		if b == nil {
+			continue
+		}
+		ret = append(ret, GroupStats{
+			Name:      name,
+			ProxyType: string(v1.ProxyTypeTCP),
+			Strategy:  b.strategy,
+			Members:   b.stats(),
+		})
+	}
+	return ret
+}
+
 // TCPGroup route connections to different proxies
 type TCPGroup struct {
 	group    string
@@ -70,9 +107,9 @@ type TCPGroup struct {
 	port     int
 	realPort int
 
-	acceptCh chan net.Conn
 	tcpLn    net.Listener
 	lns      []*TCPGroupListener
+	balancer *balancer
 	ctl      *TCPGroupCtl
 	mu       sync.Mutex
 }
@@ -80,16 +117,15 @@ type TCPGroup struct {
 // NewTCPGroup return a new TCPGroup
 func NewTCPGroup(ctl *TCPGroupCtl) *TCPGroup {
 	return &TCPGroup{
-		lns:      make([]*TCPGroupListener, 0),
-		ctl:      ctl,
-		acceptCh: make(chan net.Conn),
+		lns: make([]*TCPGroupListener, 0),
+		ctl: ctl,
 	}
 }
 
 // Listen will return a new TCPGroupListener
 // if TCPGroup already has a listener, just add a new TCPGroupListener to the queues
 // otherwise, listen on the real address
-func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr string, port int) (ln *TCPGroupListener, realPort int, err error) {
+func (tg *TCPGroup) Listen(proxyName string, lb v1.LoadBalancerConfig, addr string, port int) (ln *TCPGroupListener, realPort int, err error) {
 	tg.mu.Lock()
 	defer tg.mu.Unlock()
 	if len(tg.lns) == 0 {
@@ -103,22 +139,19 @@ func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = errRet
 			return
 		}
-		ln = newTCPGroupListener(group, tg, tcpLn.Addr())
-
-		tg.group = group
-		tg.groupKey = groupKey
+		tg.group = lb.Group
+		tg.groupKey = lb.GroupKey
 		tg.addr = addr
 		tg.port = port
 		tg.realPort = realPort
 		tg.tcpLn = tcpLn
+		tg.balancer = newBalancer(lb.Strategy)
+		ln = newTCPGroupListener(lb.Group, tg, tcpLn.Addr(), tg.balancer.add(proxyName, lb.Weight))
 		tg.lns = append(tg.lns, ln)
-		if tg.acceptCh == nil {
-			tg.acceptCh = make(chan net.Conn)
-		}
 		go tg.worker()
 	} else {
 		// address and port in the same group must be equal
-		if tg.group != group || tg.addr != addr {
+		if tg.group != lb.Group || tg.addr != addr || !sameStrategy(tg.balancer.strategy, lb.Strategy) {
 			err = ErrGroupParamsInvalid
 			return
 		}
@@ -126,11 +159,11 @@ func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = ErrGroupDifferentPort
 			return
 		}
-		if tg.groupKey != groupKey {
+		if tg.groupKey != lb.GroupKey {
 			err = ErrGroupAuthFailed
 			return
 		}
-		ln = newTCPGroupListener(group, tg, tg.lns[0].Addr())
+		ln = newTCPGroupListener(lb.Group, tg, tg.lns[0].Addr(), tg.balancer.add(proxyName, lb.Weight))
 		realPort = tg.realPort
 		tg.lns = append(tg.lns, ln)
 	}
@@ -144,17 +177,36 @@ func (tg *TCPGroup) worker() {
 		if err != nil {
 			return
 		}
-		err = gerr.PanicToError(func() {
-			tg.acceptCh <- c
-		})
-		if err != nil {
-			return
-		}
+		tg.dispatch(c)
 	}
 }
 
-func (tg *TCPGroup) Accept() <-chan net.Conn {
-	return tg.acceptCh
+// dispatch passes c to the listener chosen by the load balancing strategy, it retries if the chosen listener
+// is closed meanwhile.
+func (tg *TCPGroup) dispatch(c net.Conn) {
+	for {
+		tg.mu.Lock()
+		var ln *TCPGroupListener
+		if m := tg.balancer.choose(c.RemoteAddr().String(), ""); m != nil {
+			for _, tmpLn := range tg.lns {
+				if tmpLn.member == m {
+					ln = tmpLn
+					break
+				}
+			}
+		}
+		tg.mu.Unlock()
+		if ln == nil {
+			c.Close()
+			return
+		}
+
+		select {
+		case ln.acceptCh <- c:
+			return
+		case <-ln.closeCh:
+		}
+	}
 }
 
 // CloseListener remove the TCPGroupListener from the TCPGroup
@@ -164,11 +216,11 @@ func (tg *TCPGroup) CloseListener(ln *TCPGroupListener) {
 	for i, tmpLn := range tg.lns {
 		if tmpLn == ln {
 			tg.lns = append(tg.lns[:i], tg.lns[i+1:]...)
+			tg.balancer.remove(ln.member.name)
 			break
 		}
 	}
 	if len(tg.lns) == 0 {
-		close(tg.acceptCh)
 		tg.tcpLn.Close()
 		tg.ctl.portManager.Release(tg.realPort)
 		tg.ctl.RemoveGroup(tg.group)
@@ -179,31 +231,31 @@ func (tg *TCPGroup) CloseListener(ln *TCPGroupListener) {
 type TCPGroupListener struct {
 	groupName string
 	group     *TCPGroup
+	member    *member
 
-	addr    net.Addr
-	closeCh chan struct{}
+	addr     net.Addr
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
 }
 
-func newTCPGroupListener(name string, group *TCPGroup, addr net.Addr) *TCPGroupListener {
+func newTCPGroupListener(name string, group *TCPGroup, addr net.Addr, m *member) *TCPGroupListener {
 	return &TCPGroupListener{
 		groupName: name,
 		group:     group,
+		member:    m,
 		addr:      addr,
+		acceptCh:  make(chan net.Conn),
 		closeCh:   make(chan struct{}),
 	}
 }
 
 // Accept will accept connections from TCPGroup
 func (ln *TCPGroupListener) Accept() (c net.Conn, err error) {
-	var ok bool
 	select {
 	case <-ln.closeCh:
 		return nil, ErrListenerClosed
-	case c, ok = <-ln.group.Accept():
-		if !ok {
-			return nil, ErrListenerClosed
-		}
-		return c, nil
+	case c = <-ln.acceptCh:
+		return ln.member.trackConn(c), nil
 	}
 }
 
@@ -0,0 +1,202 @@
+// Package health checks the proxies of groups on frps through work connections.
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/util/xlog"
+)
+
+var ErrHealthCheckType = errors.New("error health check type")
+
+// DialFunc returns a new work connection to the local service of the proxy.
+type DialFunc func() (net.Conn, error)
+
+// StatusFunc is called when the status of the proxy changes, err is the reason if it becomes unhealthy.
+type StatusFunc func(healthy bool, err error)
+
+type Checker struct {
+	checkType      string
+	interval       time.Duration
+	timeout        time.Duration
+	maxFailedTimes int
+
+	// For http
+	path   string
+	header http.Header
+
+	// For payload
+	send   []byte
+	expect []byte
+
+	dialFn      DialFunc
+	statusFn    StatusFunc
+	failedTimes int
+	healthy     bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewChecker creates a checker of a proxy, the proxy is considered healthy until checks fail. cfg should be
+// completed.
+func NewChecker(ctx context.Context, cfg v1.GroupHealthCheckConfig, dialFn DialFunc, statusFn StatusFunc) *Checker {
+	newctx, cancel := context.WithCancel(ctx)
+
+	path := cfg.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	header := make(http.Header)
+	for _, h := range cfg.HTTPHeaders {
+		header.Set(h.Name, h.Value)
+	}
+
+	return &Checker{
+		checkType:      cfg.Type,
+		interval:       time.Duration(cfg.IntervalSeconds) * time.Second,
+		timeout:        time.Duration(cfg.TimeoutSeconds) * time.Second,
+		maxFailedTimes: cfg.MaxFailed,
+		path:           path,
+		header:         header,
+		send:           []byte(cfg.Send),
+		expect:         []byte(cfg.Expect),
+		dialFn:         dialFn,
+		statusFn:       statusFn,
+		healthy:        true,
+		ctx:            newctx,
+		cancel:         cancel,
+	}
+}
+
+func (c *Checker) Start() {
+	go c.checkWorker()
+}
+
+func (c *Checker) Stop() {
+	c.cancel()
+}
+
+func (c *Checker) checkWorker() {
+	xl := xlog.FromContextSafe(c.ctx)
+	for {
+		doCtx, cancel := context.WithTimeout(c.ctx, c.timeout)
+		err := c.doCheck(doCtx)
+		cancel()
+
+		// check if this checker has been stopped
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			xl.Tracef("do one group health check success")
+			c.failedTimes = 0
+			if !c.healthy {
+				xl.Infof("group health check status change to success")
+				c.healthy = true
+				c.statusFn(true, nil)
+			}
+		} else {
+			xl.Warnf("do one group health check failed: %v", err)
+			c.failedTimes++
+			if c.healthy && c.failedTimes >= c.maxFailedTimes {
+				xl.Warnf("group health check status change to failed")
+				c.healthy = false
+				c.statusFn(false, err)
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *Checker) doCheck(ctx context.Context) error {
+	conn, err := c.dialFn()
+	if err != nil {
+		return fmt.Errorf("get work connection: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	switch c.checkType {
+	case "tcp":
+		return doTCPCheck(conn)
+	case "http":
+		return c.doHTTPCheck(ctx, conn)
+	case "payload":
+		return c.doPayloadCheck(conn)
+	default:
+		return ErrHealthCheckType
+	}
+}
+
+// doTCPCheck succeeds if the client doesn't close the work connection before the deadline of the check, the
+// client closes it if it can't connect to the local service.
+func doTCPCheck(conn net.Conn) error {
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return fmt.Errorf("work connection closed: %v", err)
+}
+
+func (c *Checker) doHTTPCheck(ctx context.Context, conn net.Conn) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://127.0.0.1"+c.path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header
+	if host := c.header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("do http health check, StatusCode is [%d] not 2xx", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Checker) doPayloadCheck(conn net.Conn) error {
+	if _, err := conn.Write(c.send); err != nil {
+		return err
+	}
+	if len(c.expect) == 0 {
+		return doTCPCheck(conn)
+	}
+	buf := make([]byte, len(c.expect))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("read response: %v", err)
+	}
+	if !bytes.Equal(buf, c.expect) {
+		return fmt.Errorf("unexpected response [%q]", buf)
+	}
+	return nil
+}
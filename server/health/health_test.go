@@ -0,0 +1,76 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+// pipeDialer returns a DialFunc whose connections are served by handler.
+func pipeDialer(handler func(c net.Conn)) DialFunc {
+	return func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			handler(c2)
+		}()
+		return c1, nil
+	}
+}
+
+func doCheck(cfg v1.GroupHealthCheckConfig, dialFn DialFunc) error {
+	cfg.Complete()
+	c := NewChecker(context.Background(), cfg, dialFn, func(bool, error) {})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.doCheck(ctx)
+}
+
+func TestCheckTypes(t *testing.T) {
+	require := require.New(t)
+
+	closed := pipeDialer(func(net.Conn) {})
+	silent := pipeDialer(func(c net.Conn) {
+		_, _ = io.Copy(io.Discard, c)
+	})
+	require.Error(doCheck(v1.GroupHealthCheckConfig{Type: "tcp"}, closed))
+	require.NoError(doCheck(v1.GroupHealthCheckConfig{Type: "tcp"}, silent))
+
+	echo := pipeDialer(func(c net.Conn) {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err == nil {
+			_, _ = c.Write(append([]byte("+"), buf...))
+		}
+	})
+	require.NoError(doCheck(v1.GroupHealthCheckConfig{Type: "payload", Send: "PING", Expect: "+PING"}, echo))
+	require.Error(doCheck(v1.GroupHealthCheckConfig{Type: "payload", Send: "PING", Expect: "+PONG"}, echo))
+
+	httpServer := func(code int) DialFunc {
+		return pipeDialer(func(c net.Conn) {
+			req, err := http.ReadRequest(bufio.NewReader(c))
+			if err != nil {
+				return
+			}
+			resp := &http.Response{StatusCode: code, ProtoMajor: 1, ProtoMinor: 1}
+			if req.URL.Path != "/healthz" || req.Host != "example.com" {
+				resp.StatusCode = http.StatusNotFound
+			}
+			_ = resp.Write(c)
+		})
+	}
+	cfg := v1.GroupHealthCheckConfig{
+		Type:        "http",
+		Path:        "healthz",
+		HTTPHeaders: []v1.HTTPHeader{{Name: "Host", Value: "example.com"}},
+	}
+	require.NoError(doCheck(cfg, httpServer(http.StatusOK)))
+	require.Error(doCheck(cfg, httpServer(http.StatusServiceUnavailable)))
+}
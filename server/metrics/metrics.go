@@ -13,8 +13,17 @@ type ServerMetrics interface {
 	CloseConnection(name string, proxyType string)
 	AddTrafficIn(name string, proxyType string, trafficBytes int64)
 	AddTrafficOut(name string, proxyType string, trafficBytes int64)
+	// RejectConnection is called when a user connection is refused before it's forwarded to the client.
+	RejectConnection(name string, proxyType string, reason string)
 }
 
+// Reasons of rejected user connections.
+const (
+	RejectReasonSourceACL = "source_acl"
+	RejectReasonPlugin    = "plugin"
+	RejectReasonQuota     = "quota"
+)
+
 var Server ServerMetrics = noopServerMetrics{}
 
 var registerMetrics sync.Once
@@ -27,11 +36,12 @@ func Register(m ServerMetrics) {
 
 type noopServerMetrics struct{}
 
-func (noopServerMetrics) NewClient()                          {}
-func (noopServerMetrics) CloseClient()                        {}
-func (noopServerMetrics) NewProxy(string, string)             {}
-func (noopServerMetrics) CloseProxy(string, string)           {}
-func (noopServerMetrics) OpenConnection(string, string)       {}
-func (noopServerMetrics) CloseConnection(string, string)      {}
-func (noopServerMetrics) AddTrafficIn(string, string, int64)  {}
-func (noopServerMetrics) AddTrafficOut(string, string, int64) {}
+func (noopServerMetrics) NewClient()                              {}
+func (noopServerMetrics) CloseClient()                            {}
+func (noopServerMetrics) NewProxy(string, string)                 {}
+func (noopServerMetrics) CloseProxy(string, string)               {}
+func (noopServerMetrics) OpenConnection(string, string)           {}
+func (noopServerMetrics) CloseConnection(string, string)          {}
+func (noopServerMetrics) AddTrafficIn(string, string, int64)      {}
+func (noopServerMetrics) AddTrafficOut(string, string, int64)     {}
+func (noopServerMetrics) RejectConnection(string, string, string) {}
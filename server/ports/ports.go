@@ -35,6 +35,7 @@ type Manager struct {
 	reservedPorts map[string]*PortCtx
 	usedPorts     map[int]*PortCtx
 	freePorts     map[int]struct{}
+	allowPorts    []types.PortsRange
 
 	bindAddr string
 	netType  string
@@ -49,23 +50,61 @@ func NewManager(netType string, bindAddr string, allowPorts []types.PortsRange)
 		bindAddr:      bindAddr,
 		netType:       netType,
 	}
+	pm.setAllowPorts(allowPorts)
+	go pm.cleanReservedPortsWorker()
+	return pm
+}
+
+// SetAllowPorts changes the ports which can be acquired. Used ports are kept until they are released even if
+// they are not allowed anymore.
+func (pm *Manager) SetAllowPorts(allowPorts []types.PortsRange) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.setAllowPorts(allowPorts)
+}
+
+func (pm *Manager) setAllowPorts(allowPorts []types.PortsRange) {
+	pm.allowPorts = allowPorts
+	pm.freePorts = make(map[int]struct{})
+	add := func(port int) {
+		if _, ok := pm.usedPorts[port]; !ok {
+			pm.freePorts[port] = struct{}{}
+		}
+	}
 	if len(allowPorts) > 0 {
 		for _, pair := range allowPorts {
 			if pair.Single > 0 {
-				pm.freePorts[pair.Single] = struct{}{}
+				add(pair.Single)
 			} else {
 				for i := pair.Start; i <= pair.End; i++ {
-					pm.freePorts[i] = struct{}{}
+					add(i)
 				}
 			}
 		}
 	} else {
 		for i := MinPort; i <= MaxPort; i++ {
-			pm.freePorts[i] = struct{}{}
+			add(i)
 		}
 	}
-	go pm.cleanReservedPortsWorker()
-	return pm
+}
+
+// IsAllowed returns true if the port can be acquired by the current allowPorts.
+func (pm *Manager) IsAllowed(port int) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.isAllowed(port)
+}
+
+func (pm *Manager) isAllowed(port int) bool {
+	if len(pm.allowPorts) == 0 {
+		return port >= MinPort && port <= MaxPort
+	}
+	for _, pair := range pm.allowPorts {
+		if pair.Single > 0 && pair.Single == port || pair.Single <= 0 && port >= pair.Start && port <= pair.End {
+			return true
+		}
+	}
+	return false
 }
 
 func (pm *Manager) Acquire(name string, port int) (realPort int, err error) {
@@ -166,7 +205,9 @@ func (pm *Manager) Release(port int) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	if ctx, ok := pm.usedPorts[port]; ok {
-		pm.freePorts[port] = struct{}{}
+		if pm.isAllowed(port) {
+			pm.freePorts[port] = struct{}{}
+		}
 		delete(pm.usedPorts, port)
 		ctx.Closed = true
 		ctx.UpdateTime = time.Now()
@@ -15,9 +15,12 @@
 package proxy
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"slices"
 	"strings"
 
 	libio "github.com/fatedier/golib/io"
@@ -27,6 +30,7 @@ import (
 	netpkg "github.com/iami317/hepx/pkg/util/net"
 	"github.com/iami317/hepx/pkg/util/util"
 	"github.com/iami317/hepx/pkg/util/vhost"
+	"github.com/iami317/hepx/server/accesslog"
 	"github.com/iami317/hepx/server/metrics"
 )
 
@@ -55,13 +59,18 @@ func NewHTTPProxy(baseProxy *BaseProxy) Proxy {
 func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 	xl := pxy.xl
 	routeConfig := vhost.RouteConfig{
+		ProxyName:       pxy.name,
+		User:            pxy.GetUserInfo().User,
 		RewriteHost:     pxy.cfg.HostHeaderRewrite,
 		RouteByHTTPUser: pxy.cfg.RouteByHTTPUser,
 		Headers:         pxy.cfg.RequestHeaders.Set,
 		ResponseHeaders: pxy.cfg.ResponseHeaders.Set,
 		Username:        pxy.cfg.HTTPUser,
 		Password:        pxy.cfg.HTTPPassword,
+		Methods:         pxy.cfg.Methods,
+		Priority:        pxy.cfg.RoutePriority,
 		CreateConnFn:    pxy.GetRealConn,
+		RequestDoneFn:   pxy.logRequest,
 	}
 
 	locations := pxy.cfg.Locations
@@ -89,7 +98,7 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.LoadBalancer.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer, routeConfig)
 				if err != nil {
 					return
 				}
@@ -107,6 +116,7 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 					pxy.rc.HTTPReverseProxy.UnRegister(tmpRouteConfig)
 				})
 			}
+			pxy.warnOverlappingRegexRoutes(routeConfig)
 			addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, pxy.serverCfg.VhostHTTPPort))
 			xl.Infof("http proxy listen for host [%s] location [%s] group [%s], routeByHTTPUser [%s]",
 				routeConfig.Domain, routeConfig.Location, pxy.cfg.LoadBalancer.Group, pxy.cfg.RouteByHTTPUser)
@@ -122,7 +132,7 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.LoadBalancer.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer, routeConfig)
 				if err != nil {
 					return
 				}
@@ -139,16 +149,30 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 					pxy.rc.HTTPReverseProxy.UnRegister(tmpRouteConfig)
 				})
 			}
+			pxy.warnOverlappingRegexRoutes(routeConfig)
 			addrs = append(addrs, util.CanonicalAddr(tmpRouteConfig.Domain, pxy.serverCfg.VhostHTTPPort))
 
 			xl.Infof("http proxy listen for host [%s] location [%s] group [%s], routeByHTTPUser [%s]",
 				routeConfig.Domain, routeConfig.Location, pxy.cfg.LoadBalancer.Group, pxy.cfg.RouteByHTTPUser)
 		}
 	}
+	pxy.startGroupHealthCheck(pxy.rc.HTTPGroupCtl.SetMemberHealth)
 	remoteAddr = strings.Join(addrs, ",")
 	return
 }
 
+// warnOverlappingRegexRoutes warns about regular expression routes of other proxies which may serve the
+// requests of routeConfig, as which proxy wins isn't obvious to users.
+func (pxy *HTTPProxy) warnOverlappingRegexRoutes(routeConfig vhost.RouteConfig) {
+	owners := slices.DeleteFunc(pxy.rc.HTTPReverseProxy.OverlappingRegexRoutes(routeConfig), func(owner string) bool {
+		return owner == routeConfig.ProxyName
+	})
+	if len(owners) > 0 {
+		pxy.xl.Warnf("http proxy route host [%s] location [%s] may overlap regex routes of %v with the same priority, "+
+			"set different priorities to decide which one serves the requests", routeConfig.Domain, routeConfig.Location, owners)
+	}
+}
+
 func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err error) {
 	xl := pxy.xl
 	rAddr, errRet := net.ResolveTCPAddr("tcp", remoteAddr)
@@ -157,9 +181,27 @@ func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err err
 		// we do not return error here since remoteAddr is not necessary for proxies without proxy protocol enabled
 	}
 
+	proxyType := pxy.GetConfigurer().GetBaseConfig().Type
+	if !pxy.sourceAllowed(remoteAddr) {
+		xl.Debugf("the user conn [%s] was rejected by source ACL", remoteAddr)
+		metrics.Server.RejectConnection(pxy.GetName(), proxyType, metrics.RejectReasonSourceACL)
+		err = &rejectedError{
+			reason: accesslog.ReasonSourceACL,
+			err:    fmt.Errorf("source address [%s] is not allowed", remoteAddr),
+		}
+		return
+	}
+	quotaLimiter, errRet := pxy.quota.Check()
+	if errRet != nil {
+		xl.Infof("the user conn [%s] was rejected, err:%v", remoteAddr, errRet)
+		metrics.Server.RejectConnection(pxy.GetName(), proxyType, metrics.RejectReasonQuota)
+		err = &rejectedError{reason: accesslog.ReasonQuotaExceeded, err: errRet}
+		return
+	}
+	pxy.publishUserConnAccepted(remoteAddr)
 	tmpConn, errRet := pxy.GetWorkConnFromPool(rAddr, nil)
 	if errRet != nil {
-		err = errRet
+		err = &rejectedError{reason: accesslog.ReasonNoWorkConn, err: errRet}
 		return
 	}
 
@@ -180,6 +222,7 @@ func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err err
 			return rwc.Close()
 		})
 	}
+	rwc = pxy.quota.Wrap(rwc, quotaLimiter)
 
 	workConn = netpkg.WrapReadWriteCloserToConn(rwc, tmpConn)
 	workConn = netpkg.WrapStatsConn(workConn, pxy.updateStatsAfterClosedConn)
@@ -187,6 +230,42 @@ func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err err
 	return
 }
 
+// rejectedError is returned by GetRealConn if the connection is not created, reason is the close reason of
+// the access log entry of the request.
+type rejectedError struct {
+	reason string
+	err    error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// logRequest writes an access log entry for a request served by this proxy. Connections to the local service
+// are kept alive and shared by requests, so http proxies log requests instead of connections.
+func (pxy *HTTPProxy) logRequest(stats *vhost.RequestStats) {
+	accessEntry := pxy.newAccessEntry(stats.RemoteAddr)
+	accessEntry.Time = stats.Time
+	accessEntry.Method = stats.Method
+	accessEntry.Host = stats.Host
+	accessEntry.Path = stats.Path
+	accessEntry.Status = stats.StatusCode
+	accessEntry.BytesIn = stats.BytesIn
+	accessEntry.BytesOut = stats.BytesOut
+	accessEntry.CloseReason = accesslog.ReasonClosed
+	var rejected *rejectedError
+	if errors.As(stats.Err, &rejected) {
+		accessEntry.CloseReason = rejected.reason
+	} else if stats.Err != nil {
+		accessEntry.CloseReason = stats.Err.Error()
+	}
+	pxy.rc.AccessLog.Log(accessEntry)
+}
+
 func (pxy *HTTPProxy) updateStatsAfterClosedConn(totalRead, totalWrite int64) {
 	name := pxy.GetName()
 	proxyType := pxy.GetConfigurer().GetBaseConfig().Type
@@ -45,7 +45,10 @@ func NewHTTPSProxy(baseProxy *BaseProxy) Proxy {
 
 func (pxy *HTTPSProxy) Run() (remoteAddr string, err error) {
 	xl := pxy.xl
-	routeConfig := &vhost.RouteConfig{}
+	routeConfig := &vhost.RouteConfig{
+		ProxyName: pxy.name,
+		User:      pxy.GetUserInfo().User,
+	}
 
 	defer func() {
 		if err != nil {
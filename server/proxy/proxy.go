@@ -16,6 +16,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -31,11 +32,16 @@ import (
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/msg"
 	plugin "github.com/iami317/hepx/pkg/plugin/server"
+	"github.com/iami317/hepx/pkg/proto/udp"
 	"github.com/iami317/hepx/pkg/util/limit"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
 	"github.com/iami317/hepx/pkg/util/xlog"
+	"github.com/iami317/hepx/server/accesslog"
 	"github.com/iami317/hepx/server/controller"
+	"github.com/iami317/hepx/server/event"
+	"github.com/iami317/hepx/server/health"
 	"github.com/iami317/hepx/server/metrics"
+	"github.com/iami317/hepx/server/quota"
 )
 
 var proxyFactoryRegistry = map[reflect.Type]func(*BaseProxy) Proxy{}
@@ -72,6 +78,15 @@ type BaseProxy struct {
 	userInfo      plugin.UserInfo
 	loginMsg      *msg.Login
 	configurer    v1.ProxyConfigurer
+	quota         *quota.Tracker
+	// source IPs of user connections must be allowed by all filters
+	sourceFilters []*netpkg.CIDRFilter
+	// healthChecker checks the proxy through work connections if it's in a group with health check
+	healthChecker *health.Checker
+	// datagramMux carries udp packets in QUIC datagrams if the client supports it
+	datagramMux *udp.DatagramMux
+	// datagramFlowID is sent to the client in StartWorkConn if it's not zero
+	datagramFlowID uint32
 
 	mu  sync.RWMutex
 	xl  *xlog.Logger
@@ -113,11 +128,53 @@ func (pxy *BaseProxy) GetConfigurer() v1.ProxyConfigurer {
 func (pxy *BaseProxy) Close() {
 	xl := xlog.FromContextSafe(pxy.ctx)
 	xl.Infof("proxy closing")
+	if pxy.healthChecker != nil {
+		pxy.healthChecker.Stop()
+	}
 	for _, l := range pxy.listeners {
 		l.Close()
 	}
 }
 
+// startGroupHealthCheck starts the health check of the proxy in its group if it's configured, setHealthFn
+// updates the status of the proxy in the group.
+func (pxy *BaseProxy) startGroupHealthCheck(setHealthFn func(group, proxyName string, healthy bool, reason string)) {
+	cfg := pxy.configurer.GetBaseConfig()
+	if cfg.LoadBalancer.Group == "" || cfg.LoadBalancer.HealthCheck.Type == "" {
+		return
+	}
+	pxy.healthChecker = health.NewChecker(pxy.ctx, cfg.LoadBalancer.HealthCheck, pxy.getHealthCheckConn,
+		func(healthy bool, err error) {
+			reason := ""
+			if err != nil {
+				reason = err.Error()
+			}
+			setHealthFn(cfg.LoadBalancer.Group, pxy.name, healthy, reason)
+		})
+	pxy.healthChecker.Start()
+}
+
+// getHealthCheckConn returns a work connection for health checks, the traffic isn't counted.
+func (pxy *BaseProxy) getHealthCheckConn() (net.Conn, error) {
+	workConn, err := pxy.GetWorkConnFromPool(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg := pxy.configurer.GetBaseConfig()
+	var rwc io.ReadWriteCloser = workConn
+	if cfg.Transport.UseEncryption {
+		rwc, err = libio.WithEncryption(rwc, []byte(pxy.serverCfg.Auth.Token))
+		if err != nil {
+			workConn.Close()
+			return nil, err
+		}
+	}
+	if cfg.Transport.UseCompression {
+		rwc = libio.WithCompression(rwc)
+	}
+	return netpkg.WrapReadWriteCloserToConn(rwc, workConn), nil
+}
+
 // GetWorkConnFromPool try to get a new work connections from pool
 // for quickly response, we immediately send the StartWorkConn message to frpc after take out one from pool
 func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
@@ -129,7 +186,6 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 			return
 		}
 		xl.Tracef("get a new work connection: [%s]", workConn.RemoteAddr().String())
-		xl.Spawn().AppendPrefix(pxy.GetName())
 		workConn = netpkg.NewContextConn(pxy.ctx, workConn)
 
 		var (
@@ -156,6 +212,8 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 			DstAddr:   dstAddr,
 			DstPort:   uint16(dstPort),
 			Error:     "",
+
+			DatagramFlowID: pxy.datagramFlowID,
 		})
 		if err != nil {
 			xl.Warnf("failed to send message to work connection from pool: %v, times: %d", err, i)
@@ -213,8 +271,18 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 	xl := xlog.FromContextSafe(pxy.Context())
 	defer userConn.Close()
 
+	accessEntry := pxy.newAccessEntry(userConn.RemoteAddr().String())
+	accessEntry.CloseReason = accesslog.ReasonClosed
+	defer pxy.rc.AccessLog.Log(accessEntry)
+
 	serverCfg := pxy.serverCfg
 	cfg := pxy.configurer.GetBaseConfig()
+	if !pxy.sourceAllowed(userConn.RemoteAddr().String()) {
+		xl.Debugf("the user conn [%s] was rejected by source ACL", userConn.RemoteAddr().String())
+		metrics.Server.RejectConnection(pxy.GetName(), cfg.Type, metrics.RejectReasonSourceACL)
+		accessEntry.CloseReason = accesslog.ReasonSourceACL
+		return
+	}
 	// server plugin hook
 	rc := pxy.GetResourceController()
 	content := &plugin.NewUserConnContent{
@@ -226,12 +294,23 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 	_, err := rc.PluginManager.NewUserConn(content)
 	if err != nil {
 		xl.Warnf("the user conn [%s] was rejected, err:%v", content.RemoteAddr, err)
+		metrics.Server.RejectConnection(pxy.GetName(), cfg.Type, metrics.RejectReasonPlugin)
+		accessEntry.CloseReason = accesslog.ReasonRejected
 		return
 	}
+	quotaLimiter, err := pxy.quota.Check()
+	if err != nil {
+		xl.Infof("the user conn [%s] was rejected, err:%v", content.RemoteAddr, err)
+		metrics.Server.RejectConnection(pxy.GetName(), cfg.Type, metrics.RejectReasonQuota)
+		accessEntry.CloseReason = accesslog.ReasonQuotaExceeded
+		return
+	}
+	pxy.publishUserConnAccepted(content.RemoteAddr)
 
 	// try all connections from the pool
 	workConn, err := pxy.GetWorkConnFromPool(userConn.RemoteAddr(), userConn.LocalAddr())
 	if err != nil {
+		accessEntry.CloseReason = accesslog.ReasonNoWorkConn
 		return
 	}
 	defer workConn.Close()
@@ -257,6 +336,7 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 			return local.Close()
 		})
 	}
+	local = pxy.quota.Wrap(local, quotaLimiter)
 
 	xl.Tracef("join connections, workConn(l[%s] r[%s]) userConn(l[%s] r[%s])", workConn.LocalAddr().String(),
 		workConn.RemoteAddr().String(), userConn.LocalAddr().String(), userConn.RemoteAddr().String())
@@ -264,13 +344,62 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 	name := pxy.GetName()
 	proxyType := cfg.Type
 	metrics.Server.OpenConnection(name, proxyType)
-	inCount, outCount, _ := libio.Join(local, userConn)
+	inCount, outCount, errs := libio.Join(local, userConn)
 	metrics.Server.CloseConnection(name, proxyType)
 	metrics.Server.AddTrafficIn(name, proxyType, inCount)
 	metrics.Server.AddTrafficOut(name, proxyType, outCount)
+	accessEntry.BytesIn = inCount
+	accessEntry.BytesOut = outCount
+	accessEntry.CloseReason = closeReason(errs)
 	xl.Tracef("join connections closed")
 }
 
+func (pxy *BaseProxy) publishUserConnAccepted(userAddr string) {
+	e := &event.Event{
+		Type:      event.TypeUserConnAccepted,
+		RunID:     pxy.userInfo.RunID,
+		ProxyName: pxy.GetName(),
+		ProxyType: pxy.configurer.GetBaseConfig().Type,
+		UserAddr:  userAddr,
+	}
+	if pxy.loginMsg != nil {
+		e.Login = event.NewLoginInfo(pxy.loginMsg)
+	}
+	pxy.rc.EventBus.Publish(e)
+}
+
+// sourceAllowed returns true if the source IP of addr is allowed by the server-wide and the proxy's CIDR lists.
+func (pxy *BaseProxy) sourceAllowed(addr string) bool {
+	for _, f := range pxy.sourceFilters {
+		if !f.AllowedAddr(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newAccessEntry returns an access log entry of a user connection from srcAddr accepted now.
+func (pxy *BaseProxy) newAccessEntry(srcAddr string) *accesslog.Entry {
+	return &accesslog.Entry{
+		Time:      time.Now(),
+		SrcAddr:   srcAddr,
+		ProxyName: pxy.GetName(),
+		ProxyType: pxy.configurer.GetBaseConfig().Type,
+		RunID:     pxy.userInfo.RunID,
+		User:      pxy.userInfo.User,
+	}
+}
+
+// closeReason returns the first unexpected error of joined connections, or accesslog.ReasonClosed.
+func closeReason(errs []error) string {
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			return err.Error()
+		}
+	}
+	return accesslog.ReasonClosed
+}
+
 type Options struct {
 	UserInfo           plugin.UserInfo
 	LoginMsg           *msg.Login
@@ -279,11 +408,16 @@ type Options struct {
 	GetWorkConnFn      GetWorkConnFn
 	Configurer         v1.ProxyConfigurer
 	ServerCfg          *v1.ServerConfig
+	Quota              *quota.Tracker
+	// DatagramMux is set if udp packets can be sent in QUIC datagrams of the client connection.
+	DatagramMux *udp.DatagramMux
 }
 
 func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
 	configurer := options.Configurer
-	xl := xlog.FromContextSafe(ctx).Spawn().AppendPrefix(configurer.GetBaseConfig().Name)
+	xl := xlog.FromContextSafe(ctx).Spawn().
+		AddPrefix(xlog.LogPrefix{Name: "proxyName", Value: configurer.GetBaseConfig().Name}).
+		AddPrefix(xlog.LogPrefix{Name: "proxyType", Value: configurer.GetBaseConfig().Type, Hidden: true})
 
 	var limiter *rate.Limiter
 	limitBytes := configurer.GetBaseConfig().Transport.BandwidthLimit.Bytes()
@@ -291,6 +425,20 @@ func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
 		limiter = rate.NewLimiter(rate.Limit(float64(limitBytes)), int(limitBytes))
 	}
 
+	var sourceFilters []*netpkg.CIDRFilter
+	for _, lists := range [][2][]string{
+		{options.ServerCfg.AllowSourceCIDRs, options.ServerCfg.DenySourceCIDRs},
+		{configurer.GetBaseConfig().AllowSourceCIDRs, configurer.GetBaseConfig().DenySourceCIDRs},
+	} {
+		f, err := netpkg.NewCIDRFilter(lists[0], lists[1])
+		if err != nil {
+			return nil, err
+		}
+		if !f.IsEmpty() {
+			sourceFilters = append(sourceFilters, f)
+		}
+	}
+
 	basePxy := BaseProxy{
 		name:          configurer.GetBaseConfig().Name,
 		rc:            options.ResourceController,
@@ -304,6 +452,9 @@ func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
 		userInfo:      options.UserInfo,
 		loginMsg:      options.LoginMsg,
 		configurer:    configurer,
+		quota:         options.Quota,
+		sourceFilters: sourceFilters,
+		datagramMux:   options.DatagramMux,
 	}
 
 	factory := proxyFactoryRegistry[reflect.TypeOf(configurer)]
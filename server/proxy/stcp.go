@@ -17,6 +17,7 @@ package proxy
 import (
 	"reflect"
 
+	"github.com/iami317/hepx/pkg/auth"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 )
 
@@ -47,7 +48,8 @@ func (pxy *STCPProxy) Run() (remoteAddr string, err error) {
 	if len(allowUsers) == 0 {
 		allowUsers = []string{pxy.GetUserInfo().User}
 	}
-	listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Secretkey, allowUsers)
+	verifier := auth.NewVisitorVerifier(pxy.GetName(), pxy.cfg.Secretkey, pxy.cfg.Credentials, pxy.rc.VisitorRevocations)
+	listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), verifier, allowUsers)
 	if errRet != nil {
 		err = errRet
 		return
@@ -51,6 +51,8 @@ func (pxy *TCPMuxProxy) httpConnectListen(
 	var l net.Listener
 	var err error
 	routeConfig := &vhost.RouteConfig{
+		ProxyName:       pxy.name,
+		User:            pxy.GetUserInfo().User,
 		Domain:          domain,
 		RouteByHTTPUser: routeByHTTPUser,
 		Username:        httpUser,
@@ -21,6 +21,7 @@ import (
 	"net"
 	"reflect"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/golib/errors"
@@ -31,6 +32,7 @@ import (
 	"github.com/iami317/hepx/pkg/proto/udp"
 	"github.com/iami317/hepx/pkg/util/limit"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
+	"github.com/iami317/hepx/server/accesslog"
 	"github.com/iami317/hepx/server/metrics"
 )
 
@@ -52,17 +54,26 @@ type UDPProxy struct {
 	workConn net.Conn
 
 	// sendCh is used for sending packages to workConn
-	sendCh chan *msg.UDPPacket
+	sendCh chan *udp.Packet
 
 	// readCh is used for reading packages from workConn
-	readCh chan *msg.UDPPacket
+	readCh chan *udp.Packet
 
 	// checkCloseCh is used for watching if workConn is closed
 	checkCloseCh chan int
 
+	// accessSessions logs user addresses as connections in the access log
+	accessSessions *accesslog.Sessions
+
+	// datagramConfirmed is set when the client has confirmed the datagram flow of the proxy
+	datagramConfirmed atomic.Bool
+
 	isClosed bool
 }
 
+// udpAccessSessionTimeout is the idle time after which a user address is logged as a closed connection.
+const udpAccessSessionTimeout = 60 * time.Second
+
 func NewUDPProxy(baseProxy *BaseProxy) Proxy {
 	unwrapped, ok := baseProxy.GetConfigurer().(*v1.UDPProxyConfig)
 	if !ok {
@@ -103,12 +114,58 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 	xl.Infof("udp proxy listen port [%d]", pxy.cfg.RemotePort)
 
 	pxy.udpConn = udpConn
-	pxy.sendCh = make(chan *msg.UDPPacket, 1024)
-	pxy.readCh = make(chan *msg.UDPPacket, 1024)
+	pxy.sendCh = make(chan *udp.Packet, 1024)
+	pxy.readCh = make(chan *udp.Packet, 1024)
 	pxy.checkCloseCh = make(chan int)
+	pxy.accessSessions = accesslog.NewSessions(pxy.rc.AccessLog, *pxy.newAccessEntry(""), udpAccessSessionTimeout)
+
+	// send packets from the client to users, packets of datagrams are dropped if readCh is full because
+	// blocking would stall the datagrams of all proxies of the client
+	handlePacket := func(m *udp.Packet, isDatagram bool) error {
+		return errors.PanicToError(func() {
+			xl.Tracef("get udp message from workConn, length: %d", len(m.Content))
+			length, remoteAddr := int64(len(m.Content)), m.RemoteAddr.String()
+			if !isDatagram {
+				pxy.readCh <- m
+			} else {
+				select {
+				case pxy.readCh <- m:
+				default:
+					xl.Tracef("drop udp datagram, too many packets to send to users")
+					return
+				}
+			}
+			metrics.Server.AddTrafficOut(
+				pxy.GetName(),
+				pxy.GetConfigurer().GetBaseConfig().Type,
+				length,
+			)
+			pxy.accessSessions.Add(remoteAddr, 0, length)
+		})
+	}
+	// Datagrams skip the work connection, so encryption and compression of it can't be applied to them. The
+	// traffic of datagrams is counted and limited here instead.
+	if pxy.datagramMux != nil && !pxy.cfg.Transport.UseEncryption && !pxy.cfg.Transport.UseCompression {
+		pxy.datagramFlowID = pxy.datagramMux.Allocate(func(m *udp.Packet) {
+			// any datagram of the flow means the client has registered it
+			if !pxy.datagramConfirmed.Swap(true) {
+				xl.Debugf("client confirmed the datagram flow, udp packets are sent in QUIC datagrams")
+			}
+			if m.RemoteAddr == nil {
+				return
+			}
+			length := len(m.Content)
+			pxy.quota.Add(int64(length))
+			if limiter := pxy.GetLimiter(); limiter != nil && !limiter.AllowN(time.Now(), length) {
+				xl.Tracef("drop udp datagram exceeding the bandwidth limit, length: %d", length)
+				return
+			}
+			_ = handlePacket(m, true)
+		})
+	}
 
 	// read message from workConn, if it returns any error, notify proxy to start a new workConn
-	workConnReaderFn := func(conn net.Conn) {
+	workConnReaderFn := func(conn *udp.FramedConn) {
 		for {
 			var (
 				rawMsg msg.Message
@@ -117,7 +174,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 			xl.Tracef("loop waiting message from udp workConn")
 			// client will send heartbeat in workConn for keeping alive
 			_ = conn.SetReadDeadline(time.Now().Add(time.Duration(60) * time.Second))
-			if rawMsg, errRet = msg.ReadMsg(conn); errRet != nil {
+			if rawMsg, errRet = conn.ReadMsg(); errRet != nil {
 				xl.Warnf("read from workConn for udp error: %v", errRet)
 				_ = conn.Close()
 				// notify proxy to start a new work connection
@@ -134,16 +191,8 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 			case *msg.Ping:
 				xl.Tracef("udp work conn get ping message")
 				continue
-			case *msg.UDPPacket:
-				if errRet := errors.PanicToError(func() {
-					xl.Tracef("get udp message from workConn: %s", m.Content)
-					pxy.readCh <- m
-					metrics.Server.AddTrafficOut(
-						pxy.GetName(),
-						pxy.GetConfigurer().GetBaseConfig().Type,
-						int64(len(m.Content)),
-					)
-				}); errRet != nil {
+			case *udp.Packet:
+				if errRet := handlePacket(m, false); errRet != nil {
 					conn.Close()
 					xl.Infof("reader goroutine for udp work connection closed")
 					return
@@ -153,7 +202,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 	}
 
 	// send message to workConn
-	workConnSenderFn := func(conn net.Conn, ctx context.Context) {
+	workConnSenderFn := func(conn *udp.FramedConn, ctx context.Context) {
 		var errRet error
 		for {
 			select {
@@ -162,17 +211,43 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 					xl.Infof("sender goroutine for udp work connection closed")
 					return
 				}
-				if errRet = msg.WriteMsg(conn, udpMsg); errRet != nil {
+				if !pxy.sourceAllowed(udpMsg.RemoteAddr.String()) {
+					xl.Tracef("drop udp packet from [%s] rejected by source ACL", udpMsg.RemoteAddr)
+					metrics.Server.RejectConnection(pxy.GetName(), pxy.GetConfigurer().GetBaseConfig().Type, metrics.RejectReasonSourceACL)
+					udpMsg.Release()
+					continue
+				}
+				// packets from users are dropped while a rejecting traffic quota is exceeded
+				quotaLimiter, err := pxy.quota.Check()
+				if err != nil {
+					xl.Tracef("drop udp packet: %v", err)
+					udpMsg.Release()
+					continue
+				}
+				length, remoteAddr := int64(len(udpMsg.Content)), udpMsg.RemoteAddr.String()
+				if quotaLimiter != nil {
+					if err := limit.WaitN(ctx, quotaLimiter, int(length)); err != nil {
+						xl.Tracef("drop udp packet: %v", err)
+						udpMsg.Release()
+						continue
+					}
+				}
+				// the packet is released after it's sent
+				if pxy.datagramConfirmed.Load() && pxy.sendDatagram(ctx, udpMsg) {
+					xl.Tracef("send udp datagram, length: %d", length)
+				} else if errRet = conn.WriteMsg(udpMsg); errRet != nil {
 					xl.Infof("sender goroutine for udp work connection closed: %v", errRet)
 					conn.Close()
 					return
+				} else {
+					xl.Tracef("send message to udp workConn, length: %d", length)
 				}
-				xl.Tracef("send message to udp workConn: %s", udpMsg.Content)
 				metrics.Server.AddTrafficIn(
 					pxy.GetName(),
 					pxy.GetConfigurer().GetBaseConfig().Type,
-					int64(len(udpMsg.Content)),
+					length,
 				)
+				pxy.accessSessions.Add(remoteAddr, length, 0)
 				continue
 			case <-ctx.Done():
 				xl.Infof("sender goroutine for udp work connection closed")
@@ -221,11 +296,13 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 					return rwc.Close()
 				})
 			}
+			rwc = pxy.quota.Wrap(rwc, nil)
 
 			pxy.workConn = netpkg.WrapReadWriteCloserToConn(rwc, workConn)
+			framedConn := udp.NewFramedConn(pxy.workConn)
 			ctx, cancel := context.WithCancel(context.Background())
-			go workConnReaderFn(pxy.workConn)
-			go workConnSenderFn(pxy.workConn, ctx)
+			go workConnReaderFn(framedConn)
+			go workConnSenderFn(framedConn, ctx)
 			_, ok := <-pxy.checkCloseCh
 			cancel()
 			if !ok {
@@ -245,6 +322,22 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 	return remoteAddr, nil
 }
 
+// sendDatagram sends p in a datagram after waiting for the bandwidth limit, the traffic is counted to the
+// traffic quota. It returns false if p should be sent through the work connection.
+func (pxy *UDPProxy) sendDatagram(ctx context.Context, p *udp.Packet) bool {
+	length := len(p.Content)
+	if limiter := pxy.GetLimiter(); limiter != nil {
+		if err := limit.WaitN(ctx, limiter, length); err != nil {
+			return false
+		}
+	}
+	if !pxy.datagramMux.Send(pxy.datagramFlowID, p) {
+		return false
+	}
+	pxy.quota.Add(int64(length))
+	return true
+}
+
 func (pxy *UDPProxy) Close() {
 	pxy.mu.Lock()
 	defer pxy.mu.Unlock()
@@ -256,6 +349,10 @@ func (pxy *UDPProxy) Close() {
 			pxy.workConn.Close()
 		}
 		pxy.udpConn.Close()
+		pxy.accessSessions.Close()
+		if pxy.datagramMux != nil {
+			pxy.datagramMux.Unregister(pxy.datagramFlowID)
+		}
 
 		// all channels only closed here
 		close(pxy.checkCloseCh)
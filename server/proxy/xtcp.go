@@ -20,8 +20,10 @@ import (
 
 	"github.com/fatedier/golib/errors"
 
+	"github.com/iami317/hepx/pkg/auth"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	"github.com/iami317/hepx/pkg/msg"
+	"github.com/iami317/hepx/server/accesslog"
 )
 
 func init() {
@@ -58,7 +60,8 @@ func (pxy *XTCPProxy) Run() (remoteAddr string, err error) {
 	if len(allowUsers) == 0 {
 		allowUsers = []string{pxy.GetUserInfo().User}
 	}
-	sidCh, err := pxy.rc.NatHoleController.ListenClient(pxy.GetName(), pxy.cfg.Secretkey, allowUsers)
+	verifier := auth.NewVisitorVerifier(pxy.GetName(), pxy.cfg.Secretkey, pxy.cfg.Credentials, pxy.rc.VisitorRevocations)
+	sidCh, err := pxy.rc.NatHoleController.ListenClient(pxy.GetName(), verifier, allowUsers)
 	if err != nil {
 		return "", err
 	}
@@ -80,6 +83,14 @@ func (pxy *XTCPProxy) Run() (remoteAddr string, err error) {
 					xl.Warnf("write nat hole sid package error, %v", errRet)
 				}
 				workConn.Close()
+
+				// traffic of xtcp goes between the visitor and the client directly, only the session is logged
+				accessEntry := pxy.newAccessEntry("")
+				accessEntry.CloseReason = accesslog.ReasonNatHoleSession
+				if errRet != nil {
+					accessEntry.CloseReason = errRet.Error()
+				}
+				pxy.rc.AccessLog.Log(accessEntry)
 			}
 		}
 	}()
@@ -0,0 +1,414 @@
+// Package quota counts the traffic of clients against the trafficQuota rules of frps.
+package quota
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	libio "github.com/fatedier/golib/io"
+	"golang.org/x/time/rate"
+
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+	"github.com/iami317/hepx/pkg/util/limit"
+	"github.com/iami317/hepx/pkg/util/xlog"
+)
+
+var ErrExceeded = errors.New("traffic quota exceeded")
+
+// Status is a snapshot of the counter of a rule for one value of its key.
+type Status struct {
+	Rule   string `json:"rule"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Period string `json:"period"`
+	Action string `json:"action"`
+	Limit  int64  `json:"limit"`
+	Used   int64  `json:"used"`
+}
+
+func (s *Status) Exceeded() bool {
+	return s.Used >= s.Limit
+}
+
+type counter struct {
+	rule  *v1.TrafficQuotaRule
+	value string
+	// limiter is shared by all connections throttled by this counter
+	limiter *rate.Limiter
+
+	mu     sync.Mutex
+	period string
+	used   int64
+}
+
+func newCounter(rule *v1.TrafficQuotaRule, value string) *counter {
+	c := &counter{
+		rule:  rule,
+		value: value,
+	}
+	if rule.Action == v1.TrafficQuotaActionThrottle {
+		rateBytes := rule.ThrottleRate.Bytes()
+		c.limiter = rate.NewLimiter(rate.Limit(float64(rateBytes)), int(rateBytes))
+	}
+	return c
+}
+
+func counterID(rule string, value string) string {
+	return rule + "/" + value
+}
+
+// roll resets the counter if a new period has begun, c.mu must be held.
+func (c *counter) roll(now time.Time) {
+	if period := periodOf(c.rule.Period, now); period != c.period {
+		c.period = period
+		c.used = 0
+	}
+}
+
+// add counts n bytes and returns true if the limit is reached by them.
+func (c *counter) add(n int64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(now)
+	limit := c.rule.Limit.Bytes()
+	reached := c.used < limit && c.used+n >= limit
+	c.used += n
+	return reached
+}
+
+func (c *counter) status(now time.Time) Status {
+	c.mu.Lock()
+	c.roll(now)
+	used := c.used
+	c.mu.Unlock()
+	return Status{
+		Rule:   c.rule.Name,
+		Key:    c.rule.Key,
+		Value:  c.value,
+		Period: c.rule.Period,
+		Action: c.rule.Action,
+		Limit:  c.rule.Limit.Bytes(),
+		Used:   used,
+	}
+}
+
+func (c *counter) usage(now time.Time) Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(now)
+	return Usage{
+		Period: c.period,
+		Bytes:  c.used,
+	}
+}
+
+func periodOf(period string, t time.Time) string {
+	switch period {
+	case v1.TrafficQuotaPeriodDaily:
+		return t.Format("2006-01-02")
+	case v1.TrafficQuotaPeriodMonthly:
+		return t.Format("2006-01")
+	default:
+		return ""
+	}
+}
+
+// Manager holds the counters of all rules. A nil Manager counts nothing and limits nothing.
+type Manager struct {
+	cfg        *v1.TrafficQuotaConfig
+	store      Store
+	onExceeded func(Status)
+	now        func() time.Time
+	xl         *xlog.Logger
+
+	counters map[string]*counter
+	mu       sync.Mutex
+}
+
+// NewManager returns nil if there is no rule in cfg.
+func NewManager(cfg *v1.TrafficQuotaConfig) *Manager {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	return &Manager{
+		cfg:      cfg,
+		store:    NewFileStore(cfg.StoreFile),
+		now:      time.Now,
+		xl:       xlog.New(),
+		counters: make(map[string]*counter),
+	}
+}
+
+// SetLogger sets the logger of the manager. It should be called before Run.
+func (m *Manager) SetLogger(xl *xlog.Logger) {
+	if m == nil {
+		return
+	}
+	m.xl = xl
+}
+
+// SetStore replaces the default file store. It should be called before Load.
+func (m *Manager) SetStore(store Store) {
+	if m == nil {
+		return
+	}
+	m.store = store
+}
+
+// OnExceeded sets the function called in a new goroutine when a counter reaches its limit.
+func (m *Manager) OnExceeded(fn func(Status)) {
+	if m == nil {
+		return
+	}
+	m.onExceeded = fn
+}
+
+// Load restores counters from the store, usages of removed rules are dropped.
+func (m *Manager) Load() error {
+	if m == nil {
+		return nil
+	}
+	usages, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.cfg.Rules {
+		rule := &m.cfg.Rules[i]
+		prefix := rule.Name + "/"
+		for id, usage := range usages {
+			value, ok := strings.CutPrefix(id, prefix)
+			if !ok {
+				continue
+			}
+			c := newCounter(rule, value)
+			c.period = usage.Period
+			c.used = usage.Bytes
+			m.counters[id] = c
+		}
+	}
+	return nil
+}
+
+// Save writes all counters to the store.
+func (m *Manager) Save() error {
+	if m == nil {
+		return nil
+	}
+	now := m.now()
+	m.mu.Lock()
+	usages := make(map[string]Usage, len(m.counters))
+	for id, c := range m.counters {
+		usages[id] = c.usage(now)
+	}
+	m.mu.Unlock()
+	return m.store.Save(usages)
+}
+
+// Run saves counters periodically until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(m.cfg.SaveInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Save(); err != nil {
+				m.xl.Warnf("save traffic quota usages error: %v", err)
+			}
+		case <-ctx.Done():
+			if err := m.Save(); err != nil {
+				m.xl.Warnf("save traffic quota usages error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (m *Manager) getCounter(rule *v1.TrafficQuotaRule, value string) *counter {
+	id := counterID(rule.Name, value)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[id]
+	if !ok {
+		c = newCounter(rule, value)
+		m.counters[id] = c
+	}
+	return c
+}
+
+// Tracker returns the tracker of the rules matched by the client of loginMsg.
+// It returns nil if no rule matches.
+func (m *Manager) Tracker(loginMsg *msg.Login) *Tracker {
+	if m == nil {
+		return nil
+	}
+	var counters []*counter
+	for i := range m.cfg.Rules {
+		rule := &m.cfg.Rules[i]
+		value := keyValue(rule.Key, loginMsg)
+		if value == "" || (len(rule.Values) > 0 && !slices.Contains(rule.Values, value)) {
+			continue
+		}
+		counters = append(counters, m.getCounter(rule, value))
+	}
+	if len(counters) == 0 {
+		return nil
+	}
+	return &Tracker{
+		m:        m,
+		counters: counters,
+	}
+}
+
+// Statuses returns the status of all counters sorted by rule and value.
+func (m *Manager) Statuses() []Status {
+	if m == nil {
+		return nil
+	}
+	now := m.now()
+	m.mu.Lock()
+	statuses := make([]Status, 0, len(m.counters))
+	for _, c := range m.counters {
+		statuses = append(statuses, c.status(now))
+	}
+	m.mu.Unlock()
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return cmp.Or(cmp.Compare(a.Rule, b.Rule), cmp.Compare(a.Value, b.Value))
+	})
+	return statuses
+}
+
+func keyValue(key string, loginMsg *msg.Login) string {
+	switch key {
+	case v1.TrafficQuotaKeyUser:
+		return loginMsg.User
+	case v1.TrafficQuotaKeyRunID:
+		return loginMsg.RunID
+	}
+	if metaKey, ok := strings.CutPrefix(key, v1.TrafficQuotaKeyMetaPrefix); ok {
+		return loginMsg.Metas[metaKey]
+	}
+	return ""
+}
+
+// Tracker counts the traffic of one client. A nil Tracker counts nothing and limits nothing.
+type Tracker struct {
+	m        *Manager
+	counters []*counter
+}
+
+// Check is called before a new user connection is accepted. It returns an error wrapping ErrExceeded
+// if a rejecting rule is exceeded, or the limiter to throttle the connection with if a throttling rule is exceeded.
+func (t *Tracker) Check() (*rate.Limiter, error) {
+	if t == nil {
+		return nil, nil
+	}
+	now := t.m.now()
+	var limiter *rate.Limiter
+	for _, c := range t.counters {
+		s := c.status(now)
+		if !s.Exceeded() {
+			continue
+		}
+		if c.limiter == nil {
+			return nil, fmt.Errorf("%w: rule [%s]", ErrExceeded, s.Rule)
+		}
+		if limiter == nil || c.limiter.Limit() < limiter.Limit() {
+			limiter = c.limiter
+		}
+	}
+	return limiter, nil
+}
+
+// Add counts n bytes to all counters of the tracker.
+func (t *Tracker) Add(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	now := t.m.now()
+	for _, c := range t.counters {
+		if c.add(n, now) {
+			s := c.status(now)
+			t.m.xl.Warnf("traffic quota of rule [%s] exceeded by [%s], used %d bytes, limit %d bytes", s.Rule, s.Value, s.Used, s.Limit)
+			if t.m.onExceeded != nil {
+				go t.m.onExceeded(s)
+			}
+		}
+	}
+}
+
+// Exceeded returns the statuses of all exceeded counters of the tracker.
+func (t *Tracker) Exceeded() []Status {
+	if t == nil {
+		return nil
+	}
+	now := t.m.now()
+	var statuses []Status
+	for _, c := range t.counters {
+		if s := c.status(now); s.Exceeded() {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+// Has returns true if the tracker counts for the rule and value.
+func (t *Tracker) Has(rule string, value string) bool {
+	if t == nil {
+		return false
+	}
+	return slices.ContainsFunc(t.counters, func(c *counter) bool {
+		return c.rule.Name == rule && c.value == value
+	})
+}
+
+// Wrap counts the traffic of rwc, and throttles it if limiter isn't nil.
+func (t *Tracker) Wrap(rwc io.ReadWriteCloser, limiter *rate.Limiter) io.ReadWriteCloser {
+	if t == nil {
+		return rwc
+	}
+	var (
+		r io.Reader = &countReader{r: rwc, t: t}
+		w io.Writer = &countWriter{w: rwc, t: t}
+	)
+	if limiter != nil {
+		r = limit.NewReader(r, limiter)
+		w = limit.NewWriter(w, limiter)
+	}
+	return libio.WrapReadWriteCloser(r, w, rwc.Close)
+}
+
+type countReader struct {
+	r io.Reader
+	t *Tracker
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.t.Add(int64(n))
+	return
+}
+
+type countWriter struct {
+	w io.Writer
+	t *Tracker
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.t.Add(int64(n))
+	return
+}
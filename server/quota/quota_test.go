@@ -0,0 +1,100 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/config/types"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/msg"
+)
+
+func newTestConfig(t *testing.T, rules ...v1.TrafficQuotaRule) *v1.TrafficQuotaConfig {
+	cfg := &v1.TrafficQuotaConfig{
+		StoreFile: filepath.Join(t.TempDir(), "quota.json"),
+		Rules:     rules,
+	}
+	cfg.Complete()
+	return cfg
+}
+
+func mustQuantity(s string) types.BandwidthQuantity {
+	q, err := types.NewBandwidthQuantity(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func TestTrackerCheck(t *testing.T) {
+	require := require.New(t)
+	cfg := newTestConfig(t,
+		v1.TrafficQuotaRule{Name: "users", Key: "user", Limit: mustQuantity("1KB")},
+		v1.TrafficQuotaRule{
+			Name: "team", Key: "meta:team", Values: []string{"a"}, Limit: mustQuantity("2KB"),
+			Action: v1.TrafficQuotaActionThrottle, ThrottleRate: mustQuantity("1KB"),
+		},
+	)
+	m := NewManager(cfg)
+
+	require.Nil(m.Tracker(&msg.Login{Metas: map[string]string{"team": "b"}}))
+
+	user1 := m.Tracker(&msg.Login{User: "u1", Metas: map[string]string{"team": "a"}})
+	user2 := m.Tracker(&msg.Login{Metas: map[string]string{"team": "a"}})
+	require.True(user1.Has("users", "u1"))
+	require.True(user1.Has("team", "a"))
+	require.False(user2.Has("users", ""))
+
+	user2.Add(1500)
+	limiter, err := user1.Check()
+	require.NoError(err)
+	require.Nil(limiter)
+
+	user2.Add(600)
+	limiter, err = user1.Check()
+	require.NoError(err)
+	require.NotNil(limiter)
+
+	user1.Add(1024)
+	_, err = user1.Check()
+	require.True(errors.Is(err, ErrExceeded))
+	require.Len(user1.Exceeded(), 2)
+}
+
+func TestPeriodReset(t *testing.T) {
+	require := require.New(t)
+	cfg := newTestConfig(t, v1.TrafficQuotaRule{
+		Name: "daily", Key: "runID", Period: v1.TrafficQuotaPeriodDaily, Limit: mustQuantity("1KB"),
+	})
+	m := NewManager(cfg)
+	now := time.Date(2024, 1, 2, 23, 0, 0, 0, time.Local)
+	m.now = func() time.Time { return now }
+
+	tracker := m.Tracker(&msg.Login{RunID: "r1"})
+	tracker.Add(2048)
+	_, err := tracker.Check()
+	require.Error(err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = tracker.Check()
+	require.NoError(err)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	require := require.New(t)
+	cfg := newTestConfig(t, v1.TrafficQuotaRule{Name: "users", Key: "user", Limit: mustQuantity("1MB")})
+	m := NewManager(cfg)
+	m.Tracker(&msg.Login{User: "u1"}).Add(100)
+	require.NoError(m.Save())
+
+	m2 := NewManager(cfg)
+	require.NoError(m2.Load())
+	statuses := m2.Statuses()
+	require.Len(statuses, 1)
+	require.Equal("u1", statuses[0].Value)
+	require.EqualValues(100, statuses[0].Used)
+}
@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Usage is the traffic counted by a rule for one value of its key in one period.
+type Usage struct {
+	// Period identifies the period the bytes are counted in, like "2024-01-02" for daily rules,
+	// "2024-01" for monthly rules and empty for total rules.
+	Period string `json:"period,omitempty"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Store persists usage counters so they survive restarts of frps.
+// Usages are indexed by the rule name and the value of the rule key joined by "/".
+type Store interface {
+	Load() (map[string]Usage, error)
+	Save(usages map[string]Usage) error
+}
+
+// FileStore keeps usage counters in a local JSON file.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+func (s *FileStore) Load() (map[string]Usage, error) {
+	usages := make(map[string]Usage)
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usages, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &usages); err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+// Save writes usages to a temporary file first and renames it, so the file is never left half written.
+func (s *FileStore) Save(usages map[string]Usage) error {
+	buf, err := json.Marshal(usages)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
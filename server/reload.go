@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+
+	"github.com/iami317/hepx/pkg/auth"
+	"github.com/iami317/hepx/pkg/config"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/config/v1/validation"
+	"github.com/iami317/hepx/pkg/msg"
+	"github.com/iami317/hepx/pkg/util/vhost"
+	"github.com/iami317/hepx/pkg/util/xlog"
+)
+
+var ErrNoConfigFile = errors.New("frps isn't started with a config file")
+
+// reloadableServerConfigFields are the json names of the fields of v1.ServerConfig which can be changed
+// without restarting frps, changes of other fields need listeners to be rebound.
+var reloadableServerConfigFields = []string{
+	"auth",
+	"log",
+	"allowPorts",
+	"maxPortsPerClient",
+	"custom404Page",
+	"httpPlugins",
+}
+
+// ReloadResult is the result of a successful reload.
+type ReloadResult struct {
+	// Changed are the json names of the changed fields.
+	Changed []string `json:"changed"`
+	// ClosedProxies are the proxies closed because they don't comply with the new configuration.
+	ClosedProxies []string `json:"closedProxies"`
+}
+
+// Reload loads the config file again and applies it by UpdateConfig.
+func (svr *Service) Reload() (*ReloadResult, error) {
+	result, err := svr.reload()
+	if err != nil {
+		svr.xl.Warnf("reload frps config error: %v", err)
+	}
+	return result, err
+}
+
+func (svr *Service) reload() (*ReloadResult, error) {
+	if svr.configFilePath == "" {
+		return nil, ErrNoConfigFile
+	}
+	cfg, _, err := config.LoadServerConfig(svr.configFilePath, svr.strictConfigMode)
+	if err != nil {
+		return nil, err
+	}
+	warning, err := validation.ValidateServerConfig(cfg)
+	if warning != nil {
+		svr.xl.Warnf("reload frps config warning: %v", warning)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return svr.UpdateConfig(cfg)
+}
+
+// UpdateConfig applies the reloadable fields of cfg without disconnecting clients, it fails without changing
+// anything if other fields are changed. Only the level of log can be reloaded. Existing proxies are checked
+// against the new allowPorts, maxPortsPerClient and proxy rules of the auth method, proxies which don't
+// comply are closed.
+//
+// Clients which have logged in keep being verified by the old auth settings.
+func (svr *Service) UpdateConfig(cfg *v1.ServerConfig) (*ReloadResult, error) {
+	svr.reloadMu.Lock()
+	defer svr.reloadMu.Unlock()
+
+	oldCfg := svr.config()
+	changed := diffServerConfig(oldCfg, cfg)
+	var needRestart []string
+	for i, name := range changed {
+		if name == "log" {
+			// only the level of log is applied without restarting
+			for _, field := range diffFields(&oldCfg.Log, &cfg.Log) {
+				if field != "level" {
+					needRestart = append(needRestart, "log."+field)
+				}
+			}
+			changed[i] = "log.level"
+			continue
+		}
+		if !slices.Contains(reloadableServerConfigFields, name) {
+			needRestart = append(needRestart, name)
+		}
+	}
+	if len(needRestart) > 0 {
+		return nil, fmt.Errorf("changes of [%s] require restarting frps", strings.Join(needRestart, ", "))
+	}
+
+	authChanged := slices.Contains(changed, "auth")
+	authMethodChanged := cfg.Auth.Method != oldCfg.Auth.Method
+	if authChanged {
+		authVerifier, err := auth.NewAuthVerifier(cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("create auth verifier error: %v", err)
+		}
+		svr.authVerifier = authVerifier
+	}
+
+	if slices.Contains(changed, "log.level") {
+		xlog.SetLevel(cfg.Log.Level)
+	}
+	if slices.Contains(changed, "allowPorts") {
+		svr.rc.TCPPortManager.SetAllowPorts(cfg.AllowPorts)
+		svr.rc.UDPPortManager.SetAllowPorts(cfg.AllowPorts)
+	}
+	if slices.Contains(changed, "custom404Page") {
+		vhost.SetNotFoundPagePath(cfg.Custom404Page)
+	}
+	if slices.Contains(changed, "httpPlugins") {
+		svr.pluginManager.ReplaceHTTPPlugins(cfg.HTTPPlugins)
+	}
+	// the config is read concurrently, so a new one is stored instead of changing the old one
+	newCfg := *oldCfg
+	newCfg.Auth = cfg.Auth
+	newCfg.Log.Level = cfg.Log.Level
+	newCfg.AllowPorts = cfg.AllowPorts
+	newCfg.MaxPortsPerClient = cfg.MaxPortsPerClient
+	newCfg.Custom404Page = cfg.Custom404Page
+	newCfg.HTTPPlugins = cfg.HTTPPlugins
+	svr.cfg.Store(&newCfg)
+
+	var proxyVerifier auth.ProxyVerifier
+	if authChanged && !authMethodChanged {
+		proxyVerifier, _ = svr.authVerifier.(auth.ProxyVerifier)
+	}
+	result := &ReloadResult{
+		Changed:       changed,
+		ClosedProxies: make([]string, 0),
+	}
+	for _, ctl := range svr.ctlManager.All() {
+		result.ClosedProxies = append(result.ClosedProxies, svr.revalidateProxies(ctl, proxyVerifier)...)
+	}
+	slices.Sort(result.ClosedProxies)
+	svr.xl.Infof("frps config reloaded, changed: %v, closed proxies: %v", result.Changed, result.ClosedProxies)
+	return result, nil
+}
+
+// diffServerConfig returns the json names of the top level fields which are different in a and b.
+func diffServerConfig(a, b *v1.ServerConfig) []string {
+	return diffFields(a, b)
+}
+
+// diffFields returns the json names of the fields which are different in the structs pointed by a and b.
+func diffFields(a, b any) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		field := va.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		changed = append(changed, lo.Ternary(name != "", name, field.Name))
+	}
+	return changed
+}
+
+// revalidateProxies closes the proxies of ctl which don't comply with the current configuration and returns
+// their names. The client is told the reason and won't register them again until it reconnects.
+func (svr *Service) revalidateProxies(ctl *Control, proxyVerifier auth.ProxyVerifier) []string {
+	// clients of the ssh tunnel gateway may skip auth
+	if ctl.authVerifier == auth.AlwaysPassVerifier {
+		proxyVerifier = nil
+	}
+
+	infos := ctl.proxyInfos()
+	slices.SortFunc(infos, func(a, b *ProxyInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var closed []string
+	closeProxy := func(name string, reason string) {
+		if err := ctl.CloseProxyByServer(name, reason); err == nil {
+			closed = append(closed, name)
+		}
+	}
+
+	remaining := make([]*ProxyInfo, 0, len(infos))
+	for _, info := range infos {
+		if port, ok := remotePort(info.Conf); ok {
+			pm := svr.rc.TCPPortManager
+			if info.Type == string(v1.ProxyTypeUDP) {
+				pm = svr.rc.UDPPortManager
+			}
+			if !pm.IsAllowed(port) {
+				closeProxy(info.Name, fmt.Sprintf("remote port [%d] is not allowed by frps", port))
+				continue
+			}
+		}
+		if proxyVerifier != nil {
+			pxyMsg := &msg.NewProxy{}
+			info.Conf.MarshalToMsg(pxyMsg)
+			if err := proxyVerifier.VerifyNewProxy(ctl.loginMsg, pxyMsg); err != nil {
+				closeProxy(info.Name, err.Error())
+				continue
+			}
+		}
+		remaining = append(remaining, info)
+	}
+
+	// close proxies from the end until the client doesn't use more ports than maxPortsPerClient
+	maxPorts := int(svr.config().MaxPortsPerClient)
+	for i := len(remaining) - 1; i >= 0 && maxPorts > 0 && ctl.usedPortsNum() > maxPorts; i-- {
+		if _, ok := remotePort(remaining[i].Conf); ok {
+			closeProxy(remaining[i].Name, "exceed the max_ports_per_client")
+		}
+	}
+	return closed
+}
+
+// remotePort returns the remote port of tcp and udp proxies.
+func remotePort(cfg v1.ProxyConfigurer) (int, bool) {
+	switch c := cfg.(type) {
+	case *v1.TCPProxyConfig:
+		return c.RemotePort, true
+	case *v1.UDPProxyConfig:
+		return c.RemotePort, true
+	}
+	return 0, false
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/config/types"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+)
+
+func TestDiffServerConfig(t *testing.T) {
+	require := require.New(t)
+
+	newConfig := func() *v1.ServerConfig {
+		cfg := &v1.ServerConfig{}
+		cfg.Complete()
+		return cfg
+	}
+	a, b := newConfig(), newConfig()
+	require.Empty(diffServerConfig(a, b))
+
+	b.Auth.Token = "new-token"
+	b.AllowPorts = []types.PortsRange{{Start: 6000, End: 7000}}
+	b.Custom404Page = "/etc/frp/404.html"
+	require.ElementsMatch([]string{"auth", "allowPorts", "custom404Page"}, diffServerConfig(a, b))
+
+	b.BindPort = 7001
+	require.Contains(diffServerConfig(a, b), "bindPort")
+}
+
+func TestDiffFields(t *testing.T) {
+	require := require.New(t)
+
+	a := v1.LogConfig{To: "console", Level: "info", MaxDays: 3}
+	b := a
+	b.Level = "debug"
+	require.Equal([]string{"level"}, diffFields(&a, &b))
+	b.To = "./frps.log"
+	require.Equal([]string{"to", "level"}, diffFields(&a, &b))
+}
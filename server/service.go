@@ -5,12 +5,14 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"gitee.com/menciis/logx"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/golib/crypto"
@@ -22,6 +24,7 @@ import (
 	"github.com/iami317/hepx/pkg/auth"
 	v1 "github.com/iami317/hepx/pkg/config/v1"
 	modelmetrics "github.com/iami317/hepx/pkg/metrics"
+	"github.com/iami317/hepx/pkg/metrics/mem"
 	"github.com/iami317/hepx/pkg/msg"
 	"github.com/iami317/hepx/pkg/nathole"
 	plugin "github.com/iami317/hepx/pkg/plugin/server"
@@ -33,11 +36,15 @@ import (
 	"github.com/iami317/hepx/pkg/util/util"
 	"github.com/iami317/hepx/pkg/util/vhost"
 	"github.com/iami317/hepx/pkg/util/xlog"
+	"github.com/iami317/hepx/server/accesslog"
+	"github.com/iami317/hepx/server/acme"
 	"github.com/iami317/hepx/server/controller"
+	"github.com/iami317/hepx/server/event"
 	"github.com/iami317/hepx/server/group"
 	"github.com/iami317/hepx/server/metrics"
 	"github.com/iami317/hepx/server/ports"
 	"github.com/iami317/hepx/server/proxy"
+	"github.com/iami317/hepx/server/quota"
 	"github.com/iami317/hepx/server/visitor"
 )
 
@@ -99,22 +106,69 @@ type Service struct {
 
 	sshTunnelGateway *ssh.Gateway
 
-	// Verifies authentication based on selected method
+	// Obtains certificates of http proxies and terminates TLS for them, nil if acme is disabled
+	acmeManager *acme.Manager
+
+	// Verifies authentication based on selected method, it's replaced by reloading
 	authVerifier auth.Verifier
 
+	// Publish lifecycle events of clients and proxies
+	eventBus *event.Bus
+
+	// Persist statistics of proxies shown in the dashboard, nil if they are only kept in memory
+	metricsStorage mem.Storage
+
 	tlsConfig *tls.Config
 
-	cfg *v1.ServerConfig
+	// cfg is replaced as a whole by reloading, use config to get it. Controls keep the config of their
+	// logins, so clients keep using the settings they logged in with.
+	cfg atomic.Pointer[v1.ServerConfig]
+
+	// config file reloaded by Reload, empty if frps isn't started with a config file
+	configFilePath   string
+	strictConfigMode bool
+	// reloadMu serializes reloads and protects authVerifier
+	reloadMu sync.RWMutex
+
+	// root logger of the service, all loggers of clients and proxies are spawned from it
+	xl *xlog.Logger
 
 	// service context
 	ctx context.Context
 	// call cancel to stop service
 	cancel context.CancelFunc
-	//有登录成功后的其他操作
-	OnLoginFn func(ctx context.Context, msg *msg.NewProxy)
 }
 
-func NewService(cfg *v1.ServerConfig) (*Service, error) {
+type serviceOptions struct {
+	logHandler       slog.Handler
+	configFilePath   string
+	strictConfigMode bool
+}
+
+type ServiceOption func(*serviceOptions)
+
+// WithLogHandler sends all logs of the service to h as structured records instead of the global logger.
+// Run ID, proxy name, proxy type and remote address are attached as attributes.
+func WithLogHandler(h slog.Handler) ServiceOption {
+	return func(o *serviceOptions) {
+		o.logHandler = h
+	}
+}
+
+// WithConfigFile sets the config file the service is created from, Reload loads it again.
+func WithConfigFile(path string, strict bool) ServiceOption {
+	return func(o *serviceOptions) {
+		o.configFilePath = path
+		o.strictConfigMode = strict
+	}
+}
+
+func NewService(cfg *v1.ServerConfig, opts ...ServiceOption) (*Service, error) {
+	var options serviceOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	tlsConfig, err := transport.NewServerTLSConfig(
 		cfg.Transport.TLS.CertFile,
 		cfg.Transport.TLS.KeyFile,
@@ -132,31 +186,59 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 		webServer = ws
 
 		modelmetrics.EnableMem()
+		mem.SetRetentionDays(cfg.Metrics.RetentionDays)
 		if cfg.EnablePrometheus {
 			modelmetrics.EnablePrometheus()
 		}
 	}
 
+	accessLog, err := accesslog.New(&cfg.AccessLog)
+	if err != nil {
+		return nil, fmt.Errorf("create access log error, %v", err)
+	}
+
+	authVerifier, err := auth.NewAuthVerifier(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("create auth verifier error, %v", err)
+	}
+
+	eventBus := event.NewBus()
 	svr := &Service{
+		xl:            xlog.New().SetHandler(options.logHandler),
 		ctlManager:    NewControlManager(),
 		pxyManager:    proxy.NewManager(),
 		pluginManager: plugin.NewManager(),
 		rc: &controller.ResourceController{
-			VisitorManager: visitor.NewManager(),
-			TCPPortManager: ports.NewManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
-			UDPPortManager: ports.NewManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+			VisitorManager:     visitor.NewManager(),
+			VisitorRevocations: auth.NewVisitorRevocations(),
+			TCPPortManager:     ports.NewManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
+			UDPPortManager:     ports.NewManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+			EventBus:           eventBus,
+			QuotaManager:       quota.NewManager(&cfg.TrafficQuota),
+			AccessLog:          accessLog,
 		},
 		sshTunnelListener: netpkg.NewInternalListener(),
 		httpVhostRouter:   vhost.NewRouters(),
-		authVerifier:      auth.NewAuthVerifier(cfg.Auth),
+		authVerifier:      authVerifier,
+		eventBus:          eventBus,
 		webServer:         webServer,
 		tlsConfig:         tlsConfig,
-		cfg:               cfg,
+		configFilePath:    options.configFilePath,
+		strictConfigMode:  options.strictConfigMode,
 		ctx:               context.Background(),
 	}
+	svr.ctx = xlog.NewContext(svr.ctx, svr.xl)
+	svr.cfg.Store(cfg)
+	svr.rc.ServerConfig = svr.config
 	if webServer != nil {
 		webServer.RouteRegister(svr.registerRouteHandlers)
 	}
+	svr.rc.QuotaManager.SetLogger(svr.xl)
+	mem.SetLogger(svr.xl)
+	svr.rc.QuotaManager.OnExceeded(svr.notifyQuotaExceeded)
+	if webServer != nil && cfg.Metrics.StoreFile != "" {
+		svr.metricsStorage = mem.NewFileStorage(cfg.Metrics.StoreFile)
+	}
 
 	// Create tcpmux httpconnect multiplexer.
 	if cfg.TCPMuxHTTPConnectPort > 0 {
@@ -167,18 +249,19 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			return nil, fmt.Errorf("create server listener error, %v", err)
 		}
 
-		svr.rc.TCPMuxHTTPConnectMuxer, err = tcpmux.NewHTTPConnectTCPMuxer(l, cfg.TCPMuxPassthrough, vhostReadWriteTimeout)
+		svr.rc.TCPMuxHTTPConnectMuxer, err = tcpmux.NewHTTPConnectTCPMuxer(svr.ctx, l, cfg.TCPMuxPassthrough, vhostReadWriteTimeout)
 		if err != nil {
 			return nil, fmt.Errorf("create vhost tcpMuxer error, %v", err)
 		}
-		logx.Infof("tcpmux httpconnect multiplexer listen on %s, passthough: %v", address, cfg.TCPMuxPassthrough)
+		svr.xl.Infof("tcpmux httpconnect multiplexer listen on %s, passthough: %v", address, cfg.TCPMuxPassthrough)
 	}
 
 	// Init all plugins
 	for _, p := range cfg.HTTPPlugins {
 		svr.pluginManager.Register(plugin.NewHTTPPluginOptions(p))
-		logx.Infof("plugin [%s] has been registered", p.Name)
+		svr.xl.Infof("plugin [%s] has been registered", p.Name)
 	}
+	svr.pluginManager.SetLogger(svr.xl)
 	svr.rc.PluginManager = svr.pluginManager
 
 	// Init group controller
@@ -191,7 +274,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	svr.rc.TCPMuxGroupCtl = group.NewTCPMuxGroupCtl(svr.rc.TCPMuxHTTPConnectMuxer)
 
 	// Init 404 not found page
-	vhost.NotFoundPagePath = cfg.Custom404Page
+	vhost.SetNotFoundPagePath(cfg.Custom404Page)
 
 	var (
 		httpMuxOn  bool
@@ -221,7 +304,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	ln = svr.muxer.DefaultListener()
 
 	svr.listener = ln
-	logx.Verbosef("frps tcp listen on %s", address)
+	svr.xl.Debugf("frps tcp listen on %s", address)
 
 	// Listen for accepting connections from client using kcp protocol.
 	if cfg.KCPBindPort > 0 {
@@ -230,7 +313,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("listen on kcp udp address %s error: %v", address, err)
 		}
-		logx.Verbosef("frps kcp listen on udp %s", address)
+		svr.xl.Debugf("frps kcp listen on udp %s", address)
 	}
 
 	if cfg.QUICBindPort > 0 {
@@ -241,20 +324,21 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			MaxIdleTimeout:     time.Duration(cfg.Transport.QUIC.MaxIdleTimeout) * time.Second,
 			MaxIncomingStreams: int64(cfg.Transport.QUIC.MaxIncomingStreams),
 			KeepAlivePeriod:    time.Duration(cfg.Transport.QUIC.KeepalivePeriod) * time.Second,
+			EnableDatagrams:    true,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("listen on quic udp address %s error: %v", address, err)
 		}
-		logx.Verbosef("frps quic listen on %s", address)
+		svr.xl.Debugf("frps quic listen on %s", address)
 	}
 
 	if cfg.SSHTunnelGateway.BindPort > 0 {
-		sshGateway, err := ssh.NewGateway(cfg.SSHTunnelGateway, cfg.ProxyBindAddr, svr.sshTunnelListener)
+		sshGateway, err := ssh.NewGateway(svr.ctx, cfg.SSHTunnelGateway, cfg.ProxyBindAddr, svr.sshTunnelListener)
 		if err != nil {
 			return nil, fmt.Errorf("create ssh gateway error: %v", err)
 		}
 		svr.sshTunnelGateway = sshGateway
-		logx.Verbosef("frps sshTunnelGateway listen on port %d", cfg.SSHTunnelGateway.BindPort)
+		svr.xl.Debugf("frps sshTunnelGateway listen on port %d", cfg.SSHTunnelGateway.BindPort)
 	}
 
 	// Listen for accepting connections from client using websocket protocol.
@@ -264,17 +348,40 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	})
 	svr.websocketListener = netpkg.NewWebsocketListener(websocketLn)
 
+	// Create acme manager, it answers challenges on the vhost http port and serves https requests of http proxies.
+	if cfg.ACME.Enable {
+		svr.acmeManager, err = acme.NewManager(&cfg.ACME, func(domain string) bool {
+			// A broad regular expression would let any SNI trigger certificate orders, so domains routed by
+			// regular expressions only get certificates if acme.domains limits them.
+			if len(cfg.ACME.Domains) == 0 {
+				return svr.rc.HTTPReverseProxy.HasDomainName(domain)
+			}
+			return svr.rc.HTTPReverseProxy.HasDomain(domain)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create acme manager error, %v", err)
+		}
+	}
+
 	// Create http vhost muxer.
 	if cfg.VhostHTTPPort > 0 {
 		rp := vhost.NewHTTPReverseProxy(vhost.HTTPReverseProxyOptions{
 			ResponseHeaderTimeoutS: cfg.VhostHTTPTimeout,
+			Logger:                 svr.xl,
 		}, svr.httpVhostRouter)
 		svr.rc.HTTPReverseProxy = rp
+		rp.SetRequestHook(svr.handleNewHTTPRequest)
+
+		var handler http.Handler = rp
+		if svr.acmeManager != nil {
+			handler = svr.acmeManager.HTTPHandler(rp)
+			svr.acmeManager.Run(rp)
+		}
 
 		address := net.JoinHostPort(cfg.ProxyBindAddr, strconv.Itoa(cfg.VhostHTTPPort))
 		server := &http.Server{
 			Addr:              address,
-			Handler:           rp,
+			Handler:           handler,
 			ReadHeaderTimeout: 60 * time.Second,
 		}
 		var l net.Listener
@@ -289,7 +396,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 		go func() {
 			_ = server.Serve(l)
 		}()
-		logx.Verbosef("http service listen on %s", address)
+		svr.xl.Debugf("http service listen on %s", address)
 	}
 
 	// Create https vhost muxer.
@@ -303,13 +410,17 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			if err != nil {
 				return nil, fmt.Errorf("create server listener error, %v", err)
 			}
-			logx.Verbosef("https service listen on %s", address)
+			svr.xl.Debugf("https service listen on %s", address)
 		}
 
-		svr.rc.VhostHTTPSMuxer, err = vhost.NewHTTPSMuxer(l, vhostReadWriteTimeout)
+		svr.rc.VhostHTTPSMuxer, err = vhost.NewHTTPSMuxer(svr.ctx, l, vhostReadWriteTimeout)
 		if err != nil {
 			return nil, fmt.Errorf("create vhost httpsMuxer error, %v", err)
 		}
+		if svr.acmeManager != nil {
+			// https proxies take precedence, other domains of http proxies are served with acme certificates
+			svr.rc.VhostHTTPSMuxer.SetFallbackFunc(svr.acmeManager.HandleConn)
+		}
 	}
 
 	// frp tls listener
@@ -327,17 +438,34 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	return svr, nil
 }
 
+// config returns the current config of frps.
+func (svr *Service) config() *v1.ServerConfig {
+	return svr.cfg.Load()
+}
+
 func (svr *Service) Run(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
-	svr.ctx = ctx
+	svr.ctx = xlog.NewContext(ctx, svr.xl)
 	svr.cancel = cancel
 
+	// Usages must be loaded before any client logs in.
+	if err := svr.rc.QuotaManager.Load(); err != nil {
+		svr.xl.Warnf("load traffic quota usages error: %v", err)
+	}
+	go svr.rc.QuotaManager.Run(svr.ctx)
+	if svr.metricsStorage != nil {
+		flushInterval := time.Duration(svr.config().Metrics.FlushInterval) * time.Second
+		if err := mem.EnablePersistence(svr.ctx, svr.metricsStorage, flushInterval); err != nil {
+			svr.xl.Warnf("load proxy statistics error: %v", err)
+		}
+	}
+
 	// 运行仪表板 Web 服务器。
 	if svr.webServer != nil {
 		go func() {
-			logx.Verbosef("dashboard listen on %s", svr.webServer.Address())
+			svr.xl.Debugf("dashboard listen on %s", svr.webServer.Address())
 			if err := svr.webServer.Run(); err != nil {
-				logx.Warnf("dashboard server exit with error: %v", err)
+				svr.xl.Warnf("dashboard server exit with error: %v", err)
 			}
 		}()
 	}
@@ -391,13 +519,42 @@ func (svr *Service) Close() error {
 		svr.listener.Close()
 		svr.listener = nil
 	}
+	if svr.acmeManager != nil {
+		svr.acmeManager.Close()
+	}
 	svr.ctlManager.Close()
+	svr.rc.AccessLog.Close()
+	svr.eventBus.Close()
 	if svr.cancel != nil {
 		svr.cancel()
 	}
 	return nil
 }
 
+// SetMetricsStorage replaces the storage of proxy statistics configured by metrics.storeFile.
+// It must be called before Run.
+func (svr *Service) SetMetricsStorage(storage mem.Storage) {
+	svr.metricsStorage = storage
+}
+
+// RegisterPlugin registers a server plugin implemented in Go, it's called in process without the HTTP round trip
+// of plugins configured by httpPlugins. It must be called before Run.
+func (svr *Service) RegisterPlugin(p plugin.Plugin, opts plugin.RegisterOptions) {
+	svr.pluginManager.RegisterWithOptions(p, opts)
+	svr.xl.Infof("plugin [%s] has been registered", p.Name())
+}
+
+// Subscribe returns a subscription to lifecycle events of clients and proxies, see event.Bus.Subscribe.
+// Close the subscription when it's no longer used.
+func (svr *Service) Subscribe(bufferSize int, types ...event.Type) *event.Subscription {
+	return svr.eventBus.Subscribe(bufferSize, types...)
+}
+
+// SubscribeFunc calls handler for every lifecycle event of the given types, see event.Bus.SubscribeFunc.
+func (svr *Service) SubscribeFunc(bufferSize int, handler event.Handler, types ...event.Type) *event.Subscription {
+	return svr.eventBus.SubscribeFunc(bufferSize, handler, types...)
+}
+
 func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, internal bool) {
 	xl := xlog.FromContextSafe(ctx)
 
@@ -408,7 +565,7 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 
 	_ = conn.SetReadDeadline(time.Now().Add(connReadTimeout))
 	if rawMsg, err = msg.ReadMsg(conn); err != nil {
-		logx.Warnf("Failed to read message: %v", err)
+		xl.Warnf("Failed to read message: %v", err)
 		conn.Close()
 		return
 	}
@@ -424,21 +581,28 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 		retContent, err := svr.pluginManager.Login(content)
 		if err == nil {
 			m = &retContent.Login
-			err = svr.RegisterControl(conn, m, internal)
+			err = svr.RegisterControl(conn, m, internal, netpkg.TLSStateFromContext(ctx))
 		}
 
 		// 如果登录失败，请在那里发送错误消息。
 		//否则，在控件的工作例程中发送成功消息。
 		if err != nil {
 			xl.Warnf("register control error: %v", err)
+			svr.eventBus.Publish(&event.Event{
+				Type:       event.TypeClientLoginRejected,
+				RunID:      m.RunID,
+				ClientAddr: conn.RemoteAddr().String(),
+				Login:      event.NewLoginInfo(m),
+				Error:      err.Error(),
+			})
 			_ = msg.WriteMsg(conn, &msg.LoginResp{
 				Version: "v0.58.1",
-				Error:   util.GenerateResponseErrorString("register control error", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+				Error:   util.GenerateResponseErrorString("register control error", err, lo.FromPtr(svr.config().DetailedErrorsToClient)),
 			})
 			conn.Close()
 		}
 	case *msg.NewWorkConn:
-		if err := svr.RegisterWorkConn(conn, m); err != nil {
+		if err := svr.RegisterWorkConn(conn, m, netpkg.TLSStateFromContext(ctx)); err != nil {
 			conn.Close()
 		}
 	case *msg.NewVisitorConn:
@@ -446,7 +610,7 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 			xl.Warnf("register visitor conn error: %v", err)
 			_ = msg.WriteMsg(conn, &msg.NewVisitorConnResp{
 				ProxyName: m.ProxyName,
-				Error:     util.GenerateResponseErrorString("register visitor conn error", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+				Error:     util.GenerateResponseErrorString("register visitor conn error", err, lo.FromPtr(svr.config().DetailedErrorsToClient)),
 			})
 			conn.Close()
 		} else {
@@ -456,7 +620,7 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 			})
 		}
 	default:
-		logx.Warnf("Error message type for the new connection [%s]", conn.RemoteAddr().String())
+		xl.Warnf("Error message type for the new connection [%s]", conn.RemoteAddr().String())
 		conn.Close()
 	}
 }
@@ -469,39 +633,42 @@ func (svr *Service) HandleListener(l net.Listener, internal bool) {
 	for {
 		c, err := l.Accept()
 		if err != nil {
-			logx.Warnf("Listener for incoming connections from client closed")
+			svr.xl.Warnf("Listener for incoming connections from client closed")
 			return
 		}
 		// inject xlog object into net.Conn context
-		xl := xlog.New()
+		xl := svr.xl.Spawn().AddPrefix(xlog.LogPrefix{Name: "remoteAddr", Value: c.RemoteAddr().String(), Hidden: true})
 		ctx := context.Background()
 
 		c = netpkg.NewContextConn(xlog.NewContext(ctx, xl), c)
 
 		if !internal {
-			logx.Verbosef("start check TLS connection...")
+			xl.Debugf("start check TLS connection...")
 			originConn := c
-			forceTLS := svr.cfg.Transport.TLS.Force
+			forceTLS := svr.config().Transport.TLS.Force
 			var isTLS, custom bool
 			c, isTLS, custom, err = netpkg.CheckAndEnableTLSServerConnWithTimeout(c, svr.tlsConfig, forceTLS, connReadTimeout)
 			if err != nil {
-				logx.Warnf("CheckAndEnableTLSServerConnWithTimeout error: %v", err)
+				xl.Warnf("CheckAndEnableTLSServerConnWithTimeout error: %v", err)
 				originConn.Close()
 				continue
 			}
-			logx.Verbosef("check TLS connection success, isTLS: %v custom: %v internal: %v", isTLS, custom, internal)
+			xl.Debugf("check TLS connection success, isTLS: %v custom: %v internal: %v", isTLS, custom, internal)
+			if tlsConn, ok := c.(*tls.Conn); ok {
+				ctx = netpkg.NewTLSStateContext(ctx, tlsConn.ConnectionState)
+			}
 		}
 
 		// Start a new goroutine to handle connection.
 		go func(ctx context.Context, frpConn net.Conn) {
-			if lo.FromPtr(svr.cfg.Transport.TCPMux) && !internal {
+			if lo.FromPtr(svr.config().Transport.TCPMux) && !internal {
 				fmuxCfg := fmux.DefaultConfig()
-				fmuxCfg.KeepAliveInterval = time.Duration(svr.cfg.Transport.TCPMuxKeepaliveInterval) * time.Second
+				fmuxCfg.KeepAliveInterval = time.Duration(svr.config().Transport.TCPMuxKeepaliveInterval) * time.Second
 				fmuxCfg.LogOutput = io.Discard
 				fmuxCfg.MaxStreamWindowSize = 6 * 1024 * 1024
 				session, err := fmux.Server(frpConn, fmuxCfg)
 				if err != nil {
-					logx.Warnf("Failed to create mux connection: %v", err)
+					svr.xl.Warnf("Failed to create mux connection: %v", err)
 					frpConn.Close()
 					return
 				}
@@ -509,7 +676,7 @@ func (svr *Service) HandleListener(l net.Listener, internal bool) {
 				for {
 					stream, err := session.AcceptStream()
 					if err != nil {
-						logx.Verbosef("Accept new mux stream error: %v", err)
+						svr.xl.Debugf("Accept new mux stream error: %v", err)
 						session.Close()
 						return
 					}
@@ -527,7 +694,7 @@ func (svr *Service) HandleQUICListener(l *quic.Listener) {
 	for {
 		c, err := l.Accept(context.Background())
 		if err != nil {
-			logx.Warnf("QUICListener for incoming connections from client closed")
+			svr.xl.Warnf("QUICListener for incoming connections from client closed")
 			return
 		}
 		// Start a new goroutine to handle connection.
@@ -535,17 +702,21 @@ func (svr *Service) HandleQUICListener(l *quic.Listener) {
 			for {
 				stream, err := frpConn.AcceptStream(context.Background())
 				if err != nil {
-					logx.Verbosef("Accept new quic mux stream error: %v", err)
+					svr.xl.Debugf("Accept new quic mux stream error: %v", err)
 					_ = frpConn.CloseWithError(0, "")
 					return
 				}
 				go svr.handleConnection(ctx, netpkg.QuicStreamToNetConn(stream, frpConn), false)
 			}
-		}(context.Background(), c)
+		}(netpkg.NewTLSStateContext(context.Background(), func() tls.ConnectionState {
+			return c.ConnectionState().TLS
+		}), c)
 	}
 }
 
-func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, internal bool) error {
+// RegisterControl registers the control of a login, tlsState is the state of the TLS connection of the login
+// or nil.
+func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, internal bool, tlsState *tls.ConnectionState) error {
 	// 如果客户端的 RunID 为空，则它是一个新客户端，我们只是创建一个新控制器。
 	// 否则，我们会检查是否有一个控制器具有相同的运行 ID。如果是这样，我们释放以前的控制器并启动新的控制器。
 	var err error
@@ -558,22 +729,29 @@ func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, inter
 
 	ctx := netpkg.NewContextFromConn(ctlConn)
 	xl := xlog.FromContextSafe(ctx)
-	xl.AppendPrefix(loginMsg.RunID)
+	xl.AddPrefix(xlog.LogPrefix{Name: "runID", Value: loginMsg.RunID})
 	ctx = xlog.NewContext(ctx, xl)
 	xl.Tracef("client login info: ip [%s] version [%s] hostname [%s] os [%s] arch [%s]",
 		ctlConn.RemoteAddr().String(), loginMsg.Version, loginMsg.Hostname, loginMsg.Os, loginMsg.Arch)
 
 	// Check auth.
+	svr.reloadMu.RLock()
 	authVerifier := svr.authVerifier
+	svr.reloadMu.RUnlock()
 	if internal && loginMsg.ClientSpec.AlwaysAuthPass {
 		authVerifier = auth.AlwaysPassVerifier
 	}
-	if err := authVerifier.VerifyLogin(loginMsg); err != nil {
+	if v, ok := authVerifier.(auth.TLSVerifier); ok {
+		err = v.VerifyLoginTLS(loginMsg, tlsState)
+	} else {
+		err = authVerifier.VerifyLogin(loginMsg)
+	}
+	if err != nil {
 		return err
 	}
 
 	// TODO(fatedier): use SessionContext
-	ctl, err := NewControl(ctx, svr.rc, svr.pxyManager, svr.pluginManager, authVerifier, ctlConn, !internal, loginMsg, svr.cfg, svr.OnLoginFn)
+	ctl, err := NewControl(ctx, svr.rc, svr.pxyManager, svr.pluginManager, authVerifier, ctlConn, !internal, loginMsg, svr.config(), svr.eventBus)
 	if err != nil {
 		xl.Warnf("create new controller error: %v", err)
 		// don't return detailed errors to client
@@ -584,6 +762,10 @@ func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, inter
 	}
 
 	ctl.Start()
+	svr.eventBus.Publish(ctl.newEvent(event.TypeClientLogin))
+	for _, s := range ctl.quota.Exceeded() {
+		ctl.NotifyQuotaExceeded(s)
+	}
 
 	// for statistics
 	metrics.Server.NewClient()
@@ -596,8 +778,8 @@ func (svr *Service) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login, inter
 	return nil
 }
 
-// RegisterWorkConn 注册一个新的工作连接以控制，代理需要它。
-func (svr *Service) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn) error {
+// RegisterWorkConn 注册一个新的工作连接以控制，代理需要它。tlsState 是工作连接的 TLS 状态或 nil。
+func (svr *Service) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn, tlsState *tls.ConnectionState) error {
 	xl := netpkg.NewLogFromConn(workConn)
 	ctl, exist := svr.ctlManager.GetByID(newMsg.RunID)
 	if !exist {
@@ -618,18 +800,54 @@ func (svr *Service) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn)
 	if err == nil {
 		newMsg = &retContent.NewWorkConn
 		// Check auth.
-		err = ctl.authVerifier.VerifyNewWorkConn(newMsg)
+		if v, ok := ctl.authVerifier.(auth.TLSVerifier); ok {
+			err = v.VerifyNewWorkConnTLS(ctl.loginMsg, newMsg, tlsState)
+		} else {
+			err = ctl.authVerifier.VerifyNewWorkConn(newMsg)
+		}
 	}
 	if err != nil {
 		xl.Warnf("invalid NewWorkConn with run id [%s]", newMsg.RunID)
 		_ = msg.WriteMsg(workConn, &msg.StartWorkConn{
-			Error: util.GenerateResponseErrorString("invalid NewWorkConn", err, lo.FromPtr(svr.cfg.DetailedErrorsToClient)),
+			Error: util.GenerateResponseErrorString("invalid NewWorkConn", err, lo.FromPtr(svr.config().DetailedErrorsToClient)),
 		})
 		return fmt.Errorf("invalid NewWorkConn with run id [%s]", newMsg.RunID)
 	}
 	return ctl.RegisterWorkConn(workConn)
 }
 
+// handleNewHTTPRequest sends requests of http proxies to server plugins, which may reject them, set headers
+// or reroute them to another http proxy.
+func (svr *Service) handleNewHTTPRequest(req *http.Request, rc *vhost.RouteConfig) (string, error) {
+	if !svr.pluginManager.HasNewHTTPRequestPlugins() {
+		return "", nil
+	}
+	content := &plugin.NewHTTPRequestContent{
+		ProxyName: rc.ProxyName,
+		Method:    req.Method,
+		Host:      req.Host,
+		Path:      req.URL.Path,
+		Headers:   req.Header,
+		ClientIP:  req.RemoteAddr,
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		content.ClientIP = host
+	}
+	if pxy, ok := svr.pxyManager.GetByName(rc.ProxyName); ok {
+		content.User = pxy.GetUserInfo()
+	}
+
+	retContent, err := svr.pluginManager.NewHTTPRequest(content)
+	if err != nil {
+		metrics.Server.RejectConnection(rc.ProxyName, string(v1.ProxyTypeHTTP), metrics.RejectReasonPlugin)
+		return "", err
+	}
+	for k, v := range retContent.SetHeaders {
+		req.Header.Set(k, v)
+	}
+	return retContent.RouteTo, nil
+}
+
 func (svr *Service) RegisterVisitorConn(visitorConn net.Conn, newMsg *msg.NewVisitorConn) error {
 	visitorUser := ""
 	// TODO(deprecation): Compatible with old versions, can be without runID, user is empty. In later versions, it will be mandatory to include runID.
@@ -641,6 +859,6 @@ func (svr *Service) RegisterVisitorConn(visitorConn net.Conn, newMsg *msg.NewVis
 		}
 		visitorUser = ctl.loginMsg.User
 	}
-	return svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
+	return svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey, newMsg.Credential,
 		newMsg.UseEncryption, newMsg.UseCompression, visitorUser)
 }
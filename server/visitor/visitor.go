@@ -23,14 +23,51 @@ import (
 
 	libio "github.com/fatedier/golib/io"
 
+	"github.com/iami317/hepx/pkg/auth"
 	netpkg "github.com/iami317/hepx/pkg/util/net"
-	"github.com/iami317/hepx/pkg/util/util"
 )
 
 type listenerBundle struct {
 	l          *netpkg.InternalListener
-	sk         string
+	verifier   *auth.VisitorVerifier
 	allowUsers []string
+
+	// connections authenticated with named credentials, indexed by credential name
+	credentialConns map[string]map[*credentialConn]struct{}
+	mu              sync.Mutex
+}
+
+func (b *listenerBundle) addConn(c *credentialConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.credentialConns[c.credential] == nil {
+		b.credentialConns[c.credential] = make(map[*credentialConn]struct{})
+	}
+	b.credentialConns[c.credential][c] = struct{}{}
+}
+
+func (b *listenerBundle) removeConn(c *credentialConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.credentialConns[c.credential], c)
+	if len(b.credentialConns[c.credential]) == 0 {
+		delete(b.credentialConns, c.credential)
+	}
+}
+
+// credentialConn is a visitor connection authenticated with a named credential, it's tracked until closed.
+type credentialConn struct {
+	net.Conn
+	credential string
+	bundle     *listenerBundle
+	once       sync.Once
+}
+
+func (c *credentialConn) Close() error {
+	c.once.Do(func() {
+		c.bundle.removeConn(c)
+	})
+	return c.Conn.Close()
 }
 
 // Manager for visitor listeners.
@@ -46,7 +83,7 @@ func NewManager() *Manager {
 	}
 }
 
-func (vm *Manager) Listen(name string, sk string, allowUsers []string) (*netpkg.InternalListener, error) {
+func (vm *Manager) Listen(name string, verifier *auth.VisitorVerifier, allowUsers []string) (*netpkg.InternalListener, error) {
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
 
@@ -56,22 +93,24 @@ func (vm *Manager) Listen(name string, sk string, allowUsers []string) (*netpkg.
 
 	l := netpkg.NewInternalListener()
 	vm.listeners[name] = &listenerBundle{
-		l:          l,
-		sk:         sk,
-		allowUsers: allowUsers,
+		l:               l,
+		verifier:        verifier,
+		allowUsers:      allowUsers,
+		credentialConns: make(map[string]map[*credentialConn]struct{}),
 	}
 	return l, nil
 }
 
-func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey string,
+func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey string, credential string,
 	useEncryption bool, useCompression bool, visitorUser string,
 ) (err error) {
 	vm.mu.RLock()
 	defer vm.mu.RUnlock()
 
 	if l, ok := vm.listeners[name]; ok {
-		if util.GetAuthKey(l.sk, timestamp) != signKey {
-			err = fmt.Errorf("visitor connection of [%s] auth failed", name)
+		sk, errRet := l.verifier.Verify(credential, timestamp, signKey)
+		if errRet != nil {
+			err = fmt.Errorf("visitor connection of [%s] user [%s] auth failed: %v", name, visitorUser, errRet)
 			return
 		}
 
@@ -80,9 +119,20 @@ func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey
 			return
 		}
 
+		if credential != "" {
+			cc := &credentialConn{Conn: conn, credential: credential, bundle: l}
+			l.addConn(cc)
+			defer func() {
+				if err != nil {
+					l.removeConn(cc)
+				}
+			}()
+			conn = cc
+		}
+
 		var rwc io.ReadWriteCloser = conn
 		if useEncryption {
-			if rwc, err = libio.WithEncryption(rwc, []byte(l.sk)); err != nil {
+			if rwc, err = libio.WithEncryption(rwc, []byte(sk)); err != nil {
 				err = fmt.Errorf("create encryption connection failed: %v", err)
 				return
 			}
@@ -98,6 +148,29 @@ func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey
 	return
 }
 
+// CloseCredentialConns closes the visitor connections of the proxy which were authenticated with the credential
+// and returns how many were closed.
+func (vm *Manager) CloseCredentialConns(name, credential string) int {
+	vm.mu.RLock()
+	l, ok := vm.listeners[name]
+	vm.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+
+	l.mu.Lock()
+	conns := make([]*credentialConn, 0, len(l.credentialConns[credential]))
+	for c := range l.credentialConns[credential] {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
 func (vm *Manager) CloseListener(name string) {
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
@@ -0,0 +1,43 @@
+package visitor
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iami317/hepx/pkg/auth"
+	v1 "github.com/iami317/hepx/pkg/config/v1"
+	"github.com/iami317/hepx/pkg/util/util"
+)
+
+func TestCloseCredentialConns(t *testing.T) {
+	require := require.New(t)
+
+	vm := NewManager()
+	credentials := []v1.VisitorCredential{{Name: "alice", SecretKey: "a"}, {Name: "bob", SecretKey: "b"}}
+	l, err := vm.Listen("ssh", auth.NewVisitorVerifier("ssh", "", credentials, nil), []string{"*"})
+	require.NoError(err)
+
+	connect := func(credential, sk string) net.Conn {
+		c1, c2 := net.Pipe()
+		now := time.Now().Unix()
+		go func() {
+			_ = vm.NewConn("ssh", c1, now, util.GetAuthKey(sk, now), credential, false, false, "")
+		}()
+		_, err := l.Accept()
+		require.NoError(err)
+		return c2
+	}
+	alice := connect("alice", "a")
+	bob := connect("bob", "b")
+	defer bob.Close()
+
+	require.Equal(1, vm.CloseCredentialConns("ssh", "alice"))
+	_, err = alice.Read(make([]byte, 1))
+	require.ErrorIs(err, io.EOF)
+	require.Equal(0, vm.CloseCredentialConns("ssh", "alice"))
+	require.Equal(0, vm.CloseCredentialConns("web", "bob"))
+}